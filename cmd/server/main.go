@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,7 +17,7 @@ import (
 )
 
 func main() {
-	cfg, err := parseConfig()
+	cfg, configPath, err := parseConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "parse config: %v\n", err)
 		os.Exit(2)
@@ -24,7 +25,19 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	slog.SetDefault(logger)
 
-	gateway, err := server.NewJT809Gateway(cfg, jtt1078.NewVideoServer(""))
+	var rtpServer *jtt1078.Server
+	if !cfg.Video.Disabled {
+		rtpServer = jtt1078.NewVideoServer(cfg.Video.Listen)
+		if cfg.Video.Listen != "" {
+			go func() {
+				if err := rtpServer.Start(); err != nil {
+					slog.Error("rtp proxy stopped", "err", err)
+				}
+			}()
+		}
+	}
+
+	gateway, err := server.NewJT809Gateway(cfg.Config, rtpServer)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "init gateway: %v\n", err)
 		os.Exit(2)
@@ -111,30 +124,108 @@ func main() {
 				"type", info.WarnType,
 				"info", info.InfoContent)
 		},
+		OnGeofenceEvent: func(userID uint32, evt *server.GeofenceEvent) {
+			slog.Info("【业务回调】电子围栏",
+				"user_id", userID,
+				"plate", evt.VehicleNo,
+				"fence", evt.FenceName,
+				"type", evt.Type)
+		},
+		OnVehicleOffline: func(userID uint32, plate string, color jtt809.PlateColor, lastPosition time.Time) {
+			slog.Info("【业务回调】车辆离线",
+				"user_id", userID,
+				"plate", plate,
+				"last_position", lastPosition.Format(time.DateTime))
+		},
+		OnLinkStateChange: func(userID uint32, link string, state server.LinkState, reason string) {
+			if state == server.LinkStateFailed {
+				slog.Warn("【业务回调】从链路重连失败", "user_id", userID, "reason", reason)
+			}
+		},
+		OnRuleAlarm: func(userID uint32, alarm *server.RuleAlarm) {
+			slog.Info("【业务回调】违规报警",
+				"user_id", userID,
+				"plate", alarm.VehicleNo,
+				"kind", alarm.Kind,
+				"active", alarm.Active)
+		},
 	})
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if configPath != "" {
+		// 配置文件变化或收到 SIGHUP 时热更新账号与策略
+		go gateway.WatchConfigFile(ctx, configPath, 0)
+		go reloadOnSIGHUP(ctx, gateway, configPath)
+	}
+
 	if err := gateway.Start(ctx); err != nil && err != context.Canceled {
 		slog.Error("gateway stopped with error", "err", err)
 	}
 }
 
-// parseConfig 解析命令行参数，返回标准化配置。
-func parseConfig() (server.Config, error) {
+// reloadOnSIGHUP 收到 SIGHUP 时重新加载配置文件。
+func reloadOnSIGHUP(ctx context.Context, gateway *server.JT809Gateway, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("received SIGHUP, reloading config", "path", path)
+			if err := gateway.ReloadConfigFile(path); err != nil {
+				slog.Error("reload config failed, keeping current config", "path", path, "err", err)
+			}
+		}
+	}
+}
+
+// parseConfig 解析命令行参数，返回标准化配置与配置文件路径。
+// 指定 -config 时全部配置从文件读取，其余参数被忽略。
+func parseConfig() (server.FileConfig, string, error) {
 	var (
-		mainAddr  = flag.String("main", ":10709", "主链路监听地址，格式 host:port")
-		httpAddr  = flag.String("http", ":18080", "管理与调度 HTTP 地址")
-		idleSec   = flag.Int("idle", 300, "连接空闲超时时间，单位秒，<=0 表示不超时")
-		accountFS server.MultiAccountFlag
+		configPath = flag.String("config", "", "YAML 配置文件路径，指定后忽略其余参数，文件变化或收到 SIGHUP 时热更新账号与策略")
+		platformID = flag.String("platform-id", "", "本级平台唯一编码，下发报警预警时作为源平台编码")
+		mainAddr   = flag.String("main", ":10709", "主链路监听地址，格式 host:port")
+		httpAddr   = flag.String("http", ":18080", "管理与调度 HTTP 地址")
+		idleSec    = flag.Int("idle", 300, "连接空闲超时时间，单位秒，<=0 表示不超时")
+		dataDir    = flag.String("data", "", "本地数据目录，用于持久化平台与车辆状态、历史轨迹，为空表示不持久化")
+		trackDays  = flag.Int("track-days", 180, "历史轨迹保留天数，<=0 表示永久保留")
+		webhookURL = flag.String("webhook-url", "", "事件推送 Webhook 地址，为空表示不推送")
+		webhookKey = flag.String("webhook-secret", "", "Webhook HMAC-SHA256 签名密钥")
+		mqttBroker = flag.String("mqtt-broker", "", "MQTT Broker 地址，如 tcp://127.0.0.1:1883、ssl://host:8883，为空表示不推送")
+		mqttUser   = flag.String("mqtt-user", "", "MQTT 用户名")
+		mqttPass   = flag.String("mqtt-password", "", "MQTT 密码")
+		mqttQoS    = flag.Int("mqtt-qos", 0, "MQTT 发布 QoS（0/1，不支持 2）")
+		mqttV5     = flag.Bool("mqtt-v5", false, "使用 MQTT 5 协议，默认 3.1.1")
+		kafkaAddrs = flag.String("kafka-brokers", "", "Kafka Broker 地址，逗号分隔，为空表示不推送")
+		kafkaFmt   = flag.String("kafka-format", server.KafkaFormatJSON, "Kafka 消息格式：json 或 avro")
+		jwtKeyFile = flag.String("http-jwt-key", "", "管理接口 JWT 验签公钥文件（PEM）")
+		auditLog   = flag.String("http-audit-log", "", "管理接口审计日志文件（JSON Lines）")
+		tlsCert    = flag.String("http-tls-cert", "", "管理接口 TLS 证书文件，为空表示使用 HTTP")
+		tlsKey     = flag.String("http-tls-key", "", "管理接口 TLS 私钥文件")
+		clientCA   = flag.String("http-client-ca", "", "管理接口客户端证书 CA 文件，配置后校验客户端证书")
+		accountFS  server.MultiAccountFlag
+		apiKeyFS   server.MultiAPIKeyFlag
 	)
 	flag.Var(&accountFS, "account", "下级平台账号，格式 userID:password:gnssCenterID[:allowIPs]，allowIPs 逗号分隔，可重复指定")
+	flag.Var(&apiKeyFS, "http-api-key", "管理接口 API Key，格式 key:role[:name]，role 为 viewer、dispatcher 或 admin，可重复指定")
 	flag.Parse()
 
+	if *configPath != "" {
+		fc, err := server.LoadConfigFile(*configPath)
+		return fc, *configPath, err
+	}
+
 	cfg := server.Config{
-		MainListen: *mainAddr,
-		HTTPListen: *httpAddr,
+		PlatformID:         *platformID,
+		MainListen:         *mainAddr,
+		HTTPListen:         *httpAddr,
+		DataDir:            *dataDir,
+		TrackRetentionDays: *trackDays,
 		IdleTimeout: func() time.Duration {
 			if *idleSec <= 0 {
 				return 0
@@ -152,6 +243,41 @@ func parseConfig() (server.Config, error) {
 	// 		AllowIPs:     []string{"*"},
 	// 	})
 	// }
+	if *webhookURL != "" {
+		cfg.Webhook = &server.WebhookConfig{URL: *webhookURL, Secret: *webhookKey}
+	}
+	if *mqttBroker != "" {
+		cfg.MQTT = &server.MQTTConfig{
+			Broker:         *mqttBroker,
+			Username:       *mqttUser,
+			Password:       *mqttPass,
+			QoS:            byte(*mqttQoS),
+			RetainLocation: true,
+		}
+		if *mqttV5 {
+			cfg.MQTT.ProtocolVersion = server.MQTTVersion5
+		}
+	}
+	if *kafkaAddrs != "" {
+		cfg.Kafka = &server.KafkaConfig{
+			Brokers: strings.Split(*kafkaAddrs, ","),
+			Format:  *kafkaFmt,
+		}
+	}
+	if len(apiKeyFS) > 0 || *jwtKeyFile != "" {
+		cfg.HTTPAuth = &server.HTTPAuthConfig{
+			APIKeys:          apiKeyFS,
+			JWTPublicKeyFile: *jwtKeyFile,
+			AuditFile:        *auditLog,
+		}
+	}
+	if *tlsCert != "" {
+		cfg.HTTPTLS = &server.HTTPTLSConfig{
+			CertFile:     *tlsCert,
+			KeyFile:      *tlsKey,
+			ClientCAFile: *clientCA,
+		}
+	}
 	cfg.Accounts = accountFS
-	return cfg, nil
+	return server.FileConfig{Config: cfg}, "", nil
 }
@@ -4,14 +4,18 @@ go 1.25.1
 
 require (
 	github.com/stretchr/testify v1.11.1
+	github.com/twmb/franz-go v1.21.0
 	github.com/zboyco/go-server v1.3.4-0.20251208070453-8e35fff23e7d
 	golang.org/x/text v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.18.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.26 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/twmb/franz-go/pkg/kmsg v1.13.1 // indirect
 )
@@ -149,6 +149,7 @@ func (b *Broadcaster) StartPulling() {
 	for b.running && scanner.Scan() {
 		packet := scanner.Bytes()
 		totalBytes += len(packet)
+		b.manager.bytesReceived.Add(uint64(len(packet)))
 
 		// Log: heartbeat, print traffic every 30 seconds
 		if time.Since(lastLogTime) > 30*time.Second {
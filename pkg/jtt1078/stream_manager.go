@@ -4,11 +4,40 @@ import (
 	"bytes"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
 // StreamManager manages multiple video streams
 type StreamManager struct {
 	streams sync.Map
+
+	bytesReceived atomic.Uint64 // bytes pulled from upstream sources
+	bytesSent     atomic.Uint64 // bytes written to viewers
+}
+
+// Stats is a point-in-time view of the proxy's streams and traffic
+type Stats struct {
+	Streams       int    `json:"streams"`
+	Viewers       int    `json:"viewers"`
+	BytesReceived uint64 `json:"bytes_received"`
+	BytesSent     uint64 `json:"bytes_sent"`
+}
+
+// Stats returns the number of active streams and viewers along with cumulative traffic
+func (m *StreamManager) Stats() Stats {
+	st := Stats{
+		BytesReceived: m.bytesReceived.Load(),
+		BytesSent:     m.bytesSent.Load(),
+	}
+	m.streams.Range(func(_, val any) bool {
+		b := val.(*Broadcaster)
+		st.Streams++
+		b.lock.RLock()
+		st.Viewers += len(b.clients)
+		b.lock.RUnlock()
+		return true
+	})
+	return st
 }
 
 // GetOrCreateBroadcaster gets an existing broadcaster for the targetURL or creates a new one
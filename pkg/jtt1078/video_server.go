@@ -43,6 +43,11 @@ func (s *Server) SetParseRequest(fn ParseRequestFunc) {
 	s.parseRequest = fn
 }
 
+// Stats returns the current stream, viewer and traffic statistics
+func (s *Server) Stats() Stats {
+	return s.manager.Stats()
+}
+
 // Start starts the server
 func (s *Server) Start() error {
 	// Enable detailed logging: date time microseconds
@@ -133,12 +138,16 @@ func (s *Server) runStreamLoop(w http.ResponseWriter, flusher http.Flusher, targ
 				return nil
 			}
 			for _, tag := range tags {
-				if _, err := w.Write(tag); err != nil {
+				n, err := w.Write(tag)
+				s.manager.bytesSent.Add(uint64(n))
+				if err != nil {
 					return err
 				}
 			}
 		} else {
-			if _, err := w.Write(frame); err != nil {
+			n, err := w.Write(frame)
+			s.manager.bytesSent.Add(uint64(n))
+			if err != nil {
 				return err
 			}
 		}
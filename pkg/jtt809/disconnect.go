@@ -73,9 +73,29 @@ type SubLinkCloseNotify struct {
 	ReasonCode byte
 }
 
+// 0x9008 关闭原因
+const (
+	CloseLinkGatewayRestart byte = 0x00 // 网关重启
+	CloseLinkOther          byte = 0x01 // 其他原因
+)
+
 func (SubLinkCloseNotify) MsgID() uint16             { return DOWN_CLOSELINK_INFORM }
 func (s SubLinkCloseNotify) Encode() ([]byte, error) { return []byte{s.ReasonCode}, nil }
 
+// ParseSubLinkCloseNotify 解析上级主动关闭链路通知（0x9008），返回关闭原因。
+func ParseSubLinkCloseNotify(frame *Frame) (*SubLinkCloseNotify, error) {
+	if frame == nil {
+		return nil, errors.New("frame is nil")
+	}
+	if frame.BodyID != DOWN_CLOSELINK_INFORM {
+		return nil, errors.New("unexpected body id")
+	}
+	if len(frame.RawBody) < 1 {
+		return nil, errors.New("body too short")
+	}
+	return &SubLinkCloseNotify{ReasonCode: frame.RawBody[0]}, nil
+}
+
 // BuildLogoutRequestPackage 便捷构造注销请求完整报文（含转义）。
 func BuildLogoutRequestPackage(header Header, req LogoutRequest) ([]byte, error) {
 	header.BusinessType = UP_DISCONNECT_REQ
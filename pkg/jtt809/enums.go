@@ -30,9 +30,11 @@ const (
 	UP_WARN_MSG_INFORM_TIPS        uint16 = 0x1403 // 上报报警预警消息
 
 	// 下行子业务 (上级平台->下级平台)
-	DOWN_EXG_MSG_RETURN_STARTUP uint16 = 0x9205 // 启动车辆定位信息交换请求
-	DOWN_EXG_MSG_RETURN_END     uint16 = 0x9206 // 结束车辆定位信息交换请求
-	DOWN_WARN_MSG_URGE_TODO_REQ uint16 = 0x9401 // 报警督办请求
+	DOWN_EXG_MSG_RETURN_STARTUP      uint16 = 0x9205 // 启动车辆定位信息交换请求
+	DOWN_EXG_MSG_RETURN_END          uint16 = 0x9206 // 结束车辆定位信息交换请求
+	DOWN_PLATFORM_MSG_POST_QUERY_REQ uint16 = 0x9301 // 平台查岗请求
+	DOWN_WARN_MSG_URGE_TODO_REQ      uint16 = 0x9401 // 报警督办请求
+	DOWN_WARN_MSG_INFORM_TIPS        uint16 = 0x9402 // 下发报警预警消息
 
 	// JT/T 1078-2016 子业务
 	UP_AUTHORIZE_MSG_STARTUP     uint16 = 0x1701 // 时效口令上报消息
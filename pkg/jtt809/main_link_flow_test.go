@@ -1,8 +1,6 @@
 package jtt809
 
 import (
-	"encoding/binary"
-	"errors"
 	"testing"
 )
 
@@ -149,13 +147,3 @@ func TestMainLinkDisconnectNotify(t *testing.T) {
 		t.Fatalf("unexpected error code: %d", notify.ErrorCode)
 	}
 }
-
-// ParseLoginResponse 用于测试解析登录应答业务体。
-func ParseLoginResponse(body []byte) (LoginResponse, error) {
-	if len(body) < 5 {
-		return LoginResponse{}, errors.New("login response body too short")
-	}
-	result := LoginResult(body[0])
-	verify := binary.BigEndian.Uint32(body[1:5])
-	return LoginResponse{Result: result, VerifyCode: verify}, nil
-}
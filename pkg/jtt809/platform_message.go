@@ -1,6 +1,7 @@
 package jtt809
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -58,3 +59,35 @@ func ParsePlatformQueryAck(pkt *SubBusinessPacket) (*PlatformQueryAck, error) {
 		InfoContent:    info,
 	}, nil
 }
+
+// PlatformQueryRequest 对应子业务 0x9301 平台查岗请求，由上级平台下发给下级平台。
+type PlatformQueryRequest struct {
+	ObjectType  byte   // 查岗对象类型
+	ObjectID    string // 查岗对象 ID
+	InfoID      uint32 // 信息 ID
+	InfoContent string // 查岗问题
+}
+
+func (PlatformQueryRequest) MsgID() uint16 { return DOWN_PLATFORM_MSG }
+
+func (PlatformQueryRequest) SubBusinessType() uint16 { return DOWN_PLATFORM_MSG_POST_QUERY_REQ }
+
+// Encode 构造 0x9300 主业务下的 0x9301 子业务报文：子业务类型 + 后续数据长度 + 载荷。
+func (r PlatformQueryRequest) Encode() ([]byte, error) {
+	content, err := EncodeGBK(r.InfoContent)
+	if err != nil {
+		return nil, fmt.Errorf("encode info content: %w", err)
+	}
+	var payload bytes.Buffer
+	payload.WriteByte(r.ObjectType)
+	payload.Write(PadRightGBK(r.ObjectID, 20))
+	_ = binary.Write(&payload, binary.BigEndian, r.InfoID)
+	_ = binary.Write(&payload, binary.BigEndian, uint32(len(content)))
+	payload.Write(content)
+
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, DOWN_PLATFORM_MSG_POST_QUERY_REQ)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(payload.Len()))
+	buf.Write(payload.Bytes())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,47 @@
+package jtt809
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestPlatformQueryRequestEncode(t *testing.T) {
+	req := PlatformQueryRequest{
+		ObjectType:  0x01,
+		ObjectID:    "20000000002",
+		InfoID:      7,
+		InfoContent: "请回复当班人员",
+	}
+	data, err := EncodePackage(Package{Header: Header{GNSSCenterID: 0x1357}, Body: req})
+	if err != nil {
+		t.Fatalf("encode 0x9301: %v", err)
+	}
+	frame, err := DecodeFrame(data)
+	if err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if frame.BodyID != DOWN_PLATFORM_MSG {
+		t.Fatalf("unexpected body id: %x", frame.BodyID)
+	}
+	body := frame.RawBody
+	if sub := binary.BigEndian.Uint16(body[0:2]); sub != DOWN_PLATFORM_MSG_POST_QUERY_REQ {
+		t.Fatalf("unexpected sub id: %x", sub)
+	}
+	if l := binary.BigEndian.Uint32(body[2:6]); int(l) != len(body)-6 {
+		t.Fatalf("length mismatch: declare=%d actual=%d", l, len(body)-6)
+	}
+	payload := body[6:]
+	if payload[0] != 0x01 {
+		t.Fatalf("unexpected object type: %x", payload[0])
+	}
+	if id, _ := DecodeGBK(payload[1:21]); id != req.ObjectID {
+		t.Fatalf("object id mismatch: %q", id)
+	}
+	if binary.BigEndian.Uint32(payload[21:25]) != 7 {
+		t.Fatalf("info id mismatch")
+	}
+	infoLen := binary.BigEndian.Uint32(payload[25:29])
+	if content, _ := DecodeGBK(payload[29 : 29+infoLen]); content != req.InfoContent {
+		t.Fatalf("content mismatch: %q", content)
+	}
+}
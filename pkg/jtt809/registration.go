@@ -86,6 +86,14 @@ func (l LoginResponse) Encode() ([]byte, error) {
 	return buf, nil
 }
 
+// ParseLoginResponse 解析主链路登录应答业务体。
+func ParseLoginResponse(body []byte) (LoginResponse, error) {
+	if len(body) < 5 {
+		return LoginResponse{}, errors.New("login response body too short")
+	}
+	return LoginResponse{Result: LoginResult(body[0]), VerifyCode: binary.BigEndian.Uint32(body[1:5])}, nil
+}
+
 // AuthValidator 定义鉴权回调接口，可注入自定义帐号校验逻辑。
 type AuthValidator func(LoginRequest) (LoginResponse, error)
 
@@ -70,6 +70,39 @@ func ParseSubLinkHeartbeatResponse(frame *Frame) (*SubLinkHeartbeatResponse, err
 	return &SubLinkHeartbeatResponse{}, nil
 }
 
+// SubLinkLogoutRequest 从链路注销请求（0x9003），上级在关闭从链路前发送。
+type SubLinkLogoutRequest struct {
+	VerifyCode uint32 // 主链路登录成功后返回的校验码
+}
+
+func (SubLinkLogoutRequest) MsgID() uint16 { return DOWN_DISCONNECT_REQ }
+
+func (s SubLinkLogoutRequest) Encode() ([]byte, error) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], s.VerifyCode)
+	return buf[:], nil
+}
+
+// SubLinkLogoutResponse 从链路注销应答（0x9004），业务体为空。
+type SubLinkLogoutResponse struct{}
+
+func (SubLinkLogoutResponse) MsgID() uint16           { return DOWN_DISCONNECT_RSP }
+func (SubLinkLogoutResponse) Encode() ([]byte, error) { return []byte{}, nil }
+
+// ParseSubLinkLogoutRequest 解析从链路注销请求（0x9003），返回校验码。
+func ParseSubLinkLogoutRequest(frame *Frame) (*SubLinkLogoutRequest, error) {
+	if frame == nil {
+		return nil, errors.New("frame is nil")
+	}
+	if frame.BodyID != DOWN_DISCONNECT_REQ {
+		return nil, errors.New("unexpected body id")
+	}
+	if len(frame.RawBody) < 4 {
+		return nil, errors.New("body too short")
+	}
+	return &SubLinkLogoutRequest{VerifyCode: binary.BigEndian.Uint32(frame.RawBody[:4])}, nil
+}
+
 // SubLinkDisconnectNotify 从链路断开通知（0x9007），上级推送给下级，无需应答。
 type SubLinkDisconnectNotify struct {
 	ReasonCode byte
@@ -85,3 +85,31 @@ func TestParseSubLinkResponses(t *testing.T) {
 		t.Fatalf("expected error for non-empty heartbeat response body")
 	}
 }
+
+func TestSubLinkLogoutAndCloseNotify(t *testing.T) {
+	data, _ := EncodePackage(Package{Header: Header{GNSSCenterID: 7}, Body: SubLinkLogoutRequest{VerifyCode: 0x11223344}})
+	frame, err := DecodeFrame(data)
+	if err != nil {
+		t.Fatalf("decode logout frame: %v", err)
+	}
+	req, err := ParseSubLinkLogoutRequest(frame)
+	if err != nil {
+		t.Fatalf("parse logout request: %v", err)
+	}
+	if req.VerifyCode != 0x11223344 {
+		t.Fatalf("unexpected verify code: %x", req.VerifyCode)
+	}
+
+	closeData, _ := EncodePackage(Package{Header: Header{GNSSCenterID: 7}, Body: SubLinkCloseNotify{ReasonCode: CloseLinkOther}})
+	closeFrame, _ := DecodeFrame(closeData)
+	notify, err := ParseSubLinkCloseNotify(closeFrame)
+	if err != nil {
+		t.Fatalf("parse close notify: %v", err)
+	}
+	if notify.ReasonCode != CloseLinkOther {
+		t.Fatalf("unexpected reason: %d", notify.ReasonCode)
+	}
+	if _, err := ParseSubLinkCloseNotify(frame); err == nil {
+		t.Fatal("expected parse error for wrong body id")
+	}
+}
@@ -35,6 +35,8 @@ const (
 	DOWN_DISCONNECT_INFORM uint16 = 0x9007 // 从链路断开通知消息
 	DOWN_CLOSELINK_INFORM  uint16 = 0x9008 // 上级平台主动关闭链路通知消息
 	DOWN_EXG_MSG           uint16 = 0x9200 // 从链路动态信息交换消息
+	DOWN_PLATFORM_MSG      uint16 = 0x9300 // 从链路平台间信息交互消息
+	DOWN_WARN_MSG          uint16 = 0x9400 // 从链路报警信息交互消息
 
 	// JT/T 1078-2016 视频业务
 	UP_AUTHORIZE_MSG   uint16 = 0x1700 // 视频相关鉴权
@@ -176,6 +178,9 @@ type Frame struct {
 	RawBody []byte
 }
 
+// ErrCRCMismatch 表示报文 CRC 校验失败，可通过 errors.Is 判断。
+var ErrCRCMismatch = errors.New("crc mismatch")
+
 // DecodeFrame 对收到的转义报文进行反转义与 CRC 校验，解析出消息头与原始业务体。
 func DecodeFrame(data []byte) (*Frame, error) {
 	if len(data) < 1+22+2+1 {
@@ -196,7 +201,7 @@ func DecodeFrame(data []byte) (*Frame, error) {
 	crcCalc := crc16CCITT(unescaped[1:bodyEnd])
 	crcReal := binary.BigEndian.Uint16(unescaped[bodyEnd : bodyEnd+2])
 	if crcCalc != crcReal {
-		return nil, fmt.Errorf("crc mismatch: calc=%04X real=%04X", crcCalc, crcReal)
+		return nil, fmt.Errorf("%w: calc=%04X real=%04X", ErrCRCMismatch, crcCalc, crcReal)
 	}
 
 	header := Header{
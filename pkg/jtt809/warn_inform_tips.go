@@ -1,6 +1,7 @@
 package jtt809
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -91,3 +92,72 @@ func ParseWarnMsgInformPacket(body []byte) (*WarnMsgInformTips, error) {
 	}
 	return ParseWarnMsgInformTips(pkt.Payload)
 }
+
+// DownWarnMsgInformTips 表示 0x9402 下发报警预警消息（DOWN_WARN_MSG_INFORM_TIPS），
+// 由上级平台通过 0x9400 下发给车属下级平台，数据体与 0x1403 一致。
+type DownWarnMsgInformTips struct {
+	WarnMsgInformTips
+}
+
+func (DownWarnMsgInformTips) MsgID() uint16 { return DOWN_WARN_MSG }
+
+// SubBusinessType 返回子业务类型，用于分配报文流水号。
+func (DownWarnMsgInformTips) SubBusinessType() uint16 { return DOWN_WARN_MSG_INFORM_TIPS }
+
+// Encode 构造 0x9400 主业务体：子业务类型(2) + 后续数据长度(4) + 报警预警数据。
+func (d DownWarnMsgInformTips) Encode() ([]byte, error) {
+	payload, err := d.encodePayload()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, DOWN_WARN_MSG_INFORM_TIPS)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+func (w WarnMsgInformTips) encodePayload() ([]byte, error) {
+	if len(w.VehicleNo) == 0 {
+		return nil, errors.New("vehicle number is required")
+	}
+	if w.WarnTime.IsZero() {
+		return nil, errors.New("warn time is required")
+	}
+	content := w.WarnContentRaw
+	if len(content) == 0 && w.WarnContent != "" {
+		encoded, err := EncodeGBK(w.WarnContent)
+		if err != nil {
+			return nil, fmt.Errorf("encode warn content: %w", err)
+		}
+		content = encoded
+	}
+	if len(content) > 1024 {
+		return nil, fmt.Errorf("warn content length exceeds 1024: %d", len(content))
+	}
+	var buf bytes.Buffer
+	buf.Write(PadRightGBK(w.SourcePlatformID, 11))
+	_ = binary.Write(&buf, binary.BigEndian, uint16(w.WarnType))
+	putUTCSeconds(&buf, w.WarnTime)
+	putUTCSeconds(&buf, w.StartTime)
+	putUTCSeconds(&buf, w.EndTime)
+	buf.Write(PadRightGBK(w.VehicleNo, 21))
+	buf.WriteByte(byte(w.VehicleColor))
+	buf.Write(PadRightGBK(w.TargetPlatformID, 11))
+	_ = binary.Write(&buf, binary.BigEndian, w.DrvLineID)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(content)))
+	buf.Write(content)
+	return buf.Bytes(), nil
+}
+
+// ParseDownWarnMsgInformPacket 校验并解析 0x9400 主业务下的 0x9402 子业务。
+func ParseDownWarnMsgInformPacket(body []byte) (*WarnMsgInformTips, error) {
+	pkt, err := ParseAlarmInfo(body)
+	if err != nil {
+		return nil, err
+	}
+	if pkt.SubBusinessID != DOWN_WARN_MSG_INFORM_TIPS {
+		return nil, fmt.Errorf("unexpected sub business id: %x", pkt.SubBusinessID)
+	}
+	return ParseWarnMsgInformTips(pkt.Payload)
+}
@@ -0,0 +1,47 @@
+package jtt809
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownWarnMsgInformTipsEncode(t *testing.T) {
+	msg := DownWarnMsgInformTips{WarnMsgInformTips{
+		SourcePlatformID: "10000000001",
+		WarnType:         WarnTypeEnterRegion,
+		WarnTime:         time.Date(2025, 3, 1, 8, 30, 0, 0, time.UTC),
+		StartTime:        time.Date(2025, 3, 1, 8, 30, 0, 0, time.UTC),
+		EndTime:          time.Date(2025, 3, 1, 8, 31, 0, 0, time.UTC),
+		VehicleNo:        "粤B12345",
+		VehicleColor:     PlateColorBlue,
+		TargetPlatformID: "20000000002",
+		WarnContent:      "车辆进入区域[园区]",
+	}}
+	data, err := EncodePackage(Package{Header: Header{GNSSCenterID: 0x1357}, Body: msg})
+	if err != nil {
+		t.Fatalf("encode 0x9402: %v", err)
+	}
+	frame, err := DecodeFrame(data)
+	if err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if frame.BodyID != DOWN_WARN_MSG {
+		t.Fatalf("unexpected body id: %x", frame.BodyID)
+	}
+	parsed, err := ParseDownWarnMsgInformPacket(frame.RawBody)
+	if err != nil {
+		t.Fatalf("parse 0x9402: %v", err)
+	}
+	if parsed.VehicleNo != msg.VehicleNo || parsed.VehicleColor != msg.VehicleColor || parsed.WarnType != msg.WarnType {
+		t.Fatalf("vehicle fields mismatch: %+v", parsed)
+	}
+	if parsed.SourcePlatformID != msg.SourcePlatformID || parsed.TargetPlatformID != msg.TargetPlatformID {
+		t.Fatalf("platform fields mismatch: %+v", parsed)
+	}
+	if !parsed.WarnTime.Equal(msg.WarnTime) || !parsed.EndTime.Equal(msg.EndTime) {
+		t.Fatalf("time mismatch: warn=%v end=%v", parsed.WarnTime, parsed.EndTime)
+	}
+	if parsed.WarnContent != msg.WarnContent {
+		t.Fatalf("content mismatch: %q", parsed.WarnContent)
+	}
+}
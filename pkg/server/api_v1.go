@@ -0,0 +1,522 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+	maskedPassword  = "******"
+)
+
+// APIError 为 /api/v1 的统一错误结构。
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// AccountView 为对外展示的账号信息，密码始终脱敏。
+type AccountView struct {
+	UserID       uint32   `json:"user_id"`
+	Password     string   `json:"password"`
+	GnssCenterID uint32   `json:"gnss_center_id"`
+	AllowIPs     []string `json:"allow_ips"`
+	MainLink     bool     `json:"main_link"`
+	SubLink      bool     `json:"sub_link"`
+
+	DuplicateLogin DuplicateLoginPolicy `json:"duplicate_login,omitempty"`
+}
+
+// accountInput 为新增或更新账号的请求体。
+type accountInput struct {
+	UserID       uint32   `json:"user_id"`
+	Password     string   `json:"password"`
+	GnssCenterID uint32   `json:"gnss_center_id"`
+	AllowIPs     []string `json:"allow_ips"`
+
+	DuplicateLogin DuplicateLoginPolicy `json:"duplicate_login"`
+}
+
+// VehicleSummary 为车辆列表项。
+type VehicleSummary struct {
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+	Online       bool              `json:"online"`
+	Registered   bool              `json:"registered"`
+	PositionTime time.Time         `json:"location_time,omitempty"`
+	Longitude    float64           `json:"longitude,omitempty"`
+	Latitude     float64           `json:"latitude,omitempty"`
+}
+
+// VehicleDetail 为单车详情。
+type VehicleDetail struct {
+	UserID uint32 `json:"user_id"`
+	Online bool   `json:"online"`
+	VehicleSnapshot
+}
+
+// Page 为分页结果。
+type Page[T any] struct {
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+	Items    []T `json:"items"`
+}
+
+// registerAPIv1 注册 /api/v1 管理接口。
+func (g *JT809Gateway) registerAPIv1(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/openapi.yaml", g.handleOpenAPI)
+	mux.HandleFunc("GET /api/v1/accounts", g.handleListAccounts)
+	mux.HandleFunc("POST /api/v1/accounts", g.handleCreateAccount)
+	mux.HandleFunc("GET /api/v1/accounts/{user_id}", g.handleGetAccount)
+	mux.HandleFunc("PUT /api/v1/accounts/{user_id}", g.handleUpdateAccount)
+	mux.HandleFunc("DELETE /api/v1/accounts/{user_id}", g.handleDeleteAccount)
+	mux.HandleFunc("GET /api/v1/platforms", g.handleListPlatforms)
+	mux.HandleFunc("GET /api/v1/platforms/{user_id}", g.handleGetPlatform)
+	mux.HandleFunc("POST /api/v1/platforms/{user_id}/links/close", g.handleClosePlatformLink)
+	mux.HandleFunc("POST /api/v1/platforms/{user_id}/links/main/close", g.handleCloseMainLink)
+	mux.HandleFunc("POST /api/v1/platforms/{user_id}/links/sub/close", g.handleCloseSubLink)
+	mux.HandleFunc("POST /api/v1/platforms/{user_id}/links/sub/connect", g.handleConnectSubLink)
+	mux.HandleFunc("GET /api/v1/platforms/{user_id}/packet-debug", g.handleGetPacketDebug)
+	mux.HandleFunc("PUT /api/v1/platforms/{user_id}/packet-debug", g.handleSetPacketDebug)
+	mux.HandleFunc("GET /api/v1/vehicles", g.handleListVehicles)
+	mux.HandleFunc("GET /api/v1/vehicles/{user_id}/{vehicle_no}", g.handleGetVehicle)
+	mux.HandleFunc("POST /api/v1/monitor/startup", g.handleMonitor(true))
+	mux.HandleFunc("POST /api/v1/monitor/end", g.handleMonitor(false))
+	mux.HandleFunc("GET /api/v1/relay", g.handleGetRelay)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSONStatus(w, status, map[string]APIError{"error": {Code: code, Message: message}})
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("write json failed", "err", err)
+	}
+}
+
+// writeLinkError 将链路控制错误映射为 HTTP 状态码。
+func writeLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPlatformNotFound):
+		writeAPIError(w, http.StatusNotFound, "platform_not_found", err.Error())
+	case errors.Is(err, ErrLinkNotConnected):
+		writeAPIError(w, http.StatusConflict, "link_not_connected", err.Error())
+	case errors.Is(err, ErrLinkAlreadyActive):
+		writeAPIError(w, http.StatusConflict, "link_already_connected", err.Error())
+	default:
+		writeAPIError(w, http.StatusConflict, "link_error", err.Error())
+	}
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (uint32, bool) {
+	uid, err := strconv.ParseUint(r.PathValue("user_id"), 10, 32)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid user_id")
+		return 0, false
+	}
+	return uint32(uid), true
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return false
+	}
+	return true
+}
+
+func (g *JT809Gateway) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(openAPISpec)
+}
+
+func (g *JT809Gateway) accountView(acc Account) AccountView {
+	mainActive, subActive := g.store.GetLinkStatus(acc.UserID)
+	return AccountView{
+		UserID:       acc.UserID,
+		Password:     maskedPassword,
+		GnssCenterID: acc.GnssCenterID,
+		AllowIPs:     acc.AllowIPs,
+		MainLink:     mainActive,
+		SubLink:      subActive,
+
+		DuplicateLogin: acc.DuplicateLogin,
+	}
+}
+
+func (g *JT809Gateway) handleListAccounts(w http.ResponseWriter, r *http.Request) {
+	accs := g.auth.Accounts()
+	views := make([]AccountView, 0, len(accs))
+	for _, acc := range accs {
+		views = append(views, g.accountView(acc))
+	}
+	writeJSON(w, views)
+}
+
+func (g *JT809Gateway) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	acc, ok := g.auth.Lookup(uid)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "account_not_found", "account not found")
+		return
+	}
+	writeJSON(w, g.accountView(acc))
+}
+
+func (g *JT809Gateway) handleCreateAccount(w http.ResponseWriter, r *http.Request) {
+	var in accountInput
+	if !decodeJSONBody(w, r, &in) {
+		return
+	}
+	if in.UserID == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_argument", "user_id is required")
+		return
+	}
+	if _, exists := g.auth.Lookup(in.UserID); exists {
+		writeAPIError(w, http.StatusConflict, "account_exists", "account already exists")
+		return
+	}
+	acc, ok := g.saveAccount(w, in, Account{})
+	if !ok {
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, g.accountView(acc))
+}
+
+// handleUpdateAccount 新增或更新账号，请求体中 password 为空或为脱敏值时保留原密码。
+func (g *JT809Gateway) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	var in accountInput
+	if !decodeJSONBody(w, r, &in) {
+		return
+	}
+	if in.UserID != 0 && in.UserID != uid {
+		writeAPIError(w, http.StatusBadRequest, "invalid_argument", "user_id in body does not match path")
+		return
+	}
+	in.UserID = uid
+	existing, _ := g.auth.Lookup(uid)
+	acc, ok := g.saveAccount(w, in, existing)
+	if !ok {
+		return
+	}
+	writeJSON(w, g.accountView(acc))
+}
+
+func (g *JT809Gateway) saveAccount(w http.ResponseWriter, in accountInput, existing Account) (Account, bool) {
+	acc := Account{
+		UserID:       in.UserID,
+		Password:     in.Password,
+		GnssCenterID: in.GnssCenterID,
+		AllowIPs:     in.AllowIPs,
+
+		DuplicateLogin: in.DuplicateLogin,
+	}
+	if err := acc.DuplicateLogin.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+		return Account{}, false
+	}
+	if acc.Password == "" || acc.Password == maskedPassword {
+		acc.Password = existing.Password
+	}
+	if acc.Password == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_argument", "password is required")
+		return Account{}, false
+	}
+	if len(acc.AllowIPs) == 0 {
+		acc.AllowIPs = []string{"*"}
+	}
+	g.AddAccount(acc)
+	return acc, true
+}
+
+func (g *JT809Gateway) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	if _, exists := g.auth.Lookup(uid); !exists {
+		writeAPIError(w, http.StatusNotFound, "account_not_found", "account not found")
+		return
+	}
+	g.RemoveAccount(uid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *JT809Gateway) handleListPlatforms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, platformSnapshotsFor(r, g.store.Snapshots()...))
+}
+
+func (g *JT809Gateway) handleGetPlatform(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	snap, ok := g.store.Snapshot(uid)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "platform_not_found", "platform not found")
+		return
+	}
+	writeJSON(w, platformSnapshotsFor(r, snap)[0])
+}
+
+func (g *JT809Gateway) handleClosePlatformLink(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := g.ClosePlatformLink(uid, jtt809.CloseLinkOther); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "closed"})
+}
+
+func (g *JT809Gateway) handleCloseMainLink(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := g.CloseMainLink(uid, "closed by api"); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "closed"})
+}
+
+func (g *JT809Gateway) handleCloseSubLink(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := g.CloseSubLink(uid); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "closed"})
+}
+
+func (g *JT809Gateway) handleConnectSubLink(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := g.ConnectSubLink(uid); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusAccepted, map[string]string{"status": "connecting"})
+}
+
+// PacketDebugState 为平台报文调试转储开关。
+type PacketDebugState struct {
+	UserID  uint32 `json:"user_id"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (g *JT809Gateway) handleGetPacketDebug(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, PacketDebugState{UserID: uid, Enabled: g.PacketDebug(uid)})
+}
+
+// handleSetPacketDebug 开启或关闭平台的报文调试转储，配置文件 packet_log.debug_users 中的平台无法关闭。
+func (g *JT809Gateway) handleSetPacketDebug(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Enabled bool `json:"enabled"`
+	}
+	if !decodeJSONBody(w, r, &in) {
+		return
+	}
+	if _, ok := g.auth.Lookup(uid); !ok {
+		writeAPIError(w, http.StatusNotFound, "account_not_found", "account not found")
+		return
+	}
+	g.SetPacketDebug(uid, in.Enabled)
+	slog.Info("packet debug changed", "user_id", uid, "enabled", in.Enabled)
+	writeJSON(w, PacketDebugState{UserID: uid, Enabled: g.PacketDebug(uid)})
+}
+
+// handleListVehicles 分页查询车辆。
+// 参数：plate（车牌模糊匹配）、user_id、online=true|false、page（从 1 开始）、page_size。
+func (g *JT809Gateway) handleListVehicles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		userID    uint64
+		onlineSet bool
+		online    bool
+		err       error
+	)
+	if v := q.Get("user_id"); v != "" {
+		if userID, err = strconv.ParseUint(v, 10, 32); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid user_id")
+			return
+		}
+	}
+	if v := q.Get("online"); v != "" {
+		if online, err = strconv.ParseBool(v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid online")
+			return
+		}
+		onlineSet = true
+	}
+	page, pageSize, ok := parsePagination(w, q.Get("page"), q.Get("page_size"))
+	if !ok {
+		return
+	}
+	plate := strings.TrimSpace(q.Get("plate"))
+
+	now := time.Now()
+	var items []VehicleSummary
+	for _, snap := range g.store.Snapshots() {
+		if userID != 0 && snap.UserID != uint32(userID) {
+			continue
+		}
+		for _, v := range snap.Vehicles {
+			if plate != "" && !strings.Contains(v.VehicleNo, plate) {
+				continue
+			}
+			isOnline := g.vehicleOnline(snap.UserID, v, now)
+			if onlineSet && isOnline != online {
+				continue
+			}
+			items = append(items, VehicleSummary{
+				UserID:       snap.UserID,
+				VehicleNo:    v.VehicleNo,
+				VehicleColor: v.VehicleColor,
+				Online:       isOnline,
+				Registered:   v.Registration != nil,
+				PositionTime: v.PositionTime,
+				Longitude:    v.Longitude,
+				Latitude:     v.Latitude,
+			})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].UserID != items[j].UserID {
+			return items[i].UserID < items[j].UserID
+		}
+		return items[i].VehicleNo < items[j].VehicleNo
+	})
+	result := Page[VehicleSummary]{Total: len(items), Page: page, PageSize: pageSize, Items: []VehicleSummary{}}
+	// 先按条目数判断页码，避免超大页码相乘溢出
+	if page-1 < (len(items)+pageSize-1)/pageSize {
+		start := (page - 1) * pageSize
+		result.Items = items[start:min(start+pageSize, len(items))]
+	}
+	writeJSON(w, result)
+}
+
+// handleGetVehicle 返回单车详情，vehicle_color 默认为蓝色。
+func (g *JT809Gateway) handleGetVehicle(w http.ResponseWriter, r *http.Request) {
+	uid, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+	color := jtt809.PlateColorBlue
+	if v := r.URL.Query().Get("vehicle_color"); v != "" {
+		c, err := strconv.ParseUint(v, 0, 8)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid vehicle_color")
+			return
+		}
+		color = jtt809.PlateColor(c)
+	}
+	plate := r.PathValue("vehicle_no")
+	snap, ok := g.store.Snapshot(uid)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "platform_not_found", "platform not found")
+		return
+	}
+	for _, v := range snap.Vehicles {
+		if v.VehicleNo == plate && v.VehicleColor == color {
+			writeJSON(w, VehicleDetail{UserID: uid, Online: g.vehicleOnline(uid, v, time.Now()), VehicleSnapshot: v})
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "vehicle_not_found", "vehicle not found")
+}
+
+func (g *JT809Gateway) handleMonitor(startup bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MonitorRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if req.VehicleNo == "" {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "vehicle_no is required")
+			return
+		}
+		send := g.RequestMonitorEnd
+		if startup {
+			send = g.RequestMonitorStartup
+		}
+		if err := send(req); err != nil {
+			writeAPIError(w, http.StatusConflict, "send_failed", err.Error())
+			return
+		}
+		writeJSON(w, map[string]string{"status": "sent"})
+	}
+}
+
+func parsePagination(w http.ResponseWriter, pageStr, sizeStr string) (int, int, bool) {
+	page, pageSize := 1, defaultPageSize
+	var err error
+	if pageStr != "" {
+		if page, err = strconv.Atoi(pageStr); err != nil || page < 1 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid page")
+			return 0, 0, false
+		}
+	}
+	if sizeStr != "" {
+		if pageSize, err = strconv.Atoi(sizeStr); err != nil || pageSize < 1 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_argument", "invalid page_size")
+			return 0, 0, false
+		}
+		pageSize = min(pageSize, maxPageSize)
+	}
+	return page, pageSize, true
+}
+
+// handleGetRelay 返回级联上级平台链路状态，未配置 Relay 时返回 404。
+func (g *JT809Gateway) handleGetRelay(w http.ResponseWriter, r *http.Request) {
+	st, ok := g.RelayStatus()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "relay_not_configured", "relay not configured")
+		return
+	}
+	writeJSON(w, st)
+}
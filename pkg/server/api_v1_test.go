@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+	"gopkg.in/yaml.v3"
+)
+
+func newAPITestServer(t *testing.T) (*JT809Gateway, *httptest.Server) {
+	t.Helper()
+	g := &JT809Gateway{
+		auth:  NewAuthenticator([]Account{{UserID: 10001, Password: "secret", GnssCenterID: 1, AllowIPs: []string{"*"}}}),
+		store: NewPlatformStore(),
+	}
+	mux := http.NewServeMux()
+	g.registerAPIv1(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return g, srv
+}
+
+func doAPI(t *testing.T, method, url, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+func TestAPIAccounts(t *testing.T) {
+	g, srv := newAPITestServer(t)
+
+	resp, body := doAPI(t, http.MethodGet, srv.URL+"/api/v1/accounts", "")
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(body), "secret") || !strings.Contains(string(body), maskedPassword) {
+		t.Fatalf("expected masked account list, got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doAPI(t, http.MethodPost, srv.URL+"/api/v1/accounts", `{"user_id":10001,"password":"x"}`)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(string(body), `"code":"account_exists"`) {
+		t.Fatalf("expected conflict, got %d %s", resp.StatusCode, body)
+	}
+	resp, _ = doAPI(t, http.MethodPost, srv.URL+"/api/v1/accounts", `{"user_id":20001,"password":"p2","gnss_center_id":2}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected created, got %d", resp.StatusCode)
+	}
+
+	// 更新时传入脱敏密码保留原密码
+	resp, _ = doAPI(t, http.MethodPut, srv.URL+"/api/v1/accounts/20001", `{"password":"******","gnss_center_id":3}`)
+	if acc, _ := g.auth.Lookup(20001); resp.StatusCode != http.StatusOK || acc.Password != "p2" || acc.GnssCenterID != 3 {
+		t.Fatalf("unexpected update result %d %+v", resp.StatusCode, acc)
+	}
+
+	resp, _ = doAPI(t, http.MethodDelete, srv.URL+"/api/v1/accounts/20001", "")
+	if _, ok := g.auth.Lookup(20001); resp.StatusCode != http.StatusNoContent || ok {
+		t.Fatalf("expected account deleted, got %d", resp.StatusCode)
+	}
+	resp, body = doAPI(t, http.MethodGet, srv.URL+"/api/v1/accounts/20001", "")
+	var apiErr struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err != nil || resp.StatusCode != http.StatusNotFound || apiErr.Error.Code != "account_not_found" {
+		t.Fatalf("expected structured not found, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestAPIVehiclesAndLinks(t *testing.T) {
+	g, srv := newAPITestServer(t)
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 10001}, 1, 0)
+	for _, plate := range []string{"粤B00003", "粤B00001", "粤A00002"} {
+		g.store.UpdateLocation(10001, jtt809.PlateColorBlue, plate, &jtt809.VehiclePosition{}, 0)
+	}
+	g.store.UpdateVehicleRegistration(10001, jtt809.PlateColorYellow, "粤B00004", &VehicleRegistration{})
+
+	resp, body := doAPI(t, http.MethodGet, srv.URL+"/api/v1/vehicles?plate=粤B&online=true&page=2&page_size=1", "")
+	var page Page[VehicleSummary]
+	if err := json.Unmarshal(body, &page); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("list vehicles: %d %s", resp.StatusCode, body)
+	}
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].VehicleNo != "粤B00003" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	// 超大页码返回空页
+	resp, body = doAPI(t, http.MethodGet, srv.URL+"/api/v1/vehicles?page=9223372036854775807&page_size=100", "")
+	if err := json.Unmarshal(body, &page); err != nil || resp.StatusCode != http.StatusOK || len(page.Items) != 0 {
+		t.Fatalf("unexpected page beyond range: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doAPI(t, http.MethodGet, srv.URL+"/api/v1/vehicles/10001/粤B00004?vehicle_color=2", "")
+	var detail VehicleDetail
+	if err := json.Unmarshal(body, &detail); err != nil || resp.StatusCode != http.StatusOK || detail.Online || detail.Registration == nil {
+		t.Fatalf("unexpected detail %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = doAPI(t, http.MethodGet, srv.URL+"/api/v1/vehicles/10001/粤B00004", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected color mismatch to be not found, got %d", resp.StatusCode)
+	}
+
+	resp, body = doAPI(t, http.MethodPost, srv.URL+"/api/v1/platforms/10001/links/sub/close", "")
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(string(body), "link_not_connected") {
+		t.Fatalf("expected sub link not connected, got %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = doAPI(t, http.MethodPost, srv.URL+"/api/v1/platforms/99/links/sub/connect", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown platform, got %d", resp.StatusCode)
+	}
+	if resp, _ = doAPI(t, http.MethodPost, srv.URL+"/api/v1/monitor/startup", `{"user_id":10001}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected monitor validation error, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIOpenAPIDocument(t *testing.T) {
+	_, srv := newAPITestServer(t)
+	resp, body := doAPI(t, http.MethodGet, srv.URL+"/api/v1/openapi.yaml", "")
+	var doc struct {
+		OpenAPI string         `yaml:"openapi"`
+		Paths   map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(body, &doc); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("invalid openapi document: %v", err)
+	}
+	for _, p := range []string{"/accounts/{user_id}", "/vehicles", "/platforms/{user_id}/links/sub/connect", "/monitor/end"} {
+		if _, ok := doc.Paths[p]; !ok {
+			t.Fatalf("openapi document missing %s", p)
+		}
+	}
+}
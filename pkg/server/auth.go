@@ -2,6 +2,7 @@ package server
 
 import (
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -89,3 +90,26 @@ func (a *Authenticator) RemoveAccount(userID uint32) bool {
 	delete(a.accounts, userID)
 	return true
 }
+
+// UserIDs 返回全部已配置账号的用户ID。
+func (a *Authenticator) UserIDs() []uint32 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	ids := make([]uint32, 0, len(a.accounts))
+	for uid := range a.accounts {
+		ids = append(ids, uid)
+	}
+	return ids
+}
+
+// Accounts 返回全部账号，按用户ID升序。
+func (a *Authenticator) Accounts() []Account {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]Account, 0, len(a.accounts))
+	for _, acc := range a.accounts {
+		out = append(out, acc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/zboyco/jtt809/pkg/jtt809"
 )
 
@@ -11,6 +13,10 @@ type Callbacks struct {
 	// 参数: userID - 用户ID, req - 登录请求, resp - 登录应答
 	OnLogin func(userID uint32, req *jtt809.LoginRequest, resp *jtt809.LoginResponse)
 
+	// OnLogout 平台登出回调
+	// 参数: userID - 用户ID, reason - logout（收到 0x1003 主链路注销请求）或 kicked（重复登录被新连接顶替）
+	OnLogout func(userID uint32, reason string)
+
 	// OnVehicleRegistration 车辆注册消息回调
 	// 参数: userID - 用户ID, plate - 车牌号, color - 车牌颜色, reg - 注册信息
 	OnVehicleRegistration func(userID uint32, plate string, color jtt809.PlateColor, reg *VehicleRegistration)
@@ -46,4 +52,32 @@ type Callbacks struct {
 	// OnWarnMsgInformTips 报警预警消息回调（0x1400 子业务 0x1403）
 	// 参数: userID - 用户ID, info - 报警预警信息
 	OnWarnMsgInformTips func(userID uint32, info *jtt809.WarnMsgInformTips)
+
+	// OnGeofenceEvent 电子围栏事件回调（进出区域、偏离/回到线路）
+	// 参数: userID - 用户ID, evt - 围栏事件
+	OnGeofenceEvent func(userID uint32, evt *GeofenceEvent)
+
+	// OnRuleAlarm 平台侧违规判定报警回调，报警开始与结束时各触发一次（结束时 Active 为 false）
+	// 参数: userID - 用户ID, alarm - 报警记录
+	OnRuleAlarm func(userID uint32, alarm *RuleAlarm)
+
+	// OnVehicleOnline 车辆上线回调，离线后首次收到实时定位时触发
+	// 参数: userID - 用户ID, plate - 车牌号, color - 车牌颜色
+	OnVehicleOnline func(userID uint32, plate string, color jtt809.PlateColor)
+
+	// OnVehicleOffline 车辆离线回调，定位中断超过 VehiclePolicy.OfflineAfter 时触发
+	// 参数: userID - 用户ID, plate - 车牌号, color - 车牌颜色, lastPosition - 最后一次定位的接收时间
+	OnVehicleOffline func(userID uint32, plate string, color jtt809.PlateColor, lastPosition time.Time)
+
+	// OnVehicleEvicted 车辆从内存删除回调
+	// 参数: userID - 用户ID, plate - 车牌号, color - 车牌颜色, reason - registration_expired（注册后未上报定位）或 position_timeout（定位中断超过 EvictAfter）
+	OnVehicleEvicted func(userID uint32, plate string, color jtt809.PlateColor, reason string)
+
+	// OnLinkStateChange 链路状态变化回调
+	// 参数: userID - 用户ID, link - main 或 sub, state - 链路状态, reason - 断开或失败原因
+	OnLinkStateChange func(userID uint32, link string, state LinkState, reason string)
+
+	// OnQualityIssue 定位数据质量问题回调（0x1202/0x1203 中被标记的定位点）
+	// 参数: userID - 用户ID, evt - 质量事件
+	OnQualityIssue func(userID uint32, evt *QualityEvent)
 }
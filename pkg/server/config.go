@@ -11,19 +11,119 @@ import (
 
 // Config 保存服务运行参数。
 type Config struct {
-	MainListen string
-	HTTPListen string
+	// PlatformID 本级平台唯一编码，用作下发报警预警等消息的源平台编码
+	PlatformID string `yaml:"platform_id"`
 
-	IdleTimeout time.Duration
-	Accounts    []Account
+	MainListen string `yaml:"main_listen"`
+	HTTPListen string `yaml:"http_listen"`
+
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	Accounts    []Account     `yaml:"accounts"`
+
+	// DataDir 本地数据目录，非空时平台状态持久化到 DataDir/state，重启后自动恢复
+	DataDir string `yaml:"data_dir"`
+	// PersistInterval 平台状态落盘间隔，<=0 时使用默认值 5s
+	PersistInterval time.Duration `yaml:"persist_interval"`
+	// TrackRetentionDays 历史轨迹保留天数（存储于 DataDir/track），<=0 表示永久保留
+	TrackRetentionDays int `yaml:"track_retention_days"`
+	// Rules 平台侧超速、疲劳、夜间禁行等违规判定参数，nil 时使用 DefaultRuleConfig
+	Rules *RuleConfig `yaml:"-"`
+	// Quality 定位数据质量检查参数，nil 时使用 DefaultQualityConfig
+	Quality *QualityConfig `yaml:"-"`
+	// LinkPolicies 按消息 ID 覆盖默认的下发链路策略，支持热更新
+	LinkPolicies map[uint16]LinkPolicy `yaml:"link_policies"`
+	// VehiclePolicy 车辆自动订阅、离线判定与淘汰策略，支持热更新
+	VehiclePolicy VehiclePolicy `yaml:"vehicle_policy"`
+	// VehiclePolicyOverrides 按账号覆盖 VehiclePolicy，仅非零字段生效
+	VehiclePolicyOverrides map[uint32]VehiclePolicy `yaml:"vehicle_policy_overrides"`
+	// LinkTiming 从链路重连退避、心跳超时与主链路看门狗参数，支持热更新
+	LinkTiming LinkTiming `yaml:"link_timing"`
+	// LinkTimingOverrides 按账号覆盖 LinkTiming，仅非零字段生效
+	LinkTimingOverrides map[uint32]LinkTiming `yaml:"link_timing_overrides"`
+	// PacketLog 报文十六进制转储的级别、采样比例与调试平台，支持热更新
+	PacketLog PacketLogConfig `yaml:"packet_log"`
+	// CallbackDispatch 回调工作协程数、队列长度、队列满处理方式与保序粒度，未配置的字段使用默认值
+	CallbackDispatch CallbackDispatchConfig `yaml:"callback_dispatch"`
+	// HealthCheckInterval 链路健康检查间隔（主链路断开保留、主链路心跳看门狗），<=0 时使用默认值 60s
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// ShutdownTimeout 关闭平台链路时等待 0x9004 应答及停机时等待回调完成的时限，<=0 时使用默认值 5s
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// OutboundQueue 主从链路均不可用时下行业务报文的暂存队列，nil 表示不暂存；落盘目录未配置时使用 DataDir/outbound
+	OutboundQueue *OutboundQueueConfig `yaml:"outbound_queue"`
+	// CheckTimeout 平台查岗应答时限，超时应答不计入查岗响应率，<=0 时使用默认值 10 分钟
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// Webhook 事件推送配置，nil 表示不推送；重试队列目录未配置时使用 DataDir/webhook
+	Webhook *WebhookConfig `yaml:"webhook"`
+	// MQTT 定位与报警 MQTT 推送配置，nil 表示不推送
+	MQTT *MQTTConfig `yaml:"mqtt"`
+	// Kafka 定位、车辆注册与报警 Kafka 推送配置，nil 表示不推送
+	Kafka *KafkaConfig `yaml:"kafka"`
+	// HTTPAuth 管理接口认证与审计配置，nil 表示不认证
+	HTTPAuth *HTTPAuthConfig `yaml:"http_auth"`
+	// HTTPTLS 管理接口 TLS 配置，nil 表示使用明文 HTTP
+	HTTPTLS *HTTPTLSConfig `yaml:"http_tls"`
+	// Relay 级联上级平台配置，nil 表示不转发
+	Relay *RelayConfig `yaml:"relay"`
 }
 
 // Account 表示允许接入的下级平台注册信息。
 type Account struct {
-	UserID       uint32
-	Password     string
-	GnssCenterID uint32
-	AllowIPs     []string
+	UserID       uint32   `yaml:"user_id"`
+	Password     string   `yaml:"password"`
+	GnssCenterID uint32   `yaml:"gnss_center_id"`
+	AllowIPs     []string `yaml:"allow_ips"`
+	// DuplicateLogin 重复登录处理方式：kick（默认）、reject 或 allow
+	DuplicateLogin DuplicateLoginPolicy `yaml:"duplicate_login"`
+}
+
+// Validate 校验配置中可热更新部分的合法性。
+func (c Config) Validate() error {
+	if c.MainListen == "" {
+		return errors.New("main listen address is required")
+	}
+	seen := make(map[uint32]struct{}, len(c.Accounts))
+	for _, acc := range c.Accounts {
+		if acc.UserID == 0 {
+			return errors.New("account user_id is required")
+		}
+		if _, ok := seen[acc.UserID]; ok {
+			return fmt.Errorf("duplicate account %d", acc.UserID)
+		}
+		if err := acc.DuplicateLogin.validate(); err != nil {
+			return fmt.Errorf("account %d: %w", acc.UserID, err)
+		}
+		seen[acc.UserID] = struct{}{}
+	}
+	for msgID, p := range c.LinkPolicies {
+		switch p.PreferredLink {
+		case "main", "sub", "both":
+		default:
+			return fmt.Errorf("link policy 0x%04X: preferred_link must be main, sub or both", msgID)
+		}
+	}
+	if err := c.VehiclePolicy.withDefaults().validate(); err != nil {
+		return err
+	}
+	for uid, override := range c.VehiclePolicyOverrides {
+		if err := c.VehiclePolicy.merge(override).withDefaults().validate(); err != nil {
+			return fmt.Errorf("vehicle policy for account %d: %w", uid, err)
+		}
+	}
+	if err := c.LinkTiming.withDefaults().validate(); err != nil {
+		return err
+	}
+	for uid, override := range c.LinkTimingOverrides {
+		if err := c.LinkTiming.merge(override).withDefaults().validate(); err != nil {
+			return fmt.Errorf("link timing for account %d: %w", uid, err)
+		}
+	}
+	if err := c.PacketLog.withDefaults().validate(); err != nil {
+		return err
+	}
+	if err := c.CallbackDispatch.withDefaults().validate(); err != nil {
+		return err
+	}
+	return nil
 }
 
 // normalizeHostPort 将 host:port 字符串拆分为 host 与 port，便于 go-server 初始化。
@@ -100,3 +200,31 @@ func parseAllowIPs(parts []string) []string {
 	}
 	return allow
 }
+
+// MultiAPIKeyFlag 支持重复声明管理接口 API Key，格式 key:role[:name]。
+type MultiAPIKeyFlag []APIKey
+
+func (m *MultiAPIKeyFlag) String() string {
+	parts := make([]string, 0, len(*m))
+	for _, k := range *m {
+		parts = append(parts, fmt.Sprintf("***:%s:%s", k.Role, k.Name))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *MultiAPIKeyFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return errors.New("api key must be formatted as key:role[:name]")
+	}
+	role, err := ParseRole(parts[1])
+	if err != nil {
+		return err
+	}
+	name := string(role)
+	if len(parts) == 3 && parts[2] != "" {
+		name = parts[2]
+	}
+	*m = append(*m, APIKey{Key: parts[0], Role: role, Name: name})
+	return nil
+}
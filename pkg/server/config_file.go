@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig 为 YAML 配置文件结构，在 Config 基础上增加视频代理配置。
+type FileConfig struct {
+	Config `yaml:",inline"`
+	Video  VideoConfig `yaml:"video"`
+}
+
+// VideoConfig JT/T 1078 RTP 代理配置。
+type VideoConfig struct {
+	// Disabled 为 true 时不启用 RTP 代理，管理接口不提供 /proxy
+	Disabled bool `yaml:"disabled"`
+	// Listen 独立代理监听地址，为空时仅通过管理接口的 /proxy 提供
+	Listen string `yaml:"listen"`
+}
+
+// LoadConfigFile 读取并校验 YAML 配置文件，未知字段视为错误以便发现拼写问题。
+func LoadConfigFile(path string) (FileConfig, error) {
+	var fc FileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fc, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := fc.Validate(); err != nil {
+		return fc, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// runtimePolicy 为可热更新的网关策略，整体替换，读取时无需加锁。
+type runtimePolicy struct {
+	links            map[uint16]LinkPolicy
+	vehicle          VehiclePolicy
+	vehicleOverrides map[uint32]VehiclePolicy
+	timing           LinkTiming
+	timingOverrides  map[uint32]LinkTiming
+	packetLog        PacketLogConfig
+}
+
+func newRuntimePolicy(cfg Config) *runtimePolicy {
+	links := maps.Clone(linkPolicies)
+	maps.Copy(links, cfg.LinkPolicies)
+	return &runtimePolicy{
+		links:            links,
+		vehicle:          cfg.VehiclePolicy,
+		vehicleOverrides: maps.Clone(cfg.VehiclePolicyOverrides),
+		timing:           cfg.LinkTiming,
+		timingOverrides:  maps.Clone(cfg.LinkTimingOverrides),
+		packetLog:        cfg.PacketLog.withDefaults(),
+	}
+}
+
+// linkPolicy 返回消息的下发链路策略，未配置时使用默认策略。
+func (p *runtimePolicy) linkPolicy(msgID uint16) LinkPolicy {
+	if policy, ok := p.links[msgID]; ok {
+		return policy
+	}
+	return defaultLinkPolicy
+}
+
+// currentPolicy 返回当前生效的策略，未经 NewJT809Gateway 创建的网关按 cfg 初始化。
+func (g *JT809Gateway) currentPolicy() *runtimePolicy {
+	if p := g.policy.Load(); p != nil {
+		return p
+	}
+	g.policy.CompareAndSwap(nil, newRuntimePolicy(g.cfg))
+	return g.policy.Load()
+}
+
+// ApplyConfig 热更新账号与策略。账号按差异新增、更新或删除，仅被删除账号的链路会断开，
+// 已建立的链路不受密码等变更影响；监听地址、数据目录、事件推送与管理接口认证等需重启生效，变化时记录告警。
+// 配置文件是账号的唯一来源，通过管理接口新增但未写入文件的账号会在重载时删除。
+// 需重启的配置项与上一次应用的配置比较，同一变化只告警一次。
+func (g *JT809Gateway) ApplyConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	g.applyMu.Lock()
+	defer g.applyMu.Unlock()
+	current := make(map[uint32]Account)
+	for _, acc := range g.auth.Accounts() {
+		current[acc.UserID] = acc
+	}
+	var changed []Account
+	for _, acc := range cfg.Accounts {
+		old, ok := current[acc.UserID]
+		delete(current, acc.UserID)
+		if ok && accountEqual(old, acc) {
+			continue
+		}
+		changed = append(changed, acc)
+	}
+	removed := slices.Sorted(maps.Keys(current))
+
+	g.AddAccounts(changed)
+	for _, uid := range removed {
+		g.RemoveAccount(uid)
+	}
+	g.policy.Store(newRuntimePolicy(cfg))
+
+	prev := g.applied
+	if prev == nil {
+		prev = &g.cfg
+	}
+	for _, field := range restartRequiredFields(*prev, cfg) {
+		slog.Warn("config change requires restart to take effect", "field", field)
+	}
+	g.applied = &cfg
+	slog.Info("config applied", "accounts", len(cfg.Accounts), "changed", len(changed), "removed", len(removed))
+	return nil
+}
+
+func accountEqual(a, b Account) bool {
+	return a.Password == b.Password && a.GnssCenterID == b.GnssCenterID && slices.Equal(a.AllowIPs, b.AllowIPs) && a.DuplicateLogin == b.DuplicateLogin
+}
+
+// restartRequiredFields 返回不支持热更新且发生变化的配置项。
+func restartRequiredFields(old, cur Config) []string {
+	fields := map[string]bool{
+		"platform_id":           old.PlatformID != cur.PlatformID,
+		"main_listen":           old.MainListen != cur.MainListen,
+		"http_listen":           old.HTTPListen != cur.HTTPListen,
+		"idle_timeout":          old.IdleTimeout != cur.IdleTimeout,
+		"data_dir":              old.DataDir != cur.DataDir,
+		"persist_interval":      old.PersistInterval != cur.PersistInterval,
+		"track_retention_days":  old.TrackRetentionDays != cur.TrackRetentionDays,
+		"check_timeout":         old.CheckTimeout != cur.CheckTimeout,
+		"health_check_interval": old.HealthCheckInterval != cur.HealthCheckInterval,
+		"shutdown_timeout":      old.ShutdownTimeout != cur.ShutdownTimeout,
+		"outbound_queue":        !reflect.DeepEqual(old.OutboundQueue, cur.OutboundQueue),
+		"callback_dispatch":     old.CallbackDispatch != cur.CallbackDispatch,
+		"webhook":               !reflect.DeepEqual(old.Webhook, cur.Webhook),
+		"mqtt":                  !reflect.DeepEqual(old.MQTT, cur.MQTT),
+		"kafka":                 !reflect.DeepEqual(old.Kafka, cur.Kafka),
+		"http_auth":             !reflect.DeepEqual(old.HTTPAuth, cur.HTTPAuth),
+		"http_tls":              !reflect.DeepEqual(old.HTTPTLS, cur.HTTPTLS),
+		"relay":                 !reflect.DeepEqual(old.Relay, cur.Relay),
+	}
+	var out []string
+	for name, changed := range fields {
+		if changed {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ReloadConfigFile 重新读取配置文件并应用，文件有误时保留当前配置。
+func (g *JT809Gateway) ReloadConfigFile(path string) error {
+	fc, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return g.ApplyConfig(fc.Config)
+}
+
+// WatchConfigFile 定期检查配置文件的修改时间与大小，变化时自动重载，直到 ctx 结束。
+// interval<=0 时使用默认值 2s。
+func (g *JT809Gateway) WatchConfigFile(ctx context.Context, path string, interval time.Duration) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	lastMod, lastSize := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mod, size := stat()
+			if size < 0 || (mod.Equal(lastMod) && size == lastSize) {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			slog.Info("config file changed, reloading", "path", path)
+			if err := g.ReloadConfigFile(path); err != nil {
+				slog.Error("reload config failed, keeping current config", "path", path, "err", err)
+			}
+		}
+	}
+}
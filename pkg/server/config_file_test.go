@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+const testConfigYAML = `
+main_listen: ":10709"
+http_listen: ":18080"
+idle_timeout: 5m
+accounts:
+  - user_id: 10001
+    password: pass809
+    gnss_center_id: 324469864
+    allow_ips: ["10.0.0.1"]
+  - user_id: 10002
+    password: other
+    gnss_center_id: 2
+link_policies:
+  0x9200: { preferred_link: main, allow_fallback: false }
+vehicle_policy:
+  resubscribe_after: 20m
+  offline_after: 1h
+  evict_after: 2h
+video:
+  listen: ":18081"
+kafka:
+  brokers: ["127.0.0.1:9092"]
+  linger: 50ms
+http_auth:
+  api_keys:
+    - { key: k1, name: ops, role: dispatcher }
+`
+
+func writeTestConfig(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeTestConfig(t, path, testConfigYAML)
+	fc, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if fc.MainListen != ":10709" || fc.IdleTimeout != 5*time.Minute || len(fc.Accounts) != 2 || fc.Accounts[0].AllowIPs[0] != "10.0.0.1" {
+		t.Fatalf("unexpected config %+v", fc.Config)
+	}
+	if p := fc.LinkPolicies[jtt809.DOWN_EXG_MSG]; p.PreferredLink != "main" {
+		t.Fatalf("unexpected link policies %+v", fc.LinkPolicies)
+	}
+	if fc.Video.Listen != ":18081" || fc.Kafka.Linger != 50*time.Millisecond || fc.HTTPAuth.APIKeys[0].Role != RoleDispatcher {
+		t.Fatalf("unexpected sections %+v %+v %+v", fc.Video, fc.Kafka, fc.HTTPAuth)
+	}
+	vp := fc.VehiclePolicy.withDefaults()
+	if vp.RegistrationExpiry != 10*time.Minute || vp.OfflineAfter != time.Hour {
+		t.Fatalf("unexpected vehicle policy %+v", vp)
+	}
+
+	for name, data := range map[string]string{
+		"unknown field":  testConfigYAML + "mian_listen: x\n",
+		"bad link":       "main_listen: \":1\"\nlink_policies:\n  0x1006: { preferred_link: up }\n",
+		"duplicate user": "main_listen: \":1\"\naccounts:\n  - { user_id: 1 }\n  - { user_id: 1 }\n",
+		"bad timeouts":   "main_listen: \":1\"\nvehicle_policy: { resubscribe_after: 1h }\n",
+	} {
+		writeTestConfig(t, path, data)
+		if _, err := LoadConfigFile(path); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestApplyConfigDiffsAccounts(t *testing.T) {
+	g := &JT809Gateway{
+		cfg: Config{MainListen: ":10709"},
+		auth: NewAuthenticator([]Account{
+			{UserID: 1, Password: "a", GnssCenterID: 1},
+			{UserID: 2, Password: "b", GnssCenterID: 2},
+			{UserID: 3, Password: "c", GnssCenterID: 3},
+		}),
+		store: NewPlatformStore(),
+	}
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1}, 1, 0)
+	g.store.BindMainSession("s2", jtt809.LoginRequest{UserID: 2}, 2, 0)
+
+	err := g.ApplyConfig(Config{
+		MainListen: ":10709",
+		Accounts: []Account{
+			{UserID: 1, Password: "a", GnssCenterID: 1},
+			{UserID: 2, Password: "b2", GnssCenterID: 2},
+			{UserID: 4, Password: "d", GnssCenterID: 4},
+		},
+		LinkPolicies:  map[uint16]LinkPolicy{jtt809.UP_LINKTEST_RSP: {PreferredLink: "main"}},
+		VehiclePolicy: VehiclePolicy{OfflineAfter: time.Hour, EvictAfter: 2 * time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("apply config: %v", err)
+	}
+	if acc, _ := g.auth.Lookup(2); acc.Password != "b2" {
+		t.Fatalf("expected account 2 updated, got %+v", acc)
+	}
+	if _, ok := g.auth.Lookup(3); ok {
+		t.Fatal("expected account 3 removed")
+	}
+	if _, ok := g.auth.Lookup(4); !ok {
+		t.Fatal("expected account 4 added")
+	}
+	// 未删除账号的链路保持不变
+	for uid, sid := range map[uint32]string{1: "s1", 2: "s2"} {
+		if got, _, _, _ := g.store.PlatformLinks(uid); got != sid {
+			t.Fatalf("expected account %d main link kept, got %q", uid, got)
+		}
+	}
+	p := g.currentPolicy()
+	if p.linkPolicy(jtt809.UP_LINKTEST_RSP).PreferredLink != "main" || p.linkPolicy(jtt809.DOWN_CONNECT_REQ).PreferredLink != "sub" {
+		t.Fatalf("unexpected link policies %+v", p.links)
+	}
+	if vp := p.vehiclePolicy(1); vp.OfflineAfter != time.Hour || vp.ResubscribeAfter != 5*time.Minute {
+		t.Fatalf("unexpected vehicle policy %+v", vp)
+	}
+
+	// 需重启的变化只与上一次应用的配置比较，重复重载不再告警
+	moved := Config{MainListen: ":10710", Accounts: []Account{{UserID: 1, Password: "a", GnssCenterID: 1}}}
+	if err := g.ApplyConfig(moved); err != nil {
+		t.Fatalf("apply config: %v", err)
+	}
+	if fields := restartRequiredFields(*g.applied, moved); len(fields) != 0 {
+		t.Fatalf("expected applied config stored, still differs in %v", fields)
+	}
+
+	if err := g.ApplyConfig(Config{Accounts: []Account{{UserID: 9}}}); err == nil {
+		t.Fatal("expected invalid config rejected")
+	}
+	if _, ok := g.auth.Lookup(1); !ok {
+		t.Fatal("invalid config must not change accounts")
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeTestConfig(t, path, "main_listen: \":10709\"\naccounts:\n  - { user_id: 1, password: a }\n")
+	g := &JT809Gateway{
+		cfg:   Config{MainListen: ":10709"},
+		auth:  NewAuthenticator([]Account{{UserID: 1, Password: "a"}}),
+		store: NewPlatformStore(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.WatchConfigFile(ctx, path, 10*time.Millisecond)
+
+	time.Sleep(30 * time.Millisecond)
+	writeTestConfig(t, path, "main_listen: \":10709\"\naccounts:\n  - { user_id: 1, password: a }\n  - { user_id: 2, password: b }\n")
+	waitFor(t, func() bool {
+		_, ok := g.auth.Lookup(2)
+		return ok
+	})
+
+	// 内容有误时保留当前配置
+	writeTestConfig(t, path, "accounts: [")
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := g.auth.Lookup(2); !ok {
+		t.Fatal("broken config must not change accounts")
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 回调队列满时的处理方式
+const (
+	QueueFullBlock      = "block"       // 阻塞调用方直到队列有空位
+	QueueFullDropOldest = "drop_oldest" // 丢弃队列中最早的回调
+	QueueFullDropNewest = "drop_newest" // 丢弃新回调
+)
+
+// callbackDropClosed 为停机后提交的回调的丢弃原因，用于 jtt809_callbacks_dropped_total 的 policy 标签。
+const callbackDropClosed = "closed"
+
+// CallbackDispatchConfig 回调执行参数，字段为空时使用默认值。
+// 回调按平台与车辆分配到固定的工作协程，同一车辆（OrderBy 为 platform 时为同一平台）的回调按触发顺序串行执行。
+type CallbackDispatchConfig struct {
+	// Workers 工作协程数，默认 16
+	Workers int `yaml:"workers"`
+	// QueueSize 每个工作协程的队列长度，默认 1024
+	QueueSize int `yaml:"queue_size"`
+	// QueueFullPolicy 队列满时的处理方式：block（默认）、drop_oldest 或 drop_newest。
+	// block 会阻塞报文接收，回调中同步触发新回调时可能因队列已满死锁
+	QueueFullPolicy string `yaml:"queue_full_policy"`
+	// OrderBy 保序粒度：vehicle（默认）或 platform。平台级回调（登录、链路状态等）不区分车辆，
+	// 按 vehicle 保序时与该平台车辆回调之间不保证顺序
+	OrderBy string `yaml:"order_by"`
+	// MaxRetries EventHandler 返回错误时的重试次数，默认 0 不重试。重试在同一工作协程中进行，期间阻塞后续回调
+	MaxRetries int `yaml:"max_retries"`
+	// RetryBackoff 首次重试前的等待时长，之后每次加倍，默认 1s
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+func (c CallbackDispatchConfig) withDefaults() CallbackDispatchConfig {
+	if c.Workers <= 0 {
+		c.Workers = 16
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1024
+	}
+	if c.QueueFullPolicy == "" {
+		c.QueueFullPolicy = QueueFullBlock
+	}
+	if c.OrderBy == "" {
+		c.OrderBy = "vehicle"
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = time.Second
+	}
+	return c
+}
+
+func (c CallbackDispatchConfig) validate() error {
+	switch c.QueueFullPolicy {
+	case QueueFullBlock, QueueFullDropOldest, QueueFullDropNewest:
+	default:
+		return errors.New("callback_dispatch: queue_full_policy must be block, drop_oldest or drop_newest")
+	}
+	switch c.OrderBy {
+	case "vehicle", "platform":
+	default:
+		return errors.New("callback_dispatch: order_by must be vehicle or platform")
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("callback_dispatch: max_retries must not be negative")
+	}
+	return nil
+}
+
+type callbackTask struct {
+	name     string
+	userID   uint32
+	fn       func(ctx context.Context) error
+	queuedAt time.Time
+}
+
+// callbackWorker 为单个工作协程的有界队列。
+type callbackWorker struct {
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	tasks    []callbackTask
+	closed   bool // 停机后不再接收回调，队列清空后工作协程退出
+}
+
+// callbackDispatcher 将回调分配到固定数量的工作协程执行。
+type callbackDispatcher struct {
+	cfg     CallbackDispatchConfig
+	seed    maphash.Seed
+	workers []*callbackWorker
+	ctx     context.Context // 传给回调，停机超时后取消
+	cancel  context.CancelFunc
+	running sync.WaitGroup // 运行中的工作协程
+}
+
+func newCallbackDispatcher(cfg CallbackDispatchConfig) *callbackDispatcher {
+	d := &callbackDispatcher{cfg: cfg.withDefaults(), seed: maphash.MakeSeed()}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.workers = make([]*callbackWorker, d.cfg.Workers)
+	for i := range d.workers {
+		w := &callbackWorker{}
+		w.notEmpty.L = &w.mu
+		w.notFull.L = &w.mu
+		d.workers[i] = w
+	}
+	return d
+}
+
+// worker 按平台与车牌选择工作协程。
+func (d *callbackDispatcher) worker(userID uint32, plate string) *callbackWorker {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], userID)
+	h.Write(buf[:])
+	if d.cfg.OrderBy == "vehicle" {
+		h.WriteString(plate)
+	}
+	return d.workers[h.Sum64()%uint64(len(d.workers))]
+}
+
+// push 将回调加入队列，返回被丢弃的回调及原因（队列满时的处理方式或 closed），未丢弃时 reason 为空。
+func (d *callbackDispatcher) push(w *callbackWorker, task callbackTask) (dropped callbackTask, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return task, callbackDropClosed
+	}
+	switch {
+	case len(w.tasks) < d.cfg.QueueSize:
+	case d.cfg.QueueFullPolicy == QueueFullDropNewest:
+		return task, QueueFullDropNewest
+	case d.cfg.QueueFullPolicy == QueueFullDropOldest:
+		dropped, w.tasks = w.tasks[0], w.tasks[1:]
+		reason = QueueFullDropOldest
+	default:
+		for len(w.tasks) >= d.cfg.QueueSize && !w.closed {
+			w.notFull.Wait()
+		}
+		if w.closed {
+			return task, callbackDropClosed
+		}
+	}
+	w.tasks = append(w.tasks, task)
+	w.notEmpty.Signal()
+	return dropped, reason
+}
+
+// pop 取出队首回调，队列为空时等待；已停止且队列为空时返回 false。
+func (w *callbackWorker) pop() (callbackTask, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.tasks) == 0 && !w.closed {
+		w.notEmpty.Wait()
+	}
+	if len(w.tasks) == 0 {
+		return callbackTask{}, false
+	}
+	task := w.tasks[0]
+	w.tasks[0] = callbackTask{}
+	w.tasks = w.tasks[1:]
+	w.notFull.Signal()
+	return task, true
+}
+
+// stop 停止接收回调，工作协程执行完队列中剩余的回调后退出。
+func (d *callbackDispatcher) stop() {
+	for _, w := range d.workers {
+		w.mu.Lock()
+		w.closed = true
+		w.notEmpty.Broadcast()
+		w.notFull.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// depth 返回全部队列中等待执行的回调数。
+func (d *callbackDispatcher) depth() int {
+	n := 0
+	for _, w := range d.workers {
+		w.mu.Lock()
+		n += len(w.tasks)
+		w.mu.Unlock()
+	}
+	return n
+}
+
+// callbackDispatcher 返回回调调度器，首次调用时按配置启动工作协程。
+func (g *JT809Gateway) callbackDispatcher() *callbackDispatcher {
+	g.dispatcherOnce.Do(func() {
+		g.dispatcher = newCallbackDispatcher(g.cfg.CallbackDispatch)
+		g.dispatcher.running.Add(len(g.dispatcher.workers))
+		for _, w := range g.dispatcher.workers {
+			go g.runCallbackWorker(w)
+		}
+	})
+	return g.dispatcher
+}
+
+// runCallback 将回调交给平台与车辆对应的工作协程执行，同一车辆的回调按调用顺序执行。
+// 平台级回调 plate 为空。
+func (g *JT809Gateway) runCallback(name string, userID uint32, plate string, fn func(ctx context.Context) error) {
+	d := g.callbackDispatcher()
+	g.inflight.Add(1)
+	dropped, reason := d.push(d.worker(userID, plate), callbackTask{name: name, userID: userID, fn: fn, queuedAt: time.Now()})
+	switch reason {
+	case "":
+	case callbackDropClosed:
+		g.inflight.Add(-1)
+		g.metrics.callbacksDropped.inc(dropped.name, reason)
+		slog.Debug("callback dispatcher stopped, callback dropped", "callback", dropped.name, "user_id", dropped.userID)
+	default:
+		g.inflight.Add(-1)
+		g.metrics.callbacksDropped.inc(dropped.name, reason)
+		slog.Warn("callback queue full, callback dropped", "callback", dropped.name, "user_id", dropped.userID, "policy", reason)
+	}
+}
+
+func (g *JT809Gateway) runCallbackWorker(w *callbackWorker) {
+	defer g.dispatcher.running.Done()
+	for {
+		task, ok := w.pop()
+		if !ok {
+			return
+		}
+		g.execCallback(task)
+	}
+}
+
+// execCallback 执行回调，返回错误时按配置退避重试，ctx 取消后不再重试。
+func (g *JT809Gateway) execCallback(task callbackTask) {
+	defer g.inflight.Add(-1)
+	d := g.dispatcher
+	g.metrics.callbackQueueWait.observe(time.Since(task.queuedAt).Seconds(), task.name)
+	backoff := d.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		panicked, err := g.callOnce(d.ctx, task)
+		if err == nil || panicked {
+			return
+		}
+		g.metrics.callbackErrors.inc(task.name)
+		if attempt >= d.cfg.MaxRetries || d.ctx.Err() != nil {
+			slog.Warn("callback failed", "callback", task.name, "user_id", task.userID, "attempts", attempt+1, "err", err)
+			return
+		}
+		g.metrics.callbackRetries.inc(task.name)
+		select {
+		case <-d.ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// callOnce 执行一次回调并记录耗时，回调 panic 时记录后返回，不影响后续回调。
+func (g *JT809Gateway) callOnce(ctx context.Context, task callbackTask) (panicked bool, err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			g.metrics.callbackPanics.inc(task.name)
+			slog.Error("callback panic", "callback", task.name, "panic", r, "stack", string(debug.Stack()))
+		}
+		g.metrics.callbackDuration.observe(time.Since(start).Seconds(), task.name)
+	}()
+	return false, task.fn(ctx)
+}
+
+// cancelCallbacks 取消传给回调的 ctx，用于停机超时。
+func (g *JT809Gateway) cancelCallbacks() {
+	g.callbackDispatcher().cancel()
+}
+
+// stopCallbacks 停止回调工作协程，之后提交的回调直接丢弃。
+func (g *JT809Gateway) stopCallbacks() {
+	g.callbackDispatcher().stop()
+}
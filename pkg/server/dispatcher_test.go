@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallbackOrdering(t *testing.T) {
+	g := &JT809Gateway{cfg: Config{CallbackDispatch: CallbackDispatchConfig{Workers: 4}}, metrics: newGatewayMetrics()}
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	for i := range 500 {
+		for _, plate := range []string{"粤A12345", "粤B12345", "粤C12345"} {
+			g.runCallback("OnVehicleLocation", 1, plate, func(context.Context) error {
+				mu.Lock()
+				got[plate] = append(got[plate], i)
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+	waitFor(t, func() bool { return g.inflight.Load() == 0 })
+	for plate, seq := range got {
+		if len(seq) != 500 || !slices.IsSorted(seq) {
+			t.Fatalf("callbacks for %s out of order or missing: %d", plate, len(seq))
+		}
+	}
+}
+
+func TestCallbackQueueFull(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   []int
+	}{
+		{QueueFullDropNewest, []int{0, 1, 2}},
+		{QueueFullDropOldest, []int{0, 2, 3}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			g := &JT809Gateway{cfg: Config{CallbackDispatch: CallbackDispatchConfig{Workers: 1, QueueSize: 2, QueueFullPolicy: tc.policy}}, metrics: newGatewayMetrics()}
+			release := make(chan struct{})
+			started := make(chan struct{})
+			var mu sync.Mutex
+			var ran []int
+			record := func(i int) func(context.Context) error {
+				return func(context.Context) error {
+					mu.Lock()
+					ran = append(ran, i)
+					mu.Unlock()
+					return nil
+				}
+			}
+			g.runCallback("OnVehicleLocation", 1, "粤A12345", func(ctx context.Context) error {
+				close(started)
+				<-release
+				return record(0)(ctx)
+			})
+			<-started
+			for i := 1; i <= 3; i++ {
+				g.runCallback("OnVehicleLocation", 1, "粤A12345", record(i))
+			}
+			close(release)
+			waitFor(t, func() bool { return g.inflight.Load() == 0 })
+			if !slices.Equal(ran, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, ran)
+			}
+			if n := g.metrics.callbacksDropped.get("OnVehicleLocation", tc.policy); n != 1 {
+				t.Fatalf("expected 1 dropped, got %v", n)
+			}
+		})
+	}
+}
+
+func TestCallbackPanicRecovered(t *testing.T) {
+	g := &JT809Gateway{cfg: Config{CallbackDispatch: CallbackDispatchConfig{Workers: 1}}, metrics: newGatewayMetrics()}
+	done := make(chan struct{})
+	g.runCallback("OnLogin", 1, "", func(context.Context) error { panic("boom") })
+	g.runCallback("OnLogin", 1, "", func(context.Context) error {
+		close(done)
+		return nil
+	})
+	<-done
+	waitFor(t, func() bool { return g.inflight.Load() == 0 })
+	if n := g.metrics.callbackPanics.get("OnLogin"); n != 1 {
+		t.Fatalf("expected 1 panic recorded, got %v", n)
+	}
+}
+
+func TestCallbackWorkersStop(t *testing.T) {
+	g := &JT809Gateway{cfg: Config{CallbackDispatch: CallbackDispatchConfig{Workers: 2}}, metrics: newGatewayMetrics()}
+	ran := make(chan struct{}, 1)
+	g.runCallback("OnLogin", 1, "", func(context.Context) error {
+		ran <- struct{}{}
+		return nil
+	})
+	g.stopCallbacks()
+
+	// 队列中的回调执行完后工作协程退出
+	stopped := make(chan struct{})
+	go func() {
+		g.dispatcher.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("callback workers did not exit")
+	}
+	<-ran
+	g.runCallback("OnLogin", 1, "", func(context.Context) error {
+		t.Error("callback after stop must not run")
+		return nil
+	})
+	if n := g.metrics.callbacksDropped.get("OnLogin", callbackDropClosed); n != 1 || g.inflight.Load() != 0 {
+		t.Fatalf("expected callback dropped after stop, got %v dropped, %d inflight", n, g.inflight.Load())
+	}
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+const (
+	defaultEventHubCapacity = 4096
+	eventSubscriberBuffer   = 256
+	eventFeedHeartbeat      = 15 * time.Second
+)
+
+// LinkEventData 为 link_up 与 link_down 事件数据。
+type LinkEventData struct {
+	Link   string `json:"link"` // main 或 sub
+	Reason string `json:"reason,omitempty"`
+}
+
+// VehiclePresenceData 为 vehicle_online 与 vehicle_offline 事件数据。
+type VehiclePresenceData struct {
+	LastPosition time.Time `json:"last_position"`
+}
+
+// EventFilter 按平台、车牌与事件类型过滤事件，字段为空表示不限。
+type EventFilter struct {
+	UserIDs map[uint32]bool
+	Plates  map[string]bool
+	Types   map[EventType]bool
+
+	hideSecrets bool // 不推送携带时效口令的 authorize 事件
+}
+
+func (f EventFilter) match(evt *Event) bool {
+	if f.hideSecrets && evt.Type == EventAuthorize {
+		return false
+	}
+	if len(f.UserIDs) > 0 && !f.UserIDs[evt.UserID] {
+		return false
+	}
+	if len(f.Plates) > 0 && !f.Plates[evt.VehicleNo] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[evt.Type] {
+		return false
+	}
+	return true
+}
+
+// parseEventFilter 解析 user_id、plate、type 查询参数，多个值以逗号分隔。
+func parseEventFilter(q url.Values) (EventFilter, error) {
+	var f EventFilter
+	for _, v := range splitQueryList(q.Get("user_id")) {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return f, fmt.Errorf("invalid user_id %q", v)
+		}
+		if f.UserIDs == nil {
+			f.UserIDs = make(map[uint32]bool)
+		}
+		f.UserIDs[uint32(id)] = true
+	}
+	for _, v := range splitQueryList(q.Get("plate")) {
+		if f.Plates == nil {
+			f.Plates = make(map[string]bool)
+		}
+		f.Plates[v] = true
+	}
+	for _, v := range splitQueryList(q.Get("type")) {
+		if f.Types == nil {
+			f.Types = make(map[EventType]bool)
+		}
+		f.Types[EventType(v)] = true
+	}
+	return f, nil
+}
+
+func splitQueryList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// feedEvent 为推送给订阅者的事件，Seq 为事件流内单调递增的序号，断线重连时作为 Last-Event-ID 续传。
+type feedEvent struct {
+	Seq uint64 `json:"seq"`
+	*Event
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	ch     chan feedEvent
+}
+
+// EventHub 为实时事件流的分发中心，实现 EventSink。
+// 最近的事件保存在环形缓冲中供断线续传，订阅者消费过慢时被断开，由客户端携带最后序号重连补齐。
+type EventHub struct {
+	mu     sync.Mutex
+	ring   []feedEvent
+	seq    uint64
+	subs   map[*eventSubscriber]struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// NewEventHub 创建事件分发中心，capacity 为可续传的最近事件数，<=0 时使用默认值 4096。
+func NewEventHub(capacity int) *EventHub {
+	if capacity <= 0 {
+		capacity = defaultEventHubCapacity
+	}
+	return &EventHub{
+		ring: make([]feedEvent, 0, capacity),
+		subs: make(map[*eventSubscriber]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Publish 实现 EventSink。
+func (h *EventHub) Publish(evt *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.seq++
+	fe := feedEvent{Seq: h.seq, Event: evt}
+	if len(h.ring) < cap(h.ring) {
+		h.ring = append(h.ring, fe)
+	} else {
+		h.ring[int((h.seq-1)%uint64(cap(h.ring)))] = fe
+	}
+	for sub := range h.subs {
+		if !sub.filter.match(evt) {
+			continue
+		}
+		select {
+		case sub.ch <- fe:
+		default:
+			// 消费过慢，断开订阅者，由客户端续传补齐
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe 注册订阅者并返回 lastSeq 之后仍在缓冲中的匹配事件，lastSeq 为 0 表示不续传。
+func (h *EventHub) subscribe(filter EventFilter, lastSeq uint64) (*eventSubscriber, []feedEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+	var backlog []feedEvent
+	if lastSeq > 0 && lastSeq < h.seq {
+		n := uint64(len(h.ring))
+		from := lastSeq + 1
+		if oldest := h.seq - n + 1; from < oldest {
+			from = oldest
+		}
+		for s := from; s <= h.seq; s++ {
+			fe := h.ring[int((s-1)%uint64(cap(h.ring)))]
+			if filter.match(fe.Event) {
+				backlog = append(backlog, fe)
+			}
+		}
+	}
+	sub := &eventSubscriber{filter: filter, ch: make(chan feedEvent, eventSubscriberBuffer)}
+	h.subs[sub] = struct{}{}
+	return sub, backlog, true
+}
+
+func (h *EventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Close 断开所有订阅者。
+func (h *EventHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+	close(h.done)
+	return nil
+}
+
+// vehiclePresence 记录车辆在线状态，用于产生上线/离线事件。
+type vehiclePresence struct {
+	mu       sync.Mutex
+	vehicles map[string]*presenceEntry
+}
+
+type presenceEntry struct {
+	userID   uint32
+	plate    string
+	color    jtt809.PlateColor
+	lastSeen time.Time
+}
+
+// seen 记录一次定位，车辆由离线变为在线时返回 true。
+func (p *vehiclePresence) seen(userID uint32, plate string, color jtt809.PlateColor, at time.Time) bool {
+	key := platformVehicleKey(userID, plate, color)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.vehicles == nil {
+		p.vehicles = make(map[string]*presenceEntry)
+	}
+	if e, ok := p.vehicles[key]; ok {
+		e.lastSeen = at
+		return false
+	}
+	p.vehicles[key] = &presenceEntry{userID: userID, plate: plate, color: color, lastSeen: at}
+	return true
+}
+
+// expire 移除超过 window 返回时长未上报定位的车辆并返回。
+func (p *vehiclePresence) expire(now time.Time, window func(userID uint32) time.Duration) []presenceEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []presenceEntry
+	for key, e := range p.vehicles {
+		if now.Sub(e.lastSeen) > window(e.userID) {
+			out = append(out, *e)
+			delete(p.vehicles, key)
+		}
+	}
+	return out
+}
+
+// remove 移除车辆的在线记录，返回车辆此前是否在线。
+func (p *vehiclePresence) remove(userID uint32, plate string, color jtt809.PlateColor) (presenceEntry, bool) {
+	key := platformVehicleKey(userID, plate, color)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.vehicles[key]
+	if !ok {
+		return presenceEntry{}, false
+	}
+	delete(p.vehicles, key)
+	return *e, true
+}
+
+// markVehicleSeen 在车辆上线时触发 OnVehicleOnline 并发布 vehicle_online 事件。
+func (g *JT809Gateway) markVehicleSeen(meta EventMeta, plate string, color jtt809.PlateColor) {
+	userID, now := meta.UserID, time.Now()
+	if !g.presence.seen(userID, plate, color, now) {
+		return
+	}
+	g.emit("OnVehicleOnline", userID, plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleOnline(ctx, &VehicleOnlineEvent{EventMeta: meta, VehicleNo: plate, VehicleColor: color})
+	})
+	g.publish(EventVehicleOnline, userID, plate, color, VehiclePresenceData{LastPosition: now})
+}
+
+// checkVehiclePresence 将超过账号离线时长未上报定位的车辆标记为离线。
+func (g *JT809Gateway) checkVehiclePresence(now time.Time) {
+	window := func(userID uint32) time.Duration { return g.vehiclePolicy(userID).OfflineAfter }
+	for _, e := range g.presence.expire(now, window) {
+		g.vehicleOffline(e)
+	}
+}
+
+// vehicleOffline 触发 OnVehicleOffline 并发布 vehicle_offline 事件。
+func (g *JT809Gateway) vehicleOffline(e presenceEntry) {
+	slog.Info("vehicle offline", "user_id", e.userID, "plate", e.plate, "last_position", e.lastSeen.Format("2006-01-02 15:04:05"))
+	meta := newEventMeta(e.userID, "")
+	g.emit("OnVehicleOffline", e.userID, e.plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleOffline(ctx, &VehicleOfflineEvent{EventMeta: meta, VehicleNo: e.plate, VehicleColor: e.color, LastPosition: e.lastSeen})
+	})
+	g.publish(EventVehicleOffline, e.userID, e.plate, e.color, VehiclePresenceData{LastPosition: e.lastSeen})
+}
+
+// handleEvents 提供实时事件流，携带 Upgrade: websocket 时使用 WebSocket，否则使用 SSE。
+// 支持 user_id、plate、type 过滤；通过 Last-Event-ID 头或 last_event_id 参数续传。
+func (g *JT809Gateway) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if g.events == nil {
+		http.Error(w, "event feed disabled", http.StatusServiceUnavailable)
+		return
+	}
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.hideSecrets = !canReadSecrets(r)
+	var lastSeq uint64
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		if lastSeq, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+	}
+	if isWebSocketUpgrade(r) {
+		g.serveEventsWebSocket(w, r, filter, lastSeq)
+		return
+	}
+	g.serveEventsSSE(w, r, filter, lastSeq)
+}
+
+func (g *JT809Gateway) serveEventsSSE(w http.ResponseWriter, r *http.Request, filter EventFilter, lastSeq uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, backlog, ok := g.events.subscribe(filter, lastSeq)
+	if !ok {
+		http.Error(w, "event feed closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer g.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	write := func(fe feedEvent) bool {
+		data, err := json.Marshal(fe)
+		if err != nil {
+			slog.Warn("encode feed event failed", "event", fe.Type, "err", err)
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", fe.Seq, fe.Type, data)
+		return err == nil
+	}
+	for _, fe := range backlog {
+		if !write(fe) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventFeedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-g.events.done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case fe, ok := <-sub.ch:
+			if !ok || !write(fe) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (g *JT809Gateway) serveEventsWebSocket(w http.ResponseWriter, r *http.Request, filter EventFilter, lastSeq uint64) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		slog.Debug("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+	sub, backlog, ok := g.events.subscribe(filter, lastSeq)
+	if !ok {
+		_ = conn.writeFrame(wsOpClose, []byte{0x03, 0xE9}) // 1001 going away
+		return
+	}
+	defer g.events.unsubscribe(sub)
+
+	readDone := make(chan struct{})
+	go conn.readLoop(readDone)
+
+	write := func(fe feedEvent) bool {
+		data, err := json.Marshal(fe)
+		if err != nil {
+			slog.Warn("encode feed event failed", "event", fe.Type, "err", err)
+			return true
+		}
+		return conn.writeFrame(wsOpText, data) == nil
+	}
+	for _, fe := range backlog {
+		if !write(fe) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventFeedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-readDone:
+			return
+		case <-g.events.done:
+			_ = conn.writeFrame(wsOpClose, []byte{0x03, 0xE9})
+			return
+		case <-heartbeat.C:
+			if err := conn.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case fe, ok := <-sub.ch:
+			if !ok || !write(fe) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestEventHubResume(t *testing.T) {
+	h := NewEventHub(3)
+	for i := 0; i < 5; i++ {
+		h.Publish(&Event{ID: string(rune('a' + i)), Type: EventVehicleLocation, UserID: uint32(i % 2)})
+	}
+	// 缓冲仅保留序号 3~5，续传从最早仍在缓冲中的事件开始
+	_, backlog, _ := h.subscribe(EventFilter{}, 1)
+	if len(backlog) != 3 || backlog[0].Seq != 3 || backlog[2].ID != "e" {
+		t.Fatalf("unexpected backlog %+v", backlog)
+	}
+	_, backlog, _ = h.subscribe(EventFilter{UserIDs: map[uint32]bool{1: true}}, 3)
+	if len(backlog) != 1 || backlog[0].Seq != 4 {
+		t.Fatalf("unexpected filtered backlog %+v", backlog)
+	}
+
+	// 消费过慢的订阅者被断开
+	sub, _, _ := h.subscribe(EventFilter{}, 0)
+	for i := 0; i < eventSubscriberBuffer+1; i++ {
+		h.Publish(&Event{Type: EventLinkUp})
+	}
+	n := 0
+	for range sub.ch {
+		n++
+	}
+	if n != eventSubscriberBuffer {
+		t.Fatalf("expected %d buffered events before drop, got %d", eventSubscriberBuffer, n)
+	}
+}
+
+func TestVehiclePresence(t *testing.T) {
+	var p vehiclePresence
+	now := time.Now()
+	if !p.seen(1, "粤B12345", jtt809.PlateColorBlue, now.Add(-10*time.Minute)) {
+		t.Fatalf("expected first position to mark online")
+	}
+	if p.seen(1, "粤B12345", jtt809.PlateColorBlue, now.Add(-6*time.Minute)) {
+		t.Fatalf("expected repeated position to stay online")
+	}
+	if got := p.expire(now, func(uint32) time.Duration { return 5 * time.Minute }); len(got) != 1 || got[0].plate != "粤B12345" {
+		t.Fatalf("expected vehicle offline, got %+v", got)
+	}
+	if !p.seen(1, "粤B12345", jtt809.PlateColorBlue, now) {
+		t.Fatalf("expected vehicle back online")
+	}
+}
+
+func TestHandleEventsSSE(t *testing.T) {
+	g := &JT809Gateway{events: NewEventHub(0)}
+	g.AddEventSink(g.events)
+	srv := httptest.NewServer(http.HandlerFunc(g.handleEvents))
+	defer srv.Close()
+	defer g.events.Close()
+
+	g.publish(EventLinkUp, 10001, "", 0, LinkEventData{Link: "main"})
+	g.publish(EventVehicleLocation, 10002, "粤B1", 0, &LocationData{})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?user_id=10001,10003", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	g.publish(EventLinkDown, 10002, "", 0, LinkEventData{Link: "sub"})
+	g.publish(EventLinkDown, 10003, "", 0, LinkEventData{Link: "sub"})
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "data:") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	if lines[0] != "id: 4" || lines[1] != "event: link_down" || !strings.Contains(lines[2], `"user_id":10003`) {
+		t.Fatalf("unexpected sse event %q", lines)
+	}
+
+	if resp, _ := http.Get(srv.URL + "?user_id=abc"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request for invalid filter, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleEventsWebSocket(t *testing.T) {
+	g := &JT809Gateway{events: NewEventHub(0)}
+	g.AddEventSink(g.events)
+	srv := httptest.NewServer(http.HandlerFunc(g.handleEvents))
+	defer srv.Close()
+	defer g.events.Close()
+
+	g.publish(EventVehicleOnline, 1, "粤B1", jtt809.PlateColorBlue, VehiclePresenceData{})
+	g.publish(EventWarnInformTips, 1, "粤B1", jtt809.PlateColorBlue, WarnEventData{})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	io.WriteString(conn, "GET /api/events?type=vehicle_online,warn_inform_tips&last_event_id=1 HTTP/1.1\r\n"+
+		"Host: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+key+"\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %v %v", resp, err)
+	}
+
+	ws := &wsConn{conn: conn, r: r}
+	op, payload, err := ws.readFrame()
+	if err != nil || op != wsOpText {
+		t.Fatalf("read frame: op=%d err=%v", op, err)
+	}
+	var got struct {
+		Seq  uint64    `json:"seq"`
+		Type EventType `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &got); err != nil || got.Seq != 2 || got.Type != EventWarnInformTips {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+
+	g.publish(EventVehicleLocation, 1, "粤B1", jtt809.PlateColorBlue, &LocationData{})
+	g.publish(EventVehicleOnline, 2, "粤B2", jtt809.PlateColorBlue, VehiclePresenceData{})
+	if _, payload, err = ws.readFrame(); err != nil || !strings.Contains(string(payload), `"seq":4`) {
+		t.Fatalf("unexpected live payload %s err=%v", payload, err)
+	}
+}
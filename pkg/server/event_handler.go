@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// EventMeta 为事件的公共字段。
+type EventMeta struct {
+	UserID uint32
+	// Link 触发事件的报文接收链路（main 或 sub），链路状态事件为状态变化的链路，定时检查产生的事件为空
+	Link string
+	// MsgSN 触发事件的报文流水号，非报文触发的事件为 0
+	MsgSN uint32
+	// ReceivedAt 触发事件的报文接收时间，非报文触发的事件为事件产生时间
+	ReceivedAt time.Time
+}
+
+// meta 返回报文触发事件的公共字段。
+func (m *Message) meta() EventMeta {
+	return EventMeta{UserID: m.UserID, Link: m.Link, MsgSN: m.Frame.Header.MsgSN, ReceivedAt: m.ReceivedAt}
+}
+
+// newEventMeta 返回非报文触发事件的公共字段。
+func newEventMeta(userID uint32, link string) EventMeta {
+	return EventMeta{UserID: userID, Link: link, ReceivedAt: time.Now()}
+}
+
+// LoginEvent 平台登录请求（0x1001）。
+type LoginEvent struct {
+	EventMeta
+	Request  *jtt809.LoginRequest
+	Response *jtt809.LoginResponse
+}
+
+// LogoutEvent 平台登出。
+type LogoutEvent struct {
+	EventMeta
+	Reason string // logout（收到 0x1003 主链路注销请求）或 kicked（重复登录被新连接顶替）
+}
+
+// VehicleRegistrationEvent 车辆注册信息（0x1201）。
+type VehicleRegistrationEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	Registration *VehicleRegistration
+}
+
+// VehicleLocationEvent 车辆实时定位（0x1202）。
+type VehicleLocationEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	Position     *jtt809.VehiclePosition
+	GNSS         *jtt809.GNSSData // 解析失败时为 nil
+}
+
+// VehicleLocationSupplementaryEvent 车辆定位信息自动补报（0x1203）。
+type VehicleLocationSupplementaryEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	GNSS         []jtt809.GNSSData
+}
+
+// VideoResponseEvent 实时音视频请求应答（0x1801）。
+type VideoResponseEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	Ack          *VideoAckState
+}
+
+// AuthorizeEvent 视频时效口令上报（0x1701）。
+type AuthorizeEvent struct {
+	EventMeta
+	PlatformID    string
+	AuthorizeCode string
+}
+
+// MonitorAckEvent 启动或结束车辆定位信息交换应答（0x1205、0x1206）。
+type MonitorAckEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+}
+
+// WarnAdptInfoEvent 上报报警信息（0x1402）。
+type WarnAdptInfoEvent struct {
+	EventMeta
+	Info *jtt809.WarnMsgAdptInfo
+}
+
+// WarnInformTipsEvent 上报报警预警信息（0x1403）。
+type WarnInformTipsEvent struct {
+	EventMeta
+	Info *jtt809.WarnMsgInformTips
+}
+
+// GeofenceTriggeredEvent 电子围栏事件，由实时定位触发。
+type GeofenceTriggeredEvent struct {
+	EventMeta
+	Geofence *GeofenceEvent
+}
+
+// RuleAlarmEvent 平台侧违规判定报警，由实时定位触发，报警开始与结束时各一次。
+type RuleAlarmEvent struct {
+	EventMeta
+	Alarm *RuleAlarm
+}
+
+// QualityIssueEvent 定位数据质量问题，由 0x1202/0x1203 触发。
+type QualityIssueEvent struct {
+	EventMeta
+	Quality *QualityEvent
+}
+
+// VehicleOnlineEvent 车辆上线，离线后首次收到实时定位时触发。
+type VehicleOnlineEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+}
+
+// VehicleOfflineEvent 车辆离线，定位中断超过 VehiclePolicy.OfflineAfter 时触发。
+type VehicleOfflineEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	LastPosition time.Time // 最后一次定位的接收时间
+}
+
+// VehicleEvictedEvent 车辆从内存删除。
+type VehicleEvictedEvent struct {
+	EventMeta
+	VehicleNo    string
+	VehicleColor jtt809.PlateColor
+	Reason       string // registration_expired 或 position_timeout
+}
+
+// LinkStateEvent 链路状态变化，EventMeta.Link 为状态变化的链路。
+type LinkStateEvent struct {
+	EventMeta
+	State  LinkState
+	Reason string
+}
+
+// EventHandler 处理网关事件。方法在回调工作协程中执行（见 CallbackDispatchConfig），同一车辆的事件按序处理；
+// ctx 在停机超时后取消。返回的错误计入指标，并按 CallbackDispatchConfig.MaxRetries 重试。
+// 只关心部分事件时可嵌入 BaseEventHandler。
+type EventHandler interface {
+	OnLogin(ctx context.Context, evt *LoginEvent) error
+	OnLogout(ctx context.Context, evt *LogoutEvent) error
+	OnVehicleRegistration(ctx context.Context, evt *VehicleRegistrationEvent) error
+	OnVehicleLocation(ctx context.Context, evt *VehicleLocationEvent) error
+	OnVehicleLocationSupplementary(ctx context.Context, evt *VehicleLocationSupplementaryEvent) error
+	OnVideoResponse(ctx context.Context, evt *VideoResponseEvent) error
+	OnAuthorize(ctx context.Context, evt *AuthorizeEvent) error
+	OnMonitorStartupAck(ctx context.Context, evt *MonitorAckEvent) error
+	OnMonitorEndAck(ctx context.Context, evt *MonitorAckEvent) error
+	OnWarnMsgAdptInfo(ctx context.Context, evt *WarnAdptInfoEvent) error
+	OnWarnMsgInformTips(ctx context.Context, evt *WarnInformTipsEvent) error
+	OnGeofenceEvent(ctx context.Context, evt *GeofenceTriggeredEvent) error
+	OnRuleAlarm(ctx context.Context, evt *RuleAlarmEvent) error
+	OnQualityIssue(ctx context.Context, evt *QualityIssueEvent) error
+	OnVehicleOnline(ctx context.Context, evt *VehicleOnlineEvent) error
+	OnVehicleOffline(ctx context.Context, evt *VehicleOfflineEvent) error
+	OnVehicleEvicted(ctx context.Context, evt *VehicleEvictedEvent) error
+	OnLinkStateChange(ctx context.Context, evt *LinkStateEvent) error
+}
+
+// BaseEventHandler 为 EventHandler 的空实现。
+type BaseEventHandler struct{}
+
+func (BaseEventHandler) OnLogin(context.Context, *LoginEvent) error               { return nil }
+func (BaseEventHandler) OnLogout(context.Context, *LogoutEvent) error             { return nil }
+func (BaseEventHandler) OnAuthorize(context.Context, *AuthorizeEvent) error       { return nil }
+func (BaseEventHandler) OnRuleAlarm(context.Context, *RuleAlarmEvent) error       { return nil }
+func (BaseEventHandler) OnLinkStateChange(context.Context, *LinkStateEvent) error { return nil }
+func (BaseEventHandler) OnVehicleRegistration(context.Context, *VehicleRegistrationEvent) error {
+	return nil
+}
+func (BaseEventHandler) OnVehicleLocation(context.Context, *VehicleLocationEvent) error { return nil }
+func (BaseEventHandler) OnVehicleLocationSupplementary(context.Context, *VehicleLocationSupplementaryEvent) error {
+	return nil
+}
+func (BaseEventHandler) OnVideoResponse(context.Context, *VideoResponseEvent) error  { return nil }
+func (BaseEventHandler) OnMonitorStartupAck(context.Context, *MonitorAckEvent) error { return nil }
+func (BaseEventHandler) OnMonitorEndAck(context.Context, *MonitorAckEvent) error     { return nil }
+func (BaseEventHandler) OnWarnMsgAdptInfo(context.Context, *WarnAdptInfoEvent) error { return nil }
+func (BaseEventHandler) OnWarnMsgInformTips(context.Context, *WarnInformTipsEvent) error {
+	return nil
+}
+func (BaseEventHandler) OnGeofenceEvent(context.Context, *GeofenceTriggeredEvent) error { return nil }
+func (BaseEventHandler) OnQualityIssue(context.Context, *QualityIssueEvent) error       { return nil }
+func (BaseEventHandler) OnVehicleOnline(context.Context, *VehicleOnlineEvent) error     { return nil }
+func (BaseEventHandler) OnVehicleOffline(context.Context, *VehicleOfflineEvent) error   { return nil }
+func (BaseEventHandler) OnVehicleEvicted(context.Context, *VehicleEvictedEvent) error   { return nil }
+
+// eventFilter 由只处理部分事件的 EventHandler 实现，未处理的事件不进入回调队列。
+type eventFilter interface {
+	handles(name string) bool
+}
+
+// CallbacksHandler 将 Callbacks 适配为 EventHandler，未设置的回调不会入队执行。
+func CallbacksHandler(cb *Callbacks) EventHandler {
+	return callbacksHandler{cb}
+}
+
+type callbacksHandler struct {
+	cb *Callbacks
+}
+
+func (h callbacksHandler) handles(name string) bool {
+	cb := h.cb
+	switch name {
+	case "OnLogin":
+		return cb.OnLogin != nil
+	case "OnLogout":
+		return cb.OnLogout != nil
+	case "OnVehicleRegistration":
+		return cb.OnVehicleRegistration != nil
+	case "OnVehicleLocation":
+		return cb.OnVehicleLocation != nil
+	case "OnVehicleLocationSupplementary":
+		return cb.OnVehicleLocationSupplementary != nil
+	case "OnVideoResponse":
+		return cb.OnVideoResponse != nil
+	case "OnAuthorize":
+		return cb.OnAuthorize != nil
+	case "OnMonitorStartupAck":
+		return cb.OnMonitorStartupAck != nil
+	case "OnMonitorEndAck":
+		return cb.OnMonitorEndAck != nil
+	case "OnWarnMsgAdptInfo":
+		return cb.OnWarnMsgAdptInfo != nil
+	case "OnWarnMsgInformTips":
+		return cb.OnWarnMsgInformTips != nil
+	case "OnGeofenceEvent":
+		return cb.OnGeofenceEvent != nil
+	case "OnRuleAlarm":
+		return cb.OnRuleAlarm != nil
+	case "OnQualityIssue":
+		return cb.OnQualityIssue != nil
+	case "OnVehicleOnline":
+		return cb.OnVehicleOnline != nil
+	case "OnVehicleOffline":
+		return cb.OnVehicleOffline != nil
+	case "OnVehicleEvicted":
+		return cb.OnVehicleEvicted != nil
+	case "OnLinkStateChange":
+		return cb.OnLinkStateChange != nil
+	}
+	return false
+}
+
+func (h callbacksHandler) OnLogin(_ context.Context, evt *LoginEvent) error {
+	h.cb.OnLogin(evt.UserID, evt.Request, evt.Response)
+	return nil
+}
+
+func (h callbacksHandler) OnLogout(_ context.Context, evt *LogoutEvent) error {
+	h.cb.OnLogout(evt.UserID, evt.Reason)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleRegistration(_ context.Context, evt *VehicleRegistrationEvent) error {
+	h.cb.OnVehicleRegistration(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.Registration)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleLocation(_ context.Context, evt *VehicleLocationEvent) error {
+	h.cb.OnVehicleLocation(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.Position, evt.GNSS)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleLocationSupplementary(_ context.Context, evt *VehicleLocationSupplementaryEvent) error {
+	h.cb.OnVehicleLocationSupplementary(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.GNSS)
+	return nil
+}
+
+func (h callbacksHandler) OnVideoResponse(_ context.Context, evt *VideoResponseEvent) error {
+	h.cb.OnVideoResponse(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.Ack)
+	return nil
+}
+
+func (h callbacksHandler) OnAuthorize(_ context.Context, evt *AuthorizeEvent) error {
+	h.cb.OnAuthorize(evt.UserID, evt.PlatformID, evt.AuthorizeCode)
+	return nil
+}
+
+func (h callbacksHandler) OnMonitorStartupAck(_ context.Context, evt *MonitorAckEvent) error {
+	h.cb.OnMonitorStartupAck(evt.UserID, evt.VehicleNo, evt.VehicleColor)
+	return nil
+}
+
+func (h callbacksHandler) OnMonitorEndAck(_ context.Context, evt *MonitorAckEvent) error {
+	h.cb.OnMonitorEndAck(evt.UserID, evt.VehicleNo, evt.VehicleColor)
+	return nil
+}
+
+func (h callbacksHandler) OnWarnMsgAdptInfo(_ context.Context, evt *WarnAdptInfoEvent) error {
+	h.cb.OnWarnMsgAdptInfo(evt.UserID, evt.Info)
+	return nil
+}
+
+func (h callbacksHandler) OnWarnMsgInformTips(_ context.Context, evt *WarnInformTipsEvent) error {
+	h.cb.OnWarnMsgInformTips(evt.UserID, evt.Info)
+	return nil
+}
+
+func (h callbacksHandler) OnGeofenceEvent(_ context.Context, evt *GeofenceTriggeredEvent) error {
+	h.cb.OnGeofenceEvent(evt.UserID, evt.Geofence)
+	return nil
+}
+
+func (h callbacksHandler) OnRuleAlarm(_ context.Context, evt *RuleAlarmEvent) error {
+	h.cb.OnRuleAlarm(evt.UserID, evt.Alarm)
+	return nil
+}
+
+func (h callbacksHandler) OnQualityIssue(_ context.Context, evt *QualityIssueEvent) error {
+	h.cb.OnQualityIssue(evt.UserID, evt.Quality)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleOnline(_ context.Context, evt *VehicleOnlineEvent) error {
+	h.cb.OnVehicleOnline(evt.UserID, evt.VehicleNo, evt.VehicleColor)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleOffline(_ context.Context, evt *VehicleOfflineEvent) error {
+	h.cb.OnVehicleOffline(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.LastPosition)
+	return nil
+}
+
+func (h callbacksHandler) OnVehicleEvicted(_ context.Context, evt *VehicleEvictedEvent) error {
+	h.cb.OnVehicleEvicted(evt.UserID, evt.VehicleNo, evt.VehicleColor, evt.Reason)
+	return nil
+}
+
+func (h callbacksHandler) OnLinkStateChange(_ context.Context, evt *LinkStateEvent) error {
+	h.cb.OnLinkStateChange(evt.UserID, evt.Link, evt.State, evt.Reason)
+	return nil
+}
+
+// SetEventHandler 设置事件处理器，替换 SetCallbacks 设置的回调。
+func (g *JT809Gateway) SetEventHandler(h EventHandler) {
+	g.eventHandler = h
+}
+
+// SetCallbacks 设置回调函数，等价于 SetEventHandler(CallbacksHandler(callbacks))。
+func (g *JT809Gateway) SetCallbacks(callbacks *Callbacks) {
+	if callbacks == nil {
+		g.eventHandler = nil
+		return
+	}
+	g.SetEventHandler(CallbacksHandler(callbacks))
+}
+
+// emit 将事件交给事件处理器，事件处理器未处理该事件时不入队。
+func (g *JT809Gateway) emit(name string, userID uint32, plate string, fn func(ctx context.Context, h EventHandler) error) {
+	h := g.eventHandler
+	if h == nil {
+		return
+	}
+	if f, ok := h.(eventFilter); ok && !f.handles(name) {
+		return
+	}
+	g.runCallback(name, userID, plate, func(ctx context.Context) error { return fn(ctx, h) })
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+	"github.com/zboyco/jtt809/pkg/jtt809/jt1078"
+)
+
+type failingHandler struct {
+	BaseEventHandler
+	mu     sync.Mutex
+	events []*AuthorizeEvent
+	ctxErr error
+}
+
+func (h *failingHandler) OnAuthorize(ctx context.Context, evt *AuthorizeEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, evt)
+	h.ctxErr = ctx.Err()
+	return errors.New("downstream unavailable")
+}
+
+func (h *failingHandler) calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.events)
+}
+
+func TestEventHandlerRetry(t *testing.T) {
+	g := &JT809Gateway{
+		cfg:     Config{CallbackDispatch: CallbackDispatchConfig{Workers: 1, MaxRetries: 2, RetryBackoff: time.Millisecond}},
+		store:   NewPlatformStore(),
+		metrics: newGatewayMetrics(),
+	}
+	h := &failingHandler{}
+	g.SetEventHandler(h)
+
+	payload, err := jt1078.AuthorizeStartupReq{PlatformID: "PLAT123", AuthorizeCode1: "CODE"}.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	frame := &jtt809.Frame{
+		Header:  jtt809.Header{MsgSN: 7},
+		BodyID:  jtt809.UP_AUTHORIZE_MSG,
+		RawBody: append([]byte{0x17, 0x01}, payload...),
+	}
+	g.handleBusinessMessage(1, frame, true)
+	waitFor(t, func() bool { return g.inflight.Load() == 0 })
+
+	if n := h.calls(); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+	evt := h.events[0]
+	if evt.UserID != 1 || evt.Link != "main" || evt.MsgSN != 7 || evt.ReceivedAt.IsZero() || evt.PlatformID != "PLAT123" || evt.AuthorizeCode != "CODE" {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+	if n := g.metrics.callbackErrors.get("OnAuthorize"); n != 3 {
+		t.Fatalf("expected 3 errors, got %v", n)
+	}
+	if n := g.metrics.callbackRetries.get("OnAuthorize"); n != 2 {
+		t.Fatalf("expected 2 retries, got %v", n)
+	}
+
+	// 停机超时取消 ctx 后不再重试
+	g.cancelCallbacks()
+	g.handleBusinessMessage(1, frame, true)
+	waitFor(t, func() bool { return g.inflight.Load() == 0 })
+	if n := h.calls(); n != 4 {
+		t.Fatalf("expected no retry after cancel, got %d attempts", n)
+	}
+	if !errors.Is(h.ctxErr, context.Canceled) {
+		t.Fatalf("expected ctx canceled, got %v", h.ctxErr)
+	}
+}
+
+func TestCallbacksHandlerSkipsUnset(t *testing.T) {
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics()}
+	reasons := make(chan string, 1)
+	g.SetCallbacks(&Callbacks{OnLogout: func(_ uint32, reason string) { reasons <- reason }})
+
+	g.linkStateChanged(1, "main", LinkStateDown, "closed")
+	if g.dispatcher != nil {
+		t.Fatal("unset callback should not be queued")
+	}
+	g.loggedOut(1, LogoutRequested)
+	select {
+	case got := <-reasons:
+		if got != LogoutRequested {
+			t.Fatalf("unexpected reason %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnLogout called")
+	}
+}
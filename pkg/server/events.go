@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// EventType 表示网关对外发布的事件类型，除链路、车辆上下线与下行报文丢弃事件外与 Callbacks 中的回调一一对应。
+type EventType string
+
+const (
+	EventLogin                        EventType = "login"
+	EventLogout                       EventType = "logout"
+	EventVehicleRegistration          EventType = "vehicle_registration"
+	EventVehicleLocation              EventType = "vehicle_location"
+	EventVehicleLocationSupplementary EventType = "vehicle_location_supplementary"
+	EventVideoResponse                EventType = "video_response"
+	EventAuthorize                    EventType = "authorize"
+	EventMonitorStartupAck            EventType = "monitor_startup_ack"
+	EventMonitorEndAck                EventType = "monitor_end_ack"
+	EventWarnAdptInfo                 EventType = "warn_adpt_info"
+	EventWarnInformTips               EventType = "warn_inform_tips"
+	EventGeofence                     EventType = "geofence"
+	EventRuleAlarm                    EventType = "rule_alarm"
+	EventQualityIssue                 EventType = "quality_issue"
+	EventLinkUp                       EventType = "link_up"
+	EventLinkDown                     EventType = "link_down"
+	EventVehicleOnline                EventType = "vehicle_online"
+	EventVehicleOffline               EventType = "vehicle_offline"
+	EventOutboundDropped              EventType = "outbound_dropped"
+)
+
+// Event 为网关对外发布的统一事件结构，Data 的具体类型由 Type 决定。
+type Event struct {
+	ID           string            `json:"id"`
+	Type         EventType         `json:"type"`
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no,omitempty"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color,omitempty"`
+	Time         time.Time         `json:"time"` // 网关产生事件的时间
+	Data         any               `json:"data,omitempty"`
+}
+
+// EventSink 接收网关事件。Publish 在消息处理路径上同步调用，实现方不得阻塞。
+type EventSink interface {
+	Publish(evt *Event)
+}
+
+// LoginEventData 为 login 事件数据。
+type LoginEventData struct {
+	Result       jtt809.LoginResult `json:"result"`
+	GNSSCenterID uint32             `json:"gnss_center_id"`
+	DownLinkIP   string             `json:"down_link_ip"`
+	DownLinkPort uint16             `json:"down_link_port"`
+}
+
+// LogoutEventData 为 logout 事件数据。
+type LogoutEventData struct {
+	Reason string `json:"reason"` // logout 或 kicked
+}
+
+// OutboundDroppedEventData 为 outbound_dropped 事件数据，描述未能在链路恢复前发出的暂存下行报文。
+type OutboundDroppedEventData struct {
+	MsgID    uint16    `json:"msg_id"`
+	SubID    uint16    `json:"sub_id"`
+	Reason   string    `json:"reason"` // expired 或 overflow
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// RegistrationEventData 为 vehicle_registration 事件数据。
+type RegistrationEventData struct {
+	PlatformID        string `json:"platform_id"`
+	ProducerID        string `json:"producer_id"`
+	TerminalModelType string `json:"terminal_model_type"`
+	IMEI              string `json:"imei"`
+	TerminalID        string `json:"terminal_id"`
+	TerminalSIM       string `json:"terminal_sim"`
+}
+
+// LocationData 为定位事件中的单个定位点。
+type LocationData struct {
+	Time      time.Time `json:"time"`
+	Longitude float64   `json:"longitude"`
+	Latitude  float64   `json:"latitude"`
+	Speed     float64   `json:"speed"` // km/h
+	Direction uint16    `json:"direction"`
+	Altitude  uint16    `json:"altitude"`
+	Mileage   float64   `json:"mileage"` // km
+	Alarm     uint32    `json:"alarm"`
+	State     uint32    `json:"state"`
+}
+
+// VideoAckEventData 为 video_response 事件数据。
+type VideoAckEventData struct {
+	Result     byte   `json:"result"`
+	ServerIP   string `json:"server_ip"`
+	ServerPort uint16 `json:"server_port"`
+}
+
+// AuthorizeEventData 为 authorize 事件数据。
+type AuthorizeEventData struct {
+	PlatformID    string `json:"platform_id"`
+	AuthorizeCode string `json:"authorize_code"`
+}
+
+// WarnEventData 为 warn_adpt_info 与 warn_inform_tips 事件数据。
+type WarnEventData struct {
+	SourcePlatformID string          `json:"source_platform_id"`
+	TargetPlatformID string          `json:"target_platform_id"`
+	WarnType         jtt809.WarnType `json:"warn_type"`
+	WarnTime         time.Time       `json:"warn_time"`
+	StartTime        time.Time       `json:"start_time,omitempty"`
+	EndTime          time.Time       `json:"end_time,omitempty"`
+	DrvLineID        uint32          `json:"drv_line_id"`
+	Content          string          `json:"content"`
+}
+
+func newLocationData(gnss *jtt809.GNSSData) *LocationData {
+	if gnss == nil {
+		return nil
+	}
+	return &LocationData{
+		Time:      gnss.DateTime.Time(),
+		Longitude: gnss.Longitude,
+		Latitude:  gnss.Latitude,
+		Speed:     float64(gnss.Speed) / 10,
+		Direction: gnss.Direction,
+		Altitude:  gnss.Altitude,
+		Mileage:   float64(gnss.Mileage) / 10,
+		Alarm:     gnss.Alarm,
+		State:     gnss.State,
+	}
+}
+
+var eventSeq atomic.Uint64
+
+// AddEventSink 注册事件接收端（Webhook 等），需在 Start 之前调用。
+func (g *JT809Gateway) AddEventSink(sink EventSink) {
+	if sink != nil {
+		g.sinks = append(g.sinks, sink)
+	}
+}
+
+// publish 构造事件并分发给所有接收端，未注册接收端时不做任何处理。
+func (g *JT809Gateway) publish(typ EventType, userID uint32, plate string, color jtt809.PlateColor, data any) {
+	if len(g.sinks) == 0 {
+		return
+	}
+	now := time.Now()
+	evt := &Event{
+		ID:           fmt.Sprintf("%d-%d", now.UnixMilli(), eventSeq.Add(1)),
+		Type:         typ,
+		UserID:       userID,
+		VehicleNo:    plate,
+		VehicleColor: color,
+		Time:         now,
+		Data:         data,
+	}
+	for _, sink := range g.sinks {
+		sink.Publish(evt)
+	}
+}
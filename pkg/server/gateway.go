@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	goserver "github.com/zboyco/go-server"
@@ -20,8 +25,8 @@ import (
 
 // LinkPolicy 链路策略配置
 type LinkPolicy struct {
-	PreferredLink string // "main"、"sub" 或 "both"
-	AllowFallback bool   // 是否允许降级
+	PreferredLink string `yaml:"preferred_link"` // "main"、"sub" 或 "both"
+	AllowFallback bool   `yaml:"allow_fallback"` // 是否允许降级
 }
 
 // 链路策略配置表
@@ -29,7 +34,9 @@ var linkPolicies = map[uint16]LinkPolicy{
 	jtt809.UP_CONNECT_RSP:         {PreferredLink: "main", AllowFallback: false}, // 0x1002 主链路登录应答，不能降级
 	jtt809.DOWN_CONNECT_REQ:       {PreferredLink: "sub", AllowFallback: false},  // 0x9001 从链路登录请求，不能降级
 	jtt809.DOWN_LINKTEST_REQ:      {PreferredLink: "sub", AllowFallback: false},  // 0x9005 从链路心跳请求，不能降级
+	jtt809.DOWN_DISCONNECT_REQ:    {PreferredLink: "sub", AllowFallback: false},  // 0x9003 从链路注销请求，不能降级
 	jtt809.DOWN_DISCONNECT_INFORM: {PreferredLink: "main", AllowFallback: false}, // 0x9007 从链路断开通知，只能主链路
+	jtt809.DOWN_CLOSELINK_INFORM:  {PreferredLink: "main", AllowFallback: true},  // 0x9008 关闭链路通知，主链路不可用时经从链路发送
 	jtt809.UP_LINKTEST_RSP:        {PreferredLink: "both", AllowFallback: false}, // 0x1006 主链路心跳应答需主/从链路各发一次,原因是因为有些平台没按照规范处理通过从链路返回的主链路心跳应答
 }
 
@@ -49,25 +56,177 @@ type JT809Gateway struct {
 	httpSrv *http.Server
 	rtpSrv  *jtt1078.Server
 
-	callbacks *Callbacks // 消息回调
+	track *TrackStore // 历史轨迹存储，未配置 DataDir 时为 nil
+
+	geofences *GeofenceEngine     // 电子围栏
+	rules     *RuleEngine         // 平台侧违规判定
+	quality   *QualityMonitor     // 定位数据质量检查
+	report    *AssessmentRecorder // 每日考核统计
+
+	eventHandler EventHandler       // 事件处理器
+	sinks        []EventSink        // 事件推送（Webhook 等）
+	events       *EventHub          // 实时事件流，未启用 HTTP 时为 nil
+	presence     vehiclePresence    // 车辆上下线状态
+	metrics      *gatewayMetrics    // 运行指标
+	httpAuth     *httpAuthenticator // 管理接口认证，未配置时为 nil
+	relay        *relayClient       // 级联上级平台，未配置时为 nil
+
+	policy atomic.Pointer[runtimePolicy] // 可热更新的链路与车辆策略
+
+	applyMu sync.Mutex // 串行化 ApplyConfig，文件监听与 SIGHUP 可能同时触发重载
+	applied *Config    // 最近一次应用的配置，为 nil 时即 cfg
+
+	subLogoutAcks sync.Map        // userID -> chan struct{}，等待 0x9004 从链路注销应答
+	pending       pendingRequests // SendAndWait 等待应答的请求
+	outbound      *outboundQueue  // 链路不可用时的下行报文暂存队列，未启用时为 nil
+	inflight      atomic.Int64    // 排队与执行中的回调数，停机时等待归零
+	closing       atomic.Bool     // 停机中，不再重连从链路
+
+	handlersOnce sync.Once
+	handlerMu    sync.RWMutex
+	handlers     map[handlerKey]HandlerFunc // 业务报文处理器，见 Handle
+
+	dispatcherOnce sync.Once
+	dispatcher     *callbackDispatcher // 回调调度器，见 runCallback
+
+	frameMu     sync.RWMutex
+	middlewares []FrameMiddleware // 应用注册的报文中间件，见 Use
+	packetDebug sync.Map          // userID -> struct{}，运行时开启报文调试转储的平台
 
 	startOnce sync.Once
 }
 
-func NewJT809Gateway(cfg Config, rtpServer *jtt1078.Server) (*JT809Gateway, error) {
+func NewJT809Gateway(cfg Config, rtpServer *jtt1078.Server) (_ *JT809Gateway, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	printStartupInfo(cfg, rtpServer != nil)
 
-	return &JT809Gateway{
-		cfg:    cfg,
-		auth:   NewAuthenticator(cfg.Accounts),
-		store:  NewPlatformStore(),
-		rtpSrv: rtpServer,
-	}, nil
+	g := &JT809Gateway{
+		cfg:     cfg,
+		auth:    NewAuthenticator(cfg.Accounts),
+		store:   NewPlatformStore(),
+		rtpSrv:  rtpServer,
+		metrics: newGatewayMetrics(),
+	}
+	g.policy.Store(newRuntimePolicy(cfg))
+	// 后续组件创建失败时释放已创建的推送、队列与文件
+	defer func() {
+		if err != nil {
+			g.closeResources()
+		}
+	}()
+	fencePath := ""
+	if cfg.DataDir != "" {
+		fencePath = filepath.Join(cfg.DataDir, "geofences.json")
+	}
+	fences, err := NewGeofenceEngine(fencePath)
+	if err != nil {
+		return nil, err
+	}
+	g.geofences = fences
+	ruleCfg := DefaultRuleConfig()
+	if cfg.Rules != nil {
+		ruleCfg = *cfg.Rules
+	}
+	g.rules = NewRuleEngine(ruleCfg, fences)
+	qualityCfg := DefaultQualityConfig()
+	if cfg.Quality != nil {
+		qualityCfg = *cfg.Quality
+	}
+	g.quality = NewQualityMonitor(qualityCfg)
+	reportDir := ""
+	if cfg.DataDir != "" {
+		reportDir = filepath.Join(cfg.DataDir, "report")
+	}
+	report, err := NewAssessmentRecorder(reportDir, cfg.CheckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	g.report = report
+	if cfg.HTTPListen != "" {
+		g.events = NewEventHub(0)
+		g.AddEventSink(g.events)
+	}
+	if cfg.HTTPAuth != nil {
+		auth, err := newHTTPAuthenticator(*cfg.HTTPAuth)
+		if err != nil {
+			return nil, err
+		}
+		g.httpAuth = auth
+	} else if cfg.HTTPListen != "" {
+		slog.Warn("management http api is unauthenticated, set Config.HTTPAuth to enable authentication")
+	}
+	if cfg.Webhook != nil {
+		whCfg := *cfg.Webhook
+		if whCfg.QueueDir == "" && cfg.DataDir != "" {
+			whCfg.QueueDir = filepath.Join(cfg.DataDir, "webhook")
+		}
+		webhook, err := NewWebhookDispatcher(whCfg)
+		if err != nil {
+			return nil, err
+		}
+		g.AddEventSink(webhook)
+	}
+	if cfg.MQTT != nil {
+		mqtt, err := NewMQTTPublisher(*cfg.MQTT)
+		if err != nil {
+			return nil, err
+		}
+		g.AddEventSink(mqtt)
+	}
+	if cfg.Kafka != nil {
+		kafka, err := NewKafkaProducer(*cfg.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		g.AddEventSink(kafka)
+	}
+	if cfg.OutboundQueue != nil {
+		qCfg := *cfg.OutboundQueue
+		if qCfg.Dir == "" && cfg.DataDir != "" {
+			qCfg.Dir = filepath.Join(cfg.DataDir, "outbound")
+		}
+		outbound, err := newOutboundQueue(qCfg)
+		if err != nil {
+			return nil, err
+		}
+		g.outbound = outbound
+	}
+	if cfg.Relay != nil {
+		relay, err := newRelayClient(g, *cfg.Relay)
+		if err != nil {
+			return nil, err
+		}
+		g.relay = relay
+	}
+	if cfg.DataDir != "" {
+		p, err := NewFilePersistence(filepath.Join(cfg.DataDir, "state"))
+		if err != nil {
+			return nil, err
+		}
+		if err := g.SetPersistence(p); err != nil {
+			return nil, err
+		}
+		track, err := NewTrackStore(filepath.Join(cfg.DataDir, "track"), cfg.TrackRetentionDays)
+		if err != nil {
+			return nil, err
+		}
+		g.track = track
+	}
+	return g, nil
 }
 
-// SetCallbacks 设置回调函数，用于在收到特定消息时执行自定义业务逻辑
-func (g *JT809Gateway) SetCallbacks(callbacks *Callbacks) {
-	g.callbacks = callbacks
+// SetPersistence 设置平台状态持久化后端并立即恢复已保存的状态，需在 Start 之前调用。
+// 恢复时个别记录损坏只记录告警，不阻止启动。
+func (g *JT809Gateway) SetPersistence(p Persistence) error {
+	if err := g.store.AttachPersistence(p); err != nil {
+		if p == nil {
+			return err
+		}
+		slog.Warn("restore platform state incomplete", "err", err)
+	}
+	return nil
 }
 
 // AddAccount 动态新增或更新账号。
@@ -138,6 +297,65 @@ func (g *JT809Gateway) disconnectUserLinks(userID uint32) {
 	slog.Info("account removed and links closed", "user_id", userID)
 }
 
+// 链路控制错误。
+var (
+	ErrPlatformNotFound  = errors.New("platform not found")
+	ErrLinkNotConnected  = errors.New("link not connected")
+	ErrLinkAlreadyActive = errors.New("link already connected")
+)
+
+// CloseMainLink 强制断开指定平台的主链路，下级平台需重新登录。
+func (g *JT809Gateway) CloseMainLink(userID uint32, reason string) error {
+	sessionID, ok := g.store.GetMainSession(userID)
+	if !ok {
+		return ErrLinkNotConnected
+	}
+	if g.mainSrv == nil {
+		return ErrLinkNotConnected
+	}
+	session, err := g.mainSrv.GetSessionByID(sessionID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLinkNotConnected, err)
+	}
+	slog.Info("closing main link", "user_id", userID, "reason", reason)
+	session.Close(reason)
+	return nil
+}
+
+// CloseSubLink 强制断开指定平台的从链路，主链路在线时从链路随后自动重连。
+func (g *JT809Gateway) CloseSubLink(userID uint32) error {
+	_, subClient, cancel, _ := g.store.PlatformLinks(userID)
+	if subClient == nil {
+		return ErrLinkNotConnected
+	}
+	slog.Info("closing sub link", "user_id", userID)
+	if cancel != nil {
+		cancel()
+		return nil
+	}
+	g.store.CloseSubLink(userID)
+	return nil
+}
+
+// ConnectSubLink 立即发起从链路连接，要求主链路在线且从链路未连接。
+func (g *JT809Gateway) ConnectSubLink(userID uint32) error {
+	snap, ok := g.store.Snapshot(userID)
+	if !ok {
+		return ErrPlatformNotFound
+	}
+	if snap.MainSessionID == "" {
+		return fmt.Errorf("%w: main link offline", ErrLinkNotConnected)
+	}
+	if snap.SubConnected {
+		return ErrLinkAlreadyActive
+	}
+	if snap.DownLinkIP == "" || snap.DownLinkPort == 0 {
+		return errors.New("down link address unknown")
+	}
+	go g.connectSubLinkWithRetry(userID, true)
+	return nil
+}
+
 // Start 同时启动主链路、从链路服务，并阻塞直至 ctx 结束。
 func (g *JT809Gateway) Start(ctx context.Context) error {
 	var startErr error
@@ -149,15 +367,76 @@ func (g *JT809Gateway) Start(ctx context.Context) error {
 		go g.mainSrv.Start()
 		g.startHTTPServer(ctx)
 		go g.healthCheckLoop(ctx)
+		go g.persistLoop(ctx)
+		if g.outbound != nil {
+			go g.outboundLoop(ctx)
+		}
+		if g.relay != nil {
+			go g.relay.run()
+		}
 	})
 	if startErr != nil {
 		return startErr
 	}
 	<-ctx.Done()
 	slog.Info("gateway shutting down", "reason", ctx.Err())
+	g.shutdown()
+	g.closeResources()
 	return nil
 }
 
+// closeResources 关闭出站队列、持久化、轨迹存储、事件推送与审计日志，未创建的组件跳过。
+func (g *JT809Gateway) closeResources() {
+	if g.outbound != nil {
+		g.outbound.close()
+	}
+	if err := g.store.ClosePersistence(); err != nil {
+		slog.Warn("flush platform state on shutdown failed", "err", err)
+	}
+	if g.track != nil {
+		if err := g.track.Close(); err != nil {
+			slog.Warn("close track store failed", "err", err)
+		}
+	}
+	if g.report != nil {
+		if err := g.report.Flush(time.Now()); err != nil {
+			slog.Warn("flush assessment stats on shutdown failed", "err", err)
+		}
+	}
+	for _, sink := range g.sinks {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Warn("close event sink failed", "err", err)
+			}
+		}
+	}
+	if g.httpAuth != nil {
+		if err := g.httpAuth.Close(); err != nil {
+			slog.Warn("close audit log failed", "err", err)
+		}
+	}
+}
+
+// persistLoop 定期将平台状态变更落盘。
+func (g *JT809Gateway) persistLoop(ctx context.Context) {
+	interval := g.cfg.PersistInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.store.Flush(); err != nil {
+				slog.Warn("flush platform state failed", "err", err)
+			}
+		}
+	}
+}
+
 func (g *JT809Gateway) initServers() error {
 	mainHost, mainPort, err := normalizeHostPort(g.cfg.MainListen)
 	if err != nil {
@@ -197,96 +476,73 @@ func (g *JT809Gateway) initServers() error {
 
 // handleMainMessage 处理主链路报文。
 func (g *JT809Gateway) handleMainMessage(session *goserver.AppSession, payload []byte) ([]byte, error) {
-	g.logPacket("main", "recv", session.ID, payload)
-	frame, err := jtt809.DecodeFrame(payload)
-	if err != nil {
-		slog.Warn("decode main frame failed", "session", session.ID, "err", err)
-		return nil, nil
-	}
-	if _, ok := g.sessionUser(session); !ok && frame.BodyID != jtt809.UP_CONNECT_REQ {
-		// 未登录成功前的报文直接忽略
-		slog.Warn("ignore message before login", "session", session.ID, "msg_id", fmt.Sprintf("0x%04X", frame.BodyID))
-		return nil, nil
-	}
-	switch frame.BodyID {
-	case jtt809.UP_CONNECT_REQ:
-		return g.handleMainLogin(session, frame)
-	case jtt809.UP_LINKTEST_REQ:
-		return g.handleHeartbeat(session, frame, true)
-	case jtt809.UP_DISCONNECT_REQ:
-		user, ok := g.sessionUser(session)
-		if ok {
-			resp := jtt809.LogoutResponse{}
-			if err := g.SendToSubordinate(user, frame.Header, resp); err != nil {
-				slog.Error("send logout response failed", "user_id", user, "err", err)
-			}
+	userID, loggedIn := g.sessionUser(session)
+	g.receiveFrame("main", userID, session.ID, payload, func(fc *FrameContext) {
+		frame := fc.Frame
+		if !loggedIn && frame.BodyID != jtt809.UP_CONNECT_REQ {
+			// 未登录成功前的报文直接忽略
+			slog.Warn("ignore message before login", "session", session.ID, "msg_id", fmt.Sprintf("0x%04X", frame.BodyID))
+			return
 		}
-		return nil, nil
-	case jtt809.DOWN_DISCONNECT_INFORM:
-		g.handleDisconnectInform(session, frame)
-	default:
-		user, ok := g.sessionUser(session)
-		if ok {
-			g.handleBusinessMessage(user, frame, true)
+		switch frame.BodyID {
+		case jtt809.UP_CONNECT_REQ:
+			g.handleMainLogin(session, frame)
+		case jtt809.UP_LINKTEST_REQ:
+			g.handleHeartbeat(session, frame, true)
+		case jtt809.UP_DISCONNECT_REQ:
+			g.handleLogout(session, frame)
+		case jtt809.DOWN_DISCONNECT_INFORM:
+			g.handleDisconnectInform(session, frame)
+		default:
+			g.handleBusinessMessage(userID, frame, true)
 		}
-	}
+	})
 	return nil, nil
 }
 
 // handleSubMessage 处理从链路报文（Active Mode）。
 // 正常情况下从链路用于接收应答，但当主链路断开时，下级平台可能通过从链路发送请求。
 func (g *JT809Gateway) handleSubMessage(userID uint32, payload []byte) {
-	g.logPacket("sub", "recv", fmt.Sprintf("%d", userID), payload)
-	frame, err := jtt809.DecodeFrame(payload)
-	if err != nil {
-		slog.Warn("decode sub frame failed", "user_id", userID, "err", err)
-		return
-	}
+	g.receiveFrame("sub", userID, "", payload, func(fc *FrameContext) {
+		frame := fc.Frame
+		switch frame.BodyID {
+		case jtt809.DOWN_CONNECT_REQ:
+			slog.Debug("received sub link login request on sub link", "user_id", userID)
+		case jtt809.DOWN_CONNECT_RSP:
+			slog.Debug("received sub link login response", "user_id", userID)
+		case jtt809.UP_DISCONNECT_INFORM:
+			g.handleSubDisconnect(userID, frame)
+		default:
+			g.handleBusinessMessage(userID, frame, false)
+		}
+	})
+}
 
-	switch frame.BodyID {
-	case jtt809.DOWN_CONNECT_REQ:
-		slog.Debug("received sub link login request on sub link", "user_id", userID)
-	case jtt809.DOWN_CONNECT_RSP:
-		slog.Debug("received sub link login response", "user_id", userID)
-	case jtt809.UP_DISCONNECT_INFORM:
-		g.handleSubDisconnect(userID, frame)
-	default:
-		g.handleBusinessMessage(userID, frame, false)
+// handleBusinessMessage 处理可能在主链路或从链路接收的业务消息，按业务 ID 与子业务类型分发给已注册的处理器。
+func (g *JT809Gateway) handleBusinessMessage(userID uint32, frame *jtt809.Frame, receivedOnMain bool) {
+	link := "main"
+	if !receivedOnMain {
+		link = "sub"
+	}
+	msg := &Message{UserID: userID, Link: link, Frame: frame, ReceivedAt: time.Now(), gateway: g}
+	accepted := g.dispatch(msg)
+	if g.relay != nil {
+		g.relay.uplink(msg, accepted)
 	}
 }
 
-// handleBusinessMessage 处理可能在主链路或从链路接收的业务消息
-func (g *JT809Gateway) handleBusinessMessage(userID uint32, frame *jtt809.Frame, receivedOnMain bool) {
-	switch frame.BodyID {
-	case jtt809.UP_EXG_MSG:
-		g.handleDynamicInfo(userID, frame)
-	case jtt809.UP_PLATFORM_MSG:
-		g.handlePlatformInfo(userID, frame)
-	case jtt809.UP_REALVIDEO_MSG:
-		g.handleRealTimeVideo(userID, frame)
-	case jtt809.UP_AUTHORIZE_MSG:
-		g.handleAuthorize(userID, frame)
-	case jtt809.UP_WARN_MSG:
-		g.handleAlarmInteract(userID, frame)
-	case jtt809.DOWN_LINKTEST_RSP:
-		g.store.RecordHeartbeat(userID, false)
-	default:
-		linkType := "main"
-		if !receivedOnMain {
-			linkType = "sub"
-		}
-		slog.Debug("unhandled business message", "user_id", userID, "link", linkType, "msg_id", fmt.Sprintf("0x%04X", frame.BodyID))
-	}
-}
-
-func (g *JT809Gateway) handleMainLogin(session *goserver.AppSession, frame *jtt809.Frame) ([]byte, error) {
+func (g *JT809Gateway) handleMainLogin(session *goserver.AppSession, frame *jtt809.Frame) {
 	req, err := jtt809.ParseLoginRequest(frame.RawBody)
 	if err != nil {
 		slog.Warn("parse main login failed", "session", session.ID, "err", err)
-		return nil, nil
+		return
 	}
 	clientIP := g.getClientIP(session)
 	acc, resp := g.auth.Authenticate(req, clientIP)
+	if resp.Result == jtt809.LoginOK && !g.admitLogin(session, acc) {
+		resp = jtt809.LoginResponse{Result: jtt809.LoginResourceBusy}
+	}
+	g.metrics.loginAttempts.inc(loginResultLabel(resp.Result))
 	slog.Info("main login request", "session", session.ID, "user_id", req.UserID, "gnss", frame.Header.GNSSCenterID, "ip", clientIP, "result", resp.Result)
 	if resp.Result == jtt809.LoginOK {
 		session.SetAttr("userID", req.UserID)
@@ -294,16 +550,24 @@ func (g *JT809Gateway) handleMainLogin(session *goserver.AppSession, frame *jtt8
 		g.store.BindMainSession(session.ID, req, acc.GnssCenterID, resp.VerifyCode)
 
 		// 触发登录回调
-		if g.callbacks != nil && g.callbacks.OnLogin != nil {
-			go g.callbacks.OnLogin(req.UserID, &req, &resp)
-		}
+		meta := EventMeta{UserID: req.UserID, Link: "main", MsgSN: frame.Header.MsgSN, ReceivedAt: time.Now()}
+		g.emit("OnLogin", req.UserID, "", func(ctx context.Context, h EventHandler) error {
+			return h.OnLogin(ctx, &LoginEvent{EventMeta: meta, Request: &req, Response: &resp})
+		})
+		g.publish(EventLogin, req.UserID, "", 0, LoginEventData{
+			Result:       resp.Result,
+			GNSSCenterID: req.GnssCenterID,
+			DownLinkIP:   req.DownLinkIP,
+			DownLinkPort: req.DownLinkPort,
+		})
+		g.linkStateChanged(req.UserID, "main", LinkStateUp, "")
 
 		// Start Sub Link Connection
 		go g.connectSubLinkWithRetry(req.UserID, false)
 	}
 
-	// 主链路登录应答通过统一方法发送（配置为主链路，不允许降级）
-	if err := g.SendToSubordinate(req.UserID, frame.Header, resp); err != nil {
+	// 登录应答在收到请求的连接上发送，登录失败或被拒绝时该连接并非账号的主链路
+	if err := g.replyOnSession(session, frame.Header, resp); err != nil {
 		slog.Error("send login response failed", "user_id", req.UserID, "err", err)
 	}
 
@@ -311,10 +575,12 @@ func (g *JT809Gateway) handleMainLogin(session *goserver.AppSession, frame *jtt8
 		// 登录失败后立即断开
 		session.Close("login failed")
 	}
-	return nil, nil
 }
 
 func (g *JT809Gateway) connectSubLinkWithRetry(userID uint32, isReconnect bool) {
+	if g.closing.Load() {
+		return
+	}
 	// 设置重连标志，如果已经在重连则直接返回
 	if !g.store.SetReconnecting(userID, true) {
 		slog.Info("sub link already reconnecting, skip", "user_id", userID)
@@ -322,10 +588,13 @@ func (g *JT809Gateway) connectSubLinkWithRetry(userID uint32, isReconnect bool)
 	}
 	defer g.store.SetReconnecting(userID, false)
 
-	// 重试次数策略
-	maxRetries := 3
+	// 重试次数策略：首次连接失败立即通知，重连按链路参数退避重试
+	timing := g.linkTiming(userID)
+	maxRetries := timing.ConnectAttempts
 	if !isReconnect {
 		maxRetries = 1
+	} else {
+		g.linkStateChanged(userID, "sub", LinkStateReconnecting, "")
 	}
 
 	for i := 0; i < maxRetries; i++ {
@@ -352,14 +621,21 @@ func (g *JT809Gateway) connectSubLinkWithRetry(userID uint32, isReconnect bool)
 			return
 		}
 
-		if g.connectSubLink(snap.DownLinkIP, snap.DownLinkPort, userID, snap.GNSSCenterID, snap.VerifyCode) {
+		mode := "initial"
+		if isReconnect {
+			mode = "reconnect"
+		}
+		if g.connectSubLink(snap.DownLinkIP, snap.DownLinkPort, userID, snap.GNSSCenterID, snap.VerifyCode, timing.ConnectTimeout) {
+			g.metrics.subLinkConnects.inc(mode, "success")
 			return
 		}
+		g.metrics.subLinkConnects.inc(mode, "failure")
 
 		// 仅在重连模式下等待重试
 		if isReconnect && i < maxRetries-1 {
-			time.Sleep(10 * time.Second) // 缩短等待时间以便快速重试
-			slog.Info("retrying sub link connection", "user_id", userID, "attempt", i+1)
+			delay := timing.retryDelay(i)
+			slog.Info("retrying sub link connection", "user_id", userID, "attempt", i+1, "delay", delay)
+			time.Sleep(delay)
 		}
 	}
 
@@ -370,6 +646,7 @@ func (g *JT809Gateway) connectSubLinkWithRetry(userID uint32, isReconnect bool)
 	}
 
 	slog.Warn("sub link connection failed, sending notification", "user_id", userID, "error_code", errorCode)
+	g.linkStateChanged(userID, "sub", LinkStateFailed, fmt.Sprintf("%d attempts failed", maxRetries))
 	g.sendDownDisconnectInform(userID, errorCode)
 }
 
@@ -395,7 +672,7 @@ func (g *JT809Gateway) sendDownDisconnectInform(userID uint32, code jtt809.Disco
 	}
 }
 
-func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gnssCenterID uint32, verifyCode uint32) bool {
+func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gnssCenterID uint32, verifyCode uint32, timeout time.Duration) bool {
 	slog.Info("connecting sub link", "ip", ip, "port", port, "user_id", userID)
 
 	c := client.NewSimpleClient(goserver.TCP, ip, int(port))
@@ -408,7 +685,7 @@ func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gns
 
 	// 设置读写超时
 	if conn := c.GetRawConn(); conn != nil {
-		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		conn.SetDeadline(time.Now().Add(timeout))
 	}
 
 	// Send Login
@@ -418,8 +695,7 @@ func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gns
 		GNSSCenterID: gnssCenterID,
 	}, req)
 
-	g.logPacket("sub", "send", fmt.Sprintf("%d", userID), pkg)
-	if err := c.Send(pkg); err != nil {
+	if err := g.sendFrame("sub", userID, "", pkg, c.Send); err != nil {
 		slog.Error("send sub login failed", "err", err)
 		c.Close()
 		return false
@@ -432,11 +708,10 @@ func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gns
 		c.Close()
 		return false
 	}
-	g.logPacket("sub", "recv", fmt.Sprintf("%d", userID), respData)
-
-	frame, err := jtt809.DecodeFrame(respData)
-	if err != nil {
-		slog.Error("decode sub login response failed", "err", err)
+	var frame *jtt809.Frame
+	g.receiveFrame("sub", userID, "", respData, func(fc *FrameContext) { frame = fc.Frame })
+	if frame == nil {
+		slog.Error("sub login response dropped", "user_id", userID)
 		c.Close()
 		return false
 	}
@@ -467,20 +742,29 @@ func (g *JT809Gateway) connectSubLink(ip string, port uint16, userID uint32, gns
 		conn.SetDeadline(time.Time{})
 	}
 
-	// 创建 context 用于控制从链路相关 goroutine 的生命周期
-	ctx, cancel := context.WithCancel(context.Background())
-	g.store.BindSubSession(userID, c, cancel)
+	// 创建 context 用于控制从链路相关 goroutine 的生命周期，取消原因用于链路状态回调
+	ctx, cancel := context.WithCancelCause(context.Background())
+	g.store.BindSubSession(userID, c, func() { cancel(nil) })
+	g.linkStateChanged(userID, "sub", LinkStateUp, "")
 
 	go g.readSubLinkLoop(ctx, c, userID, gnssCenterID, verifyCode, true)
-	go g.keepAliveSubLink(ctx, c, userID)
+	go g.keepAliveSubLink(ctx, cancel, c, userID)
 	return true
 }
 
 func (g *JT809Gateway) readSubLinkLoop(ctx context.Context, c *client.SimpleClient, userID uint32, gnssCenterID uint32, verifyCode uint32, shouldReconnect bool) {
+	var readErr error
 	defer func() {
 		c.Close()
-		g.store.ClearSubConn(userID)
-		slog.Info("sub link closed", "user_id", userID)
+		g.store.ClearSubConn(userID, c)
+		reason := "closed"
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			reason = cause.Error()
+		} else if ctx.Err() == nil && readErr != nil {
+			reason = readErr.Error()
+		}
+		slog.Info("sub link closed", "user_id", userID, "reason", reason)
+		g.linkStateChanged(userID, "sub", LinkStateDown, reason)
 		// 仅在需要重连时触发
 		if shouldReconnect {
 			go g.reconnectSubLink(userID)
@@ -503,6 +787,7 @@ func (g *JT809Gateway) readSubLinkLoop(ctx context.Context, c *client.SimpleClie
 		default:
 			data, err := c.Receive()
 			if err != nil {
+				readErr = err
 				slog.Error("sub link read error", "user_id", userID, "err", err)
 				return
 			}
@@ -512,7 +797,11 @@ func (g *JT809Gateway) readSubLinkLoop(ctx context.Context, c *client.SimpleClie
 }
 
 func (g *JT809Gateway) reconnectSubLink(userID uint32) {
-	time.Sleep(5 * time.Second)
+	timing := g.linkTiming(userID)
+	time.Sleep(timing.jitter(timing.ReconnectDelay))
+	if g.closing.Load() {
+		return
+	}
 	snap, ok := g.store.Snapshot(userID)
 	if !ok || snap.MainSessionID == "" {
 		slog.Info("skip sub link reconnect, main link not active", "user_id", userID)
@@ -523,7 +812,11 @@ func (g *JT809Gateway) reconnectSubLink(userID uint32) {
 }
 
 func (g *JT809Gateway) healthCheckLoop(ctx context.Context) {
-	ticker := time.NewTicker(60 * time.Second)
+	interval := g.cfg.HealthCheckInterval
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -531,6 +824,11 @@ func (g *JT809Gateway) healthCheckLoop(ctx context.Context) {
 			return
 		case <-ticker.C:
 			g.checkConnections()
+			g.checkVehiclePresence(time.Now())
+			if g.track != nil {
+				g.track.Cleanup(time.Now())
+			}
+			g.sampleAssessment()
 		}
 	}
 }
@@ -540,10 +838,11 @@ func (g *JT809Gateway) checkConnections() {
 	now := time.Now()
 
 	for _, snap := range snapshots {
+		timing := g.linkTiming(snap.UserID)
 		// 检查主链路断开超时情况
 		if snap.MainSessionID == "" && snap.SubConnected && !snap.MainDisconnectedAt.IsZero() {
-			// 主链路断开超过5分钟，关闭从链路以释放资源
-			if now.Sub(snap.MainDisconnectedAt) > 5*time.Minute {
+			// 主链路断开超过保留时长，关闭从链路以释放资源
+			if now.Sub(snap.MainDisconnectedAt) > timing.MainLinkGrace {
 				slog.Warn("main link disconnected timeout, closing sub link",
 					"user_id", snap.UserID,
 					"disconnected_duration", now.Sub(snap.MainDisconnectedAt))
@@ -558,6 +857,17 @@ func (g *JT809Gateway) checkConnections() {
 			continue
 		}
 
+		// 主链路心跳看门狗：长时间未收到 0x1005 时断开主链路，等待下级平台重新登录
+		if timing.MainHeartbeatTimeout > 0 && now.Sub(snap.LastMainBeat) > timing.MainHeartbeatTimeout {
+			slog.Warn("main link heartbeat timeout, closing main link",
+				"user_id", snap.UserID,
+				"last_heartbeat", snap.LastMainBeat.Format("2006-01-02 15:04:05"))
+			if err := g.CloseMainLink(snap.UserID, "heartbeat timeout"); err != nil {
+				slog.Warn("close main link after heartbeat timeout failed", "user_id", snap.UserID, "err", err)
+			}
+			continue
+		}
+
 		// 检查从链路是否需要重连
 		if !snap.SubConnected && snap.DownLinkIP != "" && snap.DownLinkPort > 0 {
 			slog.Warn("sub link disconnected, triggering reconnect", "user_id", snap.UserID)
@@ -568,8 +878,9 @@ func (g *JT809Gateway) checkConnections() {
 	g.checkVehiclePositions()
 }
 
-func (g *JT809Gateway) keepAliveSubLink(ctx context.Context, c *client.SimpleClient, userID uint32) {
-	ticker := time.NewTicker(60 * time.Second)
+func (g *JT809Gateway) keepAliveSubLink(ctx context.Context, cancel context.CancelCauseFunc, c *client.SimpleClient, userID uint32) {
+	interval := g.linkTiming(userID).SubHeartbeatInterval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -577,11 +888,25 @@ func (g *JT809Gateway) keepAliveSubLink(ctx context.Context, c *client.SimpleCli
 			slog.Info("sub heartbeat goroutine stopped", "user_id", userID)
 			return
 		case <-ticker.C:
+			// 链路参数支持热更新，每次发送前重新读取
+			timing := g.linkTiming(userID)
+			if timing.SubHeartbeatInterval != interval {
+				interval = timing.SubHeartbeatInterval
+				ticker.Reset(interval)
+			}
 			snap, ok := g.store.Snapshot(userID)
 			if !ok {
 				slog.Warn("skip sub heartbeat, snapshot missing", "user_id", userID)
 				continue
 			}
+			// 超时未收到 0x9006 应答，判定从链路失效，取消后由 readSubLinkLoop 触发重连
+			if timing.SubHeartbeatTimeout > 0 && time.Since(snap.LastSubBeat) > timing.SubHeartbeatTimeout {
+				slog.Warn("sub link heartbeat response timeout, closing sub link",
+					"user_id", userID,
+					"last_response", snap.LastSubBeat.Format("2006-01-02 15:04:05"))
+				cancel(errSubHeartbeatTimeout)
+				return
+			}
 			if snap.GNSSCenterID == 0 {
 				slog.Warn("skip sub heartbeat, missing GNSSCenterID", "user_id", userID)
 				continue
@@ -589,8 +914,7 @@ func (g *JT809Gateway) keepAliveSubLink(ctx context.Context, c *client.SimpleCli
 			hb, _ := jtt809.BuildSubLinkHeartbeat(jtt809.Header{
 				GNSSCenterID: snap.GNSSCenterID,
 			})
-			g.logPacket("sub", "send", fmt.Sprintf("%d", userID), hb)
-			if err := c.Send(hb); err != nil {
+			if err := g.sendFrame("sub", userID, "", hb, c.Send); err != nil {
 				slog.Warn("send sub heartbeat failed", "user_id", userID, "err", err)
 				// 心跳失败，主动关闭连接以触发readSubLinkLoop退出
 				c.Close()
@@ -600,11 +924,11 @@ func (g *JT809Gateway) keepAliveSubLink(ctx context.Context, c *client.SimpleCli
 	}
 }
 
-func (g *JT809Gateway) handleHeartbeat(session *goserver.AppSession, frame *jtt809.Frame, isMain bool) ([]byte, error) {
+func (g *JT809Gateway) handleHeartbeat(session *goserver.AppSession, frame *jtt809.Frame, isMain bool) {
 	user, ok := g.sessionUser(session)
 	if !ok {
 		slog.Warn("heartbeat before login", "session", session.ID)
-		return nil, nil
+		return
 	}
 
 	g.store.RecordHeartbeat(user, isMain)
@@ -614,242 +938,347 @@ func (g *JT809Gateway) handleHeartbeat(session *goserver.AppSession, frame *jtt8
 	if err := g.SendToSubordinate(user, frame.Header, resp); err != nil {
 		slog.Error("send heartbeat response failed", "user_id", user, "err", err)
 	}
-	return nil, nil
 }
 
-func (g *JT809Gateway) handleDynamicInfo(userID uint32, frame *jtt809.Frame) {
-	pkt, err := jtt809.ParseSubBusiness(frame.RawBody)
+// handleVehicleRegistration 处理车辆注册信息（0x1201）。
+func (g *JT809Gateway) handleVehicleRegistration(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	info, err := jtt809.ParseVehicleRegistration(pkt.Payload)
 	if err != nil {
-		slog.Warn("parse sub business failed", "user_id", userID, "err", err)
+		slog.Warn("parse vehicle registration failed", "user_id", userID, "err", err)
+		msg.reject()
 		return
 	}
-	switch {
-	case pkt.SubBusinessID == jtt809.UP_EXG_MSG_REGISTER:
-		info, err := jtt809.ParseVehicleRegistration(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse vehicle registration failed", "user_id", userID, "err", err)
-			return
-		}
-		reg := &VehicleRegistration{
-			PlatformID:        info.PlatformID,
-			ProducerID:        info.ProducerID,
-			TerminalModelType: info.TerminalModelType,
-			IMEI:              info.IMEI,
-			TerminalID:        info.TerminalID,
-			TerminalSIM:       info.TerminalSIM,
-		}
-		g.store.UpdateVehicleRegistration(userID, pkt.Color, pkt.Plate, reg)
-		slog.Info("vehicle registration", "user_id", userID, "plate", pkt.Plate, "platform", reg.PlatformID)
+	reg := &VehicleRegistration{
+		PlatformID:        info.PlatformID,
+		ProducerID:        info.ProducerID,
+		TerminalModelType: info.TerminalModelType,
+		IMEI:              info.IMEI,
+		TerminalID:        info.TerminalID,
+		TerminalSIM:       info.TerminalSIM,
+	}
+	g.store.UpdateVehicleRegistration(userID, pkt.Color, pkt.Plate, reg)
+	slog.Info("vehicle registration", "user_id", userID, "plate", pkt.Plate, "platform", reg.PlatformID)
 
-		// 触发车辆注册回调
-		if g.callbacks != nil && g.callbacks.OnVehicleRegistration != nil {
-			go g.callbacks.OnVehicleRegistration(userID, pkt.Plate, pkt.Color, reg)
-		}
+	// 触发车辆注册回调
+	g.emit("OnVehicleRegistration", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleRegistration(ctx, &VehicleRegistrationEvent{EventMeta: msg.meta(), VehicleNo: pkt.Plate, VehicleColor: pkt.Color, Registration: reg})
+	})
+	g.publish(EventVehicleRegistration, userID, pkt.Plate, pkt.Color, RegistrationEventData{
+		PlatformID:        reg.PlatformID,
+		ProducerID:        reg.ProducerID,
+		TerminalModelType: reg.TerminalModelType,
+		IMEI:              reg.IMEI,
+		TerminalID:        reg.TerminalID,
+		TerminalSIM:       reg.TerminalSIM,
+	})
 
-		// 自动订阅该车辆的实时定位数据
-		go g.autoSubscribeVehicle(userID, pkt.Color, pkt.Plate)
-	case pkt.SubBusinessID == jtt809.UP_EXG_MSG_REAL_LOCATION:
-		pos, err := jtt809.ParseVehiclePosition(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse vehicle position failed", "user_id", userID, "err", err)
-			return
-		}
-		g.store.UpdateLocation(userID, pkt.Color, pkt.Plate, &pos, 0)
+	// 自动订阅该车辆的实时定位数据
+	go g.autoSubscribeVehicle(userID, pkt.Color, pkt.Plate)
+}
 
-		// 触发车辆定位回调
-		var gnssData *jtt809.GNSSData
-		if gnss, err := jtt809.ParseGNSSData(pos.GnssData); err == nil {
-			gnssData = &gnss
-		}
-		if g.callbacks != nil && g.callbacks.OnVehicleLocation != nil {
-			go g.callbacks.OnVehicleLocation(userID, pkt.Plate, pkt.Color, &pos, gnssData)
-		}
-		if gnssData != nil {
-			slog.Info("vehicle location", "user_id", userID, "plate", pkt.Plate, "lon", gnssData.Longitude, "lat", gnssData.Latitude)
-		} else {
-			slog.Info("vehicle location", "user_id", userID, "plate", pkt.Plate, "gnss_len", len(pos.GnssData))
-		}
-	case pkt.SubBusinessID == jtt809.UP_EXG_MSG_HISTORY_LOCATION:
-		if len(pkt.Payload) == 0 {
-			return
-		}
-		count := int(pkt.Payload[0])
-		reader := pkt.Payload[1:]
-		parsed := 0
-		gnsss := make([]jtt809.GNSSData, 0, count)
-		for i := 0; i < count && len(reader) >= 5; i++ {
-			gnssLen := int(binary.BigEndian.Uint32(reader[1:5]))
-			totalLen := 1 + 4 + gnssLen + (11+4)*3
-			if gnssLen < 0 || len(reader) < totalLen {
-				break
-			}
-			pos, err := jtt809.ParseVehiclePosition(reader[:totalLen])
-			if err != nil {
-				slog.Warn("parse batch vehicle position failed", "user_id", userID, "index", i, "err", err)
-				break
-			}
-			g.store.UpdateLocation(userID, pkt.Color, pkt.Plate, &pos, count)
-			if gnss, err := jtt809.ParseGNSSData(pos.GnssData); err == nil {
-				gnsss = append(gnsss, gnss)
-				slog.Info("batch location item", "user_id", userID, "plate", pkt.Plate, "index", i, "lon", gnss.Longitude, "lat", gnss.Latitude)
-			}
-			reader = reader[totalLen:]
-			parsed++
-		}
-		slog.Info("batch vehicle location", "user_id", userID, "plate", pkt.Plate, "count", parsed)
+// handleRealLocation 处理车辆实时定位（0x1202）。
+func (g *JT809Gateway) handleRealLocation(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	pos, err := jtt809.ParseVehiclePosition(pkt.Payload)
+	if err != nil {
+		slog.Warn("parse vehicle position failed", "user_id", userID, "err", err)
+		msg.reject()
+		return
+	}
+	g.store.UpdateLocation(userID, pkt.Color, pkt.Plate, &pos, 0)
 
-		// 触发批量定位回调
-		if g.callbacks != nil && g.callbacks.OnVehicleLocationSupplementary != nil {
-			go g.callbacks.OnVehicleLocationSupplementary(userID, pkt.Plate, pkt.Color, gnsss)
+	// 触发车辆定位回调
+	var gnssData *jtt809.GNSSData
+	gnss, err := jtt809.ParseGNSSData(pos.GnssData)
+	if err == nil {
+		gnssData = &gnss
+	}
+	meta := msg.meta()
+	g.checkQuality(meta, pkt.Plate, pkt.Color, gnssData, TrackSourceRealtime, msg.Frame.Header.Timestamp)
+	if gnssData != nil {
+		g.recordTrack(NewTrackPoint(userID, pkt.Plate, pkt.Color, gnssData, TrackSourceRealtime, time.Now()))
+		g.evaluateGeofences(meta, pkt.Plate, pkt.Color, gnssData)
+		g.evaluateRules(meta, pkt.Plate, pkt.Color, gnssData)
+	}
+	g.emit("OnVehicleLocation", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleLocation(ctx, &VehicleLocationEvent{EventMeta: meta, VehicleNo: pkt.Plate, VehicleColor: pkt.Color, Position: &pos, GNSS: gnssData})
+	})
+	g.markVehicleSeen(meta, pkt.Plate, pkt.Color)
+	if gnssData != nil {
+		g.publish(EventVehicleLocation, userID, pkt.Plate, pkt.Color, newLocationData(gnssData))
+	}
+	if gnssData != nil {
+		slog.Info("vehicle location", "user_id", userID, "plate", pkt.Plate, "lon", gnssData.Longitude, "lat", gnssData.Latitude)
+	} else {
+		slog.Info("vehicle location", "user_id", userID, "plate", pkt.Plate, "gnss_len", len(pos.GnssData))
+	}
+}
+
+// handleHistoryLocation 处理车辆定位信息自动补报（0x1203）。
+func (g *JT809Gateway) handleHistoryLocation(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	if len(pkt.Payload) == 0 {
+		msg.reject()
+		return
+	}
+	count := int(pkt.Payload[0])
+	reader := pkt.Payload[1:]
+	parsed := 0
+	gnsss := make([]jtt809.GNSSData, 0, count)
+	for i := 0; i < count && len(reader) >= 5; i++ {
+		gnssLen := int(binary.BigEndian.Uint32(reader[1:5]))
+		totalLen := 1 + 4 + gnssLen + (11+4)*3
+		if gnssLen < 0 || len(reader) < totalLen {
+			break
 		}
-	case pkt.SubBusinessID == jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK:
-		ack, err := jtt809.ParseMonitorAck(pkt.Payload)
+		pos, err := jtt809.ParseVehiclePosition(reader[:totalLen])
 		if err != nil {
-			slog.Warn("parse monitor startup ack failed", "user_id", userID, "err", err, "payload_hex", fmt.Sprintf("%X", pkt.Payload))
-			return
+			slog.Warn("parse batch vehicle position failed", "user_id", userID, "index", i, "err", err)
+			break
 		}
-		slog.Info("monitor startup ack received",
-			"user_id", userID,
-			"plate", pkt.Plate,
-			"source_type", fmt.Sprintf("0x%04X", ack.SourceDataType),
-			"source_sn", ack.SourceMsgSN,
-			"data_length", ack.DataLength)
-
-		// 触发启动车辆定位应答回调
-		if g.callbacks != nil && g.callbacks.OnMonitorStartupAck != nil {
-			go g.callbacks.OnMonitorStartupAck(userID, pkt.Plate, pkt.Color)
+		g.store.UpdateLocation(userID, pkt.Color, pkt.Plate, &pos, count)
+		if gnss, err := jtt809.ParseGNSSData(pos.GnssData); err == nil {
+			gnsss = append(gnsss, gnss)
+			g.checkQuality(msg.meta(), pkt.Plate, pkt.Color, &gnss, TrackSourceSupplementary, msg.Frame.Header.Timestamp)
+			slog.Info("batch location item", "user_id", userID, "plate", pkt.Plate, "index", i, "lon", gnss.Longitude, "lat", gnss.Latitude)
+		} else {
+			g.checkQuality(msg.meta(), pkt.Plate, pkt.Color, nil, TrackSourceSupplementary, msg.Frame.Header.Timestamp)
 		}
-	case pkt.SubBusinessID == jtt809.UP_EXG_MSG_RETURN_END_ACK:
-		ack, err := jtt809.ParseMonitorAck(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse monitor end ack failed", "user_id", userID, "err", err, "payload_hex", fmt.Sprintf("%X", pkt.Payload))
-			return
+		reader = reader[totalLen:]
+		parsed++
+	}
+	slog.Info("batch vehicle location", "user_id", userID, "plate", pkt.Plate, "count", parsed)
+	if parsed == 0 {
+		msg.reject()
+	}
+	if len(gnsss) > 0 {
+		receivedAt := time.Now()
+		points := make([]TrackPoint, 0, len(gnsss))
+		for i := range gnsss {
+			points = append(points, NewTrackPoint(userID, pkt.Plate, pkt.Color, &gnsss[i], TrackSourceSupplementary, receivedAt))
 		}
-		// 收到应答表示下级平台已接收取消订阅请求
-		slog.Info("monitor end ack received",
-			"user_id", userID,
-			"plate", pkt.Plate,
-			"source_type", fmt.Sprintf("0x%04X", ack.SourceDataType),
-			"source_sn", ack.SourceMsgSN)
+		g.recordTrack(points...)
+	}
 
-		// 触发结束车辆定位应答回调
-		if g.callbacks != nil && g.callbacks.OnMonitorEndAck != nil {
-			go g.callbacks.OnMonitorEndAck(userID, pkt.Plate, pkt.Color)
+	// 触发批量定位回调
+	g.emit("OnVehicleLocationSupplementary", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleLocationSupplementary(ctx, &VehicleLocationSupplementaryEvent{EventMeta: msg.meta(), VehicleNo: pkt.Plate, VehicleColor: pkt.Color, GNSS: gnsss})
+	})
+	if len(gnsss) > 0 {
+		locations := make([]*LocationData, 0, len(gnsss))
+		for i := range gnsss {
+			locations = append(locations, newLocationData(&gnsss[i]))
 		}
-	default:
-		slog.Debug("unhandled dynamic sub business", "user_id", userID, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
+		g.publish(EventVehicleLocationSupplementary, userID, pkt.Plate, pkt.Color, locations)
 	}
 }
 
-func (g *JT809Gateway) handlePlatformInfo(userID uint32, frame *jtt809.Frame) {
-	pkt, err := jtt809.ParseSubBusiness(frame.RawBody)
+// handleMonitorStartupAck 处理启动车辆定位信息交换应答（0x1205）。
+func (g *JT809Gateway) handleMonitorStartupAck(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	ack, err := jtt809.ParseMonitorAck(pkt.Payload)
 	if err != nil {
-		slog.Warn("parse platform info failed", "user_id", userID, "err", err)
+		slog.Warn("parse monitor startup ack failed", "user_id", userID, "err", err, "payload_hex", fmt.Sprintf("%X", pkt.Payload))
+		msg.reject()
 		return
 	}
-	if pkt.SubBusinessID == jtt809.UP_PLATFORM_MSG_POST_QUERY_ACK {
-		ack, err := jtt809.ParsePlatformQueryAck(pkt)
-		if err != nil {
-			slog.Warn("parse platform query ack failed", "user_id", userID, "err", err)
-			return
-		}
-		slog.Info("platform query ack", "user_id", userID, "object", ack.ObjectID, "info", ack.InfoContent)
+	slog.Info("monitor startup ack received",
+		"user_id", userID,
+		"plate", pkt.Plate,
+		"source_type", fmt.Sprintf("0x%04X", ack.SourceDataType),
+		"source_sn", ack.SourceMsgSN,
+		"data_length", ack.DataLength)
+
+	g.resolveReply(replyInfo{userID: userID, subID: pkt.SubBusinessID, plate: pkt.Plate, color: pkt.Color,
+		hasSource: true, sourceSub: ack.SourceDataType, sourceSN: ack.SourceMsgSN}, ack)
+
+	// 触发启动车辆定位应答回调
+	g.emit("OnMonitorStartupAck", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnMonitorStartupAck(ctx, &MonitorAckEvent{EventMeta: msg.meta(), VehicleNo: pkt.Plate, VehicleColor: pkt.Color})
+	})
+	g.publish(EventMonitorStartupAck, userID, pkt.Plate, pkt.Color, nil)
+}
+
+// handleMonitorEndAck 处理结束车辆定位信息交换应答（0x1206）。
+func (g *JT809Gateway) handleMonitorEndAck(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	ack, err := jtt809.ParseMonitorAck(pkt.Payload)
+	if err != nil {
+		slog.Warn("parse monitor end ack failed", "user_id", userID, "err", err, "payload_hex", fmt.Sprintf("%X", pkt.Payload))
+		msg.reject()
 		return
 	}
-	slog.Debug("unhandled platform info sub", "user_id", userID, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
+	// 收到应答表示下级平台已接收取消订阅请求
+	slog.Info("monitor end ack received",
+		"user_id", userID,
+		"plate", pkt.Plate,
+		"source_type", fmt.Sprintf("0x%04X", ack.SourceDataType),
+		"source_sn", ack.SourceMsgSN)
+
+	g.resolveReply(replyInfo{userID: userID, subID: pkt.SubBusinessID, plate: pkt.Plate, color: pkt.Color,
+		hasSource: true, sourceSub: ack.SourceDataType, sourceSN: ack.SourceMsgSN}, ack)
+
+	// 触发结束车辆定位应答回调
+	g.emit("OnMonitorEndAck", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnMonitorEndAck(ctx, &MonitorAckEvent{EventMeta: msg.meta(), VehicleNo: pkt.Plate, VehicleColor: pkt.Color})
+	})
+	g.publish(EventMonitorEndAck, userID, pkt.Plate, pkt.Color, nil)
 }
 
-func (g *JT809Gateway) handleAlarmInteract(userID uint32, frame *jtt809.Frame) {
-	pkt, err := jtt809.ParseAlarmInfo(frame.RawBody)
+// handlePlatformQueryAck 处理平台查岗应答（0x1301），计入查岗响应率统计。
+func (g *JT809Gateway) handlePlatformQueryAck(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	ack, err := jtt809.ParsePlatformQueryAck(pkt)
 	if err != nil {
-		slog.Warn("parse alarm interact failed", "user_id", userID, "err", err)
+		slog.Warn("parse platform query ack failed", "user_id", userID, "err", err)
+		msg.reject()
 		return
 	}
-	switch pkt.SubBusinessID {
-	case jtt809.UP_WARN_MSG_ADPT_INFO:
-		info, err := jtt809.ParseWarnMsgAdptInfo(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse warn msg adpt info failed", "user_id", userID, "err", err, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
-			return
-		}
-		slog.Info("warn msg adpt info received",
-			"user_id", userID,
-			"src_platform", info.SourcePlatformID,
-			"warn_type", fmt.Sprintf("0x%04X", info.WarnType),
-			"warn_time", info.WarnTime,
-			"start_time", info.StartTime,
-			"end_time", info.EndTime,
-			"vehicle", info.VehicleNo,
-			"vehicle_color", info.VehicleColor,
-			"dst_platform", info.TargetPlatformID,
-			"drv_line_id", info.DrvLineID,
-			"info_length", info.InfoLength)
-		if g.callbacks != nil && g.callbacks.OnWarnMsgAdptInfo != nil {
-			go g.callbacks.OnWarnMsgAdptInfo(userID, info)
-		}
-	case jtt809.UP_WARN_MSG_INFORM_TIPS:
-		info, err := jtt809.ParseWarnMsgInformTips(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse warn msg inform tips failed", "user_id", userID, "err", err, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
-			return
-		}
-		slog.Info("warn msg inform tips received",
-			"user_id", userID,
-			"src_platform", info.SourcePlatformID,
-			"warn_type", fmt.Sprintf("0x%04X", info.WarnType),
-			"warn_time", info.WarnTime,
-			"start_time", info.StartTime,
-			"end_time", info.EndTime,
-			"vehicle", info.VehicleNo,
-			"vehicle_color", info.VehicleColor,
-			"dst_platform", info.TargetPlatformID,
-			"drv_line_id", info.DrvLineID,
-			"warn_length", info.WarnLength)
-		if g.callbacks != nil && g.callbacks.OnWarnMsgInformTips != nil {
-			go g.callbacks.OnWarnMsgInformTips(userID, info)
-		}
-	default:
-		slog.Debug("unhandled alarm interact sub business", "user_id", userID, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
+	infoID, latency, matched := g.report.RecordCheckAnswer(userID, ack.ObjectID, ack.SourceMsgSN, time.Now())
+	slog.Info("platform query ack", "user_id", userID, "object", ack.ObjectID, "source_sn", ack.SourceMsgSN, "info_id", infoID, "info", ack.InfoContent, "matched", matched, "latency", latency)
+}
+
+// handleWarnAdptInfo 处理上报报警信息（0x1402）。
+func (g *JT809Gateway) handleWarnAdptInfo(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	info, err := jtt809.ParseWarnMsgAdptInfo(pkt.Payload)
+	if err != nil {
+		slog.Warn("parse warn msg adpt info failed", "user_id", userID, "err", err, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
+		msg.reject()
+		return
 	}
+	slog.Info("warn msg adpt info received",
+		"user_id", userID,
+		"src_platform", info.SourcePlatformID,
+		"warn_type", fmt.Sprintf("0x%04X", info.WarnType),
+		"warn_time", info.WarnTime,
+		"start_time", info.StartTime,
+		"end_time", info.EndTime,
+		"vehicle", info.VehicleNo,
+		"vehicle_color", info.VehicleColor,
+		"dst_platform", info.TargetPlatformID,
+		"drv_line_id", info.DrvLineID,
+		"info_length", info.InfoLength)
+	g.report.RecordAlarm(userID, info.VehicleNo, info.VehicleColor, true, time.Now())
+	g.emit("OnWarnMsgAdptInfo", userID, info.VehicleNo, func(ctx context.Context, h EventHandler) error {
+		return h.OnWarnMsgAdptInfo(ctx, &WarnAdptInfoEvent{EventMeta: msg.meta(), Info: info})
+	})
+	g.publish(EventWarnAdptInfo, userID, info.VehicleNo, info.VehicleColor, WarnEventData{
+		SourcePlatformID: info.SourcePlatformID,
+		TargetPlatformID: info.TargetPlatformID,
+		WarnType:         info.WarnType,
+		WarnTime:         info.WarnTime,
+		StartTime:        info.StartTime,
+		EndTime:          info.EndTime,
+		DrvLineID:        info.DrvLineID,
+		Content:          info.InfoContent,
+	})
 }
 
-func (g *JT809Gateway) handleDisconnectInform(session *goserver.AppSession, frame *jtt809.Frame) {
-	disc, err := jtt809.ParseDisconnectInform(frame)
+// handleWarnInformTips 处理主动上报报警处理结果（0x1403）。
+func (g *JT809Gateway) handleWarnInformTips(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	info, err := jtt809.ParseWarnMsgInformTips(pkt.Payload)
 	if err != nil {
-		slog.Warn("parse disconnect inform failed", "session", session.ID, "err", err)
+		slog.Warn("parse warn msg inform tips failed", "user_id", userID, "err", err, "sub_id", fmt.Sprintf("0x%04X", pkt.SubBusinessID))
+		msg.reject()
 		return
 	}
-	slog.Warn("platform disconnect notify", "session", session.ID, "code", disc.ErrorCode)
+	slog.Info("warn msg inform tips received",
+		"user_id", userID,
+		"src_platform", info.SourcePlatformID,
+		"warn_type", fmt.Sprintf("0x%04X", info.WarnType),
+		"warn_time", info.WarnTime,
+		"start_time", info.StartTime,
+		"end_time", info.EndTime,
+		"vehicle", info.VehicleNo,
+		"vehicle_color", info.VehicleColor,
+		"dst_platform", info.TargetPlatformID,
+		"drv_line_id", info.DrvLineID,
+		"warn_length", info.WarnLength)
+	g.report.RecordAlarm(userID, info.VehicleNo, info.VehicleColor, true, time.Now())
+	g.emit("OnWarnMsgInformTips", userID, info.VehicleNo, func(ctx context.Context, h EventHandler) error {
+		return h.OnWarnMsgInformTips(ctx, &WarnInformTipsEvent{EventMeta: msg.meta(), Info: info})
+	})
+	g.publish(EventWarnInformTips, userID, info.VehicleNo, info.VehicleColor, WarnEventData{
+		SourcePlatformID: info.SourcePlatformID,
+		TargetPlatformID: info.TargetPlatformID,
+		WarnType:         info.WarnType,
+		WarnTime:         info.WarnTime,
+		StartTime:        info.StartTime,
+		EndTime:          info.EndTime,
+		DrvLineID:        info.DrvLineID,
+		Content:          info.WarnContent,
+	})
 }
 
-func (g *JT809Gateway) handleRealTimeVideo(userID uint32, frame *jtt809.Frame) {
-	pkt, err := jtt809.ParseSubBusiness(frame.RawBody)
+// handleVideoStartupAck 处理实时音视频请求应答（0x1801）。
+func (g *JT809Gateway) handleVideoStartupAck(msg *Message) {
+	userID, pkt := msg.UserID, msg.Sub
+	ack, err := jt1078.ParseRealTimeVideoStartupAck(pkt.Payload)
 	if err != nil {
-		slog.Warn("parse sub business failed", "user_id", userID, "err", err)
+		slog.Warn("parse video ack failed", "user_id", userID, "err", err)
+		msg.reject()
 		return
 	}
-	if pkt.SubBusinessID == jtt809.UP_REALVIDEO_MSG_STARTUP_ACK {
-		ack, err := jt1078.ParseRealTimeVideoStartupAck(pkt.Payload)
-		if err != nil {
-			slog.Warn("parse video ack failed", "user_id", userID, "err", err)
-			return
-		}
-		g.store.RecordVideoAck(userID, pkt.Color, pkt.Plate, &VideoAckState{
-			Result:     ack.Result,
-			ServerIP:   ack.ServerIP,
-			ServerPort: ack.ServerPort,
-		})
-		slog.Info("video stream ack", "user_id", userID, "plate", pkt.Plate, "server", ack.ServerIP, "port", ack.ServerPort, "result", ack.Result)
-
-		// 触发视频应答回调
-		if g.callbacks != nil && g.callbacks.OnVideoResponse != nil {
-			go g.callbacks.OnVideoResponse(userID, pkt.Plate, pkt.Color, &VideoAckState{
-				Result:     ack.Result,
-				ServerIP:   ack.ServerIP,
-				ServerPort: ack.ServerPort,
-			})
-		}
+	g.store.RecordVideoAck(userID, pkt.Color, pkt.Plate, &VideoAckState{
+		Result:     ack.Result,
+		ServerIP:   ack.ServerIP,
+		ServerPort: ack.ServerPort,
+	})
+	slog.Info("video stream ack", "user_id", userID, "plate", pkt.Plate, "server", ack.ServerIP, "port", ack.ServerPort, "result", ack.Result)
+	g.resolveReply(replyInfo{userID: userID, subID: pkt.SubBusinessID, plate: pkt.Plate, color: pkt.Color}, &VideoAckState{
+		Result:     ack.Result,
+		ServerIP:   ack.ServerIP,
+		ServerPort: ack.ServerPort,
+		ReceivedAt: time.Now(),
+	})
+
+	// 触发视频应答回调
+	videoAck := &VideoAckState{
+		Result:     ack.Result,
+		ServerIP:   ack.ServerIP,
+		ServerPort: ack.ServerPort,
+	}
+	g.emit("OnVideoResponse", userID, pkt.Plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVideoResponse(ctx, &VideoResponseEvent{EventMeta: msg.meta(), VehicleNo: pkt.Plate, VehicleColor: pkt.Color, Ack: videoAck})
+	})
+	g.publish(EventVideoResponse, userID, pkt.Plate, pkt.Color, VideoAckEventData{
+		Result:     ack.Result,
+		ServerIP:   ack.ServerIP,
+		ServerPort: ack.ServerPort,
+	})
+}
+
+// handleAuthorizeStartup 处理时效口令上报（0x1701）。
+func (g *JT809Gateway) handleAuthorizeStartup(msg *Message) {
+	userID := msg.UserID
+	req, err := jt1078.ParseAuthorizeStartupReq(msg.Sub.Payload)
+	if err != nil {
+		slog.Warn("parse authorize startup req failed", "user_id", userID, "err", err)
+		msg.reject()
+		return
+	}
+	authCode := req.AuthorizeCode1
+	// 注意：0x1700 消息中没有车牌号和颜色信息，时效口令是平台级别的
+	g.store.UpdateAuthCode(userID, req.PlatformID, authCode)
+	slog.Info("video authorize report", "user_id", userID, "platform", req.PlatformID, "auth_code", authCode)
+
+	// 触发鉴权回调
+	g.emit("OnAuthorize", userID, "", func(ctx context.Context, h EventHandler) error {
+		return h.OnAuthorize(ctx, &AuthorizeEvent{EventMeta: msg.meta(), PlatformID: req.PlatformID, AuthorizeCode: authCode})
+	})
+	g.publish(EventAuthorize, userID, "", 0, AuthorizeEventData{PlatformID: req.PlatformID, AuthorizeCode: authCode})
+}
+
+func (g *JT809Gateway) handleDisconnectInform(session *goserver.AppSession, frame *jtt809.Frame) {
+	disc, err := jtt809.ParseDisconnectInform(frame)
+	if err != nil {
+		slog.Warn("parse disconnect inform failed", "session", session.ID, "err", err)
+		return
 	}
+	slog.Warn("platform disconnect notify", "session", session.ID, "code", disc.ErrorCode)
 }
 
 func (g *JT809Gateway) handleSubDisconnect(userID uint32, frame *jtt809.Frame) {
@@ -886,16 +1315,10 @@ func (g *JT809Gateway) getClientIP(session *goserver.AppSession) string {
 }
 
 // SendToSubordinate 向下级平台发送消息（统一发送方法）
-// 根据消息类型自动选择链路，支持降级
+// 根据消息类型自动选择链路，支持降级；启用 OutboundQueue 时主从链路均不可用的业务报文暂存后补发
 func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, body jtt809.Body) error {
 	msgID := body.MsgID()
 
-	// 获取链路策略
-	policy, ok := linkPolicies[msgID]
-	if !ok {
-		policy = defaultLinkPolicy
-	}
-
 	// 构造消息包
 	pkg := jtt809.Package{
 		Header: header.WithResponse(msgID),
@@ -905,6 +1328,24 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 	if err != nil {
 		return fmt.Errorf("encode package: %w", err)
 	}
+	return g.sendEncoded(userID, msgID, encodedSubBusinessID(body, data), data)
+}
+
+// deliver 按业务 ID 的链路策略发送已编码的报文。
+func (g *JT809Gateway) deliver(userID uint32, msgID uint16, data []byte) error {
+	policy := g.currentPolicy().linkPolicy(msgID)
+	send := func(link string) error {
+		var err error
+		if link == "main" {
+			err = g.sendOnMainLink(userID, data)
+		} else {
+			err = g.sendOnSubLink(userID, data)
+		}
+		if err != nil {
+			g.metrics.sendFailures.inc(link, policy.PreferredLink)
+		}
+		return err
+	}
 
 	// 获取链路状态
 	mainActive, subActive := g.store.GetLinkStatus(userID)
@@ -915,7 +1356,7 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 		sent := false
 		var lastErr error
 		if mainActive {
-			if err := g.sendOnMainLink(userID, data); err != nil {
+			if err := send("main"); err != nil {
 				slog.Warn("send on main link failed", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID), "err", err)
 				lastErr = err
 			} else {
@@ -923,7 +1364,7 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 			}
 		}
 		if subActive {
-			if err := g.sendOnSubLink(userID, data); err != nil {
+			if err := send("sub"); err != nil {
 				slog.Warn("send on sub link failed", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID), "err", err)
 				lastErr = err
 			} else {
@@ -939,7 +1380,8 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 	case "main":
 		// 首选主链路
 		if mainActive {
-			if err := g.sendOnMainLink(userID, data); err == nil {
+			err := send("main")
+			if err == nil {
 				return nil
 			}
 			slog.Warn("send on main link failed", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID), "err", err)
@@ -947,14 +1389,15 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 		// 主链路不可用，尝试降级
 		if policy.AllowFallback && subActive {
 			slog.Info("main link unavailable, fallback to sub link", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID))
-			if err := g.sendOnSubLink(userID, data); err == nil {
+			if err := send("sub"); err == nil {
 				return nil
 			}
 		}
 	default: // "sub"
 		// 首选从链路
 		if subActive {
-			if err := g.sendOnSubLink(userID, data); err == nil {
+			err := send("sub")
+			if err == nil {
 				return nil
 			}
 			slog.Warn("send on sub link failed", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID), "err", err)
@@ -962,27 +1405,42 @@ func (g *JT809Gateway) SendToSubordinate(userID uint32, header jtt809.Header, bo
 		// 从链路不可用，尝试降级
 		if policy.AllowFallback && mainActive {
 			slog.Info("sub link unavailable, fallback to main link", "user_id", userID, "msg_id", fmt.Sprintf("0x%04X", msgID))
-			if err := g.sendOnMainLink(userID, data); err == nil {
+			if err := send("main"); err == nil {
 				return nil
 			}
 		}
 	}
 
+	g.metrics.sendFailures.inc("none", policy.PreferredLink)
 	return fmt.Errorf("no available link for platform %d, msg_id=0x%04X", userID, msgID)
 }
 
+// replyOnSession 在收到请求的主链路连接上直接应答，用于登录、注销等不经过链路选择的场景。
+func (g *JT809Gateway) replyOnSession(session *goserver.AppSession, header jtt809.Header, body jtt809.Body) error {
+	msgID := body.MsgID()
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: header.WithResponse(msgID), Body: body})
+	if err != nil {
+		return fmt.Errorf("encode package: %w", err)
+	}
+	userID, _ := g.sessionUser(session)
+	if err := g.sendFrame("main", userID, session.ID, data, session.Send); err != nil {
+		g.metrics.sendFailures.inc("main", "main")
+		return err
+	}
+	return nil
+}
+
 // sendOnMainLink 在主链路发送数据
 func (g *JT809Gateway) sendOnMainLink(userID uint32, data []byte) error {
 	sessionID, ok := g.store.GetMainSession(userID)
-	if !ok {
+	if !ok || g.mainSrv == nil {
 		return fmt.Errorf("main session not found")
 	}
 	session, err := g.mainSrv.GetSessionByID(sessionID)
 	if err != nil {
 		return fmt.Errorf("get session failed: %w", err)
 	}
-	g.logPacket("main", "send", session.ID, data)
-	return session.Send(data)
+	return g.sendFrame("main", userID, session.ID, data, session.Send)
 }
 
 // sendOnSubLink 在从链路发送数据
@@ -991,12 +1449,7 @@ func (g *JT809Gateway) sendOnSubLink(userID uint32, data []byte) error {
 	if !ok {
 		return fmt.Errorf("sub client not found")
 	}
-	g.logPacket("sub", "send", fmt.Sprintf("%d", userID), data)
-	return subClient.Send(data)
-}
-
-func (g *JT809Gateway) logPacket(link, dir, sessionID string, data []byte) {
-	slog.Info("packet dump", "link", link, "dir", dir, "session", sessionID, "hex", fmt.Sprintf("%X", data))
+	return g.sendFrame("sub", userID, "", data, subClient.Send)
 }
 
 func (g *JT809Gateway) sessionUser(session *goserver.AppSession) (uint32, bool) {
@@ -1011,7 +1464,10 @@ func (g *JT809Gateway) sessionUser(session *goserver.AppSession) (uint32, bool)
 func (g *JT809Gateway) onSessionClosed(session *goserver.AppSession, reason string) {
 	link, _ := session.GetAttr("link")
 	slog.Info("session closed", "session", session.ID, "link", link, "reason", reason)
-	g.store.RemoveSession(session.ID)
+	mainDown := g.store.RemoveSession(session.ID)
+	if userID, ok := g.sessionUser(session); ok && mainDown {
+		g.linkStateChanged(userID, "main", LinkStateDown, reason)
+	}
 }
 
 func splitJT809Frames(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -1040,40 +1496,144 @@ func splitJT809Frames(data []byte, atEOF bool) (advance int, token []byte, err e
 	return stop + 1, data[:stop+1], nil
 }
 
-func (g *JT809Gateway) handleAuthorize(userID uint32, frame *jtt809.Frame) {
-	// 0x1700 消息体结构：子业务ID(2字节) + 载荷
-	// 注意：不包含车牌号和颜色，与 0x1200 的 SubBusinessPacket 格式不同
-	msg, err := jt1078.ParseAuthorizeMsg(frame.RawBody)
-	if err != nil {
-		slog.Warn("parse authorize msg failed", "user_id", userID, "err", err)
+// recordTrack 写入历史轨迹，未启用轨迹存储时忽略。
+func (g *JT809Gateway) recordTrack(points ...TrackPoint) {
+	if g.track == nil {
 		return
 	}
-	switch msg.SubBusinessID {
-	case jtt809.UP_AUTHORIZE_MSG_STARTUP: // 0x1701
-		req, err := jt1078.ParseAuthorizeStartupReq(msg.Payload)
-		if err != nil {
-			slog.Warn("parse authorize startup req failed", "user_id", userID, "err", err)
-			return
+	if err := g.track.Append(points...); err != nil {
+		slog.Warn("append track failed", "plate", points[0].VehicleNo, "err", err)
+	}
+}
+
+// evaluateGeofences 使用实时定位判断围栏进出，触发回调并按围栏配置下发报警预警。
+// 补报点为历史数据，不参与围栏判断。
+func (g *JT809Gateway) evaluateGeofences(meta EventMeta, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData) {
+	userID := meta.UserID
+	events := g.geofences.Evaluate(userID, plate, color, gnss, time.Now())
+	for i := range events {
+		evt := events[i]
+		slog.Info("geofence event", "user_id", userID, "plate", plate, "fence", evt.FenceID, "type", evt.Type)
+		g.emit("OnGeofenceEvent", userID, plate, func(ctx context.Context, h EventHandler) error {
+			return h.OnGeofenceEvent(ctx, &GeofenceTriggeredEvent{EventMeta: meta, Geofence: &evt})
+		})
+		g.publish(EventGeofence, userID, plate, color, &evt)
+		if evt.WarnType != 0 {
+			g.report.RecordAlarm(userID, plate, color, false, evt.Time)
+		}
+		if evt.notify && evt.WarnType != 0 {
+			go g.notifyWarn(userID, plate, color, evt.WarnType, evt.Time, evt.Describe())
 		}
-		authCode := req.AuthorizeCode1
-		// 注意：0x1700 消息中没有车牌号和颜色信息，时效口令是平台级别的
-		g.store.UpdateAuthCode(userID, req.PlatformID, authCode)
-		slog.Info("video authorize report", "user_id", userID, "platform", req.PlatformID, "auth_code", authCode)
+	}
+}
 
-		// 触发鉴权回调
-		if g.callbacks != nil && g.callbacks.OnAuthorize != nil {
-			go g.callbacks.OnAuthorize(userID, req.PlatformID, authCode)
+// evaluateRules 使用实时定位进行超速、疲劳、夜间禁行等判定，报警开始时按配置下发报警预警。
+func (g *JT809Gateway) evaluateRules(meta EventMeta, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData) {
+	userID := meta.UserID
+	alarms := g.rules.Evaluate(userID, plate, color, gnss, time.Now())
+	if len(alarms) == 0 {
+		return
+	}
+	notify := g.rules.Config().NotifyPlatform
+	for i := range alarms {
+		alarm := alarms[i]
+		slog.Info("rule alarm", "user_id", userID, "plate", plate, "kind", alarm.Kind, "active", alarm.Active)
+		g.emit("OnRuleAlarm", userID, plate, func(ctx context.Context, h EventHandler) error {
+			return h.OnRuleAlarm(ctx, &RuleAlarmEvent{EventMeta: meta, Alarm: &alarm})
+		})
+		g.publish(EventRuleAlarm, userID, plate, color, &alarm)
+		if alarm.Active {
+			g.report.RecordAlarm(userID, plate, color, false, alarm.StartTime)
 		}
+		if notify && alarm.Active {
+			go g.notifyWarn(userID, plate, color, alarm.WarnType, alarm.StartTime, alarm.Describe())
+		}
+	}
+}
+
+// sampleAssessment 采样平台在线状态并将考核统计落盘。
+func (g *JT809Gateway) sampleAssessment() {
+	now := time.Now()
+	g.report.Sample(g.auth.UserIDs(), g.store.Snapshots(), now)
+	if err := g.report.Flush(now); err != nil {
+		slog.Warn("flush assessment stats failed", "err", err)
+	}
+}
+
+// AssessmentReport 生成 [from, to] 日期区间的平台考核报表，withVehicles 为 true 时包含单车明细。
+func (g *JT809Gateway) AssessmentReport(from, to time.Time, withVehicles bool) (*AssessmentReport, error) {
+	return g.report.Report(from, to, withVehicles)
+}
+
+// checkQuality 检查定位点数据质量，发现问题时触发回调。gnss 为 nil 表示数据无法解析。
+func (g *JT809Gateway) checkQuality(meta EventMeta, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, source TrackSource, ref time.Time) {
+	userID := meta.UserID
+	evt, distance, gapDistance := g.quality.check(userID, plate, color, gnss, source, ref)
+	g.report.RecordPosition(evt, distance, gapDistance, time.Now())
+	if len(evt.Issues) == 0 {
+		return
+	}
+	slog.Debug("position quality issue", "user_id", userID, "plate", plate, "source", source, "issues", evt.Issues)
+	g.emit("OnQualityIssue", userID, plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnQualityIssue(ctx, &QualityIssueEvent{EventMeta: meta, Quality: evt})
+	})
+	g.publish(EventQualityIssue, userID, plate, color, evt)
+}
+
+// QualityStats 返回各下级平台的定位数据质量统计（数据合格率、轨迹完整率等）。
+func (g *JT809Gateway) QualityStats() []QualityStats {
+	return g.quality.Stats()
+}
+
+// notifyWarn 向车属平台下发报警预警，失败只记录日志。
+func (g *JT809Gateway) notifyWarn(userID uint32, plate string, color jtt809.PlateColor, warnType jtt809.WarnType, at time.Time, content string) {
+	err := g.SendWarnInform(WarnInform{
+		UserID:       userID,
+		VehicleNo:    plate,
+		VehicleColor: color,
+		WarnType:     warnType,
+		WarnTime:     at,
+		Content:      content,
+	})
+	if err != nil {
+		slog.Warn("send warn inform failed", "user_id", userID, "plate", plate, "warn_type", fmt.Sprintf("0x%04X", warnType), "err", err)
+	}
+}
+
+// Rules 返回违规判定规则引擎，可用于设置车型或调整规则参数。
+func (g *JT809Gateway) Rules() *RuleEngine {
+	return g.rules
+}
 
-	default:
-		slog.Debug("unhandled authorize sub msg", "user_id", userID, "sub_id", fmt.Sprintf("0x%04X", msg.SubBusinessID))
+// Geofences 返回电子围栏引擎，用于增删改查围栏。
+func (g *JT809Gateway) Geofences() *GeofenceEngine {
+	return g.geofences
+}
+
+// QueryTrack 查询车辆在 [from, to] 时间范围内的历史轨迹（含实时与补报点），按定位时间升序。
+func (g *JT809Gateway) QueryTrack(plate string, color jtt809.PlateColor, from, to time.Time) ([]TrackPoint, error) {
+	if g.track == nil {
+		return nil, ErrTrackStoreDisabled
+	}
+	if strings.TrimSpace(plate) == "" {
+		return nil, errors.New("plate is required")
 	}
+	if color == 0 {
+		color = jtt809.PlateColorBlue
+	}
+	return g.track.Query(plate, color, from, to)
 }
 
 // autoSubscribeVehicle 在车辆注册后自动订阅该车辆的实时定位数据
 func (g *JT809Gateway) autoSubscribeVehicle(userID uint32, color jtt809.PlateColor, vehicle string) {
+	vp := g.vehiclePolicy(userID)
+	if !vp.autoSubscribe() {
+		return
+	}
 	// 等待一小段时间，确保从链路已建立
-	time.Sleep(2 * time.Second)
+	if vp.SubscribeDelay > 0 {
+		time.Sleep(vp.SubscribeDelay)
+	}
 
 	req := MonitorRequest{
 		UserID:       userID,
@@ -1099,23 +1659,25 @@ func (g *JT809Gateway) checkVehiclePositions() {
 		if snap.MainSessionID == "" || !snap.SubConnected {
 			continue
 		}
+		vp := g.vehiclePolicy(snap.UserID)
 
 		for _, vehicle := range snap.Vehicles {
-			vehicleKey := vehicleKey(vehicle.VehicleNo, vehicle.VehicleColor)
-
 			// 处理已注册但还未订阅或订阅失败的车辆
 			if vehicle.PositionTime.IsZero() {
 				// 如果有注册信息，说明车辆已注册，尝试订阅
 				if vehicle.Registration != nil {
-					// 先判断注册时间是否超过10分钟，超过则删除
-					if now.Sub(vehicle.Registration.ReceivedAt) > 10*time.Minute {
-						g.store.RemoveVehicle(snap.UserID, vehicleKey)
+					// 先判断注册时间是否超过保留时长，超过则删除
+					if now.Sub(vehicle.activeSince(vehicle.Registration.ReceivedAt)) > vp.RegistrationExpiry {
+						g.evictVehicle(snap.UserID, vehicle.VehicleNo, vehicle.VehicleColor, "registration_expired")
 						slog.Warn("vehicle registration expired, removed",
 							"user_id", snap.UserID,
 							"plate", vehicle.VehicleNo,
 							"registration_time", vehicle.Registration.ReceivedAt.Format("2006-01-02 15:04:05"))
 						continue
 					}
+					if !vp.autoSubscribe() {
+						continue
+					}
 
 					req := MonitorRequest{
 						UserID:       snap.UserID,
@@ -1141,10 +1703,10 @@ func (g *JT809Gateway) checkVehiclePositions() {
 
 			timeSinceLastPosition := now.Sub(vehicle.PositionTime)
 
-			// 超过10分钟未上报定位，认定为离线，删除车辆
-			if timeSinceLastPosition > 10*time.Minute {
-				g.store.RemoveVehicle(snap.UserID, vehicleKey)
-				slog.Warn("vehicle offline, removed",
+			// 超过淘汰时长未上报定位，删除车辆（离线标记由 checkVehiclePresence 按 OfflineAfter 处理）
+			if vp.EvictAfter > 0 && now.Sub(vehicle.activeSince(vehicle.PositionTime)) > vp.EvictAfter {
+				g.evictVehicle(snap.UserID, vehicle.VehicleNo, vehicle.VehicleColor, "position_timeout")
+				slog.Warn("vehicle evicted after position timeout",
 					"user_id", snap.UserID,
 					"plate", vehicle.VehicleNo,
 					"last_position", vehicle.PositionTime.Format("2006-01-02 15:04:05"),
@@ -1152,8 +1714,8 @@ func (g *JT809Gateway) checkVehiclePositions() {
 				continue
 			}
 
-			// 超过5分钟未上报定位，重新发送订阅请求
-			if timeSinceLastPosition > 5*time.Minute {
+			// 超过重新订阅时长未上报定位，重新发送订阅请求
+			if vp.autoSubscribe() && timeSinceLastPosition > vp.ResubscribeAfter {
 				req := MonitorRequest{
 					UserID:       snap.UserID,
 					VehicleNo:    vehicle.VehicleNo,
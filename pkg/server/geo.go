@@ -0,0 +1,70 @@
+package server
+
+import "math"
+
+const earthRadiusMeters = 6371008.8
+
+// GeoPoint 表示 WGS84 经纬度坐标（度）。
+type GeoPoint struct {
+	Longitude float64 `json:"lon"`
+	Latitude  float64 `json:"lat"`
+}
+
+// distanceMeters 使用 Haversine 公式计算两点间球面距离（米）。
+func distanceMeters(a, b GeoPoint) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// projectMeters 以 origin 为原点将坐标投影为局部平面坐标（米），适用于数十公里范围内的几何计算。
+func projectMeters(origin, p GeoPoint) (x, y float64) {
+	x = (p.Longitude - origin.Longitude) * math.Pi / 180 * earthRadiusMeters * math.Cos(origin.Latitude*math.Pi/180)
+	y = (p.Latitude - origin.Latitude) * math.Pi / 180 * earthRadiusMeters
+	return x, y
+}
+
+// pointInPolygon 使用射线法判断点是否在多边形内（经纬度平面近似）。
+func pointInPolygon(p GeoPoint, polygon []GeoPoint) bool {
+	inside := false
+	n := len(polygon)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) {
+			cross := (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude) + a.Longitude
+			if p.Longitude < cross {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// distanceToPolylineMeters 计算点到折线的最短距离（米）。
+func distanceToPolylineMeters(p GeoPoint, line []GeoPoint) float64 {
+	if len(line) == 0 {
+		return math.Inf(1)
+	}
+	if len(line) == 1 {
+		return distanceMeters(p, line[0])
+	}
+	best := math.Inf(1)
+	for i := 0; i+1 < len(line); i++ {
+		ax, ay := projectMeters(p, line[i])
+		bx, by := projectMeters(p, line[i+1])
+		// 点 p 投影后位于原点，求原点到线段 ab 的距离
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l2 := dx*dx + dy*dy; l2 > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+		}
+		cx, cy := ax+t*dx, ay+t*dy
+		if d := math.Hypot(cx, cy); d < best {
+			best = d
+		}
+	}
+	return best
+}
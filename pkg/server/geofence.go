@@ -0,0 +1,464 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// GeofenceShape 表示围栏几何类型。
+type GeofenceShape string
+
+const (
+	GeofenceCircle    GeofenceShape = "circle"    // 圆形：Center + Radius
+	GeofenceRectangle GeofenceShape = "rectangle" // 矩形：Points 给出两个对角点
+	GeofencePolygon   GeofenceShape = "polygon"   // 多边形：Points 给出至少 3 个顶点
+	GeofenceRoute     GeofenceShape = "route"     // 线路走廊：Points 给出折线，Width 为走廊宽度
+)
+
+// GeofenceTrigger 表示区域围栏在哪些情况下触发报警。
+type GeofenceTrigger string
+
+const (
+	GeofenceTriggerBoth  GeofenceTrigger = "both"  // 进出均报警（默认）
+	GeofenceTriggerEnter GeofenceTrigger = "enter" // 仅进入报警
+	GeofenceTriggerLeave GeofenceTrigger = "leave" // 仅离开报警
+)
+
+// GeofenceEventType 表示围栏事件类型。
+type GeofenceEventType string
+
+const (
+	GeofenceEventEnter          GeofenceEventType = "enter"           // 进入区域
+	GeofenceEventLeave          GeofenceEventType = "leave"           // 离开区域
+	GeofenceEventRouteDeviation GeofenceEventType = "route_deviation" // 偏离线路走廊
+	GeofenceEventRouteReturn    GeofenceEventType = "route_return"    // 回到线路走廊
+)
+
+// TimeWindow 表示每日生效时段，时间按协议时区（UTC+8）计算。
+type TimeWindow struct {
+	Start    string         `json:"start"`              // HH:MM
+	End      string         `json:"end"`                // HH:MM，早于 Start 表示跨零点
+	Weekdays []time.Weekday `json:"weekdays,omitempty"` // 0=周日，空表示每天
+}
+
+// VehicleBinding 表示围栏绑定的单车。
+type VehicleBinding struct {
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+}
+
+// Geofence 描述一个电子围栏。
+// 绑定规则：UserIDs 与 Vehicles 均为空时对全部车辆生效，否则命中任一平台或车辆即生效。
+type Geofence struct {
+	ID     string        `json:"id"`
+	Name   string        `json:"name"`
+	Shape  GeofenceShape `json:"shape"`
+	Center *GeoPoint     `json:"center,omitempty"`
+	Radius float64       `json:"radius,omitempty"` // 圆形半径，米
+	Points []GeoPoint    `json:"points,omitempty"`
+	Width  float64       `json:"width,omitempty"` // 线路走廊总宽度，米
+
+	Trigger  GeofenceTrigger `json:"trigger,omitempty"`
+	Boundary bool            `json:"boundary,omitempty"` // 越界围栏：离开时按越界报警(0x0008)上报
+
+	Windows   []TimeWindow `json:"windows,omitempty"`
+	ValidFrom time.Time    `json:"valid_from,omitempty"`
+	ValidTo   time.Time    `json:"valid_to,omitempty"`
+
+	UserIDs  []uint32         `json:"user_ids,omitempty"`
+	Vehicles []VehicleBinding `json:"vehicles,omitempty"`
+
+	NotifyPlatform bool `json:"notify_platform,omitempty"` // 触发时向车属平台下发 0x9402 报警预警
+}
+
+// GeofenceEvent 表示一次围栏状态变化。
+type GeofenceEvent struct {
+	Type         GeofenceEventType `json:"type"`
+	FenceID      string            `json:"fence_id"`
+	FenceName    string            `json:"fence_name"`
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+	WarnType     jtt809.WarnType   `json:"warn_type,omitempty"` // 对应报警类型，回到走廊等非报警事件为 0
+	Time         time.Time         `json:"time"`
+	Longitude    float64           `json:"longitude"`
+	Latitude     float64           `json:"latitude"`
+	Speed        uint16            `json:"speed"`
+
+	notify bool
+}
+
+// GeofenceEngine 维护围栏定义与每车的内外状态，根据定位点产生进出事件。
+// 车辆首次出现或围栏刚进入生效时段时只建立基线状态，不产生事件，避免重启后误报。
+type GeofenceEngine struct {
+	mu     sync.RWMutex
+	fences map[string]*Geofence
+	inside map[string]map[string]bool // platformVehicleKey -> fenceID -> 是否在围栏内
+	path   string
+}
+
+// NewGeofenceEngine 创建围栏引擎，path 非空时从该文件加载围栏并在变更后写回。
+func NewGeofenceEngine(path string) (*GeofenceEngine, error) {
+	e := &GeofenceEngine{
+		fences: make(map[string]*Geofence),
+		inside: make(map[string]map[string]bool),
+		path:   path,
+	}
+	if path == "" {
+		return e, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return e, nil
+		}
+		return nil, fmt.Errorf("read geofence file: %w", err)
+	}
+	var fences []Geofence
+	if err := json.Unmarshal(data, &fences); err != nil {
+		return nil, fmt.Errorf("decode geofence file: %w", err)
+	}
+	for i := range fences {
+		f := fences[i]
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("geofence %s: %w", f.ID, err)
+		}
+		e.fences[f.ID] = &f
+	}
+	return e, nil
+}
+
+// Upsert 新增或更新围栏，ID 为空时自动生成。更新后该围栏的车辆状态重新建立基线。
+func (e *GeofenceEngine) Upsert(f Geofence) (Geofence, error) {
+	if f.ID == "" {
+		f.ID = newGeofenceID()
+	}
+	if f.Trigger == "" {
+		f.Trigger = GeofenceTriggerBoth
+	}
+	if err := f.validate(); err != nil {
+		return Geofence{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cp := f
+	e.fences[f.ID] = &cp
+	e.resetFenceStateLocked(f.ID)
+	return f, e.saveLocked()
+}
+
+// Remove 删除围栏，返回是否存在。
+func (e *GeofenceEngine) Remove(id string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.fences[id]; !ok {
+		return false, nil
+	}
+	delete(e.fences, id)
+	e.resetFenceStateLocked(id)
+	return true, e.saveLocked()
+}
+
+// Get 返回指定围栏。
+func (e *GeofenceEngine) Get(id string) (Geofence, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	f, ok := e.fences[id]
+	if !ok {
+		return Geofence{}, false
+	}
+	return *f, true
+}
+
+// List 返回全部围栏，按 ID 排序。
+func (e *GeofenceEngine) List() []Geofence {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	result := make([]Geofence, 0, len(e.fences))
+	for _, f := range e.fences {
+		result = append(result, *f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Contains 判断坐标是否位于指定围栏内，围栏不存在时返回 false。不考虑生效时段与绑定关系。
+func (e *GeofenceEngine) Contains(id string, p GeoPoint) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	f, ok := e.fences[id]
+	return ok && f.contains(p)
+}
+
+// Evaluate 用一个定位点更新车辆与各围栏的内外状态，返回发生的事件。
+func (e *GeofenceEngine) Evaluate(userID uint32, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, now time.Time) []GeofenceEvent {
+	if gnss == nil {
+		return nil
+	}
+	p := GeoPoint{Longitude: gnss.Longitude, Latitude: gnss.Latitude}
+	at := gnss.DateTime.Time()
+	if at.IsZero() {
+		at = now
+	}
+	key := platformVehicleKey(userID, plate, color)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.fences) == 0 {
+		return nil
+	}
+	states := e.inside[key]
+	if states == nil {
+		states = make(map[string]bool)
+		e.inside[key] = states
+	}
+	var events []GeofenceEvent
+	for id, f := range e.fences {
+		if !f.appliesTo(userID, plate, color) || !f.activeAt(at) {
+			delete(states, id)
+			continue
+		}
+		in := f.contains(p)
+		prev, seen := states[id]
+		states[id] = in
+		if !seen || prev == in {
+			continue
+		}
+		evt := GeofenceEvent{
+			FenceID:      f.ID,
+			FenceName:    f.Name,
+			UserID:       userID,
+			VehicleNo:    plate,
+			VehicleColor: color,
+			Time:         at,
+			Longitude:    gnss.Longitude,
+			Latitude:     gnss.Latitude,
+			Speed:        gnss.Speed,
+			notify:       f.NotifyPlatform,
+		}
+		switch {
+		case f.Shape == GeofenceRoute && !in:
+			evt.Type = GeofenceEventRouteDeviation
+			evt.WarnType = jtt809.WarnTypeRouteDeviation
+		case f.Shape == GeofenceRoute:
+			evt.Type = GeofenceEventRouteReturn
+		case in:
+			if f.Trigger == GeofenceTriggerLeave {
+				continue
+			}
+			evt.Type = GeofenceEventEnter
+			evt.WarnType = jtt809.WarnTypeEnterRegion
+		default:
+			if f.Trigger == GeofenceTriggerEnter {
+				continue
+			}
+			evt.Type = GeofenceEventLeave
+			evt.WarnType = jtt809.WarnTypeLeaveRegion
+			if f.Boundary {
+				evt.WarnType = jtt809.WarnTypeAcrossBoundary
+			}
+		}
+		events = append(events, evt)
+	}
+	return events
+}
+
+// Describe 返回事件的中文描述，用作报警预警内容。
+func (evt GeofenceEvent) Describe() string {
+	switch evt.Type {
+	case GeofenceEventEnter:
+		return fmt.Sprintf("车辆进入区域[%s]", evt.FenceName)
+	case GeofenceEventLeave:
+		return fmt.Sprintf("车辆离开区域[%s]", evt.FenceName)
+	case GeofenceEventRouteDeviation:
+		return fmt.Sprintf("车辆偏离线路[%s]", evt.FenceName)
+	case GeofenceEventRouteReturn:
+		return fmt.Sprintf("车辆回到线路[%s]", evt.FenceName)
+	}
+	return string(evt.Type)
+}
+
+// forget 清除车辆的围栏内外状态，用于车辆被淘汰后释放内存。
+func (e *GeofenceEngine) forget(userID uint32, plate string, color jtt809.PlateColor) {
+	e.mu.Lock()
+	delete(e.inside, platformVehicleKey(userID, plate, color))
+	e.mu.Unlock()
+}
+
+func (e *GeofenceEngine) resetFenceStateLocked(id string) {
+	for _, states := range e.inside {
+		delete(states, id)
+	}
+}
+
+func (e *GeofenceEngine) saveLocked() error {
+	if e.path == "" {
+		return nil
+	}
+	fences := make([]Geofence, 0, len(e.fences))
+	for _, f := range e.fences {
+		fences = append(fences, *f)
+	}
+	sort.Slice(fences, func(i, j int) bool { return fences[i].ID < fences[j].ID })
+	data, err := json.MarshalIndent(fences, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode geofences: %w", err)
+	}
+	return writeFileAtomic(e.path, data)
+}
+
+func (f *Geofence) validate() error {
+	if strings.TrimSpace(f.ID) == "" {
+		return errors.New("id is required")
+	}
+	switch f.Shape {
+	case GeofenceCircle:
+		if f.Center == nil || f.Radius <= 0 {
+			return errors.New("circle requires center and positive radius")
+		}
+	case GeofenceRectangle:
+		if len(f.Points) != 2 {
+			return errors.New("rectangle requires exactly 2 corner points")
+		}
+	case GeofencePolygon:
+		if len(f.Points) < 3 {
+			return errors.New("polygon requires at least 3 points")
+		}
+	case GeofenceRoute:
+		if len(f.Points) < 2 || f.Width <= 0 {
+			return errors.New("route requires at least 2 points and positive width")
+		}
+	default:
+		return fmt.Errorf("unsupported shape %q", f.Shape)
+	}
+	switch f.Trigger {
+	case "", GeofenceTriggerBoth, GeofenceTriggerEnter, GeofenceTriggerLeave:
+	default:
+		return fmt.Errorf("unsupported trigger %q", f.Trigger)
+	}
+	for _, w := range f.Windows {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+	if !f.ValidFrom.IsZero() && !f.ValidTo.IsZero() && f.ValidTo.Before(f.ValidFrom) {
+		return errors.New("valid_to before valid_from")
+	}
+	return nil
+}
+
+func (f *Geofence) appliesTo(userID uint32, plate string, color jtt809.PlateColor) bool {
+	if len(f.UserIDs) == 0 && len(f.Vehicles) == 0 {
+		return true
+	}
+	for _, uid := range f.UserIDs {
+		if uid == userID {
+			return true
+		}
+	}
+	for _, v := range f.Vehicles {
+		if v.VehicleNo == plate && (v.VehicleColor == 0 || v.VehicleColor == color) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Geofence) activeAt(t time.Time) bool {
+	if !f.ValidFrom.IsZero() && t.Before(f.ValidFrom) {
+		return false
+	}
+	if !f.ValidTo.IsZero() && t.After(f.ValidTo) {
+		return false
+	}
+	if len(f.Windows) == 0 {
+		return true
+	}
+	for _, w := range f.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Geofence) contains(p GeoPoint) bool {
+	switch f.Shape {
+	case GeofenceCircle:
+		return distanceMeters(p, *f.Center) <= f.Radius
+	case GeofenceRectangle:
+		a, b := f.Points[0], f.Points[1]
+		minLon, maxLon := min(a.Longitude, b.Longitude), max(a.Longitude, b.Longitude)
+		minLat, maxLat := min(a.Latitude, b.Latitude), max(a.Latitude, b.Latitude)
+		return p.Longitude >= minLon && p.Longitude <= maxLon && p.Latitude >= minLat && p.Latitude <= maxLat
+	case GeofencePolygon:
+		return pointInPolygon(p, f.Points)
+	case GeofenceRoute:
+		return distanceToPolylineMeters(p, f.Points) <= f.Width/2
+	}
+	return false
+}
+
+func (w TimeWindow) validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return fmt.Errorf("window start: %w", err)
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return fmt.Errorf("window end: %w", err)
+	}
+	return nil
+}
+
+// contains 判断时刻是否落在时段内，End 早于 Start 时视为跨零点。
+func (w TimeWindow) contains(t time.Time) bool {
+	t = t.In(trackPartitionZone)
+	if len(w.Weekdays) > 0 {
+		match := false
+		for _, d := range w.Weekdays {
+			if d == t.Weekday() {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	cur := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if start <= end {
+		return cur >= start && cur < end
+	}
+	return cur >= start || cur < end
+}
+
+// parseClock 解析 HH:MM 为当日零点起的偏移。
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock %q, expect HH:MM", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func newGeofenceID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("gf-%d", time.Now().UnixNano())
+	}
+	return "gf-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func gnssAt(lon, lat float64, t time.Time) *jtt809.GNSSData {
+	t = t.In(trackPartitionZone)
+	return &jtt809.GNSSData{
+		Longitude: lon,
+		Latitude:  lat,
+		DateTime: jtt809.GNSSTime{
+			Day: uint8(t.Day()), Month: uint8(t.Month()), Year: uint16(t.Year()),
+			Hour: uint8(t.Hour()), Minute: uint8(t.Minute()), Second: uint8(t.Second()),
+		},
+	}
+}
+
+func TestGeofenceEnterLeave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geofences.json")
+	engine, err := NewGeofenceEngine(path)
+	if err != nil {
+		t.Fatalf("new engine: %v", err)
+	}
+	fence, err := engine.Upsert(Geofence{
+		Name:   "场站",
+		Shape:  GeofenceCircle,
+		Center: &GeoPoint{Longitude: 114.05, Latitude: 22.54},
+		Radius: 500,
+	})
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	eval := func(lon, lat float64, offset time.Duration) []GeofenceEvent {
+		return engine.Evaluate(10001, "粤B12345", jtt809.PlateColorBlue, gnssAt(lon, lat, base.Add(offset)), base)
+	}
+	// 首个点仅建立基线
+	if evts := eval(114.10, 22.54, 0); len(evts) != 0 {
+		t.Fatalf("expected no event on first point, got %d", len(evts))
+	}
+	evts := eval(114.0505, 22.5402, time.Minute)
+	if len(evts) != 1 || evts[0].Type != GeofenceEventEnter || evts[0].WarnType != jtt809.WarnTypeEnterRegion {
+		t.Fatalf("expected enter event, got %+v", evts)
+	}
+	if evts := eval(114.0506, 22.5401, 2*time.Minute); len(evts) != 0 {
+		t.Fatalf("expected no event while inside, got %d", len(evts))
+	}
+	evts = eval(114.10, 22.54, 3*time.Minute)
+	if len(evts) != 1 || evts[0].Type != GeofenceEventLeave || evts[0].FenceID != fence.ID {
+		t.Fatalf("expected leave event, got %+v", evts)
+	}
+
+	reloaded, err := NewGeofenceEngine(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, ok := reloaded.Get(fence.ID); !ok || got.Radius != 500 {
+		t.Fatalf("fence not persisted: %+v", got)
+	}
+}
+
+func TestGeofenceRouteAndBindings(t *testing.T) {
+	engine, _ := NewGeofenceEngine("")
+	_, err := engine.Upsert(Geofence{
+		ID:       "route-1",
+		Shape:    GeofenceRoute,
+		Points:   []GeoPoint{{Longitude: 114.00, Latitude: 22.50}, {Longitude: 114.10, Latitude: 22.50}},
+		Width:    200,
+		Vehicles: []VehicleBinding{{VehicleNo: "粤B12345"}},
+		Windows:  []TimeWindow{{Start: "22:00", End: "06:00"}},
+	})
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	night := time.Date(2025, 3, 1, 23, 0, 0, 0, trackPartitionZone)
+	engine.Evaluate(1, "粤B12345", jtt809.PlateColorBlue, gnssAt(114.05, 22.5005, night), night)
+	evts := engine.Evaluate(1, "粤B12345", jtt809.PlateColorBlue, gnssAt(114.05, 22.51, night.Add(time.Minute)), night)
+	if len(evts) != 1 || evts[0].Type != GeofenceEventRouteDeviation {
+		t.Fatalf("expected route deviation, got %+v", evts)
+	}
+
+	// 未绑定的车辆不参与判断
+	engine.Evaluate(1, "粤B99999", jtt809.PlateColorBlue, gnssAt(114.05, 22.5005, night), night)
+	if evts := engine.Evaluate(1, "粤B99999", jtt809.PlateColorBlue, gnssAt(114.05, 22.51, night.Add(time.Minute)), night); len(evts) != 0 {
+		t.Fatalf("unbound vehicle should not trigger, got %+v", evts)
+	}
+
+	// 时段外清空状态，白天回到走廊不产生事件
+	day := time.Date(2025, 3, 2, 12, 0, 0, 0, trackPartitionZone)
+	if evts := engine.Evaluate(1, "粤B12345", jtt809.PlateColorBlue, gnssAt(114.05, 22.5005, day), day); len(evts) != 0 {
+		t.Fatalf("fence outside window should not trigger, got %+v", evts)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+	"github.com/zboyco/jtt809/pkg/jtt809/jt1078"
+)
+
+// Message 为分发给报文处理器的业务报文。
+type Message struct {
+	UserID uint32
+	Link   string        // 接收链路：main 或 sub
+	Frame  *jtt809.Frame // 解码后的完整报文
+	// Sub 子业务封装，链路管理等无子业务的报文为 nil。
+	// 0x1400、0x1700 的子业务封装不含车牌，仅 SubBusinessID 与 Payload 有效。
+	Sub        *jtt809.SubBusinessPacket
+	ReceivedAt time.Time
+
+	gateway  *JT809Gateway
+	rejected bool
+}
+
+// Reply 按链路策略向发送该报文的下级平台发送应答，沿用请求报文头中的 GNSS 中心编码等字段。
+func (m *Message) Reply(body jtt809.Body) error {
+	return m.gateway.SendToSubordinate(m.UserID, m.Frame.Header, body)
+}
+
+// reject 标记报文未被受理（车辆数超限或内容无法解析）。
+func (m *Message) reject() {
+	m.rejected = true
+}
+
+// HandlerFunc 处理一条业务报文，在接收链路的读协程中同步执行，耗时操作需自行异步处理。
+type HandlerFunc func(msg *Message)
+
+type handlerKey struct {
+	msgID uint16
+	subID uint16
+}
+
+// Handle 注册业务 ID 与子业务类型的报文处理器，覆盖同一键上已有的处理器（包括内置处理器）。
+// subID 为 0 时处理该业务下未单独注册的全部子业务，以及无子业务的报文。
+// 登录、心跳、注销等依赖主链路连接的报文不经过注册表。可在 Start 前后任意时刻调用。
+func (g *JT809Gateway) Handle(msgID, subID uint16, h HandlerFunc) {
+	g.initHandlers()
+	g.handlerMu.Lock()
+	defer g.handlerMu.Unlock()
+	if h == nil {
+		delete(g.handlers, handlerKey{msgID, subID})
+		return
+	}
+	g.handlers[handlerKey{msgID, subID}] = h
+}
+
+// initHandlers 注册内置处理器，首次注册或分发时执行。
+func (g *JT809Gateway) initHandlers() {
+	g.handlersOnce.Do(func() {
+		g.handlers = map[handlerKey]HandlerFunc{
+			{jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REGISTER}:                 g.admitted(g.handleVehicleRegistration),
+			{jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REAL_LOCATION}:            g.admitted(g.handleRealLocation),
+			{jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_HISTORY_LOCATION}:         g.admitted(g.handleHistoryLocation),
+			{jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK}:       g.admitted(g.handleMonitorStartupAck),
+			{jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_RETURN_END_ACK}:           g.admitted(g.handleMonitorEndAck),
+			{jtt809.UP_PLATFORM_MSG, jtt809.UP_PLATFORM_MSG_POST_QUERY_ACK}: g.handlePlatformQueryAck,
+			{jtt809.UP_WARN_MSG, jtt809.UP_WARN_MSG_ADPT_INFO}:              g.handleWarnAdptInfo,
+			{jtt809.UP_WARN_MSG, jtt809.UP_WARN_MSG_INFORM_TIPS}:            g.handleWarnInformTips,
+			{jtt809.UP_REALVIDEO_MSG, jtt809.UP_REALVIDEO_MSG_STARTUP_ACK}:  g.handleVideoStartupAck,
+			{jtt809.UP_AUTHORIZE_MSG, jtt809.UP_AUTHORIZE_MSG_STARTUP}:      g.handleAuthorizeStartup,
+			{jtt809.DOWN_LINKTEST_RSP, 0}:                                   func(msg *Message) { g.store.RecordHeartbeat(msg.UserID, false) },
+			{jtt809.DOWN_DISCONNECT_RSP, 0}:                                 func(msg *Message) { g.handleSubLogoutResponse(msg.UserID) },
+		}
+	})
+}
+
+// admitted 在车辆数达到上限时忽略新车辆的报文。
+func (g *JT809Gateway) admitted(h HandlerFunc) HandlerFunc {
+	return func(msg *Message) {
+		if !g.admitVehicle(msg.UserID, msg.Sub.Plate, msg.Sub.Color) {
+			msg.reject()
+			return
+		}
+		h(msg)
+	}
+}
+
+// handler 查找处理器：先按业务 ID 与子业务类型精确匹配，再使用该业务 ID 的通用处理器（subID 为 0）。
+func (g *JT809Gateway) handler(msgID, subID uint16) HandlerFunc {
+	g.initHandlers()
+	g.handlerMu.RLock()
+	defer g.handlerMu.RUnlock()
+	if h, ok := g.handlers[handlerKey{msgID, subID}]; ok {
+		return h
+	}
+	return g.handlers[handlerKey{msgID, 0}]
+}
+
+// dispatch 解析子业务封装并调用对应的处理器，未注册的报文记录后丢弃。
+// 返回报文是否被受理：有处理器且处理器未标记拒绝。
+func (g *JT809Gateway) dispatch(msg *Message) bool {
+	msgID := msg.Frame.BodyID
+	sub, err := parseSubBusiness(msgID, msg.Frame.RawBody)
+	if err != nil {
+		// 子业务封装不符合标准格式时交给该业务 ID 的通用处理器处理原始报文
+		if h := g.handler(msgID, 0); h != nil {
+			h(msg)
+			return !msg.rejected
+		}
+		slog.Warn("parse sub business failed", "user_id", msg.UserID, "msg_id", formatMsgID(msgID), "err", err)
+		return false
+	}
+	msg.Sub = sub
+	var subID uint16
+	if sub != nil {
+		subID = sub.SubBusinessID
+	}
+	if h := g.handler(msgID, subID); h != nil {
+		h(msg)
+		return !msg.rejected
+	}
+	slog.Debug("unhandled business message", "user_id", msg.UserID, "link", msg.Link, "msg_id", formatMsgID(msgID), "sub_id", fmt.Sprintf("0x%04X", subID))
+	return false
+}
+
+// parseSubBusiness 按业务 ID 解析子业务封装，链路管理等无子业务的报文返回 nil。
+func parseSubBusiness(msgID uint16, body []byte) (*jtt809.SubBusinessPacket, error) {
+	switch {
+	case msgID == jtt809.UP_WARN_MSG:
+		// 0x1400 子业务封装不含车牌
+		pkt, err := jtt809.ParseAlarmInfo(body)
+		if err != nil {
+			return nil, err
+		}
+		return &jtt809.SubBusinessPacket{SubBusinessID: pkt.SubBusinessID, PayloadLength: pkt.PayloadLength, Payload: pkt.Payload}, nil
+	case msgID == jtt809.UP_AUTHORIZE_MSG:
+		// 0x1700 子业务封装仅包含子业务类型与载荷
+		msg, err := jt1078.ParseAuthorizeMsg(body)
+		if err != nil {
+			return nil, err
+		}
+		return &jtt809.SubBusinessPacket{SubBusinessID: msg.SubBusinessID, PayloadLength: uint32(len(msg.Payload)), Payload: msg.Payload}, nil
+	case msgID&0x00FF != 0:
+		return nil, nil
+	default:
+		return jtt809.ParseSubBusiness(body)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func subBusinessFrame(t *testing.T, msgID, subID uint16, plate string, payload []byte) *jtt809.Frame {
+	t.Helper()
+	body, err := buildSubBusinessBody(plate, jtt809.PlateColorBlue, subID, payload)
+	if err != nil {
+		t.Fatalf("build body: %v", err)
+	}
+	return &jtt809.Frame{BodyID: msgID, RawBody: body}
+}
+
+func TestHandleRegistry(t *testing.T) {
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics()}
+
+	// 省平台扩展子业务
+	var got *Message
+	g.Handle(jtt809.UP_EXG_MSG, 0x1240, func(msg *Message) { got = msg })
+	g.handleBusinessMessage(1, subBusinessFrame(t, jtt809.UP_EXG_MSG, 0x1240, "粤A12345", []byte{1, 2}), false)
+	if got == nil || got.UserID != 1 || got.Link != "sub" || got.Sub.Plate != "粤A12345" || len(got.Sub.Payload) != 2 {
+		t.Fatalf("unexpected message %+v", got)
+	}
+
+	// subID 为 0 的处理器接收未单独注册的子业务，内置处理器不受影响
+	var fallback []uint16
+	g.Handle(jtt809.UP_EXG_MSG, 0, func(msg *Message) {
+		if msg.Sub == nil {
+			fallback = append(fallback, 0)
+			return
+		}
+		fallback = append(fallback, msg.Sub.SubBusinessID)
+	})
+	g.handleBusinessMessage(1, subBusinessFrame(t, jtt809.UP_EXG_MSG, 0x1299, "粤A12345", nil), true)
+	g.handleBusinessMessage(1, subBusinessFrame(t, jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REGISTER, "粤A12345", nil), true)
+	// 子业务封装不符合标准格式时以原始报文交给通用处理器
+	g.handleBusinessMessage(1, &jtt809.Frame{BodyID: jtt809.UP_EXG_MSG, RawBody: []byte{1}}, true)
+	if len(fallback) != 2 || fallback[0] != 0x1299 || fallback[1] != 0 {
+		t.Fatalf("unexpected fallback dispatch %04X", fallback)
+	}
+
+	// 覆盖内置处理器
+	called := false
+	g.Handle(jtt809.UP_AUTHORIZE_MSG, jtt809.UP_AUTHORIZE_MSG_STARTUP, func(msg *Message) {
+		called = msg.Sub.SubBusinessID == jtt809.UP_AUTHORIZE_MSG_STARTUP && len(msg.Sub.Payload) == 1
+	})
+	g.handleBusinessMessage(1, &jtt809.Frame{BodyID: jtt809.UP_AUTHORIZE_MSG, RawBody: []byte{0x17, 0x01, 0xFF}}, true)
+	if !called {
+		t.Fatal("expected built-in handler overridden")
+	}
+	if _, code := g.store.GetAuthCode(1); code != "" {
+		t.Fatalf("built-in authorize handler should not run, got auth code %q", code)
+	}
+}
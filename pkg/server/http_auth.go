@@ -0,0 +1,609 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role 为管理接口角色，权限依次递增：viewer < dispatcher < admin。
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"     // 只读查询
+	RoleDispatcher Role = "dispatcher" // 视频点播、定位订阅、查岗、围栏维护等调度操作
+	RoleAdmin      Role = "admin"      // 账号管理与链路控制
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleDispatcher:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// ParseRole 解析角色名称。
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	if r.level() == 0 {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return r, nil
+}
+
+// HTTP 签名请求头。
+const (
+	HTTPHeaderKeyID     = "X-JTT809-Key-Id"
+	HTTPHeaderTimestamp = "X-JTT809-Timestamp"
+	HTTPHeaderSignature = "X-JTT809-Signature"
+)
+
+const (
+	defaultAuthClockSkew = 5 * time.Minute
+	maxSignedBody        = 1 << 20
+)
+
+// APIKey 为静态 API Key，通过 Authorization: Bearer、X-API-Key 头或 access_token 参数携带。
+type APIKey struct {
+	Key  string `yaml:"key"`
+	Name string `yaml:"name"`
+	Role Role   `yaml:"role"`
+}
+
+// HMACKey 为签名请求使用的密钥，签名方式见 SignHTTPRequest。
+type HMACKey struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	Name   string `yaml:"name"`
+	Role   Role   `yaml:"role"`
+}
+
+// HTTPAuthConfig 配置管理接口认证，多种方式可同时启用。
+type HTTPAuthConfig struct {
+	// APIKeys 静态 API Key
+	APIKeys []APIKey `yaml:"api_keys"`
+	// HMACKeys 签名请求密钥
+	HMACKeys []HMACKey `yaml:"hmac_keys"`
+	// JWTPublicKeyFile JWT 验签公钥（PEM，支持 RSA/ECDSA/Ed25519），为空表示不启用 JWT
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	// JWTIssuer、JWTAudience 非空时校验 iss、aud
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+	// JWTRoleClaim 角色声明名称，默认 role，值可为字符串或字符串数组（取最高权限）
+	JWTRoleClaim string `yaml:"jwt_role_claim"`
+	// ClientCertRoles 客户端证书 CN 到角色的映射，需配置 HTTPTLSConfig.ClientCAFile
+	ClientCertRoles map[string]Role `yaml:"client_cert_roles"`
+	// MaxClockSkew 签名时间戳与 JWT 有效期允许的时钟偏差，<=0 时使用默认值 5 分钟
+	MaxClockSkew time.Duration `yaml:"max_clock_skew"`
+	// AuditFile 审计日志文件（JSON Lines），为空时仅输出到日志
+	AuditFile string `yaml:"audit_file"`
+}
+
+// HTTPTLSConfig 配置管理接口 TLS。
+type HTTPTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile 非空时校验客户端证书，证书 CN 可通过 HTTPAuthConfig.ClientCertRoles 映射为角色
+	ClientCAFile string `yaml:"client_ca_file"`
+	// RequireClientCert 为 true 时拒绝未提供有效客户端证书的连接
+	RequireClientCert bool `yaml:"require_client_cert"`
+}
+
+// Principal 为已认证的调用方。
+type Principal struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Method string `json:"method"` // api_key、hmac、jwt、client_cert
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext 返回请求上下文中的调用方，未启用认证时返回 false。
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// canReadSecrets 报告调用方能否读取时效口令，需要 dispatcher 及以上角色，未启用认证时不限制。
+func canReadSecrets(r *http.Request) bool {
+	p, ok := PrincipalFromContext(r.Context())
+	return !ok || p.Role.level() >= RoleDispatcher.level()
+}
+
+// platformSnapshotsFor 返回调用方可见的平台快照，无权读取时清空时效口令。
+func platformSnapshotsFor(r *http.Request, snaps ...PlatformSnapshot) []PlatformSnapshot {
+	if !canReadSecrets(r) {
+		for i := range snaps {
+			snaps[i].AuthCode = ""
+		}
+	}
+	return snaps
+}
+
+// SignHTTPRequest 计算签名请求的签名：HMAC-SHA256(secret, ts + "\n" + method + "\n" + requestURI + "\n" + hex(sha256(body)))，
+// 结果以十六进制放入 X-JTT809-Signature，ts 为 Unix 秒放入 X-JTT809-Timestamp。
+func SignHTTPRequest(secret, ts, method, requestURI string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n" + method + "\n" + requestURI + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// routeRole 为路由所需的最低角色，method 为空表示任意方法。
+type routeRole struct {
+	method string
+	prefix string
+	role   Role
+}
+
+// routeRoles 按顺序匹配，未匹配时 GET/HEAD 需要 viewer，其他方法需要 admin。
+var routeRoles = []routeRole{
+	{"", "/api/v1/accounts", RoleAdmin},
+	{http.MethodPost, "/api/v1/platforms/", RoleAdmin},
+	{http.MethodPost, "/api/v1/monitor/", RoleDispatcher},
+	{http.MethodPost, "/api/video/request", RoleDispatcher},
+	{http.MethodPost, "/api/platform-check", RoleDispatcher},
+	{http.MethodPost, "/api/geofences", RoleDispatcher},
+	{http.MethodDelete, "/api/geofences", RoleDispatcher},
+	{"", "/proxy/", RoleDispatcher}, // 拉流占用车辆带宽
+}
+
+// publicPaths 无需认证的路径前缀。
+var publicPaths = []string{"/healthz", "/ui/"}
+
+func requiredRole(r *http.Request) Role {
+	for _, rr := range routeRoles {
+		if (rr.method == "" || rr.method == r.Method) && strings.HasPrefix(r.URL.Path, rr.prefix) {
+			return rr.role
+		}
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return RoleViewer
+	}
+	return RoleAdmin
+}
+
+// shouldAudit 判断请求是否记录审计日志：所有写操作及视频拉流。
+func shouldAudit(r *http.Request) bool {
+	return (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasPrefix(r.URL.Path, "/proxy/")
+}
+
+// httpAuthenticator 实现管理接口认证、鉴权与审计。
+type httpAuthenticator struct {
+	cfg      HTTPAuthConfig
+	apiKeys  map[string]APIKey
+	hmacKeys map[string]HMACKey
+	jwtKey   crypto.PublicKey
+
+	auditMu sync.Mutex
+	audit   *os.File
+}
+
+func newHTTPAuthenticator(cfg HTTPAuthConfig) (*httpAuthenticator, error) {
+	if cfg.MaxClockSkew <= 0 {
+		cfg.MaxClockSkew = defaultAuthClockSkew
+	}
+	if cfg.JWTRoleClaim == "" {
+		cfg.JWTRoleClaim = "role"
+	}
+	a := &httpAuthenticator{
+		cfg:      cfg,
+		apiKeys:  make(map[string]APIKey, len(cfg.APIKeys)),
+		hmacKeys: make(map[string]HMACKey, len(cfg.HMACKeys)),
+	}
+	for _, k := range cfg.APIKeys {
+		if k.Key == "" || k.Role.level() == 0 {
+			return nil, fmt.Errorf("api key %q: key and valid role are required", k.Name)
+		}
+		a.apiKeys[k.Key] = k
+	}
+	for _, k := range cfg.HMACKeys {
+		if k.ID == "" || k.Secret == "" || k.Role.level() == 0 {
+			return nil, fmt.Errorf("hmac key %q: id, secret and valid role are required", k.ID)
+		}
+		a.hmacKeys[k.ID] = k
+	}
+	for cn, role := range cfg.ClientCertRoles {
+		if role.level() == 0 {
+			return nil, fmt.Errorf("client cert %q: invalid role %q", cn, role)
+		}
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwt public key: %w", err)
+		}
+		if a.jwtKey, err = parsePublicKeyPEM(data); err != nil {
+			return nil, fmt.Errorf("parse jwt public key: %w", err)
+		}
+	}
+	if cfg.AuditFile != "" {
+		f, err := os.OpenFile(cfg.AuditFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		a.audit = f
+	}
+	return a, nil
+}
+
+func (a *httpAuthenticator) Close() error {
+	if a.audit == nil {
+		return nil
+	}
+	return a.audit.Close()
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate 依次尝试客户端证书、签名、JWT 与 API Key，未携带任何凭据时返回 nil, nil。
+func (a *httpAuthenticator) authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if role, ok := a.cfg.ClientCertRoles[cn]; ok {
+			return &Principal{Name: cn, Role: role, Method: "client_cert"}, nil
+		}
+	}
+	if keyID := r.Header.Get(HTTPHeaderKeyID); keyID != "" {
+		return a.verifySignature(r, keyID)
+	}
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		if v := r.Header.Get("Authorization"); len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			token = strings.TrimSpace(v[7:])
+		}
+	}
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	if k, ok := a.apiKeys[token]; ok {
+		return &Principal{Name: k.Name, Role: k.Role, Method: "api_key"}, nil
+	}
+	if a.jwtKey != nil && strings.Count(token, ".") == 2 {
+		return a.verifyJWT(token, time.Now())
+	}
+	return nil, errInvalidCredentials
+}
+
+func (a *httpAuthenticator) verifySignature(r *http.Request, keyID string) (*Principal, error) {
+	k, ok := a.hmacKeys[keyID]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	ts := r.Header.Get(HTTPHeaderTimestamp)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid signature timestamp")
+	}
+	if d := time.Since(time.Unix(secs, 0)); d > a.cfg.MaxClockSkew || d < -a.cfg.MaxClockSkew {
+		return nil, errors.New("signature expired")
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1)); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		if len(body) > maxSignedBody {
+			return nil, errors.New("signed body too large")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	want := SignHTTPRequest(k.Secret, ts, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(r.Header.Get(HTTPHeaderSignature)))) {
+		return nil, errInvalidCredentials
+	}
+	return &Principal{Name: k.Name, Role: k.Role, Method: "hmac"}, nil
+}
+
+// middleware 对请求进行认证与鉴权，并为写操作与视频拉流记录审计日志。
+func (a *httpAuthenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range publicPaths {
+			if strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		principal, err := a.authenticate(r)
+		if err != nil || principal == nil {
+			msg := "authentication required"
+			if err != nil {
+				msg = err.Error()
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jtt809"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthenticated", msg)
+			a.writeAudit(r, nil, http.StatusUnauthorized, 0)
+			return
+		}
+		if need := requiredRole(r); principal.Role.level() < need.level() {
+			writeAPIError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("role %s required", need))
+			a.writeAudit(r, principal, http.StatusForbidden, 0)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+		if !shouldAudit(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		a.writeAudit(r, principal, rec.status, time.Since(start))
+	})
+}
+
+type auditRecord struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal,omitempty"`
+	Role       Role      `json:"role,omitempty"`
+	AuthMethod string    `json:"auth_method,omitempty"`
+	Remote     string    `json:"remote"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// writeAudit 记录审计日志，拒绝的请求无论方法均记录。
+func (a *httpAuthenticator) writeAudit(r *http.Request, p *Principal, status int, d time.Duration) {
+	if status < http.StatusBadRequest && !shouldAudit(r) {
+		return
+	}
+	rec := auditRecord{
+		Time:       time.Now(),
+		Remote:     r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+		DurationMS: d.Milliseconds(),
+	}
+	if p != nil {
+		rec.Principal, rec.Role, rec.AuthMethod = p.Name, p.Role, p.Method
+	}
+	slog.Info("audit", "principal", rec.Principal, "role", rec.Role, "auth", rec.AuthMethod, "remote", rec.Remote, "method", rec.Method, "path", rec.Path, "status", rec.Status)
+	if a.audit == nil {
+		return
+	}
+	line, _ := json.Marshal(rec)
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	if _, err := a.audit.Write(append(line, '\n')); err != nil {
+		slog.Warn("write audit log failed", "err", err)
+	}
+}
+
+// statusRecorder 记录响应状态码，保留流式输出能力。
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// parsePublicKeyPEM 解析 PKIX/PKCS#1 公钥或证书。
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// verifyJWT 校验 JWT 签名与有效期，签名算法由公钥类型决定（RS256、ES256/384/512、EdDSA）。
+func (a *httpAuthenticator) verifyJWT(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errInvalidCredentials
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	if err := verifyJWTSignature(a.jwtKey, header.Alg, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	var claims map[string]any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, errInvalidCredentials
+	}
+	skew := a.cfg.MaxClockSkew
+	exp, ok := numericClaim(claims, "exp")
+	if !ok || now.After(time.Unix(exp, 0).Add(skew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(skew).Before(time.Unix(nbf, 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if a.cfg.JWTIssuer != "" && claims["iss"] != a.cfg.JWTIssuer {
+		return nil, errors.New("unexpected token issuer")
+	}
+	if a.cfg.JWTAudience != "" && !claimContains(claims["aud"], a.cfg.JWTAudience) {
+		return nil, errors.New("unexpected token audience")
+	}
+	var role Role
+	for _, v := range claimStrings(claims[a.cfg.JWTRoleClaim]) {
+		if r, err := ParseRole(v); err == nil && r.level() > role.level() {
+			role = r
+		}
+	}
+	if role == "" {
+		return nil, errors.New("token has no valid role")
+	}
+	name, _ := claims["sub"].(string)
+	return &Principal{Name: name, Role: role, Method: "jwt"}, nil
+}
+
+func verifyJWTSignature(key crypto.PublicKey, alg string, signed, sig []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) != nil {
+			return errInvalidCredentials
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		var hash crypto.Hash
+		switch alg {
+		case "ES256":
+			hash = crypto.SHA256
+		case "ES384":
+			hash = crypto.SHA384
+		case "ES512":
+			hash = crypto.SHA512
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if hash == 0 || len(sig) != 2*size {
+			break
+		}
+		h := hash.New()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, h.Sum(nil), r, s) {
+			return errInvalidCredentials
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+		if !ed25519.Verify(k, signed, sig) {
+			return errInvalidCredentials
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported token algorithm %q", alg)
+}
+
+func numericClaim(claims map[string]any, name string) (int64, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func claimStrings(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func claimContains(v any, want string) bool {
+	for _, s := range claimStrings(v) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// serverTLSConfig 构造管理接口 TLS 配置。
+func serverTLSConfig(cfg HTTPTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsCfg, nil
+	}
+	data, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in client ca file")
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
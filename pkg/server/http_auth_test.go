@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAuthTestServer(t *testing.T, cfg HTTPAuthConfig) (*httptest.Server, *Principal) {
+	t.Helper()
+	a, err := newHTTPAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("new authenticator: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	got := &Principal{}
+	srv := httptest.NewServer(a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := PrincipalFromContext(r.Context()); ok {
+			*got = *p
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func authRequest(t *testing.T, method, url string, header map[string]string, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHTTPAuthAPIKeyRoles(t *testing.T) {
+	audit := filepath.Join(t.TempDir(), "audit.log")
+	srv, got := newAuthTestServer(t, HTTPAuthConfig{
+		APIKeys: []APIKey{
+			{Key: "view", Name: "screen", Role: RoleViewer},
+			{Key: "ops", Name: "dispatcher-1", Role: RoleDispatcher},
+		},
+		AuditFile: audit,
+	})
+
+	cases := []struct {
+		method, path string
+		header       map[string]string
+		want         int
+	}{
+		{http.MethodGet, "/healthz", nil, http.StatusAccepted},
+		{http.MethodGet, "/api/platforms", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/api/platforms", map[string]string{"X-API-Key": "bad"}, http.StatusUnauthorized},
+		{http.MethodGet, "/api/platforms?access_token=view", nil, http.StatusAccepted},
+		{http.MethodPost, "/api/video/request", map[string]string{"Authorization": "Bearer view"}, http.StatusForbidden},
+		{http.MethodPost, "/api/video/request", map[string]string{"Authorization": "Bearer ops"}, http.StatusAccepted},
+		{http.MethodGet, "/proxy/rtp.flv", map[string]string{"X-API-Key": "view"}, http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/accounts/1", map[string]string{"X-API-Key": "ops"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if code := authRequest(t, c.method, srv.URL+c.path, c.header, ""); code != c.want {
+			t.Fatalf("%s %s: expected %d, got %d", c.method, c.path, c.want, code)
+		}
+	}
+
+	data, err := os.ReadFile(audit)
+	if err != nil {
+		t.Fatalf("read audit: %v", err)
+	}
+	var records []auditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad audit line %q", line)
+		}
+		records = append(records, rec)
+	}
+	// 拒绝的请求与成功的写操作均记录，成功的只读请求不记录
+	if len(records) != 6 {
+		t.Fatalf("expected 6 audit records, got %d: %s", len(records), data)
+	}
+	ok := records[3]
+	if ok.Principal != "dispatcher-1" || ok.Role != RoleDispatcher || ok.Status != http.StatusAccepted || ok.Path != "/api/video/request" {
+		t.Fatalf("unexpected audit record %+v", ok)
+	}
+	if got.Name != "dispatcher-1" || got.Method != "api_key" {
+		t.Fatalf("unexpected principal in context %+v", got)
+	}
+}
+
+func TestHTTPAuthHMAC(t *testing.T) {
+	srv, got := newAuthTestServer(t, HTTPAuthConfig{
+		HMACKeys: []HMACKey{{ID: "k1", Secret: "s3cret", Name: "scheduler", Role: RoleAdmin}},
+	})
+	body := `{"user_id":10001}`
+	sign := func(ts time.Time, uri, body string) map[string]string {
+		tsStr := strconv.FormatInt(ts.Unix(), 10)
+		return map[string]string{
+			HTTPHeaderKeyID:     "k1",
+			HTTPHeaderTimestamp: tsStr,
+			HTTPHeaderSignature: SignHTTPRequest("s3cret", tsStr, http.MethodPost, uri, []byte(body)),
+		}
+	}
+	uri := "/api/v1/platforms/10001/links/sub/connect?x=1"
+	if code := authRequest(t, http.MethodPost, srv.URL+uri, sign(time.Now(), uri, body), body); code != http.StatusAccepted {
+		t.Fatalf("expected signed request accepted, got %d", code)
+	}
+	if got.Name != "scheduler" || got.Method != "hmac" {
+		t.Fatalf("unexpected principal %+v", got)
+	}
+	if code := authRequest(t, http.MethodPost, srv.URL+uri, sign(time.Now(), uri, body), `{"user_id":1}`); code != http.StatusUnauthorized {
+		t.Fatalf("expected tampered body rejected, got %d", code)
+	}
+	if code := authRequest(t, http.MethodPost, srv.URL+uri, sign(time.Now().Add(-time.Hour), uri, body), body); code != http.StatusUnauthorized {
+		t.Fatalf("expected stale signature rejected, got %d", code)
+	}
+}
+
+func signTestJWT(t *testing.T, alg string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writePublicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func TestHTTPAuthJWT(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv, got := newAuthTestServer(t, HTTPAuthConfig{
+		JWTPublicKeyFile: writePublicKeyPEM(t, &ecKey.PublicKey),
+		JWTIssuer:        "sso",
+	})
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signTestJWT(t, "ES256", ecKey, map[string]any{"sub": "alice", "iss": "sso", "exp": exp, "role": []string{"viewer", "dispatcher"}})
+	bearer := map[string]string{"Authorization": "Bearer " + token}
+	if code := authRequest(t, http.MethodPost, srv.URL+"/api/v1/monitor/startup", bearer, ""); code != http.StatusAccepted {
+		t.Fatalf("expected dispatcher token accepted, got %d", code)
+	}
+	if got.Name != "alice" || got.Role != RoleDispatcher || got.Method != "jwt" {
+		t.Fatalf("unexpected principal %+v", got)
+	}
+	if code := authRequest(t, http.MethodPost, srv.URL+"/api/v1/platforms/1/links/main/close", bearer, ""); code != http.StatusForbidden {
+		t.Fatalf("expected admin endpoint forbidden, got %d", code)
+	}
+
+	expired := signTestJWT(t, "ES256", ecKey, map[string]any{"sub": "alice", "iss": "sso", "exp": time.Now().Add(-time.Hour).Unix(), "role": "admin"})
+	if code := authRequest(t, http.MethodGet, srv.URL+"/api/platforms", map[string]string{"Authorization": "Bearer " + expired}, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected expired token rejected, got %d", code)
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := signTestJWT(t, "ES256", otherKey, map[string]any{"sub": "mallory", "iss": "sso", "exp": exp, "role": "admin"})
+	if code := authRequest(t, http.MethodGet, srv.URL+"/api/platforms", map[string]string{"Authorization": "Bearer " + forged}, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected forged token rejected, got %d", code)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edSrv, _ := newAuthTestServer(t, HTTPAuthConfig{JWTPublicKeyFile: writePublicKeyPEM(t, edKey.Public())})
+	edToken := signTestJWT(t, "EdDSA", edKey, map[string]any{"sub": "bob", "exp": exp, "role": "admin"})
+	if code := authRequest(t, http.MethodGet, edSrv.URL+"/api/platforms?access_token="+edToken, nil, ""); code != http.StatusAccepted {
+		t.Fatalf("expected EdDSA token accepted, got %d", code)
+	}
+	// 公钥类型与算法不一致时拒绝
+	wrongAlg := signTestJWT(t, "ES256", ecKey, map[string]any{"sub": "bob", "exp": exp, "role": "admin"})
+	if code := authRequest(t, http.MethodGet, edSrv.URL+"/api/platforms?access_token="+wrongAlg, nil, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected algorithm mismatch rejected, got %d", code)
+	}
+}
+
+func TestViewerCannotReadAuthCode(t *testing.T) {
+	withRole := func(role Role) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/platforms", nil)
+		return r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{Role: role}))
+	}
+	snaps := func() []PlatformSnapshot { return []PlatformSnapshot{{UserID: 1, AuthCode: "secret"}} }
+	if got := platformSnapshotsFor(withRole(RoleViewer), snaps()...); got[0].AuthCode != "" {
+		t.Fatalf("viewer should not see auth code, got %q", got[0].AuthCode)
+	}
+	if got := platformSnapshotsFor(withRole(RoleDispatcher), snaps()...); got[0].AuthCode != "secret" {
+		t.Fatalf("dispatcher should see auth code, got %q", got[0].AuthCode)
+	}
+
+	evt := &Event{Type: EventAuthorize, UserID: 1}
+	if (EventFilter{hideSecrets: !canReadSecrets(withRole(RoleViewer))}).match(evt) {
+		t.Fatal("viewer should not receive authorize events")
+	}
+	if !(EventFilter{hideSecrets: !canReadSecrets(withRole(RoleDispatcher))}).match(evt) {
+		t.Fatal("dispatcher should receive authorize events")
+	}
+}
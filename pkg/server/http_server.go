@@ -5,10 +5,13 @@ import (
 	"embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zboyco/jtt809/pkg/jtt809"
@@ -23,8 +26,17 @@ func (g *JT809Gateway) startHTTPServer(ctx context.Context) {
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", g.handleHealth)
+	mux.HandleFunc("/metrics", g.handleMetrics)
 	mux.HandleFunc("/api/platforms", g.handlePlatforms)
 	mux.HandleFunc("/api/video/request", g.handleVideoRequest)
+	mux.HandleFunc("/api/track", g.handleTrack)
+	mux.HandleFunc("/api/geofences", g.handleGeofences)
+	mux.HandleFunc("/api/rule-alarms", g.handleRuleAlarms)
+	mux.HandleFunc("/api/quality", g.handleQuality)
+	mux.HandleFunc("/api/report", g.handleReport)
+	mux.HandleFunc("/api/platform-check", g.handlePlatformCheck)
+	mux.HandleFunc("/api/events", g.handleEvents)
+	g.registerAPIv1(mux)
 	if g.rtpSrv != nil {
 		mux.HandleFunc("/proxy/rtp.raw", g.rtpSrv.HandleProxyRaw)
 		mux.HandleFunc("/proxy/rtp.flv", g.rtpSrv.HandleProxyFLV)
@@ -38,14 +50,37 @@ func (g *JT809Gateway) startHTTPServer(ctx context.Context) {
 		mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(webContent))))
 	}
 
+	var handler http.Handler = mux
+	if g.httpAuth != nil {
+		handler = g.httpAuth.middleware(mux)
+	}
 	g.httpSrv = &http.Server{
 		Addr:    g.cfg.HTTPListen,
-		Handler: mux,
+		Handler: handler,
+	}
+	if g.cfg.HTTPTLS != nil {
+		tlsCfg, err := serverTLSConfig(*g.cfg.HTTPTLS)
+		if err != nil {
+			slog.Error("http tls config failed", "err", err)
+			return
+		}
+		g.httpSrv.TLSConfig = tlsCfg
+	}
+	if g.events != nil {
+		// SSE 与 WebSocket 长连接不会被 Shutdown 主动结束，关闭时先断开事件流
+		g.httpSrv.RegisterOnShutdown(func() { g.events.Close() })
 	}
 
 	go func() {
-		slog.Info("http server listening", "addr", g.cfg.HTTPListen)
-		if err := g.httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if tlsCfg := g.cfg.HTTPTLS; tlsCfg != nil {
+			slog.Info("https server listening", "addr", g.cfg.HTTPListen, "client_ca", tlsCfg.ClientCAFile != "")
+			err = g.httpSrv.ListenAndServeTLS(tlsCfg.CertFile, tlsCfg.KeyFile)
+		} else {
+			slog.Info("http server listening", "addr", g.cfg.HTTPListen)
+			err = g.httpSrv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("http server failed", "err", err)
 		}
 	}()
@@ -73,7 +108,7 @@ func (g *JT809Gateway) handlePlatforms(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	writeJSON(w, g.store.Snapshots())
+	writeJSON(w, platformSnapshotsFor(r, g.store.Snapshots()...))
 }
 
 func (g *JT809Gateway) handleVideoRequest(w http.ResponseWriter, r *http.Request) {
@@ -99,6 +134,239 @@ func (g *JT809Gateway) handleVideoRequest(w http.ResponseWriter, r *http.Request
 	writeJSON(w, map[string]string{"status": "sent"})
 }
 
+// handleTrack 查询历史轨迹。
+// 参数：vehicle_no、vehicle_color、from、to（RFC3339、"2006-01-02 15:04:05"(UTC+8) 或 Unix 秒），
+// 时间跨度不得超过 31 天，format=json|geojson，interval 抽稀最小间隔（如 30s），max_points 最大点数。
+func (g *JT809Gateway) handleTrack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	plate := q.Get("vehicle_no")
+	color := jtt809.PlateColorBlue
+	if v := q.Get("vehicle_color"); v != "" {
+		c, err := strconv.ParseUint(v, 0, 8)
+		if err != nil {
+			http.Error(w, "invalid vehicle_color: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		color = jtt809.PlateColor(c)
+	}
+	from, err := parseQueryTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		if to, err = parseQueryTime(v); err != nil {
+			http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var interval time.Duration
+	if v := q.Get("interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "invalid interval: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	maxPoints := 0
+	if v := q.Get("max_points"); v != "" {
+		if maxPoints, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid max_points: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := g.QueryTrack(plate, color, from, to)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrTrackStoreDisabled) {
+			status = http.StatusNotImplemented
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	points = DownsampleTrack(points, interval, maxPoints)
+
+	if q.Get("format") == "geojson" {
+		w.Header().Set("Content-Type", "application/geo+json")
+		if err := json.NewEncoder(w).Encode(TrackGeoJSON(plate, color, points)); err != nil {
+			slog.Warn("write geojson failed", "err", err)
+		}
+		return
+	}
+	writeJSON(w, map[string]any{
+		"vehicle_no":    plate,
+		"vehicle_color": color,
+		"from":          from,
+		"to":            to,
+		"count":         len(points),
+		"points":        points,
+	})
+}
+
+// handleGeofences 管理电子围栏：GET 列表（带 id 时返回单个），POST 新增或更新，DELETE?id= 删除。
+func (g *JT809Gateway) handleGeofences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id"); id != "" {
+			f, ok := g.geofences.Get(id)
+			if !ok {
+				http.Error(w, "geofence not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, f)
+			return
+		}
+		writeJSON(w, g.geofences.List())
+	case http.MethodPost:
+		defer r.Body.Close()
+		var f Geofence
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&f); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, err := g.geofences.Upsert(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, saved)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		ok, err := g.geofences.Remove(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "geofence not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "deleted"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRuleAlarms 返回持续中的平台侧违规报警。
+func (g *JT809Gateway) handleRuleAlarms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, g.rules.Active())
+}
+
+// handleQuality 返回各平台定位数据质量统计，可通过 user_id 过滤。
+func (g *JT809Gateway) handleQuality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		uid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid user_id: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, ok := g.quality.PlatformStats(uint32(uid))
+		if !ok {
+			http.Error(w, "platform not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, stats)
+		return
+	}
+	writeJSON(w, g.QualityStats())
+}
+
+// handleReport 生成考核报表。
+// 参数：from、to（日期 YYYY-MM-DD，按 UTC+8，含首尾，to 默认等于 from），
+// scope=platform|vehicle，format=json|csv。
+func (g *JT809Gateway) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err := time.ParseInLocation("2006-01-02", q.Get("from"), trackPartitionZone)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := from
+	if v := q.Get("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, trackPartitionZone); err != nil {
+			http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	vehicleScope := q.Get("scope") == "vehicle"
+	report, err := g.AssessmentReport(from, to, vehicleScope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Get("format") != "csv" {
+		writeJSON(w, report)
+		return
+	}
+	name := fmt.Sprintf("assessment_%s_%s", report.From, report.To)
+	write := report.WritePlatformCSV
+	if vehicleScope {
+		name += "_vehicle"
+		write = report.WriteVehicleCSV
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	if err := write(w); err != nil {
+		slog.Warn("write csv failed", "err", err)
+	}
+}
+
+// handlePlatformCheck 下发平台查岗请求。
+func (g *JT809Gateway) handlePlatformCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	var req PlatformCheckRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	infoID, err := g.SendPlatformCheck(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]any{"status": "sent", "info_id": infoID})
+}
+
+// parseQueryTime 解析查询参数中的时间，支持 RFC3339、"2006-01-02 15:04:05"（按 UTC+8）与 Unix 秒。
+func parseQueryTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, errors.New("time is required")
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", v, trackPartitionZone)
+}
+
 func writeJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(v); err != nil {
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Kafka 消息格式。
+const (
+	KafkaFormatJSON = "json"
+	KafkaFormatAvro = "avro"
+)
+
+const (
+	defaultKafkaLinger      = 100 * time.Millisecond
+	defaultKafkaMaxBuffered = 100000
+	kafkaCloseTimeout       = 10 * time.Second
+)
+
+// defaultKafkaTopics 为未单独配置主题时各事件类型使用的主题。
+var defaultKafkaTopics = map[EventType]string{
+	EventVehicleLocation:              "jt809.location",
+	EventVehicleLocationSupplementary: "jt809.location",
+	EventVehicleRegistration:          "jt809.registration",
+	EventWarnAdptInfo:                 "jt809.warn",
+	EventWarnInformTips:               "jt809.warn",
+}
+
+// KafkaConfig 配置 Kafka 推送。
+type KafkaConfig struct {
+	// Brokers 种子 Broker 地址列表
+	Brokers []string `yaml:"brokers"`
+	// Topics 按事件类型覆盖默认主题（定位与补报 jt809.location、车辆注册 jt809.registration、报警 jt809.warn），值为空字符串表示该类型不推送
+	Topics map[EventType]string `yaml:"topics"`
+	// Format 消息格式，json（默认）或 avro（Schema 见 KafkaAvroSchema）
+	Format string `yaml:"format"`
+	// AvroSchemaID 非 0 时 Avro 消息按 Confluent Schema Registry 格式添加魔数与 Schema ID 前缀
+	AvroSchemaID uint32 `yaml:"avro_schema_id"`
+	// ClientID Kafka 客户端 ID，默认 jtt809-gateway
+	ClientID string `yaml:"client_id"`
+	// Linger 批量发送等待时间，<=0 时使用默认值 100ms
+	Linger time.Duration `yaml:"linger"`
+	// MaxBufferedRecords 客户端缓冲的最大消息数，缓冲满时新消息直接丢弃而不阻塞报文处理，<=0 时使用默认值 100000
+	MaxBufferedRecords int `yaml:"max_buffered_records"`
+}
+
+func (c KafkaConfig) topicFor(typ EventType) string {
+	if t, ok := c.Topics[typ]; ok {
+		return t
+	}
+	return defaultKafkaTopics[typ]
+}
+
+// KafkaProducer 将定位、补报定位、车辆注册与报警事件写入 Kafka，实现 EventSink。
+// 消息以 "车牌#颜色" 为 Key 保证单车有序；生产者开启幂等，重试不会产生重复或乱序消息。
+// 补报定位拆分为逐点消息。Publish 从不阻塞，客户端缓冲已满时丢弃并计数。
+type KafkaProducer struct {
+	cfg    KafkaConfig
+	client *kgo.Client
+
+	produced atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewKafkaProducer 创建 Kafka 生产者，连接在首次发送时建立。
+func NewKafkaProducer(cfg KafkaConfig) (*KafkaProducer, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka brokers are required")
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = KafkaFormatJSON
+	case KafkaFormatJSON, KafkaFormatAvro:
+	default:
+		return nil, fmt.Errorf("unsupported kafka format %q", cfg.Format)
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "jtt809-gateway"
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = defaultKafkaLinger
+	}
+	if cfg.MaxBufferedRecords <= 0 {
+		cfg.MaxBufferedRecords = defaultKafkaMaxBuffered
+	}
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(cfg.ClientID),
+		kgo.ProducerLinger(cfg.Linger),
+		kgo.MaxBufferedRecords(cfg.MaxBufferedRecords),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create kafka client: %w", err)
+	}
+	return &KafkaProducer{cfg: cfg, client: client}, nil
+}
+
+// Publish 实现 EventSink。
+func (k *KafkaProducer) Publish(evt *Event) {
+	records, err := k.records(evt)
+	if err != nil {
+		slog.Warn("encode kafka record failed", "event", evt.Type, "err", err)
+		return
+	}
+	for _, r := range records {
+		k.client.TryProduce(context.Background(), r, k.onProduced)
+	}
+}
+
+func (k *KafkaProducer) onProduced(r *kgo.Record, err error) {
+	switch {
+	case err == nil:
+		k.produced.Add(1)
+	case errors.Is(err, kgo.ErrMaxBuffered):
+		if k.dropped.Add(1)%1000 == 1 {
+			slog.Warn("kafka buffer full, dropping records", "topic", r.Topic, "dropped", k.dropped.Load())
+		}
+	default:
+		k.failed.Add(1)
+		slog.Warn("kafka produce failed", "topic", r.Topic, "key", string(r.Key), "err", err)
+	}
+}
+
+// Stats 返回已写入、因缓冲满丢弃与写入失败的消息数。
+func (k *KafkaProducer) Stats() (produced, dropped, failed uint64) {
+	return k.produced.Load(), k.dropped.Load(), k.failed.Load()
+}
+
+// Close 等待缓冲中的消息写入（最多 10 秒）后关闭客户端。
+func (k *KafkaProducer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaCloseTimeout)
+	defer cancel()
+	err := k.client.Flush(ctx)
+	k.client.Close()
+	return err
+}
+
+// records 将事件转换为 Kafka 消息，不推送的事件类型返回空。
+func (k *KafkaProducer) records(evt *Event) ([]*kgo.Record, error) {
+	topic := k.cfg.topicFor(evt.Type)
+	if topic == "" {
+		return nil, nil
+	}
+	events := []*Event{evt}
+	if points, ok := evt.Data.([]*LocationData); ok {
+		events = events[:0]
+		for _, pt := range points {
+			single := *evt
+			single.Data = pt
+			events = append(events, &single)
+		}
+	}
+	key := []byte(vehicleKey(evt.VehicleNo, evt.VehicleColor))
+	records := make([]*kgo.Record, 0, len(events))
+	for _, e := range events {
+		value, err := k.encode(e)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &kgo.Record{
+			Topic: topic,
+			Key:   key,
+			Value: value,
+			Headers: []kgo.RecordHeader{
+				{Key: "event", Value: []byte(e.Type)},
+			},
+		})
+	}
+	return records, nil
+}
+
+func (k *KafkaProducer) encode(evt *Event) ([]byte, error) {
+	if k.cfg.Format == KafkaFormatJSON {
+		return json.Marshal(evt)
+	}
+	var out []byte
+	if k.cfg.AvroSchemaID != 0 {
+		out = append(out, 0)
+		out = binary.BigEndian.AppendUint32(out, k.cfg.AvroSchemaID)
+	}
+	return appendAvroEvent(out, evt), nil
+}
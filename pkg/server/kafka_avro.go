@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// KafkaAvroSchema 为 Avro 格式 Kafka 消息的 Schema。location、registration、warn 按事件类型填充其一，其余为 null。
+const KafkaAvroSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "jtt809",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "type", "type": "string"},
+    {"name": "user_id", "type": "long"},
+    {"name": "vehicle_no", "type": "string"},
+    {"name": "vehicle_color", "type": "int"},
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "location", "default": null, "type": ["null", {
+      "type": "record", "name": "Location", "fields": [
+        {"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+        {"name": "longitude", "type": "double"},
+        {"name": "latitude", "type": "double"},
+        {"name": "speed", "type": "double"},
+        {"name": "direction", "type": "int"},
+        {"name": "altitude", "type": "int"},
+        {"name": "mileage", "type": "double"},
+        {"name": "alarm", "type": "long"},
+        {"name": "state", "type": "long"}
+      ]}]},
+    {"name": "registration", "default": null, "type": ["null", {
+      "type": "record", "name": "Registration", "fields": [
+        {"name": "platform_id", "type": "string"},
+        {"name": "producer_id", "type": "string"},
+        {"name": "terminal_model_type", "type": "string"},
+        {"name": "imei", "type": "string"},
+        {"name": "terminal_id", "type": "string"},
+        {"name": "terminal_sim", "type": "string"}
+      ]}]},
+    {"name": "warn", "default": null, "type": ["null", {
+      "type": "record", "name": "Warn", "fields": [
+        {"name": "source_platform_id", "type": "string"},
+        {"name": "target_platform_id", "type": "string"},
+        {"name": "warn_type", "type": "int"},
+        {"name": "warn_time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+        {"name": "start_time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+        {"name": "end_time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+        {"name": "drv_line_id", "type": "long"},
+        {"name": "content", "type": "string"}
+      ]}]}
+  ]
+}`
+
+// appendAvroEvent 按 KafkaAvroSchema 进行 Avro 二进制编码。
+func appendAvroEvent(b []byte, evt *Event) []byte {
+	b = appendAvroString(b, evt.ID)
+	b = appendAvroString(b, string(evt.Type))
+	b = appendAvroLong(b, int64(evt.UserID))
+	b = appendAvroString(b, evt.VehicleNo)
+	b = appendAvroLong(b, int64(evt.VehicleColor))
+	b = appendAvroTime(b, evt.Time)
+
+	var (
+		loc  *LocationData
+		reg  *RegistrationEventData
+		warn *WarnEventData
+	)
+	switch d := evt.Data.(type) {
+	case *LocationData:
+		loc = d
+	case RegistrationEventData:
+		reg = &d
+	case WarnEventData:
+		warn = &d
+	}
+
+	if loc == nil {
+		b = appendAvroLong(b, 0)
+	} else {
+		b = appendAvroLong(b, 1)
+		b = appendAvroTime(b, loc.Time)
+		b = appendAvroDouble(b, loc.Longitude)
+		b = appendAvroDouble(b, loc.Latitude)
+		b = appendAvroDouble(b, loc.Speed)
+		b = appendAvroLong(b, int64(loc.Direction))
+		b = appendAvroLong(b, int64(loc.Altitude))
+		b = appendAvroDouble(b, loc.Mileage)
+		b = appendAvroLong(b, int64(loc.Alarm))
+		b = appendAvroLong(b, int64(loc.State))
+	}
+	if reg == nil {
+		b = appendAvroLong(b, 0)
+	} else {
+		b = appendAvroLong(b, 1)
+		b = appendAvroString(b, reg.PlatformID)
+		b = appendAvroString(b, reg.ProducerID)
+		b = appendAvroString(b, reg.TerminalModelType)
+		b = appendAvroString(b, reg.IMEI)
+		b = appendAvroString(b, reg.TerminalID)
+		b = appendAvroString(b, reg.TerminalSIM)
+	}
+	if warn == nil {
+		b = appendAvroLong(b, 0)
+	} else {
+		b = appendAvroLong(b, 1)
+		b = appendAvroString(b, warn.SourcePlatformID)
+		b = appendAvroString(b, warn.TargetPlatformID)
+		b = appendAvroLong(b, int64(warn.WarnType))
+		b = appendAvroTime(b, warn.WarnTime)
+		b = appendAvroTime(b, warn.StartTime)
+		b = appendAvroTime(b, warn.EndTime)
+		b = appendAvroLong(b, int64(warn.DrvLineID))
+		b = appendAvroString(b, warn.Content)
+	}
+	return b
+}
+
+// appendAvroLong 以 zigzag 变长编码写入 int/long。
+func appendAvroLong(b []byte, v int64) []byte {
+	return binary.AppendUvarint(b, uint64(v<<1)^uint64(v>>63))
+}
+
+func appendAvroString(b []byte, s string) []byte {
+	b = appendAvroLong(b, int64(len(s)))
+	return append(b, s...)
+}
+
+func appendAvroDouble(b []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// appendAvroTime 写入 timestamp-millis，零值时间写 0。
+func appendAvroTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return appendAvroLong(b, 0)
+	}
+	return appendAvroLong(b, t.UnixMilli())
+}
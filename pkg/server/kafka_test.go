@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestKafkaRecords(t *testing.T) {
+	k, err := NewKafkaProducer(KafkaConfig{
+		Brokers: []string{"127.0.0.1:1"},
+		Topics:  map[EventType]string{EventWarnInformTips: ""},
+	})
+	if err != nil {
+		t.Fatalf("new producer: %v", err)
+	}
+	defer k.client.Close()
+
+	evt := &Event{
+		ID: "1", Type: EventVehicleLocationSupplementary, UserID: 10001,
+		VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue,
+		Data: []*LocationData{{Longitude: 114.05}, {Longitude: 114.06}},
+	}
+	records, err := k.records(evt)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d err=%v", len(records), err)
+	}
+	for i, r := range records {
+		if r.Topic != "jt809.location" || string(r.Key) != vehicleKey("粤B12345", jtt809.PlateColorBlue) {
+			t.Fatalf("unexpected record topic=%s key=%s", r.Topic, r.Key)
+		}
+		var got struct {
+			Data LocationData `json:"data"`
+		}
+		if err := json.Unmarshal(r.Value, &got); err != nil || got.Data.Longitude != 114.05+float64(i)*0.01 {
+			t.Fatalf("unexpected value %s", r.Value)
+		}
+	}
+	if records, _ := k.records(&Event{Type: EventWarnInformTips}); len(records) != 0 {
+		t.Fatalf("expected disabled topic to be skipped")
+	}
+	if records, _ := k.records(&Event{Type: EventLogin}); len(records) != 0 {
+		t.Fatalf("expected login event to be skipped")
+	}
+}
+
+func TestKafkaAvroEncoding(t *testing.T) {
+	k := &KafkaProducer{cfg: KafkaConfig{Format: KafkaFormatAvro, AvroSchemaID: 7}}
+	at := time.UnixMilli(1741312800000)
+	value, err := k.encode(&Event{
+		ID: "a", Type: EventVehicleLocation, UserID: 1, VehicleNo: "B1", VehicleColor: 2, Time: at,
+		Data: &LocationData{Time: at, Longitude: 114.5, Speed: 60, Direction: 90},
+	})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if value[0] != 0 || binary.BigEndian.Uint32(value[1:5]) != 7 {
+		t.Fatalf("missing schema registry prefix: %x", value[:5])
+	}
+	r := bytes.NewReader(value[5:])
+	readLong := func() int64 {
+		u, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("read long: %v", err)
+		}
+		return int64(u>>1) ^ -int64(u&1)
+	}
+	readString := func() string {
+		buf := make([]byte, readLong())
+		r.Read(buf)
+		return string(buf)
+	}
+	readDouble := func() float64 {
+		var buf [8]byte
+		r.Read(buf[:])
+		return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+	}
+	if readString() != "a" || readString() != "vehicle_location" || readLong() != 1 || readString() != "B1" || readLong() != 2 || readLong() != at.UnixMilli() {
+		t.Fatalf("unexpected envelope")
+	}
+	if readLong() != 1 || readLong() != at.UnixMilli() || readDouble() != 114.5 || readDouble() != 0 || readDouble() != 60 || readLong() != 90 {
+		t.Fatalf("unexpected location")
+	}
+	readLong()   // altitude
+	readDouble() // mileage
+	readLong()   // alarm
+	readLong()   // state
+	if readLong() != 0 || readLong() != 0 || r.Len() != 0 {
+		t.Fatalf("expected null registration and warn at end")
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// LinkTiming 链路重连与保活参数，字段为 0 时使用默认值。
+// 从链路断开后等待 ReconnectDelay 开始重连，失败后按 RetryBackoff×BackoffFactor^n（不超过 MaxRetryBackoff）加随机抖动等待，
+// 共尝试 ConnectAttempts 次，全部失败后通过主链路发送 0x9007 从链路断开通知。
+type LinkTiming struct {
+	// ConnectAttempts 从链路断开后的重连次数，默认 3
+	ConnectAttempts int `yaml:"connect_attempts"`
+	// ReconnectDelay 从链路断开后首次重连前的等待时长，默认 5s
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+	// RetryBackoff 重连失败后首次重试前的等待时长，默认 10s
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// MaxRetryBackoff 重试等待时长上限，默认 2 分钟
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"`
+	// BackoffFactor 重试等待时长的增长倍数，默认 2，为 1 时固定间隔
+	BackoffFactor float64 `yaml:"backoff_factor"`
+	// Jitter 等待时长的随机抖动比例（0~1），实际等待在 ±Jitter 范围内随机，默认 0.2，<0 表示不抖动
+	Jitter float64 `yaml:"jitter"`
+	// ConnectTimeout 从链路登录应答（0x9002）超时，默认 10s
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// SubHeartbeatInterval 从链路心跳（0x9005）发送间隔，默认 60s
+	SubHeartbeatInterval time.Duration `yaml:"sub_heartbeat_interval"`
+	// SubHeartbeatTimeout 超过该时长未收到心跳应答（0x9006）时判定从链路失效并断开重连，默认 3 分钟，<0 表示不检查
+	SubHeartbeatTimeout time.Duration `yaml:"sub_heartbeat_timeout"`
+	// MainHeartbeatTimeout 超过该时长未收到主链路心跳（0x1005）时断开主链路，<=0 表示不检查（默认）
+	MainHeartbeatTimeout time.Duration `yaml:"main_heartbeat_timeout"`
+	// MainLinkGrace 主链路断开后保留从链路的时长，超时后关闭从链路，默认 5 分钟
+	MainLinkGrace time.Duration `yaml:"main_link_grace"`
+}
+
+// merge 用 o 中的非零字段覆盖 t。
+func (t LinkTiming) merge(o LinkTiming) LinkTiming {
+	if o.ConnectAttempts != 0 {
+		t.ConnectAttempts = o.ConnectAttempts
+	}
+	if o.ReconnectDelay != 0 {
+		t.ReconnectDelay = o.ReconnectDelay
+	}
+	if o.RetryBackoff != 0 {
+		t.RetryBackoff = o.RetryBackoff
+	}
+	if o.MaxRetryBackoff != 0 {
+		t.MaxRetryBackoff = o.MaxRetryBackoff
+	}
+	if o.BackoffFactor != 0 {
+		t.BackoffFactor = o.BackoffFactor
+	}
+	if o.Jitter != 0 {
+		t.Jitter = o.Jitter
+	}
+	if o.ConnectTimeout != 0 {
+		t.ConnectTimeout = o.ConnectTimeout
+	}
+	if o.SubHeartbeatInterval != 0 {
+		t.SubHeartbeatInterval = o.SubHeartbeatInterval
+	}
+	if o.SubHeartbeatTimeout != 0 {
+		t.SubHeartbeatTimeout = o.SubHeartbeatTimeout
+	}
+	if o.MainHeartbeatTimeout != 0 {
+		t.MainHeartbeatTimeout = o.MainHeartbeatTimeout
+	}
+	if o.MainLinkGrace != 0 {
+		t.MainLinkGrace = o.MainLinkGrace
+	}
+	return t
+}
+
+// withDefaults 补齐未配置的字段。
+func (t LinkTiming) withDefaults() LinkTiming {
+	if t.ConnectAttempts <= 0 {
+		t.ConnectAttempts = 3
+	}
+	if t.ReconnectDelay <= 0 {
+		t.ReconnectDelay = 5 * time.Second
+	}
+	if t.RetryBackoff <= 0 {
+		t.RetryBackoff = 10 * time.Second
+	}
+	if t.MaxRetryBackoff <= 0 {
+		t.MaxRetryBackoff = 2 * time.Minute
+	}
+	if t.BackoffFactor <= 0 {
+		t.BackoffFactor = 2
+	}
+	if t.Jitter == 0 {
+		t.Jitter = 0.2
+	}
+	if t.ConnectTimeout <= 0 {
+		t.ConnectTimeout = 10 * time.Second
+	}
+	if t.SubHeartbeatInterval <= 0 {
+		t.SubHeartbeatInterval = 60 * time.Second
+	}
+	if t.SubHeartbeatTimeout == 0 {
+		t.SubHeartbeatTimeout = 3 * time.Minute
+	}
+	if t.MainLinkGrace <= 0 {
+		t.MainLinkGrace = 5 * time.Minute
+	}
+	return t
+}
+
+// validate 校验补齐默认值后的参数。
+func (t LinkTiming) validate() error {
+	if t.BackoffFactor < 1 {
+		return errors.New("link backoff_factor must be at least 1")
+	}
+	if t.Jitter > 1 {
+		return errors.New("link jitter must not exceed 1")
+	}
+	if t.SubHeartbeatTimeout > 0 && t.SubHeartbeatTimeout <= t.SubHeartbeatInterval {
+		return errors.New("link sub_heartbeat_timeout must be greater than sub_heartbeat_interval")
+	}
+	return nil
+}
+
+// retryDelay 返回第 n 次（从 0 开始）重连失败后的等待时长。
+func (t LinkTiming) retryDelay(n int) time.Duration {
+	d := float64(t.RetryBackoff) * math.Pow(t.BackoffFactor, float64(n))
+	if d > float64(t.MaxRetryBackoff) {
+		d = float64(t.MaxRetryBackoff)
+	}
+	return t.jitter(time.Duration(d))
+}
+
+// jitter 在 ±Jitter 范围内随机调整 d，避免大量平台同时重连。
+func (t LinkTiming) jitter(d time.Duration) time.Duration {
+	if t.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + t.Jitter*(2*rand.Float64()-1)))
+}
+
+// linkTiming 返回账号生效的链路参数。
+func (p *runtimePolicy) linkTiming(userID uint32) LinkTiming {
+	return p.timing.merge(p.timingOverrides[userID]).withDefaults()
+}
+
+// linkTiming 返回账号生效的链路参数。
+func (g *JT809Gateway) linkTiming(userID uint32) LinkTiming {
+	return g.currentPolicy().linkTiming(userID)
+}
+
+// LinkState 链路状态。
+type LinkState string
+
+const (
+	LinkStateUp           LinkState = "up"           // 登录成功
+	LinkStateDown         LinkState = "down"         // 连接断开
+	LinkStateReconnecting LinkState = "reconnecting" // 从链路开始重连
+	LinkStateFailed       LinkState = "failed"       // 从链路重连次数用尽，已发送 0x9007
+)
+
+// errSubHeartbeatTimeout 作为从链路 context 的取消原因，标识心跳应答超时。
+var errSubHeartbeatTimeout = errors.New("sub link heartbeat timeout")
+
+// linkStateChanged 触发 OnLinkStateChange，链路建立与断开同时发布 link_up/link_down 事件。
+func (g *JT809Gateway) linkStateChanged(userID uint32, link string, state LinkState, reason string) {
+	meta := newEventMeta(userID, link)
+	g.emit("OnLinkStateChange", userID, "", func(ctx context.Context, h EventHandler) error {
+		return h.OnLinkStateChange(ctx, &LinkStateEvent{EventMeta: meta, State: state, Reason: reason})
+	})
+	switch state {
+	case LinkStateUp:
+		g.publish(EventLinkUp, userID, "", 0, LinkEventData{Link: link})
+		if g.outbound != nil {
+			go g.flushOutbound(userID)
+		}
+	case LinkStateDown:
+		g.publish(EventLinkDown, userID, "", 0, LinkEventData{Link: link, Reason: reason})
+	}
+}
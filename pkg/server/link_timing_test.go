@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkTimingOverrides(t *testing.T) {
+	cfg := Config{
+		MainListen: ":10709",
+		LinkTiming: LinkTiming{ConnectAttempts: 5, Jitter: -1},
+		LinkTimingOverrides: map[uint32]LinkTiming{
+			2: {MainHeartbeatTimeout: 3 * time.Minute, MainLinkGrace: time.Minute},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	p := newRuntimePolicy(cfg)
+
+	global := p.linkTiming(1)
+	if global.ConnectAttempts != 5 || global.SubHeartbeatInterval != 60*time.Second || global.MainHeartbeatTimeout != 0 || global.MainLinkGrace != 5*time.Minute {
+		t.Fatalf("unexpected global timing %+v", global)
+	}
+	override := p.linkTiming(2)
+	if override.ConnectAttempts != 5 || override.MainHeartbeatTimeout != 3*time.Minute || override.MainLinkGrace != time.Minute {
+		t.Fatalf("unexpected override timing %+v", override)
+	}
+
+	for name, lt := range map[string]LinkTiming{
+		"factor":  {BackoffFactor: 0.5},
+		"jitter":  {Jitter: 1.5},
+		"timeout": {SubHeartbeatInterval: time.Minute, SubHeartbeatTimeout: 30 * time.Second},
+	} {
+		cfg.LinkTimingOverrides[3] = lt
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: expected invalid override rejected", name)
+		}
+	}
+}
+
+func TestLinkTimingRetryDelay(t *testing.T) {
+	lt := LinkTiming{RetryBackoff: time.Second, MaxRetryBackoff: 5 * time.Second, Jitter: -1}.withDefaults()
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := lt.retryDelay(n); got != want {
+			t.Fatalf("retry %d: expected %v, got %v", n, want, got)
+		}
+	}
+
+	lt.Jitter = 0.2
+	for range 100 {
+		if d := lt.retryDelay(0); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jittered delay %v out of range", d)
+		}
+	}
+}
+
+func TestLinkStateChanged(t *testing.T) {
+	type change struct {
+		link   string
+		state  LinkState
+		reason string
+	}
+	changes := make(chan change, 4)
+	g := &JT809Gateway{
+		store:   NewPlatformStore(),
+		metrics: newGatewayMetrics(),
+		eventHandler: CallbacksHandler(&Callbacks{
+			OnLinkStateChange: func(_ uint32, link string, state LinkState, reason string) {
+				changes <- change{link, state, reason}
+			},
+		}),
+	}
+	g.linkStateChanged(1, "sub", LinkStateDown, errSubHeartbeatTimeout.Error())
+	select {
+	case got := <-changes:
+		if got != (change{"sub", LinkStateDown, "sub link heartbeat timeout"}) {
+			t.Fatalf("unexpected change %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected link state callback")
+	}
+}
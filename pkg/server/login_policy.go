@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	goserver "github.com/zboyco/go-server"
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// DuplicateLoginPolicy 同一账号在新连接上重复登录（0x1001）时的处理方式。
+type DuplicateLoginPolicy string
+
+const (
+	DuplicateLoginKick   DuplicateLoginPolicy = "kick"   // 断开旧连接及其从链路，由新连接接替（默认）
+	DuplicateLoginReject DuplicateLoginPolicy = "reject" // 拒绝新连接，应答 LoginResourceBusy
+	DuplicateLoginAllow  DuplicateLoginPolicy = "allow"  // 新旧连接并存，新连接作为主链路，断开后由旧连接接替
+)
+
+func (p DuplicateLoginPolicy) validate() error {
+	switch p {
+	case "", DuplicateLoginKick, DuplicateLoginReject, DuplicateLoginAllow:
+		return nil
+	}
+	return fmt.Errorf("duplicate_login must be kick, reject or allow, got %q", p)
+}
+
+// 登出原因，见 Callbacks.OnLogout。
+const (
+	LogoutRequested = "logout" // 下级平台发送主链路注销请求（0x1003）
+	LogoutKicked    = "kicked" // 同一账号在新连接上重复登录，旧连接被断开
+)
+
+// admitLogin 按账号的重复登录策略处理已有主链路，返回是否接受新连接。
+func (g *JT809Gateway) admitLogin(session *goserver.AppSession, acc Account) bool {
+	oldSessionID, ok := g.store.GetMainSession(acc.UserID)
+	if !ok || oldSessionID == session.ID {
+		return true
+	}
+	switch acc.DuplicateLogin {
+	case DuplicateLoginReject:
+		slog.Warn("duplicate login rejected", "user_id", acc.UserID, "session", session.ID, "active_session", oldSessionID)
+		return false
+	case DuplicateLoginAllow:
+		slog.Info("duplicate login allowed", "user_id", acc.UserID, "session", session.ID, "active_session", oldSessionID)
+		return true
+	default:
+		slog.Warn("duplicate login, closing previous session", "user_id", acc.UserID, "session", session.ID, "previous_session", oldSessionID)
+		g.kickSession(acc.UserID, oldSessionID)
+		return true
+	}
+}
+
+// kickSession 注销并断开被顶替的旧连接与从链路，新连接登录成功后重新建立从链路。
+func (g *JT809Gateway) kickSession(userID uint32, sessionID string) {
+	g.store.RemoveSession(sessionID)
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+	g.teardownSubLink(ctx, userID)
+	cancel()
+	if g.mainSrv != nil {
+		if session, err := g.mainSrv.GetSessionByID(sessionID); err == nil {
+			session.Close("duplicate login")
+		}
+	}
+	g.loggedOut(userID, LogoutKicked)
+}
+
+// dropSubLink 立即断开从链路并清理状态，避免新连接的从链路建立被旧连接阻塞。注销流程见 teardownSubLink。
+func (g *JT809Gateway) dropSubLink(userID uint32) {
+	_, subClient, cancel, _ := g.store.PlatformLinks(userID)
+	if cancel != nil {
+		cancel()
+	}
+	if subClient != nil {
+		g.store.ClearSubConn(userID, subClient)
+	}
+}
+
+// handleLogout 处理主链路注销请求（0x1003）：在当前连接应答 0x1004 后断开连接，
+// 该连接为账号唯一的主链路时同时注销并断开从链路，不再重连。
+func (g *JT809Gateway) handleLogout(session *goserver.AppSession, frame *jtt809.Frame) {
+	userID, ok := g.sessionUser(session)
+	if !ok {
+		return
+	}
+	if err := g.replyOnSession(session, frame.Header, jtt809.LogoutResponse{}); err != nil {
+		slog.Warn("send logout response failed", "user_id", userID, "err", err)
+	}
+
+	slog.Info("main link logout", "user_id", userID, "session", session.ID)
+	if g.store.RemoveSession(session.ID) {
+		ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+		g.teardownSubLink(ctx, userID)
+		cancel()
+		g.linkStateChanged(userID, "main", LinkStateDown, LogoutRequested)
+	}
+	g.loggedOut(userID, LogoutRequested)
+	session.Close(LogoutRequested)
+}
+
+// loggedOut 触发 OnLogout 并发布 logout 事件。
+func (g *JT809Gateway) loggedOut(userID uint32, reason string) {
+	meta := newEventMeta(userID, "")
+	g.emit("OnLogout", userID, "", func(ctx context.Context, h EventHandler) error {
+		return h.OnLogout(ctx, &LogoutEvent{EventMeta: meta, Reason: reason})
+	})
+	g.publish(EventLogout, userID, "", 0, LogoutEventData{Reason: reason})
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// testPlatform 模拟下级平台主链路客户端。
+type testPlatform struct {
+	conn   net.Conn
+	frames chan *jtt809.Frame
+}
+
+func dialPlatform(t *testing.T, addr string) *testPlatform {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := &testPlatform{conn: conn, frames: make(chan *jtt809.Frame, 16)}
+	go func() {
+		defer close(p.frames)
+		scanner := bufio.NewScanner(conn)
+		scanner.Split(splitJT809Frames)
+		for scanner.Scan() {
+			if frame, err := jtt809.DecodeFrame(scanner.Bytes()); err == nil {
+				p.frames <- frame
+			}
+		}
+	}()
+	return p
+}
+
+func (p *testPlatform) send(t *testing.T, body jtt809.Body) {
+	t.Helper()
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: jtt809.Header{GNSSCenterID: 1}, Body: body})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := p.conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// expect 等待指定业务 ID 的报文，忽略其他报文。
+func (p *testPlatform) expect(t *testing.T, msgID uint16) *jtt809.Frame {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case frame, ok := <-p.frames:
+			if !ok {
+				t.Fatalf("connection closed while waiting for 0x%04X", msgID)
+			}
+			if frame.BodyID == msgID {
+				return frame
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for 0x%04X", msgID)
+		}
+	}
+}
+
+func (p *testPlatform) login(t *testing.T) jtt809.LoginResult {
+	t.Helper()
+	return p.loginWithDownLink(t, 1)
+}
+
+// loginWithDownLink 登录并指定从链路端口。
+func (p *testPlatform) loginWithDownLink(t *testing.T, port uint16) jtt809.LoginResult {
+	t.Helper()
+	p.send(t, jtt809.LoginRequest{UserID: 1, Password: "pass", GnssCenterID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port})
+	return jtt809.LoginResult(p.expect(t, jtt809.UP_CONNECT_RSP).RawBody[0])
+}
+
+func (p *testPlatform) expectClosed(t *testing.T) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-p.frames:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected connection closed")
+		}
+	}
+}
+
+func startLoginTestGateway(t *testing.T, policy DuplicateLoginPolicy) (*JT809Gateway, string, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	g, err := NewJT809Gateway(Config{
+		MainListen:      addr,
+		ShutdownTimeout: 100 * time.Millisecond,
+		Accounts:        []Account{{UserID: 1, Password: "pass", GnssCenterID: 1, DuplicateLogin: policy}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new gateway: %v", err)
+	}
+	logouts := make(chan string, 4)
+	g.SetCallbacks(&Callbacks{OnLogout: func(_ uint32, reason string) { logouts <- reason }})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go g.Start(ctx)
+	waitFor(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+	return g, addr, logouts
+}
+
+func expectLogout(t *testing.T, logouts chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-logouts:
+		if got != want {
+			t.Fatalf("expected logout reason %q, got %q", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected OnLogout(%q)", want)
+	}
+}
+
+func TestDuplicateLoginPolicies(t *testing.T) {
+	for _, policy := range []DuplicateLoginPolicy{DuplicateLoginKick, DuplicateLoginReject, DuplicateLoginAllow} {
+		t.Run(string(policy), func(t *testing.T) {
+			g, addr, logouts := startLoginTestGateway(t, policy)
+			first := dialPlatform(t, addr)
+			if r := first.login(t); r != jtt809.LoginOK {
+				t.Fatalf("first login: %d", r)
+			}
+			firstSession, _ := g.store.GetMainSession(1)
+
+			second := dialPlatform(t, addr)
+			result := second.login(t)
+			mainSession, _ := g.store.GetMainSession(1)
+			switch policy {
+			case DuplicateLoginKick:
+				if result != jtt809.LoginOK || mainSession == firstSession {
+					t.Fatalf("expected new session to take over, result=%d session=%s", result, mainSession)
+				}
+				first.expectClosed(t)
+				expectLogout(t, logouts, LogoutKicked)
+			case DuplicateLoginReject:
+				if result != jtt809.LoginResourceBusy || mainSession != firstSession {
+					t.Fatalf("expected new session rejected, result=%d session=%s", result, mainSession)
+				}
+				second.expectClosed(t)
+			case DuplicateLoginAllow:
+				if result != jtt809.LoginOK || mainSession == firstSession {
+					t.Fatalf("expected both sessions accepted, result=%d session=%s", result, mainSession)
+				}
+				// 新连接断开后由旧连接接替主链路
+				second.conn.Close()
+				waitFor(t, func() bool {
+					sid, _ := g.store.GetMainSession(1)
+					return sid == firstSession
+				})
+			}
+		})
+	}
+}
+
+func TestLogoutTearsDownLinks(t *testing.T) {
+	g, addr, logouts := startLoginTestGateway(t, "")
+	port, received := fakeSubServer(t, true)
+	p := dialPlatform(t, addr)
+	if r := p.loginWithDownLink(t, port); r != jtt809.LoginOK {
+		t.Fatalf("login: %d", r)
+	}
+	waitFor(t, func() bool {
+		_, sub := g.store.GetLinkStatus(1)
+		return sub
+	})
+	// 等待应答的请求在注销后立即结束
+	aborted := make(chan error, 1)
+	go func() {
+		_, err := g.RequestMonitorStartupAndWait(context.Background(), MonitorRequest{UserID: 1, VehicleNo: "粤A12345"})
+		aborted <- err
+	}()
+	waitFor(t, func() bool { return g.PendingRequests()[1] == 1 })
+
+	p.send(t, jtt809.LogoutRequest{UserID: 1, Password: "pass"})
+	p.expect(t, jtt809.UP_DISCONNECT_RSP)
+	p.expectClosed(t)
+	expectLogout(t, logouts, LogoutRequested)
+	if main, sub := g.store.GetLinkStatus(1); main || sub {
+		t.Fatalf("expected links down after logout, main=%v sub=%v", main, sub)
+	}
+	sawLogout := false
+	for len(received) > 0 {
+		if <-received == jtt809.DOWN_DISCONNECT_REQ {
+			sawLogout = true
+		}
+	}
+	if !sawLogout {
+		t.Fatal("expected 0x9003 sent on sub link")
+	}
+	select {
+	case err := <-aborted:
+		if !errors.Is(err, ErrRequestAborted) {
+			t.Fatalf("expected ErrRequestAborted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not aborted")
+	}
+}
+
+func TestDuplicateLoginPolicyValidate(t *testing.T) {
+	cfg := Config{MainListen: ":1", Accounts: []Account{{UserID: 1, DuplicateLogin: "replace"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected invalid policy %q rejected", cfg.Accounts[0].DuplicateLogin)
+	}
+}
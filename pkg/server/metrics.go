@@ -0,0 +1,401 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// defaultLatencyBuckets 回调耗时直方图分桶（秒）。
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// counterVec 为按标签分组的累加计数器。
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterEntry
+}
+
+type counterEntry struct {
+	labels []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*counterEntry)}
+}
+
+// inc 对指定标签值加一，标签值顺序与定义一致。
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+func (c *counterVec) add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.values[key]
+	if !ok {
+		e = &counterEntry{labels: append([]string(nil), values...)}
+		c.values[key] = e
+	}
+	e.value += delta
+}
+
+// get 返回指定标签值的当前计数，主要用于测试。
+func (c *counterVec) get(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.values[strings.Join(values, "\xff")]; ok {
+		return e.value
+	}
+	return 0
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		e := c.values[key]
+		writeSample(w, c.name, c.labels, e.labels, "", "", e.value)
+	}
+}
+
+// histogramVec 为按标签分组的直方图。
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramEntry
+}
+
+type histogramEntry struct {
+	labels []string
+	counts []uint64 // 与 buckets 一一对应，非累积
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramEntry)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.values[key]
+	if !ok {
+		e = &histogramEntry{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = e
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			e.counts[i]++
+			break
+		}
+	}
+	e.count++
+	e.sum += v
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		e := h.values[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += e.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, e.labels, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, e.labels, "le", "+Inf", float64(e.count))
+		writeSample(w, h.name+"_sum", h.labels, e.labels, "", "", e.sum)
+		writeSample(w, h.name+"_count", h.labels, e.labels, "", "", float64(e.count))
+	}
+}
+
+// gatewayMetrics 汇总网关运行指标，以 Prometheus 文本格式输出，不依赖客户端库。
+// 计数类指标在处理路径上累加；链路、车辆与视频等状态类指标在抓取时从当前状态计算。
+type gatewayMetrics struct {
+	loginAttempts     *counterVec
+	framesIn          *counterVec
+	framesOut         *counterVec
+	decodeErrors      *counterVec
+	subLinkConnects   *counterVec
+	sendFailures      *counterVec
+	callbackDuration  *histogramVec
+	callbackQueueWait *histogramVec
+	callbacksDropped  *counterVec
+	callbackPanics    *counterVec
+	callbackErrors    *counterVec
+	callbackRetries   *counterVec
+	relayForwarded    *counterVec
+	relayDropped      *counterVec
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	return &gatewayMetrics{
+		loginAttempts:     newCounterVec("jtt809_login_attempts_total", "Main link login attempts by result.", "result"),
+		framesIn:          newCounterVec("jtt809_frames_received_total", "Frames received by link, body id and sub business id.", "link", "body_id", "sub_id"),
+		framesOut:         newCounterVec("jtt809_frames_sent_total", "Frames sent by link, body id and sub business id.", "link", "body_id", "sub_id"),
+		decodeErrors:      newCounterVec("jtt809_decode_errors_total", "Frames that failed to decode by link and reason.", "link", "reason"),
+		subLinkConnects:   newCounterVec("jtt809_sub_link_connect_attempts_total", "Sub link connect attempts by mode and result.", "mode", "result"),
+		sendFailures:      newCounterVec("jtt809_send_failures_total", "SendToSubordinate failures by link and link policy.", "link", "policy"),
+		callbackDuration:  newHistogramVec("jtt809_callback_duration_seconds", "Callback execution time.", defaultLatencyBuckets, "callback"),
+		callbackQueueWait: newHistogramVec("jtt809_callback_queue_wait_seconds", "Time callbacks spent queued before execution.", defaultLatencyBuckets, "callback"),
+		callbacksDropped:  newCounterVec("jtt809_callbacks_dropped_total", "Callbacks dropped because the queue was full, by callback and policy.", "callback", "policy"),
+		callbackPanics:    newCounterVec("jtt809_callback_panics_total", "Callbacks that panicked.", "callback"),
+		callbackErrors:    newCounterVec("jtt809_callback_errors_total", "Event handler calls that returned an error, including retries.", "callback"),
+		callbackRetries:   newCounterVec("jtt809_callback_retries_total", "Event handler retries after an error.", "callback"),
+		relayForwarded:    newCounterVec("jtt809_relay_forwarded_total", "Messages relayed to or from the upper platform by direction and sub business id.", "direction", "sub_id"),
+		relayDropped:      newCounterVec("jtt809_relay_dropped_total", "Messages not relayed by direction and reason.", "direction", "reason"),
+	}
+}
+
+// frameReceived 记录一帧接收报文。
+func (m *gatewayMetrics) frameReceived(link string, frame *jtt809.Frame) {
+	m.framesIn.inc(link, formatMsgID(frame.BodyID), formatMsgID(frameSubBusinessID(frame.BodyID, frame.RawBody)))
+}
+
+// frameSent 记录一帧发送报文。
+func (m *gatewayMetrics) frameSent(link string, msgID, subID uint16) {
+	m.framesOut.inc(link, formatMsgID(msgID), formatMsgID(subID))
+}
+
+// decodeFailed 记录解码失败，CRC 校验失败单独归类。
+func (m *gatewayMetrics) decodeFailed(link string, err error) {
+	reason := "malformed"
+	if errors.Is(err, jtt809.ErrCRCMismatch) {
+		reason = "crc"
+	}
+	m.decodeErrors.inc(link, reason)
+}
+
+// frameSubBusinessID 按通用子业务封装格式（车牌21 + 颜色1 + 子业务ID2）提取子业务类型，
+// 0x1400、0x1700、0x9300、0x9400 的子业务类型位于业务体开头；链路管理类报文没有子业务，返回 0。
+func frameSubBusinessID(bodyID uint16, body []byte) uint16 {
+	if bodyID&0x00FF != 0 {
+		return 0
+	}
+	switch bodyID {
+	case jtt809.UP_WARN_MSG, jtt809.UP_AUTHORIZE_MSG, jtt809.DOWN_PLATFORM_MSG, jtt809.DOWN_WARN_MSG:
+		if len(body) < 2 {
+			return 0
+		}
+		return binary.BigEndian.Uint16(body[0:2])
+	}
+	if len(body) < 24 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(body[22:24])
+}
+
+// encodedSubBusinessID 获取待发送报文的子业务类型，data 为 body 编码后的完整报文，直接从中解析以免再次编码业务体。
+func encodedSubBusinessID(body jtt809.Body, data []byte) uint16 {
+	if provider, ok := body.(interface{ SubBusinessType() uint16 }); ok {
+		return provider.SubBusinessType()
+	}
+	if body.MsgID()&0x00FF != 0 {
+		return 0
+	}
+	frame, err := jtt809.DecodeFrame(data)
+	if err != nil {
+		return 0
+	}
+	return frameSubBusinessID(frame.BodyID, frame.RawBody)
+}
+
+func formatMsgID(id uint16) string {
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// loginResultLabel 将登录结果转换为指标标签。
+func loginResultLabel(r jtt809.LoginResult) string {
+	switch r {
+	case jtt809.LoginOK:
+		return "ok"
+	case jtt809.LoginIPError:
+		return "ip_error"
+	case jtt809.LoginGnssCenterIDError:
+		return "gnss_center_id_error"
+	case jtt809.LoginUnregistered:
+		return "unregistered"
+	case jtt809.LoginPasswordError:
+		return "password_error"
+	case jtt809.LoginResourceBusy:
+		return "resource_busy"
+	default:
+		return "other_error"
+	}
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出全部指标。
+func (g *JT809Gateway) WriteMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	m := g.metrics
+	now := time.Now()
+
+	snaps := g.store.Snapshots()
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].UserID < snaps[j].UserID })
+	connected := 0
+	for _, snap := range snaps {
+		if snap.MainSessionID != "" {
+			connected++
+		}
+	}
+	writeHeader(w, "jtt809_platforms_connected", "Platforms with an active main link.", "gauge")
+	writeSample(w, "jtt809_platforms_connected", nil, nil, "", "", float64(connected))
+
+	writeHeader(w, "jtt809_link_up", "Link state per platform (1 = connected).", "gauge")
+	for _, snap := range snaps {
+		uid := strconv.FormatUint(uint64(snap.UserID), 10)
+		writeSample(w, "jtt809_link_up", []string{"user_id", "link"}, []string{uid, "main"}, "", "", boolFloat(snap.MainSessionID != ""))
+		writeSample(w, "jtt809_link_up", []string{"user_id", "link"}, []string{uid, "sub"}, "", "", boolFloat(snap.SubConnected))
+	}
+
+	writeHeader(w, "jtt809_vehicles", "Known vehicles per platform.", "gauge")
+	for _, snap := range snaps {
+		writeSample(w, "jtt809_vehicles", []string{"user_id"}, []string{strconv.FormatUint(uint64(snap.UserID), 10)}, "", "", float64(len(snap.Vehicles)))
+	}
+	writeHeader(w, "jtt809_vehicles_online", "Vehicles whose last position is within the offline threshold per platform.", "gauge")
+	for _, snap := range snaps {
+		online := 0
+		for _, v := range snap.Vehicles {
+			if g.vehicleOnline(snap.UserID, v, now) {
+				online++
+			}
+		}
+		writeSample(w, "jtt809_vehicles_online", []string{"user_id"}, []string{strconv.FormatUint(uint64(snap.UserID), 10)}, "", "", float64(online))
+	}
+
+	pending := g.PendingRequests()
+	writeHeader(w, "jtt809_pending_requests", "Requests awaiting a reply per platform.", "gauge")
+	for _, snap := range snaps {
+		writeSample(w, "jtt809_pending_requests", []string{"user_id"}, []string{strconv.FormatUint(uint64(snap.UserID), 10)}, "", "", float64(pending[snap.UserID]))
+	}
+
+	queued := g.QueuedOutbound()
+	writeHeader(w, "jtt809_outbound_queued", "Downlink messages queued while no link is available per platform.", "gauge")
+	for _, snap := range snaps {
+		writeSample(w, "jtt809_outbound_queued", []string{"user_id"}, []string{strconv.FormatUint(uint64(snap.UserID), 10)}, "", "", float64(queued[snap.UserID]))
+	}
+
+	writeHeader(w, "jtt809_callback_queue_depth", "Callbacks waiting in the dispatcher queues.", "gauge")
+	writeSample(w, "jtt809_callback_queue_depth", nil, nil, "", "", float64(g.callbackDispatcher().depth()))
+
+	m.loginAttempts.write(w)
+	m.framesIn.write(w)
+	m.framesOut.write(w)
+	m.decodeErrors.write(w)
+	m.subLinkConnects.write(w)
+	m.sendFailures.write(w)
+	m.callbackDuration.write(w)
+	m.callbackQueueWait.write(w)
+	m.callbacksDropped.write(w)
+	m.callbackPanics.write(w)
+	m.callbackErrors.write(w)
+	m.callbackRetries.write(w)
+
+	if st, ok := g.RelayStatus(); ok {
+		writeHeader(w, "jtt809_relay_link_up", "Upper platform link state (1 = connected).", "gauge")
+		writeSample(w, "jtt809_relay_link_up", []string{"link"}, []string{"main"}, "", "", boolFloat(st.MainConnected))
+		writeSample(w, "jtt809_relay_link_up", []string{"link"}, []string{"sub"}, "", "", boolFloat(st.SubConnected))
+		m.relayForwarded.write(w)
+		m.relayDropped.write(w)
+	}
+
+	if g.rtpSrv != nil {
+		st := g.rtpSrv.Stats()
+		writeHeader(w, "jtt1078_streams", "Active upstream video streams.", "gauge")
+		writeSample(w, "jtt1078_streams", nil, nil, "", "", float64(st.Streams))
+		writeHeader(w, "jtt1078_viewers", "Connected video viewers.", "gauge")
+		writeSample(w, "jtt1078_viewers", nil, nil, "", "", float64(st.Viewers))
+		writeHeader(w, "jtt1078_received_bytes_total", "Bytes pulled from upstream video sources.", "counter")
+		writeSample(w, "jtt1078_received_bytes_total", nil, nil, "", "", float64(st.BytesReceived))
+		writeHeader(w, "jtt1078_sent_bytes_total", "Bytes written to video viewers.", "counter")
+		writeSample(w, "jtt1078_sent_bytes_total", nil, nil, "", "", float64(st.BytesSent))
+	}
+	return w.Flush()
+}
+
+func (g *JT809Gateway) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = g.WriteMetrics(w)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeSample 输出一行样本，extraName 非空时追加一个额外标签（如直方图的 le）。
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, labelEscaper.Replace(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	goserver "github.com/zboyco/go-server"
+	"github.com/zboyco/jtt809/pkg/jtt1078"
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestGatewayMetrics(t *testing.T) {
+	g := &JT809Gateway{
+		store:   NewPlatformStore(),
+		mainSrv: goserver.NewTCP("127.0.0.1", 0),
+		rtpSrv:  jtt1078.NewVideoServer(""),
+		metrics: newGatewayMetrics(),
+	}
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1001}, 1, 0)
+
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: jtt809.Header{GNSSCenterID: 1}, Body: jtt809.HeartbeatResponse{}})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	g.handleSubMessage(1001, data)
+
+	// 篡改消息头中的一个字节使 CRC 校验失败
+	corrupted := append([]byte(nil), data...)
+	corrupted[10] ^= 0x01
+	if b := corrupted[10]; b == 0x5a || b == 0x5b || b == 0x5d || b == 0x5e {
+		corrupted[10] ^= 0x03
+	}
+	g.handleSubMessage(1001, corrupted)
+
+	// 主链路会话未注册到服务，发送失败
+	if err := g.SendToSubordinate(1001, jtt809.Header{GNSSCenterID: 1}, jtt809.HeartbeatResponse{}); err == nil {
+		t.Fatalf("expected send failure")
+	}
+	if v := g.metrics.sendFailures.get("main", "both"); v != 1 {
+		t.Fatalf("unexpected send failures: %v", v)
+	}
+	g.metrics.loginAttempts.inc(loginResultLabel(jtt809.LoginPasswordError))
+	g.metrics.callbackDuration.observe(0.02, "OnLogin")
+
+	rec := httptest.NewRecorder()
+	g.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"jtt809_platforms_connected 1\n",
+		`jtt809_link_up{user_id="1001",link="main"} 1`,
+		`jtt809_link_up{user_id="1001",link="sub"} 0`,
+		`jtt809_frames_received_total{link="sub",body_id="0x1006",sub_id="0x0000"} 1`,
+		`jtt809_decode_errors_total{link="sub",reason="crc"} 1`,
+		`jtt809_login_attempts_total{result="password_error"} 1`,
+		`jtt809_callback_duration_seconds_bucket{callback="OnLogin",le="0.01"} 0`,
+		`jtt809_callback_duration_seconds_bucket{callback="OnLogin",le="0.05"} 1`,
+		`jtt809_callback_duration_seconds_bucket{callback="OnLogin",le="+Inf"} 1`,
+		"jtt1078_streams 0\n",
+		"# TYPE jtt809_send_failures_total counter\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// countingBody 记录业务体被编码的次数。
+type countingBody struct {
+	rawBody
+	calls *int
+}
+
+func (b countingBody) Encode() ([]byte, error) {
+	*b.calls++
+	return b.rawBody.Encode()
+}
+
+func TestEncodedSubBusinessIDSkipsReencode(t *testing.T) {
+	payload, err := buildSubBusinessBody("粤B12345", jtt809.PlateColorBlue, jtt809.DOWN_EXG_MSG_RETURN_STARTUP, nil)
+	if err != nil {
+		t.Fatalf("build body: %v", err)
+	}
+	calls := 0
+	body := countingBody{rawBody: rawBody{msgID: jtt809.DOWN_EXG_MSG, payload: payload}, calls: &calls}
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: jtt809.Header{GNSSCenterID: 1}, Body: body})
+	if err != nil {
+		t.Fatalf("encode package: %v", err)
+	}
+	if sub := encodedSubBusinessID(body, data); sub != jtt809.DOWN_EXG_MSG_RETURN_STARTUP {
+		t.Fatalf("expected sub id 0x%04X, got 0x%04X", jtt809.DOWN_EXG_MSG_RETURN_STARTUP, sub)
+	}
+	if calls != 1 {
+		t.Fatalf("expected body encoded once, got %d", calls)
+	}
+}
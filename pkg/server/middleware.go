@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// 报文方向
+const (
+	FrameRecv = "recv"
+	FrameSend = "send"
+)
+
+// FrameContext 为经过中间件链的一帧报文。
+type FrameContext struct {
+	Direction string // recv 或 send
+	Link      string // main 或 sub；级联上级平台链路为 up_main 或 up_sub
+	UserID    uint32 // 主链路登录成功前为 0
+	SessionID string // 主链路连接 ID，从链路为空
+	// Data 完整报文（含首尾标识与转义），中间件替换后需同步更新 Frame
+	Data []byte
+	// Frame 解码后的报文，接收报文解码失败时为 nil。
+	// 接收方向的后续处理以 Frame 为准，发送方向实际写出 Data。
+	Frame *jtt809.Frame
+	// DecodeErr 接收报文的解码错误
+	DecodeErr error
+}
+
+// FrameHandler 处理一帧报文。
+type FrameHandler func(fc *FrameContext) error
+
+// FrameMiddleware 包装 FrameHandler。中间件可读取或修改报文，不调用 next 即丢弃该帧；
+// 接收方向返回的错误记录后丢弃该帧，发送方向返回的错误作为发送失败处理（按链路策略降级或暂存）。
+type FrameMiddleware func(next FrameHandler) FrameHandler
+
+// Use 追加报文中间件，按注册顺序执行，作用于主从链路收发的全部报文（含登录、心跳）。
+// 内置的指标统计与报文转储位于靠近连接的一端：接收方向先于、发送方向后于应用注册的中间件执行。
+func (g *JT809Gateway) Use(mw ...FrameMiddleware) {
+	g.frameMu.Lock()
+	defer g.frameMu.Unlock()
+	g.middlewares = append(slices.Clip(g.middlewares), mw...)
+}
+
+// runFrameChain 依次执行中间件链，最后调用 terminal。
+func (g *JT809Gateway) runFrameChain(fc *FrameContext, terminal FrameHandler) error {
+	g.frameMu.RLock()
+	user := g.middlewares
+	g.frameMu.RUnlock()
+
+	builtin := []FrameMiddleware{g.metricsMiddleware, g.packetLogMiddleware}
+	chain := make([]FrameMiddleware, 0, len(builtin)+len(user))
+	if fc.Direction == FrameRecv {
+		chain = append(append(chain, builtin...), user...)
+	} else {
+		slices.Reverse(builtin)
+		chain = append(append(chain, user...), builtin...)
+	}
+	h := terminal
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h(fc)
+}
+
+// receiveFrame 解码接收的报文并经中间件链交给 handle，中间件丢弃或出错时不调用 handle。
+func (g *JT809Gateway) receiveFrame(link string, userID uint32, sessionID string, data []byte, handle func(fc *FrameContext)) {
+	fc := &FrameContext{Direction: FrameRecv, Link: link, UserID: userID, SessionID: sessionID, Data: data}
+	fc.Frame, fc.DecodeErr = jtt809.DecodeFrame(data)
+	err := g.runFrameChain(fc, func(fc *FrameContext) error {
+		if fc.Frame == nil {
+			slog.Warn("decode frame failed", "link", link, "user_id", userID, "session", sessionID, "err", fc.DecodeErr)
+			return nil
+		}
+		handle(fc)
+		return nil
+	})
+	if err != nil {
+		slog.Warn("frame rejected by middleware", "link", link, "user_id", userID, "session", sessionID, "err", err)
+	}
+}
+
+// sendFrame 经中间件链发送已编码的报文，write 写出最终的 Data。
+func (g *JT809Gateway) sendFrame(link string, userID uint32, sessionID string, data []byte, write func([]byte) error) error {
+	fc := &FrameContext{Direction: FrameSend, Link: link, UserID: userID, SessionID: sessionID, Data: data}
+	fc.Frame, _ = jtt809.DecodeFrame(data)
+	return g.runFrameChain(fc, func(fc *FrameContext) error { return write(fc.Data) })
+}
+
+// metricsMiddleware 统计收发报文数与解码失败数，发送方向仅统计写出成功的报文。
+func (g *JT809Gateway) metricsMiddleware(next FrameHandler) FrameHandler {
+	return func(fc *FrameContext) error {
+		if fc.Direction == FrameRecv {
+			if fc.Frame != nil {
+				g.metrics.frameReceived(fc.Link, fc.Frame)
+			} else if fc.DecodeErr != nil {
+				g.metrics.decodeFailed(fc.Link, fc.DecodeErr)
+			}
+			return next(fc)
+		}
+		err := next(fc)
+		if err == nil && fc.Frame != nil {
+			g.metrics.frameSent(fc.Link, fc.Frame.BodyID, frameSubBusinessID(fc.Frame.BodyID, fc.Frame.RawBody))
+		}
+		return err
+	}
+}
+
+// PacketLogConfig 报文十六进制转储参数，支持热更新。
+type PacketLogConfig struct {
+	// Level 转储日志级别：info（默认）、debug 或 off
+	Level string `yaml:"level"`
+	// SampleRate 转储采样比例（0~1），默认 1 全部转储
+	SampleRate float64 `yaml:"sample_rate"`
+	// DebugUsers 始终以 Info 级别完整转储的平台，不受 Level 与 SampleRate 限制
+	DebugUsers []uint32 `yaml:"debug_users"`
+}
+
+func (c PacketLogConfig) withDefaults() PacketLogConfig {
+	if c.Level == "" {
+		c.Level = "info"
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = 1
+	}
+	return c
+}
+
+func (c PacketLogConfig) validate() error {
+	switch c.Level {
+	case "info", "debug", "off":
+	default:
+		return errors.New("packet_log: level must be info, debug or off")
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return errors.New("packet_log: sample_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// SetPacketDebug 开启或关闭平台的报文调试转储，开启后该平台的报文不受转储级别与采样限制。
+// 与配置中的 DebugUsers 叠加，重启后失效。
+func (g *JT809Gateway) SetPacketDebug(userID uint32, enabled bool) {
+	if enabled {
+		g.packetDebug.Store(userID, struct{}{})
+		return
+	}
+	g.packetDebug.Delete(userID)
+}
+
+// PacketDebug 返回平台是否开启报文调试转储（含配置中的 DebugUsers）。
+func (g *JT809Gateway) PacketDebug(userID uint32) bool {
+	if _, ok := g.packetDebug.Load(userID); ok {
+		return true
+	}
+	return slices.Contains(g.currentPolicy().packetLog.DebugUsers, userID)
+}
+
+// packetLogMiddleware 按 PacketLog 配置转储报文。
+func (g *JT809Gateway) packetLogMiddleware(next FrameHandler) FrameHandler {
+	return func(fc *FrameContext) error {
+		level, ok := g.packetLogLevel(fc.UserID)
+		if ok && slog.Default().Enabled(context.Background(), level) {
+			session := fc.SessionID
+			if session == "" {
+				session = strconv.FormatUint(uint64(fc.UserID), 10)
+			}
+			slog.Log(context.Background(), level, "packet dump", "link", fc.Link, "dir", fc.Direction, "session", session, "hex", fmt.Sprintf("%X", fc.Data))
+		}
+		return next(fc)
+	}
+}
+
+// packetLogLevel 返回报文的转储级别，不转储时返回 false。
+func (g *JT809Gateway) packetLogLevel(userID uint32) (slog.Level, bool) {
+	if userID != 0 && g.PacketDebug(userID) {
+		return slog.LevelInfo, true
+	}
+	cfg := g.currentPolicy().packetLog
+	if cfg.Level == "off" || (cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate) {
+		return 0, false
+	}
+	if cfg.Level == "debug" {
+		return slog.LevelDebug, true
+	}
+	return slog.LevelInfo, true
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestFrameMiddleware(t *testing.T) {
+	port, received := fakeSubServer(t, true)
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics()}
+	g.closing.Store(true)
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+
+	var seen []string
+	errRejected := errors.New("rejected")
+	g.Use(func(next FrameHandler) FrameHandler {
+		return func(fc *FrameContext) error {
+			if fc.Frame == nil {
+				seen = append(seen, fc.Direction+":invalid")
+				return next(fc)
+			}
+			seen = append(seen, fc.Direction+":"+fc.Link+":"+formatMsgID(fc.Frame.BodyID))
+			switch {
+			case fc.Direction == FrameSend && fc.Frame.BodyID == jtt809.DOWN_WARN_MSG:
+				return errRejected
+			case fc.Direction == FrameRecv && frameSubBusinessID(fc.Frame.BodyID, fc.Frame.RawBody) == 0x1299:
+				return nil // 丢弃
+			}
+			return next(fc)
+		}
+	})
+
+	if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+		t.Fatal("connect sub link failed")
+	}
+	if got := <-received; got != jtt809.DOWN_CONNECT_REQ {
+		t.Fatalf("expected 0x9001, got 0x%04X", got)
+	}
+	header := jtt809.Header{GNSSCenterID: 1}
+	if err := g.SendToSubordinate(1, header, jtt809.ApplyForMonitorStartup{VehicleNo: "粤A12345", VehicleColor: jtt809.PlateColorBlue}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != jtt809.DOWN_EXG_MSG {
+			t.Fatalf("expected 0x9200, got 0x%04X", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected 0x9200 sent")
+	}
+	if err := g.SendToSubordinate(1, header, rawBody{msgID: jtt809.DOWN_WARN_MSG, payload: []byte{0x94, 0x02, 0, 0, 0, 0}}); err == nil {
+		t.Fatalf("expected send rejected by middleware, got %v", err)
+	}
+
+	var handled []uint16
+	g.Handle(jtt809.UP_EXG_MSG, 0, func(msg *Message) { handled = append(handled, msg.Sub.SubBusinessID) })
+	for _, sub := range []uint16{0x1299, 0x1240} {
+		body, err := buildSubBusinessBody("粤A12345", jtt809.PlateColorBlue, sub, nil)
+		if err != nil {
+			t.Fatalf("build body: %v", err)
+		}
+		data, err := jtt809.EncodePackage(jtt809.Package{Header: header.WithResponse(jtt809.UP_EXG_MSG), Body: rawBody{msgID: jtt809.UP_EXG_MSG, payload: body}})
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		g.handleSubMessage(1, data)
+	}
+	g.handleSubMessage(1, []byte{0x5b, 0x00, 0x5d})
+	if len(handled) != 1 || handled[0] != 0x1240 {
+		t.Fatalf("expected only 0x1240 handled, got %04X", handled)
+	}
+
+	want := []string{
+		"send:sub:0x9001", "recv:sub:0x9002",
+		"send:sub:0x9200", "send:sub:0x9400",
+		"recv:sub:0x1200", "recv:sub:0x1200", "recv:invalid",
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("unexpected frames %v", seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("frame %d: expected %s, got %s", i, want[i], seen[i])
+		}
+	}
+}
+
+func TestPacketLogLevel(t *testing.T) {
+	g := &JT809Gateway{cfg: Config{PacketLog: PacketLogConfig{Level: "off", DebugUsers: []uint32{2}}}}
+	if _, ok := g.packetLogLevel(1); ok {
+		t.Fatal("expected packet dump disabled")
+	}
+	if _, ok := g.packetLogLevel(2); !ok {
+		t.Fatal("expected packet dump for debug user in config")
+	}
+	g.SetPacketDebug(1, true)
+	if !g.PacketDebug(1) {
+		t.Fatal("expected packet debug enabled")
+	}
+	if _, ok := g.packetLogLevel(1); !ok {
+		t.Fatal("expected packet dump for debug user")
+	}
+	g.SetPacketDebug(1, false)
+	if _, ok := g.packetLogLevel(1); ok {
+		t.Fatal("expected packet dump disabled after debug off")
+	}
+
+	g.policy.Store(newRuntimePolicy(Config{PacketLog: PacketLogConfig{Level: "debug", SampleRate: 0.5}}))
+	dumped := 0
+	for range 1000 {
+		if level, ok := g.packetLogLevel(1); ok {
+			if level.String() != "DEBUG" {
+				t.Fatalf("expected debug level, got %s", level)
+			}
+			dumped++
+		}
+	}
+	if dumped < 350 || dumped > 650 {
+		t.Fatalf("expected about half sampled, got %d", dumped)
+	}
+	if err := (Config{MainListen: ":0", PacketLog: PacketLogConfig{SampleRate: 2}}).Validate(); err == nil {
+		t.Fatal("expected invalid sample rate rejected")
+	}
+}
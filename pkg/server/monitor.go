@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -18,27 +19,56 @@ type MonitorRequest struct {
 
 // RequestMonitorStartup 通过从链路向下级平台发送启动车辆定位信息交换请求。
 func (g *JT809Gateway) RequestMonitorStartup(req MonitorRequest) error {
-	if req.VehicleNo == "" {
-		return errors.New("vehicle_no is required")
-	}
-	if req.VehicleColor == 0 {
-		req.VehicleColor = jtt809.PlateColorBlue
+	header, body, err := g.buildMonitorRequest(&req, true)
+	if err != nil {
+		return err
 	}
-	return g.sendMonitorRequest(req, true)
+	return g.sendMonitorRequest(req, header, body, true)
 }
 
 // RequestMonitorEnd 通过从链路向下级平台发送结束车辆定位信息交换请求。
 func (g *JT809Gateway) RequestMonitorEnd(req MonitorRequest) error {
+	header, body, err := g.buildMonitorRequest(&req, false)
+	if err != nil {
+		return err
+	}
+	return g.sendMonitorRequest(req, header, body, false)
+}
+
+// RequestMonitorStartupAndWait 发送启动车辆定位信息交换请求并等待下级平台应答（0x1205），超时返回 ErrRequestTimeout。
+func (g *JT809Gateway) RequestMonitorStartupAndWait(ctx context.Context, req MonitorRequest) (*jtt809.MonitorAck, error) {
+	return g.monitorRequestAndWait(ctx, req, true)
+}
+
+// RequestMonitorEndAndWait 发送结束车辆定位信息交换请求并等待下级平台应答（0x1206），超时返回 ErrRequestTimeout。
+func (g *JT809Gateway) RequestMonitorEndAndWait(ctx context.Context, req MonitorRequest) (*jtt809.MonitorAck, error) {
+	return g.monitorRequestAndWait(ctx, req, false)
+}
+
+func (g *JT809Gateway) monitorRequestAndWait(ctx context.Context, req MonitorRequest, startup bool) (*jtt809.MonitorAck, error) {
+	header, body, err := g.buildMonitorRequest(&req, startup)
+	if err != nil {
+		return nil, err
+	}
+	replySub := jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK
+	if !startup {
+		replySub = jtt809.UP_EXG_MSG_RETURN_END_ACK
+	}
+	reply, err := g.SendAndWait(ctx, req.UserID, header, body, replySub)
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*jtt809.MonitorAck), nil
+}
+
+// buildMonitorRequest 校验请求并构造报文头与业务体，车牌颜色缺省为蓝色。
+func (g *JT809Gateway) buildMonitorRequest(req *MonitorRequest, startup bool) (jtt809.Header, jtt809.Body, error) {
 	if req.VehicleNo == "" {
-		return errors.New("vehicle_no is required")
+		return jtt809.Header{}, nil, errors.New("vehicle_no is required")
 	}
 	if req.VehicleColor == 0 {
 		req.VehicleColor = jtt809.PlateColorBlue
 	}
-	return g.sendMonitorRequest(req, false)
-}
-
-func (g *JT809Gateway) sendMonitorRequest(req MonitorRequest, startup bool) error {
 	var body jtt809.Body
 	if startup {
 		body = jtt809.ApplyForMonitorStartup{
@@ -54,17 +84,17 @@ func (g *JT809Gateway) sendMonitorRequest(req MonitorRequest, startup bool) erro
 		}
 	}
 
-	snap, ok := g.store.Snapshot(req.UserID)
-	if !ok || snap.MainSessionID == "" {
-		return errors.New("platform not online")
+	snap, err := g.sendableSnapshot(req.UserID)
+	if err != nil {
+		return jtt809.Header{}, nil, err
 	}
 	if snap.GNSSCenterID == 0 {
-		return fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
+		return jtt809.Header{}, nil, fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
 	}
+	return jtt809.Header{GNSSCenterID: snap.GNSSCenterID}, body, nil
+}
 
-	header := jtt809.Header{
-		GNSSCenterID: snap.GNSSCenterID,
-	}
+func (g *JT809Gateway) sendMonitorRequest(req MonitorRequest, header jtt809.Header, body jtt809.Body, startup bool) error {
 	if err := g.SendToSubordinate(req.UserID, header, body); err != nil {
 		return err
 	}
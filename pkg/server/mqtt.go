@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMQTTTopicPrefix       = "jt809"
+	defaultMQTTKeepAlive         = 60 * time.Second
+	defaultMQTTConnectTimeout    = 10 * time.Second
+	defaultMQTTReconnectInterval = 5 * time.Second
+	maxMQTTReconnectInterval     = time.Minute
+	defaultMQTTBufferSize        = 10000
+	defaultMQTTMaxInflight       = 100
+)
+
+// MQTTConfig 配置 MQTT 推送。
+// 实时定位发布到 {TopicPrefix}/{user_id}/{plate}/location，补报定位逐点发布到 .../location/supplementary，
+// 下级平台上报报警、围栏事件与违规报警发布到 .../alarm，消息体为 Event JSON。
+type MQTTConfig struct {
+	// Broker 地址，如 tcp://127.0.0.1:1883、ssl://broker:8883，未带协议时按 tcp 处理
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"client_id"` // 为空时自动生成
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// ProtocolVersion 协议级别，4 为 MQTT 3.1.1（默认），5 为 MQTT 5
+	ProtocolVersion byte `yaml:"protocol_version"`
+	// TopicPrefix 主题前缀，默认 jt809
+	TopicPrefix string `yaml:"topic_prefix"`
+	// QoS 发布服务质量 0 或 1。不支持 QoS 2：缓存仅在内存中且每次以清除会话方式连接，
+	// 重连后无法恢复 Broker 端的 QoS 2 状态，只能做到至少一次
+	QoS byte `yaml:"qos"`
+	// RetainLocation 实时定位以保留消息发布，新订阅者可立即获得最后位置
+	RetainLocation bool `yaml:"retain_location"`
+	// KeepAlive 心跳间隔，<=0 时使用默认值 60s
+	KeepAlive time.Duration `yaml:"keep_alive"`
+	// ConnectTimeout 连接超时，<=0 时使用默认值 10s
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// ReconnectInterval 首次重连等待时间，之后翻倍至最多 1 分钟，<=0 时使用默认值 5s
+	ReconnectInterval time.Duration `yaml:"reconnect_interval"`
+	// BufferSize 断线期间缓存的消息条数，超出后丢弃最早的消息，<=0 时使用默认值 10000
+	BufferSize int `yaml:"buffer_size"`
+	// MaxInflight QoS 1 时已发送未确认的消息数上限，<=0 时使用默认值 100；
+	// MQTT 5 下不超过 Broker 在 CONNACK 中声明的 Receive Maximum
+	MaxInflight int `yaml:"max_inflight"`
+	// TLS 配置；使用 ssl:// 等地址且 TLS 为 nil 时根据下列文件构造
+	TLS                *tls.Config `yaml:"-"`
+	CAFile             string      `yaml:"ca_file"`
+	CertFile           string      `yaml:"cert_file"`
+	KeyFile            string      `yaml:"key_file"`
+	InsecureSkipVerify bool        `yaml:"insecure_skip_verify"`
+}
+
+type mqttMessage struct {
+	seq     uint64
+	topic   string
+	payload []byte
+	retain  bool
+	dup     bool
+	acked   bool // 已确认，等待移出缓存
+}
+
+// mqttInflight 为一条已发送、等待 PUBACK 的 QoS 1 消息。
+type mqttInflight struct {
+	seq    uint64
+	sentAt time.Time
+}
+
+// mqttSession 为单个连接上的发送状态，重连后重建，缓存中未确认的消息重新发送。
+type mqttSession struct {
+	window   int
+	sentSeq  uint64 // 本连接已发送的最大消息序号
+	inflight map[uint16]*mqttInflight
+	packetID uint16
+}
+
+// allocID 分配未被在途消息占用的报文标识。
+func (s *mqttSession) allocID() uint16 {
+	for {
+		s.packetID++
+		if s.packetID == 0 {
+			s.packetID = 1
+		}
+		if _, ok := s.inflight[s.packetID]; !ok {
+			return s.packetID
+		}
+	}
+}
+
+// MQTTPublisher 将定位与报警事件发布到 MQTT Broker，实现 EventSink。
+// 断线期间消息缓存在内存中，重连后按顺序补发；QoS 1 时最多 MaxInflight 条消息同时等待确认，收到确认后才移出缓存。
+// 仅需发布，协议实现见 mqtt_packet.go：同时支持 MQTT 3.1.1 与 5 的 Go 客户端需要引入两套 API 不同的库
+// （paho.mqtt.golang 仅支持 3.1.1，paho.golang 仅支持 5），而发布所需的报文只有 CONNECT、PUBLISH、PUBACK 与心跳。
+type MQTTPublisher struct {
+	cfg     MQTTConfig
+	addr    string
+	tlsConf *tls.Config
+
+	mu      sync.Mutex
+	buf     []*mqttMessage
+	seq     uint64
+	notify  chan struct{}
+	dropped atomic.Uint64
+
+	connected atomic.Bool
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMQTTPublisher 创建 MQTT 发布器并在后台连接 Broker，连接失败时自动重连。
+func NewMQTTPublisher(cfg MQTTConfig) (*MQTTPublisher, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("mqtt broker is required")
+	}
+	if cfg.QoS > 1 {
+		return nil, fmt.Errorf("unsupported mqtt qos %d, must be 0 or 1", cfg.QoS)
+	}
+	if cfg.ProtocolVersion == 0 {
+		cfg.ProtocolVersion = MQTTVersion311
+	}
+	if cfg.ProtocolVersion != MQTTVersion311 && cfg.ProtocolVersion != MQTTVersion5 {
+		return nil, fmt.Errorf("unsupported mqtt protocol version %d", cfg.ProtocolVersion)
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = defaultMQTTTopicPrefix
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = fmt.Sprintf("jtt809-%d", time.Now().UnixNano()%1e9)
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = defaultMQTTKeepAlive
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultMQTTConnectTimeout
+	}
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = defaultMQTTReconnectInterval
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultMQTTBufferSize
+	}
+	if cfg.MaxInflight <= 0 {
+		cfg.MaxInflight = defaultMQTTMaxInflight
+	}
+	addr, useTLS, err := parseMQTTBroker(cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	p := &MQTTPublisher{
+		cfg:    cfg,
+		addr:   addr,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if useTLS {
+		if p.tlsConf, err = buildMQTTTLS(cfg, addr); err != nil {
+			return nil, err
+		}
+	}
+	go p.run()
+	return p, nil
+}
+
+// parseMQTTBroker 解析 Broker 地址，返回 host:port 与是否使用 TLS。
+func parseMQTTBroker(broker string) (string, bool, error) {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("parse mqtt broker: %w", err)
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS, port = true, "8883"
+	default:
+		return "", false, fmt.Errorf("unsupported mqtt scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+func buildMQTTTLS(cfg MQTTConfig, addr string) (*tls.Config, error) {
+	if cfg.TLS != nil {
+		return cfg.TLS, nil
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	conf := &tls.Config{ServerName: host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read mqtt ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in mqtt ca file")
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load mqtt client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// Publish 实现 EventSink，仅处理定位与报警类事件。
+func (p *MQTTPublisher) Publish(evt *Event) {
+	base := p.topicBase(evt)
+	switch evt.Type {
+	case EventVehicleLocation:
+		p.push(base+"/location", evt, p.cfg.RetainLocation)
+	case EventVehicleLocationSupplementary:
+		points, _ := evt.Data.([]*LocationData)
+		for _, pt := range points {
+			single := *evt
+			single.Data = pt
+			p.push(base+"/location/supplementary", &single, false)
+		}
+	case EventWarnAdptInfo, EventWarnInformTips, EventGeofence, EventRuleAlarm:
+		p.push(base+"/alarm", evt, false)
+	}
+}
+
+// Connected 返回当前是否已连接 Broker。
+func (p *MQTTPublisher) Connected() bool {
+	return p.connected.Load()
+}
+
+// Dropped 返回因缓存已满被丢弃的消息数。
+func (p *MQTTPublisher) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Close 断开连接并停止重连，缓存中未发送的消息丢弃。
+func (p *MQTTPublisher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+	return nil
+}
+
+// topicBase 返回 {prefix}/{user_id}/{plate}，车牌中的主题通配符与分隔符替换为下划线。
+func (p *MQTTPublisher) topicBase(evt *Event) string {
+	plate := strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(evt.VehicleNo)
+	if plate == "" {
+		plate = "_"
+	}
+	return p.cfg.TopicPrefix + "/" + strconv.FormatUint(uint64(evt.UserID), 10) + "/" + plate
+}
+
+func (p *MQTTPublisher) push(topic string, evt *Event, retain bool) {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		slog.Warn("marshal mqtt payload failed", "topic", topic, "err", err)
+		return
+	}
+	p.mu.Lock()
+	p.seq++
+	p.buf = append(p.buf, &mqttMessage{seq: p.seq, topic: topic, payload: payload, retain: retain})
+	if over := len(p.buf) - p.cfg.BufferSize; over > 0 {
+		p.buf = p.buf[over:]
+		p.dropped.Add(uint64(over))
+	}
+	p.mu.Unlock()
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next 返回序号大于 after 的第一条未确认消息。
+func (p *MQTTPublisher) next(after uint64) *mqttMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, _ := slices.BinarySearchFunc(p.buf, after+1, func(m *mqttMessage, seq uint64) int {
+		return cmp.Compare(m.seq, seq)
+	})
+	for ; i < len(p.buf); i++ {
+		if !p.buf[i].acked {
+			return p.buf[i]
+		}
+	}
+	return nil
+}
+
+// ack 标记消息已确认，并移出缓存头部连续已确认的消息；若该消息已因缓存溢出被丢弃则忽略。
+func (p *MQTTPublisher) ack(seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, ok := slices.BinarySearchFunc(p.buf, seq, func(m *mqttMessage, seq uint64) int {
+		return cmp.Compare(m.seq, seq)
+	})
+	if !ok {
+		return
+	}
+	p.buf[i].acked = true
+	for len(p.buf) > 0 && p.buf[0].acked {
+		p.buf[0] = nil
+		p.buf = p.buf[1:]
+	}
+}
+
+func (p *MQTTPublisher) run() {
+	defer close(p.done)
+	wait := p.cfg.ReconnectInterval
+	for {
+		conn, receiveMax, err := p.connect()
+		if err == nil {
+			wait = p.cfg.ReconnectInterval
+			slog.Info("mqtt connected", "broker", p.addr, "client_id", p.cfg.ClientID)
+			p.connected.Store(true)
+			err = p.serve(conn, receiveMax)
+			p.connected.Store(false)
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		slog.Warn("mqtt connection lost, will reconnect", "broker", p.addr, "err", err, "retry_in", wait)
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxMQTTReconnectInterval)
+	}
+}
+
+// connect 建立连接并完成 CONNECT 握手，返回 Broker 声明的 Receive Maximum（MQTT 3.1.1 为 0）。
+func (p *MQTTPublisher) connect() (net.Conn, uint16, error) {
+	dialer := &net.Dialer{Timeout: p.cfg.ConnectTimeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.tlsConf != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, p.tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	conn.SetDeadline(time.Now().Add(p.cfg.ConnectTimeout))
+	keepAlive := uint16(min(p.cfg.KeepAlive/time.Second, 65535))
+	body := encodeMQTTConnect(p.cfg.ProtocolVersion, p.cfg.ClientID, p.cfg.Username, p.cfg.Password, keepAlive, true)
+	if err := writeMQTTPacket(conn, mqttConnect, body); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	pkt, err := readMQTTPacket(bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("read connack: %w", err)
+	}
+	if pkt.kind() != mqttConnack {
+		conn.Close()
+		return nil, 0, fmt.Errorf("unexpected packet 0x%02X, want connack", pkt.header)
+	}
+	code, err := parseMQTTConnack(pkt.body)
+	if err != nil || code != 0 {
+		conn.Close()
+		return nil, 0, fmt.Errorf("connection refused, code=%d err=%v", code, err)
+	}
+	var receiveMax uint16
+	if p.cfg.ProtocolVersion == MQTTVersion5 {
+		receiveMax = parseMQTTReceiveMaximum(pkt.body)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, receiveMax, nil
+}
+
+// serve 在已建立的连接上发送缓存消息并维持心跳，返回 nil 表示发布器已关闭。
+func (p *MQTTPublisher) serve(conn net.Conn, receiveMax uint16) error {
+	acks := make(chan mqttPacket, 16)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			pkt, err := readMQTTPacket(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case acks <- pkt:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s := &mqttSession{window: min(p.cfg.MaxInflight, 65535), inflight: make(map[uint16]*mqttInflight)}
+	if receiveMax > 0 {
+		s.window = min(s.window, int(receiveMax))
+	}
+	ticker := time.NewTicker(p.cfg.KeepAlive)
+	defer ticker.Stop()
+	pingPending := false
+	for {
+		if err := p.flush(conn, s); err != nil {
+			return err
+		}
+		select {
+		case <-p.stop:
+			_ = writeMQTTPacket(conn, mqttDisconnect, nil)
+			return nil
+		case err := <-readErr:
+			return err
+		case pkt := <-acks:
+			if pkt.kind() == mqttPingresp {
+				pingPending = false
+				continue
+			}
+			if err := p.handleAck(s, pkt); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			if pingPending {
+				return errors.New("ping timeout")
+			}
+			for _, f := range s.inflight {
+				if now.Sub(f.sentAt) > p.cfg.KeepAlive {
+					return errors.New("wait puback timeout")
+				}
+			}
+			if err := writeMQTTPacket(conn, mqttPingreq, nil); err != nil {
+				return err
+			}
+			pingPending = true
+		case <-p.notify:
+		}
+	}
+}
+
+// flush 按顺序发送缓存中本连接尚未发送的消息：QoS 0 发送后即移出缓存，
+// QoS 1 时在途消息达到窗口上限后暂停，收到确认后继续。
+func (p *MQTTPublisher) flush(conn net.Conn, s *mqttSession) error {
+	for p.cfg.QoS == 0 || len(s.inflight) < s.window {
+		msg := p.next(s.sentSeq)
+		if msg == nil {
+			return nil
+		}
+		var id uint16
+		if p.cfg.QoS > 0 {
+			id = s.allocID()
+		}
+		header, body := encodeMQTTPublish(p.cfg.ProtocolVersion, msg.topic, msg.payload, p.cfg.QoS, msg.retain, msg.dup, id)
+		if err := writeMQTTPacket(conn, header, body); err != nil {
+			return err
+		}
+		msg.dup = true
+		s.sentSeq = msg.seq
+		if p.cfg.QoS == 0 {
+			p.ack(msg.seq)
+			continue
+		}
+		s.inflight[id] = &mqttInflight{seq: msg.seq, sentAt: time.Now()}
+	}
+	return nil
+}
+
+// handleAck 按报文标识匹配在途消息的 PUBACK，确认可以乱序到达。
+func (p *MQTTPublisher) handleAck(s *mqttSession, pkt mqttPacket) error {
+	if pkt.kind() != mqttPuback {
+		return nil
+	}
+	id, code, err := parseMQTTAck(pkt.body)
+	if err != nil {
+		return err
+	}
+	f, ok := s.inflight[id]
+	if !ok {
+		return nil
+	}
+	if code >= 0x80 {
+		// 被 Broker 拒绝（如无权限），重发无意义，丢弃该消息
+		slog.Warn("mqtt publish rejected", "packet_id", id, "reason", code)
+	}
+	delete(s.inflight, id)
+	p.ack(f.seq)
+	return nil
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 控制报文类型（高 4 位）。
+const (
+	mqttConnect    byte = 0x10
+	mqttConnack    byte = 0x20
+	mqttPublish    byte = 0x30
+	mqttPuback     byte = 0x40
+	mqttPingreq    byte = 0xC0
+	mqttPingresp   byte = 0xD0
+	mqttDisconnect byte = 0xE0
+)
+
+// MQTT 协议级别。
+const (
+	MQTTVersion311 byte = 4
+	MQTTVersion5   byte = 5
+)
+
+// mqttPacket 为读到的一个控制报文。
+type mqttPacket struct {
+	header byte
+	body   []byte
+}
+
+func (p mqttPacket) kind() byte { return p.header & 0xF0 }
+
+// writeMQTTPacket 写出固定头与可变部分。
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	buf := make([]byte, 0, 5+len(body))
+	buf = append(buf, header)
+	buf = appendMQTTVarint(buf, len(body))
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMQTTPacket 读取一个完整控制报文。
+func readMQTTPacket(r *bufio.Reader) (mqttPacket, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return mqttPacket{}, err
+	}
+	length, err := readMQTTVarint(r)
+	if err != nil {
+		return mqttPacket{}, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return mqttPacket{}, err
+	}
+	return mqttPacket{header: header, body: body}, nil
+}
+
+func appendMQTTVarint(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func readMQTTVarint(r io.ByteReader) (int, error) {
+	n, mult := 0, 1
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n += int(b&0x7F) * mult
+		if b&0x80 == 0 {
+			return n, nil
+		}
+		mult *= 128
+	}
+	return 0, errors.New("malformed remaining length")
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// encodeMQTTConnect 构造 CONNECT 可变头与载荷，不携带遗嘱，MQTT 5 属性为空。
+func encodeMQTTConnect(version byte, clientID, username, password string, keepAlive uint16, cleanSession bool) []byte {
+	b := appendMQTTString(nil, "MQTT")
+	b = append(b, version)
+	var flags byte
+	if cleanSession {
+		flags |= 0x02
+	}
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint16(b, keepAlive)
+	if version == MQTTVersion5 {
+		b = append(b, 0) // 属性长度
+	}
+	b = appendMQTTString(b, clientID)
+	if username != "" {
+		b = appendMQTTString(b, username)
+	}
+	if password != "" {
+		b = appendMQTTString(b, password)
+	}
+	return b
+}
+
+// parseMQTTConnack 返回连接结果码，0 表示成功。
+func parseMQTTConnack(body []byte) (byte, error) {
+	if len(body) < 2 {
+		return 0, errors.New("connack too short")
+	}
+	return body[1], nil
+}
+
+// parseMQTTReceiveMaximum 从 MQTT 5 CONNACK 属性中读取 Receive Maximum，未声明或无法解析时返回 0。
+func parseMQTTReceiveMaximum(body []byte) uint16 {
+	if len(body) < 3 {
+		return 0
+	}
+	r := bytes.NewReader(body[2:])
+	length, err := readMQTTVarint(r)
+	if err != nil || length > r.Len() {
+		return 0
+	}
+	props := body[len(body)-r.Len():][:length]
+	for len(props) > 0 {
+		id := props[0]
+		props = props[1:]
+		var size int
+		switch id {
+		case 0x01, 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2A:
+			size = 1
+		case 0x13, 0x21, 0x22, 0x23:
+			size = 2
+		case 0x02, 0x11, 0x18, 0x27:
+			size = 4
+		case 0x03, 0x08, 0x09, 0x12, 0x15, 0x16, 0x1A, 0x1C, 0x1F:
+			if len(props) < 2 {
+				return 0
+			}
+			size = 2 + int(binary.BigEndian.Uint16(props))
+		case 0x26:
+			// 用户属性为两个字符串
+			if len(props) < 2 {
+				return 0
+			}
+			size = 2 + int(binary.BigEndian.Uint16(props))
+			if len(props) < size+2 {
+				return 0
+			}
+			size += 2 + int(binary.BigEndian.Uint16(props[size:]))
+		default:
+			return 0
+		}
+		if len(props) < size {
+			return 0
+		}
+		if id == 0x21 {
+			return binary.BigEndian.Uint16(props)
+		}
+		props = props[size:]
+	}
+	return 0
+}
+
+// encodeMQTTPublish 构造 PUBLISH 报文的固定头与可变部分。
+func encodeMQTTPublish(version byte, topic string, payload []byte, qos byte, retain, dup bool, packetID uint16) (byte, []byte) {
+	header := mqttPublish | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	if dup && qos > 0 {
+		header |= 0x08
+	}
+	b := appendMQTTString(make([]byte, 0, 2+len(topic)+3+len(payload)), topic)
+	if qos > 0 {
+		b = binary.BigEndian.AppendUint16(b, packetID)
+	}
+	if version == MQTTVersion5 {
+		b = append(b, 0) // 属性长度
+	}
+	return header, append(b, payload...)
+}
+
+// parseMQTTAck 解析 PUBACK，返回报文标识与原因码（MQTT 3.1.1 恒为 0）。
+func parseMQTTAck(body []byte) (uint16, byte, error) {
+	if len(body) < 2 {
+		return 0, 0, fmt.Errorf("ack too short: %d", len(body))
+	}
+	id := binary.BigEndian.Uint16(body[:2])
+	if len(body) > 2 {
+		return id, body[2], nil
+	}
+	return id, 0, nil
+}
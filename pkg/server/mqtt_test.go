@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+type mqttReceived struct {
+	topic   string
+	retain  bool
+	payload []byte
+}
+
+// runFakeBroker 模拟 Broker：第一个连接不回 CONNACK 直接断开，之后的连接正常应答 QoS 1 发布。
+func runFakeBroker(t *testing.T, ln net.Listener, version byte, out chan<- mqttReceived) {
+	first := true
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		r := bufio.NewReader(conn)
+		pkt, err := readMQTTPacket(r)
+		if err != nil || pkt.kind() != mqttConnect {
+			t.Errorf("expected connect, got %x %v", pkt.header, err)
+			conn.Close()
+			continue
+		}
+		if pkt.body[6] != version {
+			t.Errorf("unexpected protocol level %d", pkt.body[6])
+		}
+		if first {
+			first = false
+			conn.Close()
+			continue
+		}
+		connack := []byte{0, 0}
+		if version == MQTTVersion5 {
+			connack = append(connack, 0)
+		}
+		writeMQTTPacket(conn, mqttConnack, connack)
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				pkt, err := readMQTTPacket(r)
+				if err != nil {
+					return
+				}
+				if pkt.kind() != mqttPublish {
+					continue
+				}
+				body := pkt.body
+				topicLen := int(binary.BigEndian.Uint16(body))
+				topic := string(body[2 : 2+topicLen])
+				id := body[2+topicLen : 4+topicLen]
+				payload := body[4+topicLen:]
+				if version == MQTTVersion5 {
+					payload = payload[1:]
+				}
+				out <- mqttReceived{topic: topic, retain: pkt.header&0x01 != 0, payload: payload}
+				writeMQTTPacket(conn, mqttPuback, id)
+			}
+		}(conn)
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	for _, version := range []byte{MQTTVersion311, MQTTVersion5} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		received := make(chan mqttReceived, 8)
+		go runFakeBroker(t, ln, version, received)
+
+		p, err := NewMQTTPublisher(MQTTConfig{
+			Broker:            "tcp://" + ln.Addr().String(),
+			ProtocolVersion:   version,
+			QoS:               1,
+			RetainLocation:    true,
+			ReconnectInterval: 10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("new publisher: %v", err)
+		}
+		// 首次连接失败期间发布的消息先缓存，重连后补发
+		p.Publish(&Event{Type: EventVehicleLocation, UserID: 10001, VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue, Data: &LocationData{Longitude: 114.05}})
+		p.Publish(&Event{Type: EventRuleAlarm, UserID: 10001, VehicleNo: "粤B12345", Data: &RuleAlarm{Kind: RuleOverspeed}})
+		p.Publish(&Event{Type: EventLogin, UserID: 10001})
+
+		want := []struct {
+			topic  string
+			retain bool
+		}{
+			{"jt809/10001/粤B12345/location", true},
+			{"jt809/10001/粤B12345/alarm", false},
+		}
+		for _, w := range want {
+			select {
+			case msg := <-received:
+				if msg.topic != w.topic || msg.retain != w.retain {
+					t.Fatalf("v%d: unexpected message %s retain=%v", version, msg.topic, msg.retain)
+				}
+				var evt Event
+				if err := json.Unmarshal(msg.payload, &evt); err != nil || evt.UserID != 10001 {
+					t.Fatalf("v%d: unexpected payload %s", version, msg.payload)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("v%d: timeout waiting for %s", version, w.topic)
+			}
+		}
+		waitFor(t, func() bool { return p.next(0) == nil })
+		p.Close()
+		ln.Close()
+		select {
+		case msg := <-received:
+			t.Fatalf("v%d: unexpected extra message %s", version, msg.topic)
+		default:
+		}
+	}
+}
+
+func TestMQTTPublisherInflightWindow(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	p, err := NewMQTTPublisher(MQTTConfig{Broker: ln.Addr().String(), ProtocolVersion: MQTTVersion5, QoS: 1})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	defer p.Close()
+	for range 5 {
+		p.Publish(&Event{Type: EventVehicleLocation, UserID: 10001, VehicleNo: "粤B12345", Data: &LocationData{}})
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if pkt, err := readMQTTPacket(r); err != nil || pkt.kind() != mqttConnect {
+		t.Fatalf("expected connect, got %x %v", pkt.header, err)
+	}
+	// CONNACK 声明 Receive Maximum 为 3
+	writeMQTTPacket(conn, mqttConnack, []byte{0, 0, 3, 0x21, 0, 3})
+	readIDs := func(n int) [][]byte {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var ids [][]byte
+		for len(ids) < n {
+			pkt, err := readMQTTPacket(r)
+			if err != nil {
+				t.Fatalf("read publish: %v", err)
+			}
+			topicLen := int(binary.BigEndian.Uint16(pkt.body))
+			ids = append(ids, pkt.body[2+topicLen:4+topicLen])
+		}
+		return ids
+	}
+
+	// 窗口内的消息无需等待确认即连续发送，窗口满后暂停
+	ids := readIDs(3)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := readMQTTPacket(r); err == nil {
+		t.Fatal("expected publisher to stop at receive maximum")
+	}
+	// 乱序确认后继续发送剩余消息
+	for i := len(ids) - 1; i >= 0; i-- {
+		writeMQTTPacket(conn, mqttPuback, ids[i])
+	}
+	for _, id := range readIDs(2) {
+		writeMQTTPacket(conn, mqttPuback, id)
+	}
+	waitFor(t, func() bool { return p.next(0) == nil })
+}
+
+func TestMQTTPublisherRejectsQoS2(t *testing.T) {
+	if _, err := NewMQTTPublisher(MQTTConfig{Broker: "127.0.0.1:1883", QoS: 2}); err == nil {
+		t.Fatal("expected qos 2 to be rejected")
+	}
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+const (
+	defaultOutboundTTL         = 5 * time.Minute
+	defaultOutboundMaxMessages = 1000
+	defaultOutboundMaxBytes    = 1 << 20
+	outboundSweepInterval      = time.Second
+	outboundPersistDelay       = 100 * time.Millisecond // 合并落盘的等待时间
+)
+
+// 下行报文丢弃原因，见 OutboundDroppedEventData。
+const (
+	OutboundDropExpired  = "expired"  // 超过 TTL 仍未发出
+	OutboundDropOverflow = "overflow" // 队列超出条数或字节上限，丢弃最早的报文
+)
+
+// OutboundQueueConfig 配置主从链路均不可用时下行业务报文的暂存队列，链路恢复后按入队顺序补发。
+// 仅暂存业务报文（如报警督办、报文下发），链路管理类报文（0x9001~0x9008 等）与等待应答的请求（SendAndWait）不排队。
+type OutboundQueueConfig struct {
+	// TTL 报文最长暂存时间，超时丢弃并发布 outbound_dropped 事件，<=0 时使用默认值 5 分钟
+	TTL time.Duration `yaml:"ttl"`
+	// TTLOverrides 按子业务类型覆盖 TTL
+	TTLOverrides map[uint16]time.Duration `yaml:"ttl_overrides"`
+	// MaxMessages 每个平台最多暂存的报文数，超出时丢弃最早的报文，<=0 时使用默认值 1000
+	MaxMessages int `yaml:"max_messages"`
+	// MaxBytes 每个平台暂存报文的总字节数上限，超出时丢弃最早的报文，<=0 时使用默认值 1MB
+	MaxBytes int `yaml:"max_bytes"`
+	// Dir 队列落盘目录，为空时仅保存在内存
+	Dir string `yaml:"dir"`
+	// QueueRealVideo 暂存实时音视频请求（0x9800）。默认不暂存，避免链路恢复后车辆开始无人观看的推流
+	QueueRealVideo bool `yaml:"queue_real_video"`
+}
+
+func (c OutboundQueueConfig) withDefaults() OutboundQueueConfig {
+	if c.TTL <= 0 {
+		c.TTL = defaultOutboundTTL
+	}
+	if c.MaxMessages <= 0 {
+		c.MaxMessages = defaultOutboundMaxMessages
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultOutboundMaxBytes
+	}
+	return c
+}
+
+func (c OutboundQueueConfig) ttl(subID uint16) time.Duration {
+	if d, ok := c.TTLOverrides[subID]; ok && d > 0 {
+		return d
+	}
+	return c.TTL
+}
+
+// outboundMessage 为一条暂存的已编码下行报文，补发时原样发送。
+type outboundMessage struct {
+	MsgID    uint16            `json:"msg_id"`
+	SubID    uint16            `json:"sub_id"`
+	Plate    string            `json:"vehicle_no,omitempty"`
+	Color    jtt809.PlateColor `json:"vehicle_color,omitempty"`
+	Data     []byte            `json:"data"`
+	QueuedAt time.Time         `json:"queued_at"`
+	ExpireAt time.Time         `json:"expire_at"`
+}
+
+// outboundQueue 按平台保存待补发的下行报文，Dir 非空时每个平台的队列保存为 Dir/<userID>.json。
+// 落盘由后台协程批量完成，入队与发送路径只标记变化的平台，不持锁写文件。
+type outboundQueue struct {
+	cfg OutboundQueueConfig
+
+	mu       sync.Mutex
+	items    map[uint32][]*outboundMessage
+	bytes    map[uint32]int
+	flushing map[uint32]bool
+	dirty    map[uint32]struct{} // 待落盘的平台
+
+	notify  chan struct{} // 有平台待落盘
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// newOutboundQueue 创建暂存队列，并恢复 Dir 中未发出的报文。
+func newOutboundQueue(cfg OutboundQueueConfig) (*outboundQueue, error) {
+	q := &outboundQueue{
+		cfg:      cfg.withDefaults(),
+		items:    make(map[uint32][]*outboundMessage),
+		bytes:    make(map[uint32]int),
+		flushing: make(map[uint32]bool),
+		dirty:    make(map[uint32]struct{}),
+	}
+	if q.cfg.Dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(q.cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create outbound queue dir: %w", err)
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	q.notify = make(chan struct{}, 1)
+	q.stop = make(chan struct{})
+	q.stopped = make(chan struct{})
+	go q.persistLoop()
+	return q, nil
+}
+
+// close 写入尚未落盘的队列并结束后台协程。
+func (q *outboundQueue) close() {
+	if q.stop == nil {
+		return
+	}
+	q.once.Do(func() { close(q.stop) })
+	<-q.stopped
+}
+
+// push 将报文追加到队尾，返回因超出上限被丢弃的报文（可能包含 msg 本身）。
+func (q *outboundQueue) push(userID uint32, msg *outboundMessage) []*outboundMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(msg.Data) > q.cfg.MaxBytes {
+		return []*outboundMessage{msg}
+	}
+	var dropped []*outboundMessage
+	list := q.items[userID]
+	for len(list) > 0 && (len(list) >= q.cfg.MaxMessages || q.bytes[userID]+len(msg.Data) > q.cfg.MaxBytes) {
+		dropped = append(dropped, list[0])
+		q.bytes[userID] -= len(list[0].Data)
+		list = list[1:]
+	}
+	q.items[userID] = append(list, msg)
+	q.bytes[userID] += len(msg.Data)
+	q.persistLocked(userID)
+	return dropped
+}
+
+// busy 返回平台是否有待补发的报文或正在补发，此时新报文需排在队尾以保证顺序。
+func (q *outboundQueue) busy(userID uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items[userID]) > 0 || q.flushing[userID]
+}
+
+// beginFlush 标记平台开始补发，已在补发中时返回 false。
+func (q *outboundQueue) beginFlush(userID uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.flushing[userID] {
+		return false
+	}
+	q.flushing[userID] = true
+	return true
+}
+
+// next 移除已过期的报文并返回队首报文；队列为空时结束补发并返回 nil。
+func (q *outboundQueue) next(userID uint32, now time.Time) (*outboundMessage, []*outboundMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expired := q.expireLocked(userID, now)
+	list := q.items[userID]
+	if len(list) == 0 {
+		delete(q.flushing, userID)
+		return nil, expired
+	}
+	return list[0], expired
+}
+
+// done 在队首报文发出后将其移出队列。
+func (q *outboundQueue) done(userID uint32, msg *outboundMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := q.items[userID]
+	if len(list) == 0 || list[0] != msg {
+		return
+	}
+	q.bytes[userID] -= len(msg.Data)
+	q.items[userID] = list[1:]
+	q.persistLocked(userID)
+}
+
+// abort 在发送失败时结束补发，剩余报文等待链路再次恢复。
+func (q *outboundQueue) abort(userID uint32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.flushing, userID)
+}
+
+// expire 移除全部平台中已过期的报文。
+func (q *outboundQueue) expire(now time.Time) map[uint32][]*outboundMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[uint32][]*outboundMessage)
+	for userID := range q.items {
+		if expired := q.expireLocked(userID, now); len(expired) > 0 {
+			out[userID] = expired
+		}
+	}
+	return out
+}
+
+func (q *outboundQueue) expireLocked(userID uint32, now time.Time) []*outboundMessage {
+	list := q.items[userID]
+	var expired []*outboundMessage
+	kept := list[:0]
+	for _, msg := range list {
+		if now.After(msg.ExpireAt) {
+			expired = append(expired, msg)
+			q.bytes[userID] -= len(msg.Data)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if len(kept) == 0 {
+		delete(q.items, userID)
+		delete(q.bytes, userID)
+	} else {
+		q.items[userID] = kept
+	}
+	q.persistLocked(userID)
+	return expired
+}
+
+// counts 返回各平台暂存的报文数。
+func (q *outboundQueue) counts() map[uint32]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[uint32]int, len(q.items))
+	for userID, list := range q.items {
+		if len(list) > 0 {
+			out[userID] = len(list)
+		}
+	}
+	return out
+}
+
+// persistLocked 标记平台队列待落盘并唤醒后台协程。
+func (q *outboundQueue) persistLocked(userID uint32) {
+	if q.notify == nil {
+		return
+	}
+	q.dirty[userID] = struct{}{}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// persistLoop 等待 outboundPersistDelay 合并变化后批量落盘，停止前写入剩余变化。
+func (q *outboundQueue) persistLoop() {
+	defer close(q.stopped)
+	for {
+		select {
+		case <-q.stop:
+			q.persistDirty()
+			return
+		case <-q.notify:
+		}
+		select {
+		case <-q.stop:
+			q.persistDirty()
+			return
+		case <-time.After(outboundPersistDelay):
+		}
+		q.persistDirty()
+	}
+}
+
+// persistDirty 覆盖保存变化的平台队列，队列为空时删除文件；仅在复制队列时持锁。
+func (q *outboundQueue) persistDirty() {
+	q.mu.Lock()
+	lists := make(map[uint32][]*outboundMessage, len(q.dirty))
+	for userID := range q.dirty {
+		lists[userID] = slices.Clone(q.items[userID])
+	}
+	clear(q.dirty)
+	q.mu.Unlock()
+
+	for userID, list := range lists {
+		path := q.path(userID)
+		if len(list) == 0 {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("remove outbound queue file failed", "user_id", userID, "err", err)
+			}
+			continue
+		}
+		data, err := json.Marshal(list)
+		if err != nil {
+			continue
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			slog.Warn("persist outbound queue failed", "user_id", userID, "err", err)
+		}
+	}
+}
+
+func (q *outboundQueue) load() error {
+	entries, err := os.ReadDir(q.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("read outbound queue dir: %w", err)
+	}
+	total := 0
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		userID, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 32)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(q.cfg.Dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("read outbound queue failed", "file", path, "err", err)
+			continue
+		}
+		var list []*outboundMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			slog.Warn("skip corrupted outbound queue", "file", path, "err", err)
+			continue
+		}
+		uid := uint32(userID)
+		q.items[uid] = list
+		for _, msg := range list {
+			q.bytes[uid] += len(msg.Data)
+		}
+		total += len(list)
+	}
+	if total > 0 {
+		slog.Info("outbound queue restored", "count", total)
+	}
+	return nil
+}
+
+func (q *outboundQueue) path(userID uint32) string {
+	return filepath.Join(q.cfg.Dir, strconv.FormatUint(uint64(userID), 10)+".json")
+}
+
+// sendEncoded 发送已编码的报文。主从链路均不可用时业务报文进入暂存队列并返回 nil，
+// 队列中已有待补发报文时新报文同样排在队尾，保证下发顺序。
+func (g *JT809Gateway) sendEncoded(userID uint32, msgID, subID uint16, data []byte) error {
+	if g.outbound != nil && g.outbound.busy(userID) && g.queueOutbound(userID, msgID, subID, data) {
+		go g.flushOutbound(userID)
+		return nil
+	}
+	err := g.deliver(userID, msgID, data)
+	if err != nil && g.queueOutbound(userID, msgID, subID, data) {
+		return nil
+	}
+	return err
+}
+
+// queueOutbound 将报文放入暂存队列，未启用队列、链路管理类报文、未配置暂存的实时音视频请求或平台不存在时返回 false。
+func (g *JT809Gateway) queueOutbound(userID uint32, msgID, subID uint16, data []byte) bool {
+	if g.outbound == nil || msgID&0x00FF != 0 {
+		return false
+	}
+	if msgID == jtt809.DOWN_REALVIDEO_MSG && !g.outbound.cfg.QueueRealVideo {
+		return false
+	}
+	if _, _, _, ok := g.store.PlatformLinks(userID); !ok {
+		return false
+	}
+	now := time.Now()
+	msg := &outboundMessage{
+		MsgID:    msgID,
+		SubID:    subID,
+		Data:     data,
+		QueuedAt: now,
+		ExpireAt: now.Add(g.outbound.cfg.ttl(subID)),
+	}
+	if frame, err := jtt809.DecodeFrame(data); err == nil {
+		if pkt, err := jtt809.ParseSubBusiness(frame.RawBody); err == nil {
+			msg.Plate, msg.Color = pkt.Plate, pkt.Color
+		}
+	}
+	dropped := g.outbound.push(userID, msg)
+	slog.Info("outbound message queued", "user_id", userID, "msg_id", formatMsgID(msgID), "sub_id", formatMsgID(subID), "expire_at", msg.ExpireAt)
+	g.outboundDropped(userID, dropped, OutboundDropOverflow)
+	return true
+}
+
+// flushOutbound 按入队顺序补发暂存报文，发送失败时停止，剩余报文等待链路再次恢复。
+func (g *JT809Gateway) flushOutbound(userID uint32) {
+	if g.outbound == nil || !g.outbound.beginFlush(userID) {
+		return
+	}
+	sent := 0
+	for {
+		msg, expired := g.outbound.next(userID, time.Now())
+		g.outboundDropped(userID, expired, OutboundDropExpired)
+		if msg == nil {
+			break
+		}
+		if err := g.deliver(userID, msg.MsgID, msg.Data); err != nil {
+			g.outbound.abort(userID)
+			slog.Warn("flush outbound queue stopped", "user_id", userID, "sent", sent, "err", err)
+			return
+		}
+		g.outbound.done(userID, msg)
+		sent++
+	}
+	if sent > 0 {
+		slog.Info("outbound queue flushed", "user_id", userID, "sent", sent)
+	}
+}
+
+// outboundLoop 定期丢弃超过 TTL 的暂存报文。
+func (g *JT809Gateway) outboundLoop(ctx context.Context) {
+	ticker := time.NewTicker(outboundSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for userID, expired := range g.outbound.expire(now) {
+				g.outboundDropped(userID, expired, OutboundDropExpired)
+			}
+		}
+	}
+}
+
+// outboundDropped 记录并发布被丢弃的暂存报文。
+func (g *JT809Gateway) outboundDropped(userID uint32, msgs []*outboundMessage, reason string) {
+	for _, msg := range msgs {
+		slog.Warn("outbound message dropped", "user_id", userID, "msg_id", formatMsgID(msg.MsgID), "sub_id", formatMsgID(msg.SubID), "plate", msg.Plate, "reason", reason, "queued_at", msg.QueuedAt)
+		g.publish(EventOutboundDropped, userID, msg.Plate, msg.Color, OutboundDroppedEventData{
+			MsgID:    msg.MsgID,
+			SubID:    msg.SubID,
+			Reason:   reason,
+			QueuedAt: msg.QueuedAt,
+		})
+	}
+}
+
+// sendableSnapshot 返回可下发报文的平台状态：要求主链路在线，启用暂存队列时主链路断开期间的报文暂存后补发。
+func (g *JT809Gateway) sendableSnapshot(userID uint32) (PlatformSnapshot, error) {
+	snap, ok := g.store.Snapshot(userID)
+	if !ok || (snap.MainSessionID == "" && g.outbound == nil) {
+		return PlatformSnapshot{}, errors.New("platform not online")
+	}
+	return snap, nil
+}
+
+// QueuedOutbound 返回各平台暂存待补发的下行报文数，未启用暂存队列时返回空。
+func (g *JT809Gateway) QueuedOutbound() map[uint32]int {
+	if g.outbound == nil {
+		return map[uint32]int{}
+	}
+	return g.outbound.counts()
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// eventRecorder 将收到的事件写入通道。
+type eventRecorder chan *Event
+
+func (r eventRecorder) Publish(evt *Event) { r <- evt }
+
+func TestOutboundQueueLimits(t *testing.T) {
+	q, err := newOutboundQueue(OutboundQueueConfig{MaxMessages: 2, MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("new queue: %v", err)
+	}
+	msg := func(n int) *outboundMessage {
+		return &outboundMessage{Data: make([]byte, n), ExpireAt: time.Now().Add(time.Minute)}
+	}
+	a, b, c := msg(4), msg(4), msg(4)
+	q.push(1, a)
+	q.push(1, b)
+	if dropped := q.push(1, c); len(dropped) != 1 || dropped[0] != a {
+		t.Fatalf("expected oldest message dropped by count, got %v", dropped)
+	}
+	if dropped := q.push(1, msg(6)); len(dropped) != 1 || dropped[0] != b {
+		t.Fatalf("expected oldest message dropped by bytes, got %v", dropped)
+	}
+	big := msg(11)
+	if dropped := q.push(1, big); len(dropped) != 1 || dropped[0] != big {
+		t.Fatalf("expected oversized message rejected, got %v", dropped)
+	}
+	if n := q.counts()[1]; n != 2 {
+		t.Fatalf("expected 2 queued, got %d", n)
+	}
+}
+
+func TestOutboundQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newOutboundQueue(OutboundQueueConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("new queue: %v", err)
+	}
+	q.push(7, &outboundMessage{MsgID: jtt809.DOWN_EXG_MSG, Data: []byte{1, 2, 3}, ExpireAt: time.Now().Add(time.Minute)})
+	q.close()
+
+	restored, err := newOutboundQueue(OutboundQueueConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("restore queue: %v", err)
+	}
+	msg, _ := restored.next(7, time.Now())
+	if msg == nil || msg.MsgID != jtt809.DOWN_EXG_MSG || len(msg.Data) != 3 {
+		t.Fatalf("unexpected restored message %+v", msg)
+	}
+	restored.done(7, msg)
+	restored.close()
+	reloaded, err := newOutboundQueue(OutboundQueueConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if n := reloaded.counts()[7]; n != 0 {
+		t.Fatalf("expected empty queue after flush, got %d", n)
+	}
+}
+
+func TestOutboundQueueFlushOnLinkUp(t *testing.T) {
+	q, err := newOutboundQueue(OutboundQueueConfig{TTLOverrides: map[uint16]time.Duration{jtt809.DOWN_EXG_MSG_RETURN_END: time.Millisecond}})
+	if err != nil {
+		t.Fatalf("new queue: %v", err)
+	}
+	events := make(eventRecorder, 16)
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics(), outbound: q}
+	g.closing.Store(true) // 测试中不触发从链路重连
+	g.AddEventSink(events)
+	port, received := fakeSubServer(t, true)
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+	g.store.RemoveSession("s1")
+
+	// 链路均不可用，业务报文暂存，链路管理报文直接失败
+	if err := g.RequestMonitorStartup(MonitorRequest{UserID: 1, VehicleNo: "粤A12345"}); err != nil {
+		t.Fatalf("expected request queued while offline, got %v", err)
+	}
+	header := jtt809.Header{GNSSCenterID: 1}
+	for _, body := range []jtt809.Body{
+		jtt809.ApplyForMonitorEnd{VehicleNo: "粤A12345", VehicleColor: jtt809.PlateColorBlue},
+		jtt809.ApplyForMonitorStartup{VehicleNo: "粤A67890", VehicleColor: jtt809.PlateColorBlue},
+	} {
+		if err := g.SendToSubordinate(1, header, body); err != nil {
+			t.Fatalf("expected message queued, got %v", err)
+		}
+	}
+	if err := g.SendToSubordinate(1, header, jtt809.SubLinkLogoutRequest{}); err == nil {
+		t.Fatal("expected link management message not queued")
+	}
+	// 等待应答的请求与实时音视频请求不暂存，立即失败
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := g.RequestMonitorStartupAndWait(ctx, MonitorRequest{UserID: 1, VehicleNo: "粤A12345"}); err == nil || errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("expected awaited request to fail without queueing, got %v", err)
+	}
+	video, err := buildSubBusinessBody("粤A12345", jtt809.PlateColorBlue, jtt809.DOWN_REALVIDEO_MSG_STARTUP, nil)
+	if err != nil {
+		t.Fatalf("build video body: %v", err)
+	}
+	if err := g.SendToSubordinate(1, header, rawBody{msgID: jtt809.DOWN_REALVIDEO_MSG, payload: video}); err == nil {
+		t.Fatal("expected real video request not queued")
+	}
+	if n := g.QueuedOutbound()[1]; n != 3 {
+		t.Fatalf("expected 3 queued, got %d", n)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+		t.Fatal("connect sub link failed")
+	}
+	// 0x9001 登录后按顺序补发未过期的两条 0x9200
+	for _, want := range []uint16{jtt809.DOWN_CONNECT_REQ, jtt809.DOWN_EXG_MSG, jtt809.DOWN_EXG_MSG} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected 0x%04X, got 0x%04X", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 0x%04X", want)
+		}
+	}
+	waitFor(t, func() bool { return g.QueuedOutbound()[1] == 0 })
+
+	for {
+		select {
+		case evt := <-events:
+			if evt.Type != EventOutboundDropped {
+				continue
+			}
+			data := evt.Data.(OutboundDroppedEventData)
+			if data.SubID != jtt809.DOWN_EXG_MSG_RETURN_END || data.Reason != OutboundDropExpired || evt.VehicleNo != "粤A12345" {
+				t.Fatalf("unexpected drop event %+v %+v", evt, data)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("expected outbound_dropped event")
+		}
+	}
+}
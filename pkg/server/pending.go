@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+var (
+	// ErrRequestTimeout 表示在时限内未收到下级平台应答。
+	ErrRequestTimeout = errors.New("request timed out waiting for reply")
+	// ErrRequestAborted 表示等待应答期间平台注销或被顶替，请求不会再收到应答。
+	ErrRequestAborted = errors.New("request aborted: platform logged out")
+)
+
+// defaultRequestTimeout 为 ctx 未设置截止时间时 SendAndWait 的等待时限。
+const defaultRequestTimeout = 30 * time.Second
+
+// pendingRequest 为一条等待应答的下行请求。
+type pendingRequest struct {
+	userID   uint32
+	reqSub   uint16 // 请求子业务类型
+	replySub uint16 // 期望的应答子业务类型
+	msgSN    uint32 // 请求报文序列号
+	plate    string
+	color    jtt809.PlateColor
+	reply    chan any
+}
+
+// replyInfo 描述收到的应答，应答携带 SourceDataType/SourceMsgSN 时 hasSource 为 true。
+type replyInfo struct {
+	userID    uint32
+	subID     uint16
+	plate     string
+	color     jtt809.PlateColor
+	hasSource bool
+	sourceSub uint16
+	sourceSN  uint32
+}
+
+// pendingRequests 按平台登记等待应答的下行请求。
+type pendingRequests struct {
+	mu    sync.Mutex
+	items map[uint32][]*pendingRequest
+}
+
+func (p *pendingRequests) add(req *pendingRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.items == nil {
+		p.items = make(map[uint32][]*pendingRequest)
+	}
+	p.items[req.userID] = append(p.items[req.userID], req)
+}
+
+func (p *pendingRequests) remove(req *pendingRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(req)
+}
+
+func (p *pendingRequests) removeLocked(req *pendingRequest) {
+	list := p.items[req.userID]
+	for i, r := range list {
+		if r == req {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(p.items, req.userID)
+		return
+	}
+	p.items[req.userID] = list
+}
+
+// resolve 将应答交给匹配的请求：携带源报文序列号时按序列号匹配，否则（或序列号未命中时）
+// 按应答子业务类型与车牌匹配最早发出的请求。返回是否命中。
+func (p *pendingRequests) resolve(info replyInfo, value any) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var matched *pendingRequest
+	list := p.items[info.userID]
+	if info.hasSource {
+		for _, r := range list {
+			if r.msgSN == info.sourceSN && r.reqSub == info.sourceSub {
+				matched = r
+				break
+			}
+		}
+	}
+	if matched == nil {
+		for _, r := range list {
+			if r.replySub == info.subID && r.plate == info.plate && r.color == info.color {
+				matched = r
+				break
+			}
+		}
+	}
+	if matched == nil {
+		return false
+	}
+	p.removeLocked(matched)
+	matched.reply <- value
+	return true
+}
+
+// abort 以 err 结束平台全部等待中的请求，返回结束的请求数。
+func (p *pendingRequests) abort(userID uint32, err error) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := p.items[userID]
+	delete(p.items, userID)
+	for _, r := range list {
+		r.reply <- err
+	}
+	return len(list)
+}
+
+// counts 返回各平台等待应答的请求数。
+func (p *pendingRequests) counts() map[uint32]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[uint32]int, len(p.items))
+	for uid, list := range p.items {
+		out[uid] = len(list)
+	}
+	return out
+}
+
+// SendAndWait 向下级平台发送子业务请求并等待 replySubID 对应的应答，返回解析后的应答：
+// 0x1205/0x1206 为 *jtt809.MonitorAck，0x1801 为 *VideoAckState。
+// 应答携带 SourceDataType/SourceMsgSN 时按请求报文序列号匹配，否则按平台、应答子业务类型与车牌匹配。
+// ctx 未设置截止时间时最长等待 30s，超时返回 ErrRequestTimeout；等待期间平台注销或被顶替时返回 ErrRequestAborted。
+func (g *JT809Gateway) SendAndWait(ctx context.Context, userID uint32, header jtt809.Header, body jtt809.Body, replySubID uint16) (any, error) {
+	msgID := body.MsgID()
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: header.WithResponse(msgID), Body: body})
+	if err != nil {
+		return nil, fmt.Errorf("encode package: %w", err)
+	}
+	// 报文序列号在编码时分配，从编码结果中取回
+	frame, err := jtt809.DecodeFrame(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode encoded package: %w", err)
+	}
+	sub, err := jtt809.ParseSubBusiness(frame.RawBody)
+	if err != nil {
+		return nil, fmt.Errorf("request is not a sub business message: %w", err)
+	}
+
+	req := &pendingRequest{
+		userID:   userID,
+		reqSub:   sub.SubBusinessID,
+		replySub: replySubID,
+		msgSN:    frame.Header.MsgSN,
+		plate:    sub.Plate,
+		color:    sub.Color,
+		reply:    make(chan any, 1),
+	}
+	g.pending.add(req)
+	defer g.pending.remove(req)
+
+	// 不进入暂存队列：链路不可用时立即失败，避免请求超时后报文仍在链路恢复时补发
+	if err := g.deliver(userID, msgID, data); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+	}
+	select {
+	case v := <-req.reply:
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.Warn("request reply timeout", "user_id", userID, "sub_id", fmt.Sprintf("0x%04X", req.reqSub), "msg_sn", req.msgSN, "plate", req.plate)
+			return nil, fmt.Errorf("%w: 0x%04X", ErrRequestTimeout, replySubID)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// PendingRequests 返回各平台通过 SendAndWait 发出、尚未收到应答的请求数。
+func (g *JT809Gateway) PendingRequests() map[uint32]int {
+	return g.pending.counts()
+}
+
+// resolveReply 将收到的应答交给等待中的请求，未命中时忽略。
+func (g *JT809Gateway) resolveReply(info replyInfo, value any) {
+	if g.pending.resolve(info, value) {
+		slog.Debug("request reply matched", "user_id", info.userID, "sub_id", fmt.Sprintf("0x%04X", info.subID), "plate", info.plate)
+	}
+}
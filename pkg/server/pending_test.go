@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// newPendingTestGateway 返回从链路已连接到 fakeSubServer 的网关。
+func newPendingTestGateway(t *testing.T) *JT809Gateway {
+	t.Helper()
+	port, _ := fakeSubServer(t, true)
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics()}
+	g.closing.Store(true) // 测试中不触发从链路重连
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+	if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+		t.Fatal("connect sub link failed")
+	}
+	return g
+}
+
+// monitorAckFrame 构造下级平台的 0x1205 应答帧。
+func monitorAckFrame(t *testing.T, plate string, sourceSN uint32) *jtt809.Frame {
+	t.Helper()
+	payload := make([]byte, 10)
+	binary.BigEndian.PutUint16(payload[0:2], jtt809.DOWN_EXG_MSG_RETURN_STARTUP)
+	binary.BigEndian.PutUint32(payload[2:6], sourceSN)
+	body, err := buildSubBusinessBody(plate, jtt809.PlateColorBlue, jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK, payload)
+	if err != nil {
+		t.Fatalf("build body: %v", err)
+	}
+	return &jtt809.Frame{BodyID: jtt809.UP_EXG_MSG, RawBody: body}
+}
+
+func TestSendAndWait(t *testing.T) {
+	g := newPendingTestGateway(t)
+	send := func(plate string) chan *jtt809.MonitorAck {
+		done := make(chan *jtt809.MonitorAck, 1)
+		go func() {
+			ack, err := g.RequestMonitorStartupAndWait(context.Background(), MonitorRequest{UserID: 1, VehicleNo: plate})
+			if err != nil {
+				t.Errorf("wait %s: %v", plate, err)
+			}
+			done <- ack
+		}()
+		return done
+	}
+	first := send("粤A12345")
+	waitFor(t, func() bool { return g.PendingRequests()[1] == 1 })
+	second := send("粤A12345")
+	waitFor(t, func() bool { return g.PendingRequests()[1] == 2 })
+
+	// 按源报文序列号匹配第二个请求
+	g.pending.mu.Lock()
+	sn := g.pending.items[1][1].msgSN
+	g.pending.mu.Unlock()
+	g.handleBusinessMessage(1, monitorAckFrame(t, "粤A12345", sn), true)
+	select {
+	case <-first:
+		t.Fatal("reply matched by msg sn delivered to wrong request")
+	case ack := <-second:
+		if ack == nil || ack.SourceMsgSN != sn {
+			t.Fatalf("unexpected ack %+v", ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected reply for second request")
+	}
+
+	// 序列号不匹配时按车牌匹配
+	g.handleBusinessMessage(1, monitorAckFrame(t, "粤A12345", 0), true)
+	select {
+	case ack := <-first:
+		if ack == nil {
+			t.Fatal("expected ack for first request")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected reply matched by plate")
+	}
+	if n := g.PendingRequests()[1]; n != 0 {
+		t.Fatalf("expected no pending requests, got %d", n)
+	}
+}
+
+func TestSendAndWaitTimeout(t *testing.T) {
+	g := newPendingTestGateway(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := g.RequestMonitorStartupAndWait(ctx, MonitorRequest{UserID: 1, VehicleNo: "粤A12345"})
+	if !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	if n := g.PendingRequests()[1]; n != 0 {
+		t.Fatalf("expected timed out request removed, got %d pending", n)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// Persistence 定义平台状态的持久化接口，PlatformStore 通过它在重启后恢复车辆与鉴权数据。
+// 实现方只需保证同一 userID 的写入按调用顺序生效，链路与会话信息不会传入。
+type Persistence interface {
+	// LoadPlatforms 读取全部已持久化的平台状态。
+	LoadPlatforms() ([]PersistedPlatform, error)
+	// SavePlatform 覆盖保存单个平台状态。
+	SavePlatform(p PersistedPlatform) error
+	// DeletePlatform 删除单个平台状态，不存在时不返回错误。
+	DeletePlatform(userID uint32) error
+	// Close 释放底层资源。
+	Close() error
+}
+
+// PersistedPlatform 是平台状态中需要跨重启保留的部分。
+// 主/从链路、心跳时间、校验码等会话字段不在其中，恢复后一律视为离线。
+type PersistedPlatform struct {
+	UserID       uint32             `json:"user_id"`
+	GNSSCenterID uint32             `json:"gnss_center_id"`
+	PlatformID   string             `json:"platform_id,omitempty"`
+	AuthCode     string             `json:"auth_code,omitempty"`
+	Vehicles     []PersistedVehicle `json:"vehicles"`
+	SavedAt      time.Time          `json:"saved_at"`
+}
+
+// PersistedVehicle 是单车需要持久化的状态。
+type PersistedVehicle struct {
+	Number       string                  `json:"vehicle_no"`
+	Color        jtt809.PlateColor       `json:"vehicle_color"`
+	Registration *VehicleRegistration    `json:"registration,omitempty"`
+	Position     *jtt809.VehiclePosition `json:"position,omitempty"`
+	PositionTime time.Time               `json:"position_time,omitempty"`
+	BatchCount   int                     `json:"batch_count,omitempty"`
+	LastVideoAck *VideoAckState          `json:"video_ack,omitempty"`
+}
+
+// FilePersistence 将每个平台状态保存为目录下的独立 JSON 文件，无需外部数据库。
+// 写入采用临时文件 + rename，避免进程异常退出时留下半个文件。
+type FilePersistence struct {
+	dir string
+	mu  sync.Mutex
+}
+
+const platformFilePrefix = "platform_"
+
+// NewFilePersistence 创建基于本地目录的持久化实现，目录不存在时自动创建。
+func NewFilePersistence(dir string) (*FilePersistence, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, errors.New("persistence dir must not be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create persistence dir: %w", err)
+	}
+	return &FilePersistence{dir: dir}, nil
+}
+
+// LoadPlatforms 读取目录下全部平台文件，单个文件损坏时跳过并返回其余结果。
+func (f *FilePersistence) LoadPlatforms() ([]PersistedPlatform, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read persistence dir: %w", err)
+	}
+	result := make([]PersistedPlatform, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, platformFilePrefix) || filepath.Ext(name) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read %s: %w", name, err))
+			continue
+		}
+		var p PersistedPlatform
+		if err := json.Unmarshal(data, &p); err != nil {
+			errs = append(errs, fmt.Errorf("decode %s: %w", name, err))
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, errors.Join(errs...)
+}
+
+// SavePlatform 原子地覆盖平台文件。
+func (f *FilePersistence) SavePlatform(p PersistedPlatform) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("encode platform %d: %w", p.UserID, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeFileAtomic(f.platformPath(p.UserID), data)
+}
+
+// DeletePlatform 删除平台文件。
+func (f *FilePersistence) DeletePlatform(userID uint32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(f.platformPath(userID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete platform %d: %w", userID, err)
+	}
+	return nil
+}
+
+// Close 文件实现无需释放资源。
+func (f *FilePersistence) Close() error {
+	return nil
+}
+
+func (f *FilePersistence) platformPath(userID uint32) string {
+	return filepath.Join(f.dir, platformFilePrefix+strconv.FormatUint(uint64(userID), 10)+".json")
+}
+
+// writeFileAtomic 先写临时文件并 fsync，再 rename 覆盖目标文件。
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
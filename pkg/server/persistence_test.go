@@ -0,0 +1,124 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestPlatformStorePersistenceRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewFilePersistence(dir)
+	if err != nil {
+		t.Fatalf("new file persistence: %v", err)
+	}
+	store := NewPlatformStore()
+	if err := store.AttachPersistence(p); err != nil {
+		t.Fatalf("attach persistence: %v", err)
+	}
+
+	store.BindMainSession("s1", jtt809.LoginRequest{UserID: 10001, DownLinkIP: "127.0.0.1", DownLinkPort: 9000}, 0x1357, 42)
+	store.UpdateAuthCode(10001, "PLAT01", "AUTH01")
+	store.UpdateVehicleRegistration(10001, jtt809.PlateColorBlue, "粤B12345", &VehicleRegistration{PlatformID: "PLAT01", TerminalID: "T1"})
+	store.UpdateLocation(10001, jtt809.PlateColorBlue, "粤B12345", &jtt809.VehiclePosition{GnssData: []byte{1, 2, 3}}, 0)
+	store.RecordVideoAck(10001, jtt809.PlateColorBlue, "粤B12345", &VideoAckState{ServerIP: "10.0.0.1", ServerPort: 7000})
+	store.UpdateAuthCode(20002, "PLAT02", "AUTH02")
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	store.RemovePlatform(20002)
+	if err := store.ClosePersistence(); err != nil {
+		t.Fatalf("close persistence: %v", err)
+	}
+
+	p2, err := NewFilePersistence(dir)
+	if err != nil {
+		t.Fatalf("reopen file persistence: %v", err)
+	}
+	restored := NewPlatformStore()
+	if err := restored.AttachPersistence(p2); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, ok := restored.Snapshot(20002); ok {
+		t.Fatalf("removed platform should not be restored")
+	}
+	snap, ok := restored.Snapshot(10001)
+	if !ok {
+		t.Fatalf("platform 10001 not restored")
+	}
+	if snap.MainSessionID != "" || snap.SubConnected || snap.VerifyCode != 0 || snap.DownLinkIP != "" {
+		t.Fatalf("link state must be treated as stale: %+v", snap)
+	}
+	if snap.GNSSCenterID != 0x1357 {
+		t.Fatalf("unexpected gnss center id: %d", snap.GNSSCenterID)
+	}
+	if _, code := restored.GetAuthCode(10001); code != "AUTH01" {
+		t.Fatalf("unexpected auth code: %q", code)
+	}
+	if len(snap.Vehicles) != 1 {
+		t.Fatalf("unexpected vehicle count: %d", len(snap.Vehicles))
+	}
+	v := snap.Vehicles[0]
+	if v.VehicleNo != "粤B12345" || v.Registration == nil || v.Registration.TerminalID != "T1" {
+		t.Fatalf("registration not restored: %+v", v)
+	}
+	if v.Position == nil || len(v.Position.GnssData) != 3 || v.PositionTime.IsZero() {
+		t.Fatalf("position not restored: %+v", v)
+	}
+	if v.LastVideoAck == nil || v.LastVideoAck.ServerPort != 7000 {
+		t.Fatalf("video ack not restored: %+v", v.LastVideoAck)
+	}
+}
+
+// memoryPersistence 为测试提供预置的持久化状态。
+type memoryPersistence []PersistedPlatform
+
+func (m memoryPersistence) LoadPlatforms() ([]PersistedPlatform, error) { return m, nil }
+func (memoryPersistence) SavePlatform(PersistedPlatform) error          { return nil }
+func (memoryPersistence) DeletePlatform(uint32) error                   { return nil }
+func (memoryPersistence) Close() error                                  { return nil }
+
+func TestRestoredVehiclesSurviveLongRestart(t *testing.T) {
+	// 停机 2 小时，超过淘汰时长与注册保留时长
+	stoppedAt := time.Now().Add(-2 * time.Hour)
+	manual := false
+	g := &JT809Gateway{
+		cfg:     Config{VehiclePolicy: VehiclePolicy{EvictAfter: 10 * time.Minute, RegistrationExpiry: 10 * time.Minute, AutoSubscribe: &manual}},
+		store:   NewPlatformStore(),
+		metrics: newGatewayMetrics(),
+	}
+	g.closing.Store(true)
+	if err := g.store.AttachPersistence(memoryPersistence{{
+		UserID: 1,
+		Vehicles: []PersistedVehicle{
+			{Number: "粤B00001", Color: jtt809.PlateColorBlue, Position: &jtt809.VehiclePosition{}, PositionTime: stoppedAt},
+			{Number: "粤B00002", Color: jtt809.PlateColorBlue, Registration: &VehicleRegistration{TerminalID: "T2", ReceivedAt: stoppedAt}},
+		},
+	}}); err != nil {
+		t.Fatalf("attach persistence: %v", err)
+	}
+
+	// 下级平台重新登录后首次健康检查不删除恢复的车辆
+	port, received := fakeSubServer(t, true)
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+	if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+		t.Fatal("connect sub link failed")
+	}
+	<-received
+	g.checkVehiclePositions()
+	if snap, _ := g.store.Snapshot(1); len(snap.Vehicles) != 2 {
+		t.Fatalf("expected restored vehicles kept, got %+v", snap.Vehicles)
+	}
+
+	// 恢复后仍未上报，超过时长后照常删除
+	g.store.mu.Lock()
+	for _, v := range g.store.platforms[1].Vehicles {
+		v.RestoredAt = time.Now().Add(-11 * time.Minute)
+	}
+	g.store.mu.Unlock()
+	g.checkVehiclePositions()
+	if snap, _ := g.store.Snapshot(1); len(snap.Vehicles) != 0 {
+		t.Fatalf("expected stale vehicles evicted, got %+v", snap.Vehicles)
+	}
+}
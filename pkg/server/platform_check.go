@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// PlatformCheckRequest 表示平台查岗请求（0x9301）。
+type PlatformCheckRequest struct {
+	UserID     uint32 `json:"user_id"`
+	ObjectType byte   `json:"object_type"` // 1=当前连接的下级平台,2=下级平台所属单一业户,3=下级平台所属所有业户，默认 1
+	ObjectID   string `json:"object_id"`   // 为空时取下级平台唯一编码
+	Content    string `json:"content"`     // 查岗问题
+}
+
+var platformCheckInfoID atomic.Uint32
+
+// SendPlatformCheck 向下级平台下发平台查岗请求，并计入查岗响应率统计，返回本次查岗的信息 ID。
+func (g *JT809Gateway) SendPlatformCheck(req PlatformCheckRequest) (uint32, error) {
+	if req.Content == "" {
+		return 0, errors.New("content is required")
+	}
+	if req.ObjectType == 0 {
+		req.ObjectType = 0x01
+	}
+	snap, err := g.sendableSnapshot(req.UserID)
+	if err != nil {
+		return 0, err
+	}
+	if snap.GNSSCenterID == 0 {
+		return 0, fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
+	}
+	if req.ObjectID == "" {
+		req.ObjectID = snap.PlatformID
+	}
+	infoID := platformCheckInfoID.Add(1)
+	body := jtt809.PlatformQueryRequest{
+		ObjectType:  req.ObjectType,
+		ObjectID:    req.ObjectID,
+		InfoID:      infoID,
+		InfoContent: req.Content,
+	}
+	header := jtt809.Header{
+		GNSSCenterID: snap.GNSSCenterID,
+	}
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: header.WithResponse(body.MsgID()), Body: body})
+	if err != nil {
+		return 0, fmt.Errorf("encode platform check: %w", err)
+	}
+	// 报文序列号在编码时分配，从编码结果中取回，用于匹配 0x1301 应答
+	frame, err := jtt809.DecodeFrame(data)
+	if err != nil {
+		return 0, fmt.Errorf("decode encoded platform check: %w", err)
+	}
+	if err := g.sendEncoded(req.UserID, body.MsgID(), jtt809.DOWN_PLATFORM_MSG_POST_QUERY_REQ, data); err != nil {
+		return 0, fmt.Errorf("send platform check: %w", err)
+	}
+	g.report.RecordCheckSent(req.UserID, req.ObjectID, infoID, frame.Header.MsgSN, time.Now())
+	slog.Info("platform check sent", "user_id", req.UserID, "object_id", req.ObjectID, "info_id", infoID, "msg_sn", frame.Header.MsgSN)
+	return infoID, nil
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// QualityIssue 表示定位数据质量问题类型。
+type QualityIssue string
+
+const (
+	QualityMalformed         QualityIssue = "malformed"          // GNSS 数据无法解析（含 BCD 时间非法）
+	QualityInvalidTime       QualityIssue = "invalid_time"       // 定位时间缺失或非法
+	QualityFutureTime        QualityIssue = "future_time"        // 定位时间超前于报文时间
+	QualityStaleTime         QualityIssue = "stale_time"         // 实时定位时间明显滞后于报文时间
+	QualityInvalidCoordinate QualityIssue = "invalid_coordinate" // 零坐标或超出中国境内范围
+	QualityInvalidDirection  QualityIssue = "invalid_direction"  // 方向超出 0-359
+	QualityPositionJump      QualityIssue = "position_jump"      // 相邻点推算速度超出上限（漂移）
+	QualityIntervalGap       QualityIssue = "interval_gap"       // 上报时间间隔超出阈值
+	QualityMileageAbnormal   QualityIssue = "mileage_abnormal"   // 里程回退或与位移不符
+)
+
+// WarnType 返回质量问题对应的报警类型。
+func (q QualityIssue) WarnType() jtt809.WarnType {
+	switch q {
+	case QualityIntervalGap:
+		return jtt809.WarnTypeUploadIntervalAbnormal
+	case QualityMileageAbnormal:
+		return jtt809.WarnTypeUploadMileageAbnormal
+	}
+	return jtt809.WarnTypeDynamicInfoAbnormal
+}
+
+// invalidating 判断问题是否使定位点不合格。上报间隔异常反映的是上一段缺失，不影响当前点本身。
+func (q QualityIssue) invalidating() bool {
+	return q != QualityIntervalGap
+}
+
+// QualityConfig 定位数据质量检查参数。
+type QualityConfig struct {
+	// MaxFutureSkew 定位时间允许超前报文时间的最大值
+	MaxFutureSkew time.Duration `json:"max_future_skew"`
+	// MaxStaleness 实时定位时间允许滞后报文时间的最大值，补报数据不检查
+	MaxStaleness time.Duration `json:"max_staleness"`
+	// MaxImpliedSpeed 相邻点位移推算速度上限（km/h）
+	MaxImpliedSpeed float64 `json:"max_implied_speed"`
+	// MaxUploadInterval 相邻定位点最大上报间隔，超过视为轨迹断点
+	MaxUploadInterval time.Duration `json:"max_upload_interval"`
+	// MileageTolerance 里程增量与位移比较时允许的误差（km）
+	MileageTolerance float64 `json:"mileage_tolerance"`
+}
+
+// DefaultQualityConfig 返回默认检查参数。
+func DefaultQualityConfig() QualityConfig {
+	return QualityConfig{
+		MaxFutureSkew:     5 * time.Minute,
+		MaxStaleness:      10 * time.Minute,
+		MaxImpliedSpeed:   200,
+		MaxUploadInterval: 2 * time.Minute,
+		MileageTolerance:  1,
+	}
+}
+
+// QualityEvent 表示一个被标记的定位点。
+type QualityEvent struct {
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+	Source       TrackSource       `json:"source"`
+	Time         time.Time         `json:"time,omitempty"` // 定位时间，数据无法解析时为零值
+	Longitude    float64           `json:"longitude"`
+	Latitude     float64           `json:"latitude"`
+	Issues       []QualityIssue    `json:"issues"`
+	Qualified    bool              `json:"qualified"` // 仅有上报间隔异常时该点本身仍合格
+}
+
+// QualityStats 表示单个下级平台的数据质量统计。
+// 数据合格率 = 合格点数 / 总点数；轨迹完整率 = (总里程 - 断点里程) / 总里程，里程由相邻点位移累计。
+type QualityStats struct {
+	UserID            uint32                  `json:"user_id"`
+	TotalPoints       uint64                  `json:"total_points"`
+	QualifiedPoints   uint64                  `json:"qualified_points"`
+	Issues            map[QualityIssue]uint64 `json:"issues"`
+	TotalDistance     float64                 `json:"total_distance"` // 米
+	GapDistance       float64                 `json:"gap_distance"`   // 米
+	QualifiedRate     float64                 `json:"qualified_rate"`
+	TrackCompleteness float64                 `json:"track_completeness"`
+	Since             time.Time               `json:"since"`
+}
+
+// QualityMonitor 按车辆检查定位点质量，按平台累计统计。
+type QualityMonitor struct {
+	mu       sync.Mutex
+	cfg      QualityConfig
+	vehicles map[string]*qualityState // platformVehicleKey -> 连续性状态
+	stats    map[uint32]*QualityStats
+}
+
+type qualityState struct {
+	time    time.Time
+	pos     GeoPoint
+	mileage uint32
+}
+
+// NewQualityMonitor 创建数据质量检查器。
+func NewQualityMonitor(cfg QualityConfig) *QualityMonitor {
+	return &QualityMonitor{
+		cfg:      cfg,
+		vehicles: make(map[string]*qualityState),
+		stats:    make(map[uint32]*QualityStats),
+	}
+}
+
+// Check 检查一个定位点并计入平台统计，gnss 为 nil 表示数据无法解析。
+// ref 为报文头时间，用于判断定位时间超前或滞后；存在问题时返回事件，否则返回 nil。
+func (m *QualityMonitor) Check(userID uint32, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, source TrackSource, ref time.Time) *QualityEvent {
+	evt, _, _ := m.check(userID, plate, color, gnss, source, ref)
+	if len(evt.Issues) == 0 {
+		return nil
+	}
+	return evt
+}
+
+// check 执行检查，始终返回事件（Issues 为空表示无问题），同时返回该点计入的有效里程与断点里程（米）。
+func (m *QualityMonitor) check(userID uint32, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, source TrackSource, ref time.Time) (evt *QualityEvent, distance, gapDistance float64) {
+	evt = &QualityEvent{UserID: userID, VehicleNo: plate, VehicleColor: color, Source: source}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.statsLocked(userID)
+	stats.TotalPoints++
+	if gnss == nil {
+		evt.Issues = []QualityIssue{QualityMalformed}
+		m.countLocked(stats, evt)
+		return evt, 0, 0
+	}
+
+	cfg := m.cfg
+	evt.Longitude, evt.Latitude = gnss.Longitude, gnss.Latitude
+	t := gnss.DateTime.Time()
+	if t.IsZero() || gnss.DateTime.Hour > 23 || gnss.DateTime.Minute > 59 || gnss.DateTime.Second > 59 {
+		evt.Issues = append(evt.Issues, QualityInvalidTime)
+		t = time.Time{}
+	} else {
+		evt.Time = t
+		if !ref.IsZero() {
+			if cfg.MaxFutureSkew > 0 && t.Sub(ref) > cfg.MaxFutureSkew {
+				evt.Issues = append(evt.Issues, QualityFutureTime)
+			}
+			if source == TrackSourceRealtime && cfg.MaxStaleness > 0 && ref.Sub(t) > cfg.MaxStaleness {
+				evt.Issues = append(evt.Issues, QualityStaleTime)
+			}
+		}
+	}
+	pos := GeoPoint{Longitude: gnss.Longitude, Latitude: gnss.Latitude}
+	validPos := inChina(pos)
+	if !validPos {
+		evt.Issues = append(evt.Issues, QualityInvalidCoordinate)
+	}
+	if gnss.Direction > 359 {
+		evt.Issues = append(evt.Issues, QualityInvalidDirection)
+	}
+
+	// 连续性检查仅针对时间和坐标有效、且晚于上一个有效点的数据，早到的补报点只做单点检查
+	key := platformVehicleKey(userID, plate, color)
+	last := m.vehicles[key]
+	if !t.IsZero() && validPos && (last == nil || t.After(last.time)) {
+		if last != nil {
+			dt := t.Sub(last.time)
+			dist := distanceMeters(last.pos, pos)
+			jump := cfg.MaxImpliedSpeed > 0 && dist/1000/dt.Hours() > cfg.MaxImpliedSpeed
+			if jump {
+				evt.Issues = append(evt.Issues, QualityPositionJump)
+			}
+			gap := cfg.MaxUploadInterval > 0 && dt > cfg.MaxUploadInterval
+			if gap {
+				evt.Issues = append(evt.Issues, QualityIntervalGap)
+			}
+			if last.mileage != 0 && gnss.Mileage != 0 {
+				delta := (float64(gnss.Mileage) - float64(last.mileage)) / 10
+				if delta < 0 || delta > dist/1000*2+cfg.MileageTolerance {
+					evt.Issues = append(evt.Issues, QualityMileageAbnormal)
+				}
+			}
+			if !jump {
+				distance = dist
+				if gap {
+					gapDistance = dist
+				}
+				stats.TotalDistance += distance
+				stats.GapDistance += gapDistance
+			}
+		}
+		if !hasInvalidating(evt.Issues) {
+			m.vehicles[key] = &qualityState{time: t, pos: pos, mileage: gnss.Mileage}
+		}
+	}
+	m.countLocked(stats, evt)
+	return evt, distance, gapDistance
+}
+
+// Stats 返回各平台的数据质量统计，按 UserID 排序。
+func (m *QualityMonitor) Stats() []QualityStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]QualityStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		result = append(result, s.snapshot())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result
+}
+
+// PlatformStats 返回指定平台的数据质量统计。
+func (m *QualityMonitor) PlatformStats(userID uint32) (QualityStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[userID]
+	if !ok {
+		return QualityStats{}, false
+	}
+	return s.snapshot(), true
+}
+
+// Reset 清空统计并返回清空前的数据，用于按统计周期（如自然日）出具考核数据。车辆连续性状态保留。
+func (m *QualityMonitor) Reset(now time.Time) []QualityStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]QualityStats, 0, len(m.stats))
+	for uid, s := range m.stats {
+		result = append(result, s.snapshot())
+		m.stats[uid] = newQualityStats(uid, now)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result
+}
+
+// forget 清除车辆的连续性状态，用于车辆被淘汰后释放内存。
+func (m *QualityMonitor) forget(userID uint32, plate string, color jtt809.PlateColor) {
+	m.mu.Lock()
+	delete(m.vehicles, platformVehicleKey(userID, plate, color))
+	m.mu.Unlock()
+}
+
+func (m *QualityMonitor) statsLocked(userID uint32) *QualityStats {
+	s, ok := m.stats[userID]
+	if !ok {
+		s = newQualityStats(userID, time.Now())
+		m.stats[userID] = s
+	}
+	return s
+}
+
+func (m *QualityMonitor) countLocked(stats *QualityStats, evt *QualityEvent) {
+	for _, issue := range evt.Issues {
+		stats.Issues[issue]++
+	}
+	evt.Qualified = !hasInvalidating(evt.Issues)
+	if evt.Qualified {
+		stats.QualifiedPoints++
+	}
+}
+
+func newQualityStats(userID uint32, since time.Time) *QualityStats {
+	return &QualityStats{UserID: userID, Issues: make(map[QualityIssue]uint64), Since: since}
+}
+
+func (s *QualityStats) snapshot() QualityStats {
+	cp := *s
+	cp.Issues = make(map[QualityIssue]uint64, len(s.Issues))
+	for k, v := range s.Issues {
+		cp.Issues[k] = v
+	}
+	cp.QualifiedRate, cp.TrackCompleteness = 1, 1
+	if s.TotalPoints > 0 {
+		cp.QualifiedRate = float64(s.QualifiedPoints) / float64(s.TotalPoints)
+	}
+	if s.TotalDistance > 0 {
+		cp.TrackCompleteness = (s.TotalDistance - s.GapDistance) / s.TotalDistance
+	}
+	return cp
+}
+
+func hasInvalidating(issues []QualityIssue) bool {
+	for _, q := range issues {
+		if q.invalidating() {
+			return true
+		}
+	}
+	return false
+}
+
+// inChina 粗略判断坐标是否位于中国境内经纬度范围（含近海），零坐标视为无效。
+func inChina(p GeoPoint) bool {
+	if math.Abs(p.Longitude) < 1e-6 && math.Abs(p.Latitude) < 1e-6 {
+		return false
+	}
+	return p.Longitude >= 73.5 && p.Longitude <= 135.1 && p.Latitude >= 3.8 && p.Latitude <= 53.6
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestQualityMonitorChecks(t *testing.T) {
+	m := NewQualityMonitor(DefaultQualityConfig())
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	check := func(g *jtt809.GNSSData, ref time.Time) *QualityEvent {
+		return m.Check(1, "粤B12345", jtt809.PlateColorBlue, g, TrackSourceRealtime, ref)
+	}
+
+	first := gnssAt(114.05, 22.54, base)
+	first.Mileage = 1000
+	if evt := check(first, base); evt != nil {
+		t.Fatalf("expected valid point, got %+v", evt.Issues)
+	}
+
+	// 30 秒后位移约 10km，推算速度超限
+	jump := gnssAt(114.15, 22.54, base.Add(30*time.Second))
+	jump.Mileage = 1001
+	evt := check(jump, base.Add(30*time.Second))
+	if evt == nil || !slices.Contains(evt.Issues, QualityPositionJump) || evt.Qualified {
+		t.Fatalf("expected position jump, got %+v", evt)
+	}
+
+	// 断点 5 分钟后正常位移，仅上报间隔异常，点本身合格
+	gap := gnssAt(114.051, 22.54, base.Add(5*time.Minute))
+	gap.Mileage = 1001
+	evt = check(gap, base.Add(5*time.Minute))
+	if evt == nil || len(evt.Issues) != 1 || evt.Issues[0] != QualityIntervalGap || !evt.Qualified {
+		t.Fatalf("expected interval gap only, got %+v", evt)
+	}
+
+	// 里程回退、方向非法、定位时间超前
+	bad := gnssAt(114.052, 22.54, base.Add(20*time.Minute))
+	bad.Mileage = 900
+	bad.Direction = 400
+	evt = check(bad, base.Add(5*time.Minute+30*time.Second))
+	for _, want := range []QualityIssue{QualityMileageAbnormal, QualityInvalidDirection, QualityFutureTime} {
+		if evt == nil || !slices.Contains(evt.Issues, want) {
+			t.Fatalf("expected %s, got %+v", want, evt)
+		}
+	}
+
+	if evt := check(gnssAt(0, 0, base.Add(6*time.Minute)), base.Add(6*time.Minute)); evt == nil || !slices.Contains(evt.Issues, QualityInvalidCoordinate) {
+		t.Fatalf("expected invalid coordinate, got %+v", evt)
+	}
+	if evt := check(nil, base); evt == nil || evt.Issues[0] != QualityMalformed {
+		t.Fatalf("expected malformed, got %+v", evt)
+	}
+
+	stats, ok := m.PlatformStats(1)
+	if !ok {
+		t.Fatalf("stats missing")
+	}
+	if stats.TotalPoints != 6 || stats.QualifiedPoints != 2 {
+		t.Fatalf("unexpected counts: total=%d qualified=%d", stats.TotalPoints, stats.QualifiedPoints)
+	}
+	if stats.TrackCompleteness != 0 || stats.Issues[QualityIntervalGap] != 2 {
+		t.Fatalf("unexpected completeness %.2f issues %+v", stats.TrackCompleteness, stats.Issues)
+	}
+}
@@ -0,0 +1,643 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	goserver "github.com/zboyco/go-server"
+	"github.com/zboyco/go-server/client"
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// 级联上级平台链路，用于报文中间件与指标的 link 标签
+const (
+	relayMainLink = "up_main"
+	relaySubLink  = "up_sub"
+)
+
+// relayLoginTimeout 为连接上级平台主链路并等待登录应答的时限。
+const relayLoginTimeout = 10 * time.Second
+
+// RelayConfig 级联转发参数：本平台作为下级平台接入上级平台，将选定下级平台的车辆数据转发给上级，
+// 并将上级下发的车辆请求转交车属平台。修改后需重启生效。
+type RelayConfig struct {
+	// Address 上级平台主链路地址 host:port
+	Address string `yaml:"address"`
+	// UserID、Password 本平台在上级平台的登录账号
+	UserID   uint32 `yaml:"user_id"`
+	Password string `yaml:"password"`
+	// GNSSCenterID 本平台在上级平台的接入码，转发的报文以此重新编码
+	GNSSCenterID uint32 `yaml:"gnss_center_id"`
+	// SubListen 从链路监听地址，上级平台连接该地址建立从链路
+	SubListen string `yaml:"sub_listen"`
+	// DownLinkIP 登录时上报的从链路 IP，为空时使用 SubListen 的主机部分
+	DownLinkIP string `yaml:"down_link_ip"`
+	// Accounts 转发的下级平台账号，为空时转发全部账号
+	Accounts []uint32 `yaml:"accounts"`
+	// HeartbeatInterval 主链路心跳间隔，默认 60s；超过 3 倍间隔未收到上级平台报文时断开重连
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// ReconnectDelay 主链路断开或登录失败后的重连间隔，默认 10s
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+	// RequestTimeout 上级平台请求等待车属平台应答的时限，超时后的应答不再回传，默认 60s
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+}
+
+func (c RelayConfig) withDefaults() RelayConfig {
+	if c.DownLinkIP == "" {
+		if host, _, err := net.SplitHostPort(c.SubListen); err == nil {
+			c.DownLinkIP = host
+		}
+	}
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = 60 * time.Second
+	}
+	if c.ReconnectDelay <= 0 {
+		c.ReconnectDelay = 10 * time.Second
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = 60 * time.Second
+	}
+	return c
+}
+
+func (c RelayConfig) validate() error {
+	if _, _, err := normalizeHostPort(c.Address); err != nil {
+		return fmt.Errorf("relay: address: %w", err)
+	}
+	if c.UserID == 0 || c.GNSSCenterID == 0 {
+		return errors.New("relay: user_id and gnss_center_id are required")
+	}
+	if len(c.Password) == 0 || len(c.Password) > 8 {
+		return errors.New("relay: password must be 1-8 characters")
+	}
+	if _, _, err := normalizeHostPort(c.SubListen); err != nil {
+		return fmt.Errorf("relay: sub_listen: %w", err)
+	}
+	if ip := net.ParseIP(c.DownLinkIP); ip == nil || ip.IsUnspecified() {
+		return errors.New("relay: down_link_ip is required when sub_listen has no specific host")
+	}
+	return nil
+}
+
+// relayUplinks 为转发给上级平台的子业务及其业务 ID。
+var relayUplinks = map[uint16]uint16{
+	jtt809.UP_EXG_MSG_REGISTER:         jtt809.UP_EXG_MSG,
+	jtt809.UP_EXG_MSG_REAL_LOCATION:    jtt809.UP_EXG_MSG,
+	jtt809.UP_EXG_MSG_HISTORY_LOCATION: jtt809.UP_EXG_MSG,
+	jtt809.UP_WARN_MSG_ADPT_INFO:       jtt809.UP_WARN_MSG,
+	jtt809.UP_WARN_MSG_INFORM_TIPS:     jtt809.UP_WARN_MSG,
+}
+
+// relayReplies 为上级平台请求子业务对应的应答子业务，车属平台的应答回传给上级平台。
+var relayReplies = map[uint16]uint16{
+	jtt809.DOWN_EXG_MSG_RETURN_STARTUP: jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK,
+	jtt809.DOWN_EXG_MSG_RETURN_END:     jtt809.UP_EXG_MSG_RETURN_END_ACK,
+	0x9209:                             0x1209, // 补发车辆定位信息请求
+	jtt809.DOWN_WARN_MSG_URGE_TODO_REQ: 0x1401, // 报警督办
+	jtt809.DOWN_REALVIDEO_MSG_STARTUP:  jtt809.UP_REALVIDEO_MSG_STARTUP_ACK,
+	0x9802:                             0x1802, // 主动请求停止实时音视频传输
+}
+
+// relayRoute 为一条已转交车属平台、等待应答的上级平台请求。
+type relayRoute struct {
+	userID   uint32
+	reqSub   uint16
+	replySub uint16
+	msgSN    uint32 // 转交车属平台的请求报文序列号
+	upSN     uint32 // 上级平台请求报文序列号
+	plate    string
+	color    jtt809.PlateColor
+	expires  time.Time
+}
+
+// relayRoutes 登记等待车属平台应答的上级平台请求。
+type relayRoutes struct {
+	mu    sync.Mutex
+	items []*relayRoute
+}
+
+func (p *relayRoutes) add(r *relayRoute) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = append(p.items, r)
+}
+
+// resolve 匹配车属平台的应答并移除对应请求，匹配规则同 SendAndWait；
+// 应答不含车牌（如 0x1401）时按平台与应答子业务类型匹配最早的请求。
+func (p *relayRoutes) resolve(info replyInfo, now time.Time) (*relayRoute, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = slices.DeleteFunc(p.items, func(r *relayRoute) bool { return now.After(r.expires) })
+	idx := -1
+	if info.hasSource {
+		idx = slices.IndexFunc(p.items, func(r *relayRoute) bool {
+			return r.userID == info.userID && r.msgSN == info.sourceSN && r.reqSub == info.sourceSub
+		})
+	}
+	if idx < 0 {
+		idx = slices.IndexFunc(p.items, func(r *relayRoute) bool {
+			return r.userID == info.userID && r.replySub == info.subID &&
+				(info.plate == "" || (r.plate == info.plate && r.color == info.color))
+		})
+	}
+	if idx < 0 {
+		return nil, false
+	}
+	r := p.items[idx]
+	p.items = slices.Delete(p.items, idx, idx+1)
+	return r, true
+}
+
+func (p *relayRoutes) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.items)
+}
+
+// relayClient 维护到上级平台的主链路与从链路监听。
+type relayClient struct {
+	g        *JT809Gateway
+	cfg      RelayConfig
+	accounts map[uint32]struct{}
+	routes   relayRoutes
+	lastRecv atomic.Int64 // 最后一次收到上级平台报文的时间（UnixNano）
+
+	ctx    context.Context // close 时取消，结束重连与链路处理
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	main       *client.SimpleClient // 登录成功后非 nil
+	sub        net.Conn             // 从链路登录成功后非 nil
+	listener   net.Listener
+	verifyCode uint32
+	closed     bool
+}
+
+func newRelayClient(g *JT809Gateway, cfg RelayConfig) (*relayClient, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	r := &relayClient{g: g, cfg: cfg}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	if len(cfg.Accounts) > 0 {
+		r.accounts = make(map[uint32]struct{}, len(cfg.Accounts))
+		for _, uid := range cfg.Accounts {
+			r.accounts[uid] = struct{}{}
+		}
+	}
+	return r, nil
+}
+
+// selected 判断是否转发该下级平台的数据。
+func (r *relayClient) selected(userID uint32) bool {
+	if r.accounts == nil {
+		return true
+	}
+	_, ok := r.accounts[userID]
+	return ok
+}
+
+// run 监听从链路并保持主链路登录，断开后按 ReconnectDelay 重连，直至 close。
+// 停机时由 close 先发送注销请求再断开链路，因此不随 Start 的 ctx 结束。
+func (r *relayClient) run() {
+	ln, err := net.Listen("tcp", r.cfg.SubListen)
+	if err != nil {
+		slog.Error("relay sub link listen failed", "addr", r.cfg.SubListen, "err", err)
+		return
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		ln.Close()
+		return
+	}
+	r.listener = ln
+	r.mu.Unlock()
+	go r.acceptSubLinks(ln)
+	slog.Info("relay sub link listening", "addr", ln.Addr().String())
+
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	for {
+		if err := r.serveMainLink(port); err != nil {
+			slog.Warn("relay main link down", "addr", r.cfg.Address, "err", err)
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(r.cfg.ReconnectDelay):
+		}
+	}
+}
+
+// serveMainLink 连接上级平台主链路并登录，随后处理上级平台报文直至连接断开。
+func (r *relayClient) serveMainLink(subPort uint16) error {
+	host, port, err := normalizeHostPort(r.cfg.Address)
+	if err != nil {
+		return err
+	}
+	c := client.NewSimpleClient(goserver.TCP, host, port)
+	c.SetScannerSplitFunc(splitJT809Frames)
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	defer c.Close()
+	if conn := c.GetRawConn(); conn != nil {
+		conn.SetDeadline(time.Now().Add(relayLoginTimeout))
+	}
+
+	login, err := jtt809.BuildLoginPackage(r.header(jtt809.UP_CONNECT_REQ), jtt809.LoginRequest{
+		UserID:       r.cfg.UserID,
+		Password:     r.cfg.Password,
+		GnssCenterID: r.cfg.GNSSCenterID,
+		DownLinkIP:   r.cfg.DownLinkIP,
+		DownLinkPort: subPort,
+	})
+	if err != nil {
+		return fmt.Errorf("build login: %w", err)
+	}
+	if err := r.g.sendFrame(relayMainLink, r.cfg.UserID, "", login, c.Send); err != nil {
+		return fmt.Errorf("send login: %w", err)
+	}
+	data, err := c.Receive()
+	if err != nil {
+		return fmt.Errorf("read login response: %w", err)
+	}
+	var frame *jtt809.Frame
+	r.g.receiveFrame(relayMainLink, r.cfg.UserID, "", data, func(fc *FrameContext) { frame = fc.Frame })
+	if frame == nil || frame.BodyID != jtt809.UP_CONNECT_RSP {
+		return errors.New("unexpected login response")
+	}
+	resp, err := jtt809.ParseLoginResponse(frame.RawBody)
+	if err != nil {
+		return fmt.Errorf("parse login response: %w", err)
+	}
+	if resp.Result != jtt809.LoginOK {
+		return fmt.Errorf("login refused: result %d", resp.Result)
+	}
+	if conn := c.GetRawConn(); conn != nil {
+		conn.SetDeadline(time.Time{})
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.main, r.verifyCode = c, resp.VerifyCode
+	r.mu.Unlock()
+	r.touch()
+	slog.Info("relay main link logged in", "addr", r.cfg.Address, "user_id", r.cfg.UserID)
+
+	linkCtx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	go r.keepAlive(linkCtx, c)
+	go func() {
+		<-linkCtx.Done()
+		c.Close()
+	}()
+
+	var readErr error
+	for {
+		data, err := c.Receive()
+		if err != nil {
+			readErr = err
+			break
+		}
+		r.touch()
+		r.g.receiveFrame(relayMainLink, r.cfg.UserID, "", data, func(fc *FrameContext) {
+			r.handleFrame(relayMainLink, fc.Frame, c.Send)
+		})
+	}
+	r.mu.Lock()
+	if r.main == c {
+		r.main = nil
+	}
+	r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		return nil
+	}
+	return readErr
+}
+
+// keepAlive 定期发送主链路心跳（0x1005），长时间未收到上级平台报文时关闭主链路。
+func (r *relayClient) keepAlive(ctx context.Context, c *client.SimpleClient) {
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if idle := time.Since(time.Unix(0, r.lastRecv.Load())); idle > 3*r.cfg.HeartbeatInterval {
+				slog.Warn("relay main link idle timeout, closing", "idle", idle)
+				c.Close()
+				return
+			}
+			if err := r.send(relayMainLink, c.Send, jtt809.HeartbeatRequest{}); err != nil {
+				slog.Warn("send relay heartbeat failed", "err", err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (r *relayClient) touch() {
+	r.lastRecv.Store(time.Now().UnixNano())
+}
+
+// acceptSubLinks 接受上级平台的从链路连接，新连接登录成功后替换旧连接。
+func (r *relayClient) acceptSubLinks(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		slog.Info("relay sub link connected", "remote", conn.RemoteAddr().String())
+		go r.serveSubLink(conn)
+	}
+}
+
+func (r *relayClient) serveSubLink(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		r.mu.Lock()
+		if r.sub == conn {
+			r.sub = nil
+		}
+		r.mu.Unlock()
+		slog.Info("relay sub link closed", "remote", conn.RemoteAddr().String())
+	}()
+	write := connWriter(conn)
+	loggedIn := false
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(splitJT809Frames)
+	for scanner.Scan() {
+		r.touch()
+		r.g.receiveFrame(relaySubLink, r.cfg.UserID, "", bytes.Clone(scanner.Bytes()), func(fc *FrameContext) {
+			switch {
+			case fc.Frame.BodyID == jtt809.DOWN_CONNECT_REQ:
+				loggedIn = r.subLogin(conn, fc.Frame)
+			case !loggedIn:
+				slog.Warn("relay sub link message before login", "msg_id", formatMsgID(fc.Frame.BodyID))
+			default:
+				r.handleFrame(relaySubLink, fc.Frame, write)
+			}
+		})
+	}
+}
+
+// subLogin 校验从链路登录请求（0x9001）中的校验码并应答，成功时返回 true。
+func (r *relayClient) subLogin(conn net.Conn, frame *jtt809.Frame) bool {
+	r.mu.Lock()
+	expected := r.verifyCode
+	r.mu.Unlock()
+	result := byte(0)
+	if len(frame.RawBody) < 4 || binary.BigEndian.Uint32(frame.RawBody) != expected {
+		result = 1 // 校验码错误
+	}
+	if err := r.send(relaySubLink, connWriter(conn), jtt809.SubLinkLoginResponse{Result: result}); err != nil {
+		slog.Warn("send relay sub link login response failed", "err", err)
+		return false
+	}
+	if result != 0 {
+		slog.Warn("relay sub link login rejected, verify code mismatch")
+		return false
+	}
+	r.mu.Lock()
+	old := r.sub
+	r.sub = conn
+	r.mu.Unlock()
+	if old != nil && old != conn {
+		old.Close()
+	}
+	slog.Info("relay sub link logged in")
+	return true
+}
+
+// handleFrame 处理上级平台经主链路或从链路发送的报文。
+func (r *relayClient) handleFrame(link string, frame *jtt809.Frame, write func([]byte) error) {
+	switch frame.BodyID {
+	case jtt809.UP_LINKTEST_RSP, jtt809.UP_DISCONNECT_RSP:
+	case jtt809.DOWN_LINKTEST_REQ:
+		if err := r.send(link, write, jtt809.SubLinkHeartbeatResponse{}); err != nil {
+			slog.Warn("send relay sub link heartbeat response failed", "err", err)
+		}
+	case jtt809.DOWN_DISCONNECT_REQ:
+		if err := r.send(link, write, jtt809.SubLinkLogoutResponse{}); err != nil {
+			slog.Warn("send relay sub link logout response failed", "err", err)
+		}
+		slog.Info("relay sub link logout requested by upper platform")
+	case jtt809.DOWN_DISCONNECT_INFORM, jtt809.DOWN_CLOSELINK_INFORM:
+		slog.Warn("relay link notice from upper platform", "msg_id", formatMsgID(frame.BodyID), "body", fmt.Sprintf("%X", frame.RawBody))
+	default:
+		r.routeDown(frame)
+	}
+}
+
+// routeDown 将上级平台的车辆请求转交车属平台：以车属平台的接入码重新编码，
+// 需要应答的请求登记后由 uplink 回传应答。
+func (r *relayClient) routeDown(frame *jtt809.Frame) {
+	msgID := frame.BodyID
+	plate, color, subID, ok := relayTarget(msgID, frame.RawBody)
+	if !ok {
+		slog.Warn("relay downlink message not routable", "msg_id", formatMsgID(msgID), "sub_id", formatMsgID(frameSubBusinessID(msgID, frame.RawBody)))
+		r.g.metrics.relayDropped.inc("down", "unroutable")
+		return
+	}
+	userID, ok := r.owner(plate, color)
+	if !ok {
+		slog.Warn("relay downlink vehicle not found", "plate", plate, "color", color, "sub_id", formatMsgID(subID))
+		r.g.metrics.relayDropped.inc("down", "unknown_vehicle")
+		return
+	}
+	snap, _ := r.g.store.Snapshot(userID)
+	data, err := jtt809.EncodePackage(jtt809.Package{
+		Header: jtt809.Header{BusinessType: msgID, GNSSCenterID: snap.GNSSCenterID},
+		Body:   rawBody{msgID: msgID, payload: frame.RawBody},
+	})
+	if err != nil {
+		slog.Warn("encode relay downlink message failed", "user_id", userID, "err", err)
+		r.g.metrics.relayDropped.inc("down", "encode")
+		return
+	}
+	var route *relayRoute
+	if replySub, ok := relayReplies[subID]; ok {
+		// 报文序列号在编码时分配，从编码结果中取回
+		out, err := jtt809.DecodeFrame(data)
+		if err != nil {
+			slog.Warn("decode relay downlink message failed", "user_id", userID, "err", err)
+			return
+		}
+		route = &relayRoute{userID: userID, reqSub: subID, replySub: replySub, msgSN: out.Header.MsgSN, upSN: frame.Header.MsgSN,
+			plate: plate, color: color, expires: time.Now().Add(r.cfg.RequestTimeout)}
+		r.routes.add(route)
+	}
+	if err := r.g.sendEncoded(userID, msgID, subID, data); err != nil {
+		slog.Warn("relay downlink message failed", "user_id", userID, "plate", plate, "sub_id", formatMsgID(subID), "err", err)
+		r.g.metrics.relayDropped.inc("down", "send_failed")
+		return
+	}
+	slog.Info("relay downlink message", "user_id", userID, "plate", plate, "sub_id", formatMsgID(subID), "up_sn", frame.Header.MsgSN)
+	r.g.metrics.relayForwarded.inc("down", formatMsgID(subID))
+}
+
+// relayTarget 提取上级平台请求的车牌与子业务类型。0x9400 的子业务封装不含车牌时从报警预警载荷中读取。
+func relayTarget(msgID uint16, body []byte) (string, jtt809.PlateColor, uint16, bool) {
+	if sub, err := jtt809.ParseSubBusiness(body); err == nil && sub.Plate != "" && sub.SubBusinessID>>8 == msgID>>8 {
+		return sub.Plate, sub.Color, sub.SubBusinessID, true
+	}
+	if msgID == jtt809.DOWN_WARN_MSG {
+		if tips, err := jtt809.ParseDownWarnMsgInformPacket(body); err == nil && tips.VehicleNo != "" {
+			return tips.VehicleNo, tips.VehicleColor, jtt809.DOWN_WARN_MSG_INFORM_TIPS, true
+		}
+	}
+	return "", 0, 0, false
+}
+
+// owner 返回车辆所属的转发平台，同一车辆存在于多个平台时取 userID 最小者。
+func (r *relayClient) owner(plate string, color jtt809.PlateColor) (uint32, bool) {
+	for _, uid := range r.g.store.VehicleOwners(plate, color) {
+		if r.selected(uid) {
+			return uid, true
+		}
+	}
+	return 0, false
+}
+
+// uplink 将选定平台的车辆注册、定位与报警报文，以及上级平台请求的应答，以本平台接入码转发给上级平台。
+// 车辆注册、定位与报警报文仅在本地已受理（accepted）时转发；应答按登记的请求回传，与本地是否注册处理器无关。
+func (r *relayClient) uplink(msg *Message, accepted bool) {
+	if msg.Sub == nil || !r.selected(msg.UserID) {
+		return
+	}
+	msgID, subID, body := msg.Frame.BodyID, msg.Sub.SubBusinessID, msg.Frame.RawBody
+	if relayUplinks[subID] == msgID {
+		if !accepted {
+			return
+		}
+	} else {
+		info := replyInfo{userID: msg.UserID, subID: subID, plate: msg.Sub.Plate, color: msg.Sub.Color}
+		if subID == jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK || subID == jtt809.UP_EXG_MSG_RETURN_END_ACK {
+			if ack, err := jtt809.ParseMonitorAck(msg.Sub.Payload); err == nil {
+				info.hasSource, info.sourceSub, info.sourceSN = true, ack.SourceDataType, ack.SourceMsgSN
+			}
+		}
+		route, ok := r.routes.resolve(info, time.Now())
+		if !ok {
+			return
+		}
+		if info.hasSource && len(body) >= 34 {
+			// 源报文序列号替换为上级平台请求的序列号
+			body = slices.Clone(body)
+			binary.BigEndian.PutUint32(body[30:34], route.upSN)
+		}
+	}
+	if err := r.sendUp(rawBody{msgID: msgID, payload: body}); err != nil {
+		slog.Debug("relay uplink message dropped", "user_id", msg.UserID, "sub_id", formatMsgID(subID), "err", err)
+		r.g.metrics.relayDropped.inc("up", "link_down")
+		return
+	}
+	r.g.metrics.relayForwarded.inc("up", formatMsgID(subID))
+}
+
+// sendUp 经主链路向上级平台发送业务报文，主链路不可用时经从链路发送。
+func (r *relayClient) sendUp(body jtt809.Body) error {
+	r.mu.Lock()
+	main, sub := r.main, r.sub
+	r.mu.Unlock()
+	if main != nil {
+		err := r.send(relayMainLink, main.Send, body)
+		if err == nil || sub == nil {
+			return err
+		}
+		slog.Warn("relay main link send failed, falling back to sub link", "err", err)
+	}
+	if sub != nil {
+		return r.send(relaySubLink, connWriter(sub), body)
+	}
+	return ErrLinkNotConnected
+}
+
+// send 以本平台接入码编码报文并经中间件链发送。
+func (r *relayClient) send(link string, write func([]byte) error, body jtt809.Body) error {
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: r.header(body.MsgID()), Body: body})
+	if err != nil {
+		return err
+	}
+	return r.g.sendFrame(link, r.cfg.UserID, "", data, write)
+}
+
+func (r *relayClient) header(msgID uint16) jtt809.Header {
+	return jtt809.Header{BusinessType: msgID, GNSSCenterID: r.cfg.GNSSCenterID}
+}
+
+// close 向上级平台发送主链路注销请求（0x1003）并关闭全部链路，不再重连。
+func (r *relayClient) close() {
+	r.mu.Lock()
+	r.closed = true
+	main, sub, ln := r.main, r.sub, r.listener
+	r.main, r.sub = nil, nil
+	r.mu.Unlock()
+	if main != nil {
+		if err := r.send(relayMainLink, main.Send, jtt809.LogoutRequest{UserID: r.cfg.UserID, Password: r.cfg.Password}); err != nil {
+			slog.Warn("send relay logout failed", "err", err)
+		}
+		main.Close()
+	}
+	if sub != nil {
+		sub.Close()
+	}
+	if ln != nil {
+		ln.Close()
+	}
+	r.cancel()
+}
+
+// RelayStatus 级联上级平台的链路状态。
+type RelayStatus struct {
+	Address         string `json:"address"`
+	UserID          uint32 `json:"user_id"`
+	MainConnected   bool   `json:"main_connected"`
+	SubConnected    bool   `json:"sub_connected"`
+	PendingRequests int    `json:"pending_requests"` // 等待车属平台应答的上级平台请求数
+}
+
+func (r *relayClient) status() RelayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RelayStatus{
+		Address:         r.cfg.Address,
+		UserID:          r.cfg.UserID,
+		MainConnected:   r.main != nil,
+		SubConnected:    r.sub != nil,
+		PendingRequests: r.routes.count(),
+	}
+}
+
+// RelayStatus 返回级联上级平台的链路状态，未配置 Relay 时返回 false。
+func (g *JT809Gateway) RelayStatus() (RelayStatus, bool) {
+	if g.relay == nil {
+		return RelayStatus{}, false
+	}
+	return g.relay.status(), true
+}
+
+// connWriter 返回写出完整报文的 write 函数。
+func connWriter(conn net.Conn) func([]byte) error {
+	return func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// fakeUpperPlatform 模拟上级平台主链路监听，接受的连接写入返回的通道。
+func fakeUpperPlatform(t *testing.T) (net.Listener, chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns <- conn
+	}()
+	return ln, conns
+}
+
+func readFrames(conn net.Conn) chan *jtt809.Frame {
+	frames := make(chan *jtt809.Frame, 16)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		scanner.Split(splitJT809Frames)
+		for scanner.Scan() {
+			if frame, err := jtt809.DecodeFrame(scanner.Bytes()); err == nil {
+				frames <- frame
+			}
+		}
+	}()
+	return frames
+}
+
+func nextFrame(t *testing.T, frames chan *jtt809.Frame) *jtt809.Frame {
+	t.Helper()
+	select {
+	case frame := <-frames:
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for frame")
+		return nil
+	}
+}
+
+func writePackage(t *testing.T, conn net.Conn, body jtt809.Body) uint32 {
+	t.Helper()
+	data, err := jtt809.EncodePackage(jtt809.Package{Header: jtt809.Header{GNSSCenterID: 50}, Body: body})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	frame, _ := jtt809.DecodeFrame(data)
+	return frame.Header.MsgSN
+}
+
+func TestRelay(t *testing.T) {
+	const plate = "粤A12345"
+	upper, conns := fakeUpperPlatform(t)
+
+	// 下级平台 1 在线且已上报车辆
+	port, received := fakeSubServer(t, true)
+	g := &JT809Gateway{
+		cfg:     Config{VehiclePolicyOverrides: map[uint32]VehiclePolicy{1: {MaxVehicles: 1}}},
+		store:   NewPlatformStore(),
+		metrics: newGatewayMetrics(),
+	}
+	g.closing.Store(true)
+	g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+	if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+		t.Fatal("connect sub link failed")
+	}
+	<-received
+	g.store.UpdateVehicleRegistration(1, jtt809.PlateColorBlue, plate, &VehicleRegistration{})
+	g.Handle(jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REAL_LOCATION, g.admitted(func(*Message) {}))
+	g.Handle(jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK, func(*Message) {})
+	var downSN atomic.Uint32
+	g.Use(func(next FrameHandler) FrameHandler {
+		return func(fc *FrameContext) error {
+			if fc.Direction == FrameSend && fc.Frame != nil && fc.Frame.BodyID == jtt809.DOWN_EXG_MSG {
+				downSN.Store(fc.Frame.Header.MsgSN)
+			}
+			return next(fc)
+		}
+	})
+
+	relay, err := newRelayClient(g, RelayConfig{
+		Address:           upper.Addr().String(),
+		UserID:            50,
+		Password:          "pw",
+		GNSSCenterID:      900,
+		SubListen:         "127.0.0.1:0",
+		Accounts:          []uint32{1},
+		HeartbeatInterval: time.Hour,
+		ReconnectDelay:    10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new relay: %v", err)
+	}
+	g.relay = relay
+	go relay.run()
+	t.Cleanup(relay.close)
+
+	// 主链路登录
+	var mainConn net.Conn
+	select {
+	case mainConn = <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not connect")
+	}
+	mainFrames := readFrames(mainConn)
+	login := nextFrame(t, mainFrames)
+	if login.BodyID != jtt809.UP_CONNECT_REQ || login.Header.GNSSCenterID != 900 {
+		t.Fatalf("unexpected login frame 0x%04X gnss %d", login.BodyID, login.Header.GNSSCenterID)
+	}
+	req, err := jtt809.ParseLoginRequest(login.RawBody)
+	if err != nil || req.UserID != 50 || req.Password != "pw" || req.DownLinkIP != "127.0.0.1" {
+		t.Fatalf("unexpected login request %+v: %v", req, err)
+	}
+	writePackage(t, mainConn, jtt809.LoginResponse{Result: jtt809.LoginOK, VerifyCode: 77})
+	waitFor(t, func() bool {
+		st, _ := g.RelayStatus()
+		return st.MainConnected
+	})
+
+	// 从链路登录
+	subConn, err := net.Dial("tcp", net.JoinHostPort(req.DownLinkIP, strconv.Itoa(int(req.DownLinkPort))))
+	if err != nil {
+		t.Fatalf("dial sub link: %v", err)
+	}
+	defer subConn.Close()
+	subFrames := readFrames(subConn)
+	writePackage(t, subConn, jtt809.SubLinkLoginRequest{VerifyCode: 77})
+	if resp := nextFrame(t, subFrames); resp.BodyID != jtt809.DOWN_CONNECT_RSP || resp.RawBody[0] != 0 {
+		t.Fatalf("unexpected sub login response 0x%04X % X", resp.BodyID, resp.RawBody)
+	}
+	waitFor(t, func() bool {
+		st, _ := g.RelayStatus()
+		return st.SubConnected
+	})
+
+	// 选定平台的定位转发给上级平台，业务体不变，接入码替换为本平台
+	location := subBusinessFrame(t, jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REAL_LOCATION, plate, []byte{1, 2, 3})
+	g.handleBusinessMessage(1, location, true)
+	got := nextFrame(t, mainFrames)
+	if got.BodyID != jtt809.UP_EXG_MSG || got.Header.GNSSCenterID != 900 || !bytes.Equal(got.RawBody, location.RawBody) {
+		t.Fatalf("unexpected uplink frame 0x%04X gnss %d", got.BodyID, got.Header.GNSSCenterID)
+	}
+	g.handleBusinessMessage(2, location, true)
+	// 超出车辆数上限未被受理的报文不转发
+	g.handleBusinessMessage(1, subBusinessFrame(t, jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_REAL_LOCATION, "粤A99999", []byte{1, 2, 3}), true)
+	if n := g.metrics.relayForwarded.get("up", "0x1202"); n != 1 {
+		t.Fatalf("expected only admitted location of account 1 forwarded, got %v", n)
+	}
+
+	// 上级平台请求转交车属平台，应答的源报文序列号替换为上级平台请求的序列号
+	upSN := writePackage(t, subConn, jtt809.ApplyForMonitorStartup{VehicleNo: plate, VehicleColor: jtt809.PlateColorBlue})
+	select {
+	case id := <-received:
+		if id != jtt809.DOWN_EXG_MSG {
+			t.Fatalf("expected 0x9200 sent to subordinate, got 0x%04X", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("downlink request not relayed")
+	}
+	waitFor(t, func() bool { return downSN.Load() != 0 })
+	ack := make([]byte, 10)
+	binary.BigEndian.PutUint16(ack[0:2], jtt809.DOWN_EXG_MSG_RETURN_STARTUP)
+	binary.BigEndian.PutUint32(ack[2:6], downSN.Load())
+	g.handleBusinessMessage(1, subBusinessFrame(t, jtt809.UP_EXG_MSG, jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK, plate, ack), true)
+	got = nextFrame(t, mainFrames)
+	if sub := frameSubBusinessID(got.BodyID, got.RawBody); sub != jtt809.UP_EXG_MSG_RETURN_STARTUP_ACK {
+		t.Fatalf("expected 0x1205 relayed, got 0x%04X", sub)
+	}
+	if sn := binary.BigEndian.Uint32(got.RawBody[30:34]); sn != upSN {
+		t.Fatalf("expected source sn %d, got %d", upSN, sn)
+	}
+	if st, _ := g.RelayStatus(); st.PendingRequests != 0 {
+		t.Fatalf("expected no pending requests, got %d", st.PendingRequests)
+	}
+
+	// 本地未注册处理器的应答（0x1401 报警督办应答）同样回传给上级平台
+	supervise, err := jtt809.WarnSuperviseRequest{
+		VehicleNo: plate, VehicleColor: jtt809.PlateColorBlue, SupervisionID: "00000001",
+		WarnTime: time.Now(), EndTime: time.Now().Add(time.Hour),
+	}.Encode()
+	if err != nil {
+		t.Fatalf("encode supervise request: %v", err)
+	}
+	writePackage(t, subConn, rawBody{msgID: jtt809.DOWN_WARN_MSG, payload: supervise})
+	select {
+	case id := <-received:
+		if id != jtt809.DOWN_WARN_MSG {
+			t.Fatalf("expected 0x9400 sent to subordinate, got 0x%04X", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervise request not relayed")
+	}
+	reply := binary.BigEndian.AppendUint16(nil, 0x1401)
+	reply = binary.BigEndian.AppendUint32(reply, 5)
+	reply = append(reply, 0, 0, 0, 1, 1)
+	g.handleBusinessMessage(1, &jtt809.Frame{BodyID: jtt809.UP_WARN_MSG, RawBody: reply}, true)
+	got = nextFrame(t, mainFrames)
+	if sub := frameSubBusinessID(got.BodyID, got.RawBody); got.BodyID != jtt809.UP_WARN_MSG || sub != 0x1401 {
+		t.Fatalf("expected 0x1401 relayed, got 0x%04X/0x%04X", got.BodyID, sub)
+	}
+	if st, _ := g.RelayStatus(); st.PendingRequests != 0 {
+		t.Fatalf("expected no pending requests, got %d", st.PendingRequests)
+	}
+
+	// 停机时发送主链路注销请求
+	relay.close()
+	if logout := nextFrame(t, mainFrames); logout.BodyID != jtt809.UP_DISCONNECT_REQ {
+		t.Fatalf("expected 0x1003, got 0x%04X", logout.BodyID)
+	}
+}
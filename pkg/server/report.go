@@ -0,0 +1,616 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// PlatformDayStats 单个下级平台单日考核原始计数。
+type PlatformDayStats struct {
+	UserID          uint32  `json:"user_id"`
+	OnlineSeconds   float64 `json:"online_seconds"`  // 主链路在线时长
+	SampledSeconds  float64 `json:"sampled_seconds"` // 统计覆盖时长（本级平台运行时长）
+	TotalPoints     uint64  `json:"total_points"`
+	QualifiedPoints uint64  `json:"qualified_points"`
+	DriftPoints     uint64  `json:"drift_points"`
+	TotalDistance   float64 `json:"total_distance"`  // 米
+	GapDistance     float64 `json:"gap_distance"`    // 米
+	ChecksSent      uint64  `json:"checks_sent"`     // 下发查岗次数
+	ChecksAnswered  uint64  `json:"checks_answered"` // 规定时限内应答次数
+	ChecksLate      uint64  `json:"checks_late"`     // 超时应答次数
+	AlarmsReported  uint64  `json:"alarms_reported"` // 下级平台上报报警（0x1402/0x1403）
+	AlarmsDetected  uint64  `json:"alarms_detected"` // 本级平台判定报警（违规规则、电子围栏）
+}
+
+// VehicleDayStats 单车单日考核原始计数。
+type VehicleDayStats struct {
+	UserID          uint32            `json:"user_id"`
+	VehicleNo       string            `json:"vehicle_no"`
+	VehicleColor    jtt809.PlateColor `json:"vehicle_color"`
+	TotalPoints     uint64            `json:"total_points"`
+	QualifiedPoints uint64            `json:"qualified_points"`
+	DriftPoints     uint64            `json:"drift_points"`
+	TotalDistance   float64           `json:"total_distance"`
+	GapDistance     float64           `json:"gap_distance"`
+	Alarms          uint64            `json:"alarms"`
+	FirstTime       time.Time         `json:"first_time,omitempty"`
+	LastTime        time.Time         `json:"last_time,omitempty"`
+}
+
+// DailyStats 表示一个自然日（UTC+8）的考核统计。
+type DailyStats struct {
+	Date      string                       `json:"date"` // YYYYMMDD
+	Platforms map[uint32]*PlatformDayStats `json:"platforms"`
+	Vehicles  map[string]*VehicleDayStats  `json:"vehicles"` // userID/车牌#颜色
+
+	dirty bool
+}
+
+// PlatformAssessment 表示平台在统计区间内的考核指标。
+// 上线率 = 车辆上线数 / 入网车辆数（按日累加）；平台连通率 = 主链路在线时长 / 统计时长；
+// 轨迹完整率 = (总里程 - 断点里程) / 总里程；数据合格率 = 合格点数 / 总点数；
+// 漂移率 = 漂移点数 / 总点数；查岗响应率 = 时限内应答次数 / 查岗次数。
+type PlatformAssessment struct {
+	Days              int     `json:"days"`
+	Vehicles          int     `json:"vehicles"`        // 入网车辆数（按日累加）
+	OnlineVehicles    int     `json:"online_vehicles"` // 上线车辆数（按日累加）
+	OnlineRate        float64 `json:"online_rate"`
+	LinkOnlineRate    float64 `json:"link_online_rate"`
+	TrackCompleteness float64 `json:"track_completeness"`
+	QualifiedRate     float64 `json:"qualified_rate"`
+	DriftRate         float64 `json:"drift_rate"`
+	CheckResponseRate float64 `json:"check_response_rate"`
+
+	PlatformDayStats
+}
+
+// VehicleAssessment 表示单车在统计区间内的考核指标。
+type VehicleAssessment struct {
+	OnlineDays        int     `json:"online_days"`
+	TrackCompleteness float64 `json:"track_completeness"`
+	QualifiedRate     float64 `json:"qualified_rate"`
+	DriftRate         float64 `json:"drift_rate"`
+
+	VehicleDayStats
+}
+
+// AssessmentReport 表示区间考核报表。
+type AssessmentReport struct {
+	From      string               `json:"from"` // YYYY-MM-DD
+	To        string               `json:"to"`
+	Platforms []PlatformAssessment `json:"platforms"`
+	Vehicles  []VehicleAssessment  `json:"vehicles,omitempty"`
+}
+
+// AssessmentRecorder 从链路状态、定位数据、查岗应答与报警处理中累计每日考核统计。
+// dir 非空时每日统计落盘为 <dir>/<YYYYMMDD>.json，内存中仅保留最近两天；否则全部保留在内存中。
+type AssessmentRecorder struct {
+	dir          string
+	checkTimeout time.Duration
+
+	mu         sync.Mutex
+	days       map[string]*DailyStats
+	pending    []pendingCheck // 未超过应答时限的查岗
+	expired    []pendingCheck // 已超过应答时限、24 小时内仍可计为超时应答的查岗
+	lastSample time.Time
+}
+
+type pendingCheck struct {
+	userID   uint32
+	objectID string
+	infoID   uint32
+	msgSN    uint32 // 0x9301 报文序列号，与应答中的源报文序列号对应
+	sentAt   time.Time
+}
+
+// assessmentMemoryDays 未落盘时内存中保留的天数。
+const assessmentMemoryDays = 62
+
+// maxSampleGap 两次采样间隔超过该值时不计入在线时长（如本级平台停机）。
+const maxSampleGap = 5 * time.Minute
+
+// NewAssessmentRecorder 创建考核统计器，checkTimeout 为查岗应答时限，<=0 时取 10 分钟。
+func NewAssessmentRecorder(dir string, checkTimeout time.Duration) (*AssessmentRecorder, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create report dir: %w", err)
+		}
+	}
+	if checkTimeout <= 0 {
+		checkTimeout = 10 * time.Minute
+	}
+	return &AssessmentRecorder{
+		dir:          dir,
+		checkTimeout: checkTimeout,
+		days:         make(map[string]*DailyStats),
+	}, nil
+}
+
+// Sample 按采样间隔累计平台在线时长，并登记当日入网车辆。
+// userIDs 为全部已配置账号，未登录的平台同样计入统计时长。
+func (r *AssessmentRecorder) Sample(userIDs []uint32, snaps []PlatformSnapshot, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elapsed := now.Sub(r.lastSample)
+	first := r.lastSample.IsZero()
+	r.lastSample = now
+	if first || elapsed <= 0 || elapsed > maxSampleGap {
+		elapsed = 0
+	}
+	day := r.dayLocked(now)
+	online := make(map[uint32]bool, len(snaps))
+	for _, snap := range snaps {
+		online[snap.UserID] = snap.MainSessionID != ""
+		for _, v := range snap.Vehicles {
+			r.vehicleLocked(day, snap.UserID, v.VehicleNo, v.VehicleColor)
+		}
+	}
+	for _, uid := range userIDs {
+		p := r.platformLocked(day, uid)
+		p.SampledSeconds += elapsed.Seconds()
+		if online[uid] {
+			p.OnlineSeconds += elapsed.Seconds()
+		}
+	}
+	r.expireChecksLocked(now)
+	day.dirty = true
+}
+
+// RecordPosition 计入一个定位点的质量检查结果，distance/gapDistance 为该点计入的里程与断点里程（米）。
+func (r *AssessmentRecorder) RecordPosition(evt *QualityEvent, distance, gapDistance float64, receivedAt time.Time) {
+	at := evt.Time
+	if at.IsZero() {
+		at = receivedAt
+	}
+	drift := false
+	for _, q := range evt.Issues {
+		if q == QualityPositionJump {
+			drift = true
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	day := r.dayLocked(at)
+	p := r.platformLocked(day, evt.UserID)
+	v := r.vehicleLocked(day, evt.UserID, evt.VehicleNo, evt.VehicleColor)
+	p.TotalPoints++
+	v.TotalPoints++
+	if evt.Qualified {
+		p.QualifiedPoints++
+		v.QualifiedPoints++
+		if !evt.Time.IsZero() {
+			if v.FirstTime.IsZero() || evt.Time.Before(v.FirstTime) {
+				v.FirstTime = evt.Time
+			}
+			if evt.Time.After(v.LastTime) {
+				v.LastTime = evt.Time
+			}
+		}
+	}
+	if drift {
+		p.DriftPoints++
+		v.DriftPoints++
+	}
+	p.TotalDistance += distance
+	v.TotalDistance += distance
+	p.GapDistance += gapDistance
+	v.GapDistance += gapDistance
+	day.dirty = true
+}
+
+// RecordAlarm 计入一条报警。reported 为 true 表示下级平台上报，否则为本级平台判定；plate 为空时仅计入平台。
+func (r *AssessmentRecorder) RecordAlarm(userID uint32, plate string, color jtt809.PlateColor, reported bool, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	day := r.dayLocked(at)
+	p := r.platformLocked(day, userID)
+	if reported {
+		p.AlarmsReported++
+	} else {
+		p.AlarmsDetected++
+	}
+	if plate != "" {
+		r.vehicleLocked(day, userID, plate, color).Alarms++
+	}
+	day.dirty = true
+}
+
+// RecordCheckSent 登记一次下发的平台查岗，msgSN 为 0x9301 报文序列号。
+func (r *AssessmentRecorder) RecordCheckSent(userID uint32, objectID string, infoID, msgSN uint32, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	day := r.dayLocked(at)
+	r.platformLocked(day, userID).ChecksSent++
+	r.pending = append(r.pending, pendingCheck{userID: userID, objectID: objectID, infoID: infoID, msgSN: msgSN, sentAt: at})
+	day.dirty = true
+}
+
+// RecordCheckAnswer 登记查岗应答，按应答中的源报文序列号 sourceSN 匹配查岗；下级平台未填写（为 0）时
+// 匹配查岗对象相同的最早一次查岗。超过应答时限的查岗只能按序列号匹配并计为超时应答，重复应答不再计入。
+// 统计计入查岗下发当日，返回匹配到的查岗信息 ID 及应答耗时，未匹配时 ok 为 false。
+func (r *AssessmentRecorder) RecordCheckAnswer(userID uint32, objectID string, sourceSN uint32, at time.Time) (infoID uint32, latency time.Duration, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireChecksLocked(at)
+	match := func(c pendingCheck) bool {
+		if c.userID != userID {
+			return false
+		}
+		if sourceSN != 0 {
+			return c.msgSN == sourceSN
+		}
+		return c.objectID == objectID
+	}
+	late := false
+	idx := slices.IndexFunc(r.pending, match)
+	if idx < 0 && sourceSN != 0 {
+		idx, late = slices.IndexFunc(r.expired, match), true
+	}
+	if idx < 0 {
+		return 0, 0, false
+	}
+	var c pendingCheck
+	if late {
+		c = r.expired[idx]
+		r.expired = slices.Delete(r.expired, idx, idx+1)
+	} else {
+		c = r.pending[idx]
+		r.pending = slices.Delete(r.pending, idx, idx+1)
+	}
+	day := r.dayLocked(c.sentAt)
+	p := r.platformLocked(day, userID)
+	if late {
+		p.ChecksLate++
+	} else {
+		p.ChecksAnswered++
+	}
+	day.dirty = true
+	return c.infoID, at.Sub(c.sentAt), true
+}
+
+// Flush 将有变更的日统计落盘，并从内存中淘汰较早的日期。
+func (r *AssessmentRecorder) Flush(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	keepFrom := dayStart(now).AddDate(0, 0, -1).Format("20060102")
+	if r.dir == "" {
+		keepFrom = dayStart(now).AddDate(0, 0, -assessmentMemoryDays).Format("20060102")
+	}
+	for date, d := range r.days {
+		if r.dir != "" && d.dirty {
+			data, err := json.Marshal(d)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := writeFileAtomic(filepath.Join(r.dir, date+".json"), data); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			d.dirty = false
+		}
+		// 未配置数据目录时无处落盘，仅按日期淘汰
+		if date < keepFrom && (r.dir == "" || !d.dirty) {
+			delete(r.days, date)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Daily 返回指定日期的统计副本。
+func (r *AssessmentRecorder) Daily(day time.Time) (*DailyStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, err := r.loadLocked(dayStart(day).Format("20060102"))
+	if err != nil || d == nil {
+		return d, err
+	}
+	return d.clone(), nil
+}
+
+// Report 汇总 [from, to] 日期区间（UTC+8，含首尾）的考核报表，withVehicles 为 true 时包含单车明细。
+func (r *AssessmentRecorder) Report(from, to time.Time, withVehicles bool) (*AssessmentReport, error) {
+	start, end := dayStart(from), dayStart(to)
+	if end.Before(start) {
+		return nil, errors.New("report range end before start")
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		return nil, errors.New("report range exceeds 366 days")
+	}
+	platforms := make(map[uint32]*PlatformAssessment)
+	vehicles := make(map[string]*VehicleAssessment)
+
+	r.mu.Lock()
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		d, err := r.loadLocked(day.Format("20060102"))
+		if err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		if d == nil {
+			continue
+		}
+		for uid, ps := range d.Platforms {
+			pa := platforms[uid]
+			if pa == nil {
+				pa = &PlatformAssessment{PlatformDayStats: PlatformDayStats{UserID: uid}}
+				platforms[uid] = pa
+			}
+			pa.Days++
+			pa.add(ps)
+		}
+		for key, vs := range d.Vehicles {
+			if pa := platforms[vs.UserID]; pa != nil {
+				pa.Vehicles++
+				if vs.QualifiedPoints > 0 {
+					pa.OnlineVehicles++
+				}
+			}
+			if !withVehicles {
+				continue
+			}
+			va := vehicles[key]
+			if va == nil {
+				va = &VehicleAssessment{VehicleDayStats: VehicleDayStats{UserID: vs.UserID, VehicleNo: vs.VehicleNo, VehicleColor: vs.VehicleColor}}
+				vehicles[key] = va
+			}
+			va.add(vs)
+		}
+	}
+	r.mu.Unlock()
+
+	report := &AssessmentReport{
+		From:      start.Format("2006-01-02"),
+		To:        end.Format("2006-01-02"),
+		Platforms: make([]PlatformAssessment, 0, len(platforms)),
+	}
+	for _, pa := range platforms {
+		pa.OnlineRate = ratio(float64(pa.OnlineVehicles), float64(pa.Vehicles))
+		pa.LinkOnlineRate = ratio(pa.OnlineSeconds, pa.SampledSeconds)
+		pa.TrackCompleteness = completeness(pa.TotalDistance, pa.GapDistance)
+		pa.QualifiedRate = ratio(float64(pa.QualifiedPoints), float64(pa.TotalPoints))
+		pa.DriftRate = ratio(float64(pa.DriftPoints), float64(pa.TotalPoints))
+		pa.CheckResponseRate = ratio(float64(pa.ChecksAnswered), float64(pa.ChecksSent))
+		report.Platforms = append(report.Platforms, *pa)
+	}
+	sort.Slice(report.Platforms, func(i, j int) bool { return report.Platforms[i].UserID < report.Platforms[j].UserID })
+	if withVehicles {
+		report.Vehicles = make([]VehicleAssessment, 0, len(vehicles))
+		for _, va := range vehicles {
+			va.TrackCompleteness = completeness(va.TotalDistance, va.GapDistance)
+			va.QualifiedRate = ratio(float64(va.QualifiedPoints), float64(va.TotalPoints))
+			va.DriftRate = ratio(float64(va.DriftPoints), float64(va.TotalPoints))
+			report.Vehicles = append(report.Vehicles, *va)
+		}
+		sort.Slice(report.Vehicles, func(i, j int) bool {
+			a, b := report.Vehicles[i], report.Vehicles[j]
+			if a.UserID != b.UserID {
+				return a.UserID < b.UserID
+			}
+			return a.VehicleNo < b.VehicleNo
+		})
+	}
+	return report, nil
+}
+
+// WritePlatformCSV 以 CSV 输出平台考核指标（UTF-8 BOM，便于 Excel 打开）。
+func (rep *AssessmentReport) WritePlatformCSV(w io.Writer) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"开始日期", "结束日期", "用户ID", "统计天数", "入网车辆数", "上线车辆数", "上线率", "平台连通率",
+		"轨迹完整率", "数据合格率", "漂移率", "查岗次数", "查岗应答数", "查岗响应率", "定位点数", "合格点数", "漂移点数",
+		"总里程(km)", "上报报警数", "判定报警数"})
+	for _, p := range rep.Platforms {
+		_ = cw.Write([]string{
+			rep.From, rep.To,
+			strconv.FormatUint(uint64(p.UserID), 10),
+			strconv.Itoa(p.Days),
+			strconv.Itoa(p.Vehicles),
+			strconv.Itoa(p.OnlineVehicles),
+			formatRate(p.OnlineRate),
+			formatRate(p.LinkOnlineRate),
+			formatRate(p.TrackCompleteness),
+			formatRate(p.QualifiedRate),
+			formatRate(p.DriftRate),
+			strconv.FormatUint(p.ChecksSent, 10),
+			strconv.FormatUint(p.ChecksAnswered, 10),
+			formatRate(p.CheckResponseRate),
+			strconv.FormatUint(p.TotalPoints, 10),
+			strconv.FormatUint(p.QualifiedPoints, 10),
+			strconv.FormatUint(p.DriftPoints, 10),
+			strconv.FormatFloat(p.TotalDistance/1000, 'f', 2, 64),
+			strconv.FormatUint(p.AlarmsReported, 10),
+			strconv.FormatUint(p.AlarmsDetected, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteVehicleCSV 以 CSV 输出单车考核指标。
+func (rep *AssessmentReport) WriteVehicleCSV(w io.Writer) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"开始日期", "结束日期", "用户ID", "车牌号", "车牌颜色", "上线天数", "轨迹完整率", "数据合格率", "漂移率",
+		"定位点数", "合格点数", "漂移点数", "总里程(km)", "报警数"})
+	for _, v := range rep.Vehicles {
+		_ = cw.Write([]string{
+			rep.From, rep.To,
+			strconv.FormatUint(uint64(v.UserID), 10),
+			v.VehicleNo,
+			strconv.Itoa(int(v.VehicleColor)),
+			strconv.Itoa(v.OnlineDays),
+			formatRate(v.TrackCompleteness),
+			formatRate(v.QualifiedRate),
+			formatRate(v.DriftRate),
+			strconv.FormatUint(v.TotalPoints, 10),
+			strconv.FormatUint(v.QualifiedPoints, 10),
+			strconv.FormatUint(v.DriftPoints, 10),
+			strconv.FormatFloat(v.TotalDistance/1000, 'f', 2, 64),
+			strconv.FormatUint(v.Alarms, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *AssessmentRecorder) dayLocked(t time.Time) *DailyStats {
+	date := t.In(trackPartitionZone).Format("20060102")
+	d, err := r.loadLocked(date)
+	if err != nil || d == nil {
+		// 历史文件损坏时从零开始累计，避免阻塞实时统计
+		d = &DailyStats{Date: date, Platforms: make(map[uint32]*PlatformDayStats), Vehicles: make(map[string]*VehicleDayStats)}
+		r.days[date] = d
+	}
+	return d
+}
+
+// loadLocked 返回内存中的日统计，不存在时尝试从磁盘加载，均不存在时返回 nil。
+func (r *AssessmentRecorder) loadLocked(date string) (*DailyStats, error) {
+	if d, ok := r.days[date]; ok {
+		return d, nil
+	}
+	if r.dir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.dir, date+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read daily stats %s: %w", date, err)
+	}
+	var d DailyStats
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("decode daily stats %s: %w", date, err)
+	}
+	if d.Platforms == nil {
+		d.Platforms = make(map[uint32]*PlatformDayStats)
+	}
+	if d.Vehicles == nil {
+		d.Vehicles = make(map[string]*VehicleDayStats)
+	}
+	r.days[date] = &d
+	return &d, nil
+}
+
+func (r *AssessmentRecorder) platformLocked(d *DailyStats, userID uint32) *PlatformDayStats {
+	p, ok := d.Platforms[userID]
+	if !ok {
+		p = &PlatformDayStats{UserID: userID}
+		d.Platforms[userID] = p
+	}
+	return p
+}
+
+func (r *AssessmentRecorder) vehicleLocked(d *DailyStats, userID uint32, plate string, color jtt809.PlateColor) *VehicleDayStats {
+	key := fmt.Sprintf("%d/%s", userID, vehicleKey(plate, color))
+	v, ok := d.Vehicles[key]
+	if !ok {
+		r.platformLocked(d, userID)
+		v = &VehicleDayStats{UserID: userID, VehicleNo: plate, VehicleColor: color}
+		d.Vehicles[key] = v
+	}
+	return v
+}
+
+// expireChecksLocked 将超过应答时限的查岗移出待应答列表，超过 24 小时仍未应答的查岗不再保留。
+func (r *AssessmentRecorder) expireChecksLocked(now time.Time) {
+	kept := r.pending[:0]
+	for _, c := range r.pending {
+		if now.Sub(c.sentAt) > r.checkTimeout {
+			r.expired = append(r.expired, c)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	r.pending = kept
+	r.expired = slices.DeleteFunc(r.expired, func(c pendingCheck) bool { return now.Sub(c.sentAt) >= 24*time.Hour })
+}
+
+func (d *DailyStats) clone() *DailyStats {
+	cp := &DailyStats{
+		Date:      d.Date,
+		Platforms: make(map[uint32]*PlatformDayStats, len(d.Platforms)),
+		Vehicles:  make(map[string]*VehicleDayStats, len(d.Vehicles)),
+	}
+	for k, v := range d.Platforms {
+		c := *v
+		cp.Platforms[k] = &c
+	}
+	for k, v := range d.Vehicles {
+		c := *v
+		cp.Vehicles[k] = &c
+	}
+	return cp
+}
+
+func (pa *PlatformAssessment) add(s *PlatformDayStats) {
+	pa.OnlineSeconds += s.OnlineSeconds
+	pa.SampledSeconds += s.SampledSeconds
+	pa.TotalPoints += s.TotalPoints
+	pa.QualifiedPoints += s.QualifiedPoints
+	pa.DriftPoints += s.DriftPoints
+	pa.TotalDistance += s.TotalDistance
+	pa.GapDistance += s.GapDistance
+	pa.ChecksSent += s.ChecksSent
+	pa.ChecksAnswered += s.ChecksAnswered
+	pa.ChecksLate += s.ChecksLate
+	pa.AlarmsReported += s.AlarmsReported
+	pa.AlarmsDetected += s.AlarmsDetected
+}
+
+func (va *VehicleAssessment) add(s *VehicleDayStats) {
+	if s.QualifiedPoints > 0 {
+		va.OnlineDays++
+	}
+	va.TotalPoints += s.TotalPoints
+	va.QualifiedPoints += s.QualifiedPoints
+	va.DriftPoints += s.DriftPoints
+	va.TotalDistance += s.TotalDistance
+	va.GapDistance += s.GapDistance
+	va.Alarms += s.Alarms
+	if !s.FirstTime.IsZero() && (va.FirstTime.IsZero() || s.FirstTime.Before(va.FirstTime)) {
+		va.FirstTime = s.FirstTime
+	}
+	if s.LastTime.After(va.LastTime) {
+		va.LastTime = s.LastTime
+	}
+}
+
+// ratio 计算比率，分母为 0 时返回 0。
+func ratio(num, den float64) float64 {
+	if den <= 0 {
+		return 0
+	}
+	return num / den
+}
+
+// completeness 计算轨迹完整率，无里程时视为完整。
+func completeness(total, gap float64) float64 {
+	if total <= 0 {
+		return 1
+	}
+	return (total - gap) / total
+}
+
+func formatRate(v float64) string {
+	return strconv.FormatFloat(v*100, 'f', 2, 64) + "%"
+}
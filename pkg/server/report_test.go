@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestAssessmentReport(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewAssessmentRecorder(dir, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("new recorder: %v", err)
+	}
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	online := []PlatformSnapshot{{UserID: 1, MainSessionID: "s1", Vehicles: []VehicleSnapshot{
+		{VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue},
+		{VehicleNo: "粤B99999", VehicleColor: jtt809.PlateColorBlue},
+	}}}
+	// 平台 1 在线 3 分钟、离线 1 分钟；平台 2 未登录
+	rec.Sample([]uint32{1, 2}, online, day)
+	for i := 1; i <= 3; i++ {
+		rec.Sample([]uint32{1, 2}, online, day.Add(time.Duration(i)*time.Minute))
+	}
+	rec.Sample([]uint32{1, 2}, nil, day.Add(4*time.Minute))
+
+	ok := &QualityEvent{UserID: 1, VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue, Time: day, Qualified: true}
+	rec.RecordPosition(ok, 1000, 0, day)
+	rec.RecordPosition(ok, 1000, 1000, day)
+	drift := &QualityEvent{UserID: 1, VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue, Time: day, Issues: []QualityIssue{QualityPositionJump}}
+	rec.RecordPosition(drift, 0, 0, day)
+
+	rec.RecordCheckSent(1, "P1", 1, 101, day)
+	rec.RecordCheckSent(1, "P1", 2, 102, day)
+	if infoID, _, ok := rec.RecordCheckAnswer(1, "P1", 102, day.Add(time.Minute)); !ok || infoID != 2 {
+		t.Fatalf("expected answer matched by source sn, got %d %v", infoID, ok)
+	}
+	if _, _, ok := rec.RecordCheckAnswer(1, "P1", 102, day.Add(2*time.Minute)); ok {
+		t.Fatalf("duplicate answer must not be credited")
+	}
+	if _, _, ok := rec.RecordCheckAnswer(1, "P1", 999, day.Add(2*time.Minute)); ok {
+		t.Fatalf("unknown source sn must not be credited")
+	}
+	// 超过应答时限后仅能按序列号计为超时应答
+	if _, _, ok := rec.RecordCheckAnswer(1, "P1", 0, day.Add(time.Hour)); ok {
+		t.Fatalf("expired check must not match without source sn")
+	}
+	if infoID, _, ok := rec.RecordCheckAnswer(1, "P1", 101, day.Add(time.Hour)); !ok || infoID != 1 {
+		t.Fatalf("expected late answer matched, got %d %v", infoID, ok)
+	}
+	if _, _, ok := rec.RecordCheckAnswer(2, "P2", 0, day); ok {
+		t.Fatalf("unexpected match for platform without check")
+	}
+	if err := rec.Flush(day.AddDate(0, 0, 5)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// 重新加载后从磁盘汇总
+	reloaded, _ := NewAssessmentRecorder(dir, 0)
+	report, err := reloaded.Report(day, day.AddDate(0, 0, 1), true)
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if len(report.Platforms) != 2 {
+		t.Fatalf("expected 2 platforms, got %d", len(report.Platforms))
+	}
+	p := report.Platforms[0]
+	if p.UserID != 1 || p.LinkOnlineRate != 0.75 {
+		t.Fatalf("unexpected link online rate: %+v", p)
+	}
+	if p.Vehicles != 2 || p.OnlineVehicles != 1 || p.OnlineRate != 0.5 {
+		t.Fatalf("unexpected vehicle online: %d/%d", p.OnlineVehicles, p.Vehicles)
+	}
+	if p.TrackCompleteness != 0.5 || p.DriftPoints != 1 || p.ChecksAnswered != 1 || p.ChecksLate != 1 || p.CheckResponseRate != 0.5 {
+		t.Fatalf("unexpected platform stats: %+v", p)
+	}
+	if report.Platforms[1].LinkOnlineRate != 0 || report.Platforms[1].SampledSeconds != 240 {
+		t.Fatalf("unexpected offline platform stats: %+v", report.Platforms[1])
+	}
+	if len(report.Vehicles) != 2 || report.Vehicles[0].OnlineDays != 1 {
+		t.Fatalf("unexpected vehicles: %+v", report.Vehicles)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WritePlatformCSV(&buf); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "75.00%") {
+		t.Fatalf("unexpected csv: %q", buf.String())
+	}
+}
+
+func TestAssessmentMemoryRetention(t *testing.T) {
+	rec, err := NewAssessmentRecorder("", 0)
+	if err != nil {
+		t.Fatalf("new recorder: %v", err)
+	}
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	rec.RecordAlarm(1, "粤B12345", jtt809.PlateColorBlue, true, base)
+	if err := rec.Flush(base); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(rec.days) != 1 {
+		t.Fatalf("expected recent day kept, got %d days", len(rec.days))
+	}
+
+	// 未配置数据目录时超过保留天数的日期直接淘汰
+	if err := rec.Flush(base.AddDate(0, 0, assessmentMemoryDays+1)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(rec.days) != 0 {
+		t.Fatalf("expected old days evicted, got %d days", len(rec.days))
+	}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// RuleKind 表示平台侧违规判定规则类型。
+type RuleKind string
+
+const (
+	RuleOverspeed    RuleKind = "overspeed"     // 超速
+	RuleFatigue      RuleKind = "fatigue"       // 疲劳驾驶（连续驾驶超时）
+	RuleDailyDriving RuleKind = "daily_driving" // 当日累计驾驶超时
+	RuleNightDriving RuleKind = "night_driving" // 夜间禁行时段违规运行
+)
+
+// SpeedRule 描述一条限速规则。
+// VehicleTypes 为空时对所有车型生效；FenceIDs 非空时仅在车辆位于任一围栏（可用线路走廊表示某一等级道路）内时生效。
+// 同时命中多条规则时取最低限速。
+type SpeedRule struct {
+	Name         string        `json:"name"`
+	MaxSpeed     float64       `json:"max_speed"` // km/h
+	Duration     time.Duration `json:"duration"`  // 持续超速达到该时长才报警，0 表示立即报警
+	VehicleTypes []string      `json:"vehicle_types,omitempty"`
+	FenceIDs     []string      `json:"fence_ids,omitempty"`
+}
+
+// NightBanRule 描述夜间禁行规则，默认 02:00-05:00 禁止客运车辆运行。
+type NightBanRule struct {
+	Window       TimeWindow `json:"window"`
+	VehicleTypes []string   `json:"vehicle_types,omitempty"` // 为空时对所有车型生效
+}
+
+// RuleConfig 平台侧违规判定参数。
+type RuleConfig struct {
+	SpeedRules []SpeedRule `json:"speed_rules,omitempty"`
+
+	// MovingSpeed 判定为行驶状态的最低速度（km/h）
+	MovingSpeed float64 `json:"moving_speed"`
+	// MaxGap 相邻定位点最大间隔，超过时该段按停车处理，避免掉线期间被计入驾驶时长
+	MaxGap time.Duration `json:"max_gap"`
+	// MaxContinuousDriving 连续驾驶时长上限，<=0 表示不判定疲劳驾驶
+	MaxContinuousDriving time.Duration `json:"max_continuous_driving"`
+	// MinRest 连续停车达到该时长视为有效休息，连续驾驶时长清零
+	MinRest time.Duration `json:"min_rest"`
+	// MaxDailyDriving 当日（UTC+8）累计驾驶时长上限，<=0 表示不判定
+	MaxDailyDriving time.Duration `json:"max_daily_driving"`
+	// NightBan 夜间禁行规则，nil 表示不判定
+	NightBan *NightBanRule `json:"night_ban,omitempty"`
+
+	// NotifyPlatform 报警开始时向车属平台下发 0x9402 报警预警
+	NotifyPlatform bool `json:"notify_platform"`
+}
+
+// DefaultRuleConfig 返回默认规则：连续驾驶 4 小时未休息 20 分钟判定疲劳，
+// 当日累计驾驶 8 小时判定超时，客运车辆（车型 passenger）02:00-05:00 禁行。
+func DefaultRuleConfig() RuleConfig {
+	return RuleConfig{
+		MovingSpeed:          5,
+		MaxGap:               5 * time.Minute,
+		MaxContinuousDriving: 4 * time.Hour,
+		MinRest:              20 * time.Minute,
+		MaxDailyDriving:      8 * time.Hour,
+		NightBan: &NightBanRule{
+			Window:       TimeWindow{Start: "02:00", End: "05:00"},
+			VehicleTypes: []string{"passenger"},
+		},
+	}
+}
+
+// RuleAlarm 表示一条平台侧判定的报警记录。Active 为 true 表示报警持续中，EndTime 为零值。
+type RuleAlarm struct {
+	ID           string            `json:"id"`
+	Kind         RuleKind          `json:"kind"`
+	RuleName     string            `json:"rule_name,omitempty"`
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+	WarnType     jtt809.WarnType   `json:"warn_type"`
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time,omitempty"`
+	Active       bool              `json:"active"`
+	Limit        float64           `json:"limit"` // 超速为限速 km/h，时长类为上限分钟数
+	Value        float64           `json:"value"` // 超速为最高速度 km/h，时长类为实际分钟数
+	Longitude    float64           `json:"longitude"`
+	Latitude     float64           `json:"latitude"`
+}
+
+// Describe 返回报警的中文描述，用作报警预警内容。
+func (a *RuleAlarm) Describe() string {
+	switch a.Kind {
+	case RuleOverspeed:
+		return fmt.Sprintf("车辆超速，限速%.0fkm/h，最高%.0fkm/h", a.Limit, a.Value)
+	case RuleFatigue:
+		return fmt.Sprintf("疲劳驾驶，连续驾驶%.0f分钟", a.Value)
+	case RuleDailyDriving:
+		return fmt.Sprintf("当日累计驾驶超时，已驾驶%.0f分钟", a.Value)
+	case RuleNightDriving:
+		return "夜间禁行时段违规运行"
+	}
+	return string(a.Kind)
+}
+
+// RuleEngine 基于 GNSS 数据的速度与定位时间判定超速、疲劳、累计驾驶与夜间禁行。
+// 按车辆维护驾驶状态，乱序或重复的定位点直接忽略。
+type RuleEngine struct {
+	mu       sync.Mutex
+	cfg      RuleConfig
+	fences   *GeofenceEngine
+	types    map[string]string      // vehicleKey -> 车型，属于车辆本身，不区分平台
+	vehicles map[string]*driveState // platformVehicleKey -> 驾驶状态
+	seq      uint64
+}
+
+type driveState struct {
+	last       time.Time
+	lastMoving bool
+
+	driving time.Duration // 当前连续驾驶时长
+	resting time.Duration // 当前连续停车时长
+	day     time.Time     // 累计驾驶所属自然日
+	daily   time.Duration // 当日累计驾驶时长
+
+	overStart time.Time // 超速开始时间，未超速时为零值
+	overMax   float64
+
+	active map[RuleKind]*RuleAlarm
+}
+
+// NewRuleEngine 创建规则引擎，fences 用于按围栏区分道路等级的限速规则，可为 nil。
+func NewRuleEngine(cfg RuleConfig, fences *GeofenceEngine) *RuleEngine {
+	return &RuleEngine{
+		cfg:      cfg,
+		fences:   fences,
+		types:    make(map[string]string),
+		vehicles: make(map[string]*driveState),
+	}
+}
+
+// SetConfig 替换规则参数，已有的驾驶状态保留。
+func (e *RuleEngine) SetConfig(cfg RuleConfig) {
+	e.mu.Lock()
+	e.cfg = cfg
+	e.mu.Unlock()
+}
+
+// Config 返回当前规则参数。
+func (e *RuleEngine) Config() RuleConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cfg
+}
+
+// SetVehicleType 设置车辆车型（如 passenger、freight、dangerous），用于匹配限速与禁行规则。
+func (e *RuleEngine) SetVehicleType(plate string, color jtt809.PlateColor, vehicleType string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if vehicleType == "" {
+		delete(e.types, vehicleKey(plate, color))
+		return
+	}
+	e.types[vehicleKey(plate, color)] = vehicleType
+}
+
+// forget 清除车辆在该平台下的驾驶状态，用于车辆被淘汰后释放内存，持续中的报警随之丢弃。
+// 车型由调用方设置且不区分平台，不随淘汰清除。
+func (e *RuleEngine) forget(userID uint32, plate string, color jtt809.PlateColor) {
+	e.mu.Lock()
+	delete(e.vehicles, platformVehicleKey(userID, plate, color))
+	e.mu.Unlock()
+}
+
+// Active 返回所有持续中的报警，按开始时间排序。
+func (e *RuleEngine) Active() []RuleAlarm {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var result []RuleAlarm
+	for _, st := range e.vehicles {
+		for _, a := range st.active {
+			result = append(result, *a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StartTime.Before(result[j].StartTime) })
+	return result
+}
+
+// Evaluate 用一个定位点推进车辆驾驶状态，返回新产生或已结束的报警。
+func (e *RuleEngine) Evaluate(userID uint32, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, now time.Time) []RuleAlarm {
+	if gnss == nil {
+		return nil
+	}
+	t := gnss.DateTime.Time()
+	if t.IsZero() {
+		t = now
+	}
+	speed := float64(gnss.Speed) / 10
+	pos := GeoPoint{Longitude: gnss.Longitude, Latitude: gnss.Latitude}
+	key := platformVehicleKey(userID, plate, color)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cfg := e.cfg
+	st := e.vehicles[key]
+	if st == nil {
+		st = &driveState{active: make(map[RuleKind]*RuleAlarm), day: dayStart(t)}
+		e.vehicles[key] = st
+	}
+	if !st.last.IsZero() && !t.After(st.last) {
+		return nil
+	}
+	moving := speed >= cfg.MovingSpeed
+	var changes []RuleAlarm
+	raise := func(kind RuleKind, warnType jtt809.WarnType, start time.Time, name string, limit, value float64) {
+		if _, ok := st.active[kind]; ok {
+			return
+		}
+		e.seq++
+		a := &RuleAlarm{
+			ID:           fmt.Sprintf("%s-%d-%d", kind, start.Unix(), e.seq),
+			Kind:         kind,
+			RuleName:     name,
+			UserID:       userID,
+			VehicleNo:    plate,
+			VehicleColor: color,
+			WarnType:     warnType,
+			StartTime:    start,
+			Active:       true,
+			Limit:        limit,
+			Value:        value,
+			Longitude:    pos.Longitude,
+			Latitude:     pos.Latitude,
+		}
+		st.active[kind] = a
+		changes = append(changes, *a)
+	}
+	resolve := func(kind RuleKind, end time.Time) {
+		a, ok := st.active[kind]
+		if !ok {
+			return
+		}
+		delete(st.active, kind)
+		a.Active = false
+		a.EndTime = end
+		changes = append(changes, *a)
+	}
+
+	// 自然日切换时结束当日累计驾驶报警
+	if day := dayStart(t); !day.Equal(st.day) {
+		st.day = day
+		st.daily = 0
+		resolve(RuleDailyDriving, t)
+	}
+
+	if !st.last.IsZero() {
+		dt := t.Sub(st.last)
+		if st.lastMoving && (cfg.MaxGap <= 0 || dt <= cfg.MaxGap) {
+			st.driving += dt
+			st.daily += dt
+			st.resting = 0
+		} else {
+			st.resting += dt
+			if cfg.MinRest > 0 && st.resting >= cfg.MinRest {
+				st.driving = 0
+				resolve(RuleFatigue, t)
+			}
+		}
+	}
+	st.last = t
+	st.lastMoving = moving
+
+	if cfg.MaxContinuousDriving > 0 && st.driving > cfg.MaxContinuousDriving {
+		raise(RuleFatigue, jtt809.WarnTypeFatigueDriving, t, "", cfg.MaxContinuousDriving.Minutes(), st.driving.Minutes())
+	}
+	if cfg.MaxDailyDriving > 0 && st.daily > cfg.MaxDailyDriving {
+		raise(RuleDailyDriving, jtt809.WarnTypeOvertimeDriving, t, "", cfg.MaxDailyDriving.Minutes(), st.daily.Minutes())
+	}
+
+	vehicleType := e.types[vehicleKey(plate, color)]
+	if rule, limit := e.speedLimitLocked(cfg, vehicleType, pos); rule != nil && speed > limit {
+		if st.overStart.IsZero() {
+			st.overStart = t
+			st.overMax = 0
+		}
+		st.overMax = max(st.overMax, speed)
+		if a, ok := st.active[RuleOverspeed]; ok {
+			a.Value = st.overMax
+		} else if t.Sub(st.overStart) >= rule.Duration {
+			raise(RuleOverspeed, jtt809.WarnTypeOverspeed, st.overStart, rule.Name, limit, st.overMax)
+		}
+	} else {
+		st.overStart = time.Time{}
+		resolve(RuleOverspeed, t)
+	}
+
+	if ban := cfg.NightBan; ban != nil && matchVehicleType(ban.VehicleTypes, vehicleType) && moving && ban.Window.contains(t) {
+		raise(RuleNightDriving, jtt809.WarnTypeViolationDriving, t, "", 0, speed)
+	} else {
+		resolve(RuleNightDriving, t)
+	}
+	return changes
+}
+
+// speedLimitLocked 返回命中的最低限速规则。
+func (e *RuleEngine) speedLimitLocked(cfg RuleConfig, vehicleType string, p GeoPoint) (*SpeedRule, float64) {
+	var best *SpeedRule
+	for i := range cfg.SpeedRules {
+		r := &cfg.SpeedRules[i]
+		if r.MaxSpeed <= 0 || !matchVehicleType(r.VehicleTypes, vehicleType) {
+			continue
+		}
+		if len(r.FenceIDs) > 0 {
+			if e.fences == nil {
+				continue
+			}
+			inside := false
+			for _, id := range r.FenceIDs {
+				if e.fences.Contains(id, p) {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				continue
+			}
+		}
+		if best == nil || r.MaxSpeed < best.MaxSpeed {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best, best.MaxSpeed
+}
+
+func matchVehicleType(types []string, vehicleType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == vehicleType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestRuleEngineFatigueAndRest(t *testing.T) {
+	cfg := DefaultRuleConfig()
+	cfg.NightBan = nil
+	engine := NewRuleEngine(cfg, nil)
+	base := time.Date(2025, 3, 1, 6, 0, 0, 0, trackPartitionZone)
+	feed := func(offset time.Duration, speed uint16) []RuleAlarm {
+		g := gnssAt(114.05, 22.54, base.Add(offset))
+		g.Speed = speed
+		return engine.Evaluate(1, "粤B12345", jtt809.PlateColorBlue, g, base)
+	}
+
+	var raised []RuleAlarm
+	for m := 0; m <= 241; m++ {
+		raised = append(raised, feed(time.Duration(m)*time.Minute, 600)...)
+	}
+	if len(raised) != 1 || raised[0].Kind != RuleFatigue || !raised[0].Active || raised[0].WarnType != jtt809.WarnTypeFatigueDriving {
+		t.Fatalf("expected one fatigue alarm, got %+v", raised)
+	}
+	// 重复或乱序点被忽略
+	if alarms := feed(241*time.Minute, 0); alarms != nil {
+		t.Fatalf("duplicate point should be ignored, got %+v", alarms)
+	}
+	// 停车不足 20 分钟不结束报警，满 20 分钟结束
+	if alarms := feed(250*time.Minute, 0); len(alarms) != 0 {
+		t.Fatalf("unexpected alarms during short rest: %+v", alarms)
+	}
+	alarms := feed(262*time.Minute, 0)
+	if len(alarms) != 1 || alarms[0].Active || alarms[0].EndTime.IsZero() {
+		t.Fatalf("expected fatigue alarm to end after rest, got %+v", alarms)
+	}
+	if len(engine.Active()) != 0 {
+		t.Fatalf("expected no active alarms")
+	}
+}
+
+func TestRuleEngineOverspeedAndNightBan(t *testing.T) {
+	cfg := DefaultRuleConfig()
+	cfg.SpeedRules = []SpeedRule{
+		{Name: "通用", MaxSpeed: 100, Duration: 30 * time.Second},
+		{Name: "客运", MaxSpeed: 80, Duration: 30 * time.Second, VehicleTypes: []string{"passenger"}},
+	}
+	engine := NewRuleEngine(cfg, nil)
+	engine.SetVehicleType("粤B12345", jtt809.PlateColorBlue, "passenger")
+	base := time.Date(2025, 3, 1, 1, 59, 0, 0, trackPartitionZone)
+	feed := func(offset time.Duration, speed uint16) []RuleAlarm {
+		g := gnssAt(114.05, 22.54, base.Add(offset))
+		g.Speed = speed
+		return engine.Evaluate(1, "粤B12345", jtt809.PlateColorBlue, g, base)
+	}
+
+	if alarms := feed(0, 900); len(alarms) != 0 {
+		t.Fatalf("overspeed must last 30s, got %+v", alarms)
+	}
+	alarms := feed(40*time.Second, 950)
+	if len(alarms) != 1 || alarms[0].Kind != RuleOverspeed || alarms[0].Limit != 80 || !alarms[0].StartTime.Equal(base) {
+		t.Fatalf("expected overspeed alarm with passenger limit, got %+v", alarms)
+	}
+	// 02:00 进入禁行时段，同时速度恢复正常
+	alarms = feed(61*time.Second, 600)
+	if len(alarms) != 2 {
+		t.Fatalf("expected overspeed end and night ban start, got %+v", alarms)
+	}
+	if alarms[0].Kind != RuleOverspeed || alarms[0].Active || alarms[0].Value != 95 {
+		t.Fatalf("unexpected overspeed end: %+v", alarms[0])
+	}
+	if alarms[1].Kind != RuleNightDriving || !alarms[1].Active {
+		t.Fatalf("unexpected night ban alarm: %+v", alarms[1])
+	}
+}
@@ -14,10 +14,26 @@ func printStartupInfo(cfg Config, withRtp bool) {
 	fmt.Println("\n📡 服务信息:")
 	fmt.Printf("  ├─ 主链路监听地址: %s\n", cfg.MainListen)
 	if cfg.HTTPListen != "" {
-		fmt.Printf("  ├─ HTTP管理地址:   %s\n", cfg.HTTPListen)
+		scheme := "HTTP"
+		if cfg.HTTPTLS != nil {
+			scheme = "HTTPS"
+		}
+		auth := "未启用认证"
+		if cfg.HTTPAuth != nil {
+			auth = "已启用认证"
+		}
+		fmt.Printf("  ├─ HTTP管理地址:   %s（%s，%s）\n", cfg.HTTPListen, scheme, auth)
 	} else {
 		fmt.Printf("  ├─ HTTP管理地址:   未启用\n")
 	}
+	if cfg.DataDir != "" {
+		fmt.Printf("  ├─ 数据目录:       %s\n", cfg.DataDir)
+	} else {
+		fmt.Printf("  ├─ 数据目录:       未启用（状态仅保存在内存）\n")
+	}
+	if cfg.Relay != nil {
+		fmt.Printf("  ├─ 级联上级平台:   %s（从链路监听 %s）\n", cfg.Relay.Address, cfg.Relay.SubListen)
+	}
 	if cfg.IdleTimeout > 0 {
 		fmt.Printf("  └─ 连接空闲超时:   %v\n", cfg.IdleTimeout)
 	} else {
@@ -50,11 +66,22 @@ func printStartupInfo(cfg Config, withRtp bool) {
 		fmt.Printf("  ├─ 监控系统:     GET  http://%s/ui\n", cfg.HTTPListen)
 		fmt.Printf("  ├─ 平台状态:     GET  http://%s/api/platforms\n", cfg.HTTPListen)
 		fmt.Printf("  ├─ 请求视频流:   POST http://%s/api/video/request\n", cfg.HTTPListen)
+		if cfg.DataDir != "" {
+			fmt.Printf("  ├─ 历史轨迹:     GET  http://%s/api/track\n", cfg.HTTPListen)
+		}
+		fmt.Printf("  ├─ 电子围栏:     GET/POST/DELETE http://%s/api/geofences\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 违规报警:     GET  http://%s/api/rule-alarms\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 数据质量:     GET  http://%s/api/quality\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 考核报表:     GET  http://%s/api/report\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 平台查岗:     POST http://%s/api/platform-check\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 实时事件:     GET  http://%s/api/events (SSE/WebSocket)\n", cfg.HTTPListen)
+		fmt.Printf("  ├─ 管理接口:     http://%s/api/v1 (OpenAPI: /api/v1/openapi.yaml)\n", cfg.HTTPListen)
 		if withRtp {
 			fmt.Printf("  ├─ 裸流代理:     GET  http://%s/proxy/rtp.raw\n", cfg.HTTPListen)
 			fmt.Printf("  ├─ FLV代理:      GET  http://%s/proxy/rtp.flv\n", cfg.HTTPListen)
 		}
 
+		fmt.Printf("  ├─ 运行指标:     GET  http://%s/metrics\n", cfg.HTTPListen)
 		fmt.Printf("  └─ 健康检查:     GET  http://%s/healthz\n", cfg.HTTPListen)
 	}
 
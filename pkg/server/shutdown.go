@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// shutdownTimeout 返回关闭链路时等待应答与回调的时限。
+func (g *JT809Gateway) shutdownTimeout() time.Duration {
+	if g.cfg.ShutdownTimeout > 0 {
+		return g.cfg.ShutdownTimeout
+	}
+	return 5 * time.Second
+}
+
+// ClosePlatformLink 主动关闭指定平台的主从链路：发送 0x9008 关闭通知（reason 见 jtt809.CloseLinkGatewayRestart、jtt809.CloseLinkOther），
+// 从链路在线时发送 0x9003 注销请求并等待 0x9004 应答（最长 ShutdownTimeout），随后断开从链路与主链路。下级平台可重新登录。
+func (g *JT809Gateway) ClosePlatformLink(userID uint32, reason byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+	defer cancel()
+	return g.closePlatformLink(ctx, userID, reason)
+}
+
+func (g *JT809Gateway) closePlatformLink(ctx context.Context, userID uint32, reason byte) error {
+	snap, ok := g.store.Snapshot(userID)
+	if !ok {
+		return ErrPlatformNotFound
+	}
+	if snap.MainSessionID == "" && !snap.SubConnected {
+		return ErrLinkNotConnected
+	}
+	slog.Info("closing platform links", "user_id", userID, "reason", reason)
+
+	header := jtt809.Header{GNSSCenterID: snap.GNSSCenterID}
+	if err := g.SendToSubordinate(userID, header, jtt809.SubLinkCloseNotify{ReasonCode: reason}); err != nil {
+		slog.Warn("send 0x9008 failed", "user_id", userID, "err", err)
+	}
+	g.teardownSubLink(ctx, userID)
+	if snap.MainSessionID != "" {
+		if err := g.CloseMainLink(userID, "platform link closed"); err != nil {
+			slog.Warn("close main link failed", "user_id", userID, "err", err)
+		}
+	}
+	return nil
+}
+
+// teardownSubLink 结束平台的从链路：从链路在线时发送注销请求（0x9003）并等待应答（0x9004，最长至 ctx 结束），
+// 随后断开从链路，并结束等待该平台应答的请求。用于主链路注销、重复登录被顶替与主动关闭链路。
+func (g *JT809Gateway) teardownSubLink(ctx context.Context, userID uint32) {
+	if snap, ok := g.store.Snapshot(userID); ok && snap.SubConnected {
+		g.logoutSubLink(ctx, userID, jtt809.Header{GNSSCenterID: snap.GNSSCenterID}, snap.VerifyCode)
+	}
+	g.dropSubLink(userID)
+	if n := g.pending.abort(userID, ErrRequestAborted); n > 0 {
+		slog.Info("pending requests aborted", "user_id", userID, "count", n)
+	}
+}
+
+// logoutSubLink 发送从链路注销请求（0x9003）并等待应答（0x9004），超时后直接返回。
+func (g *JT809Gateway) logoutSubLink(ctx context.Context, userID uint32, header jtt809.Header, verifyCode uint32) {
+	ack := make(chan struct{})
+	g.subLogoutAcks.Store(userID, ack)
+	defer g.subLogoutAcks.Delete(userID)
+
+	if err := g.SendToSubordinate(userID, header, jtt809.SubLinkLogoutRequest{VerifyCode: verifyCode}); err != nil {
+		slog.Warn("send 0x9003 failed", "user_id", userID, "err", err)
+		return
+	}
+	select {
+	case <-ack:
+		slog.Info("sub link logout acknowledged", "user_id", userID)
+	case <-ctx.Done():
+		slog.Warn("sub link logout response timeout", "user_id", userID)
+	}
+}
+
+// handleSubLogoutResponse 处理从链路注销应答（0x9004），唤醒等待中的注销流程。
+func (g *JT809Gateway) handleSubLogoutResponse(userID uint32) {
+	if ack, ok := g.subLogoutAcks.LoadAndDelete(userID); ok {
+		close(ack.(chan struct{}))
+		return
+	}
+	slog.Debug("unexpected sub link logout response", "user_id", userID)
+}
+
+// shutdown 优雅停机：停止从链路重连，通知并关闭所有平台链路，断开未登录的连接，最后等待排队与执行中的回调完成。
+func (g *JT809Gateway) shutdown() {
+	g.closing.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+	defer cancel()
+	if g.relay != nil {
+		g.relay.close()
+	}
+
+	var wg sync.WaitGroup
+	for _, snap := range g.store.Snapshots() {
+		if snap.MainSessionID == "" && !snap.SubConnected {
+			continue
+		}
+		wg.Go(func() {
+			if err := g.closePlatformLink(ctx, snap.UserID, jtt809.CloseLinkGatewayRestart); err != nil && !errors.Is(err, ErrLinkNotConnected) {
+				slog.Warn("close platform links on shutdown failed", "user_id", snap.UserID, "err", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if g.mainSrv != nil {
+		for session := range g.mainSrv.GetAllSessions() {
+			session.Close("gateway shutdown")
+		}
+	}
+	if !g.drainCallbacks(ctx) {
+		slog.Warn("callbacks still running at shutdown deadline", "inflight", g.inflight.Load())
+		g.cancelCallbacks()
+	}
+	g.stopCallbacks()
+}
+
+// drainCallbacks 等待排队与执行中的回调完成，ctx 结束前未完成时返回 false。
+func (g *JT809Gateway) drainCallbacks(ctx context.Context) bool {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for g.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// fakeSubServer 模拟下级平台从链路服务端，应答 0x9001 与 0x9003，并记录收到的业务 ID。
+func fakeSubServer(t *testing.T, ackLogout bool) (port uint16, received chan uint16) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received = make(chan uint16, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		scanner.Split(splitJT809Frames)
+		for scanner.Scan() {
+			frame, err := jtt809.DecodeFrame(scanner.Bytes())
+			if err != nil {
+				continue
+			}
+			received <- frame.BodyID
+			var body jtt809.Body
+			switch {
+			case frame.BodyID == jtt809.DOWN_CONNECT_REQ:
+				body = jtt809.SubLinkLoginResponse{Result: 0}
+			case frame.BodyID == jtt809.DOWN_DISCONNECT_REQ && ackLogout:
+				body = jtt809.SubLinkLogoutResponse{}
+			default:
+				continue
+			}
+			data, _ := jtt809.EncodePackage(jtt809.Package{Header: jtt809.Header{GNSSCenterID: 1}, Body: body})
+			conn.Write(data)
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), received
+}
+
+func TestClosePlatformLink(t *testing.T) {
+	for _, ackLogout := range []bool{true, false} {
+		port, received := fakeSubServer(t, ackLogout)
+		g := &JT809Gateway{
+			cfg:     Config{ShutdownTimeout: 300 * time.Millisecond},
+			store:   NewPlatformStore(),
+			metrics: newGatewayMetrics(),
+		}
+		g.closing.Store(true) // 测试中不触发从链路重连
+		g.store.BindMainSession("s1", jtt809.LoginRequest{UserID: 1, DownLinkIP: "127.0.0.1", DownLinkPort: port}, 1, 99)
+		if !g.connectSubLink("127.0.0.1", port, 1, 1, 99, time.Second) {
+			t.Fatal("connect sub link failed")
+		}
+
+		start := time.Now()
+		if err := g.ClosePlatformLink(1, jtt809.CloseLinkOther); err != nil {
+			t.Fatalf("close platform link: %v", err)
+		}
+		elapsed := time.Since(start)
+		if ackLogout && elapsed >= 300*time.Millisecond {
+			t.Fatalf("expected close without waiting for timeout, took %v", elapsed)
+		}
+		if !ackLogout && elapsed < 300*time.Millisecond {
+			t.Fatalf("expected close to wait for logout response, took %v", elapsed)
+		}
+
+		// 主链路不可用时 0x9008 经从链路发送，随后发送 0x9003
+		var got []uint16
+		for len(got) < 3 {
+			select {
+			case id := <-received:
+				got = append(got, id)
+			case <-time.After(time.Second):
+				t.Fatalf("expected login, close notify and logout, got %04X", got)
+			}
+		}
+		if got[1] != jtt809.DOWN_CLOSELINK_INFORM || got[2] != jtt809.DOWN_DISCONNECT_REQ {
+			t.Fatalf("unexpected message order %04X", got)
+		}
+		waitFor(t, func() bool {
+			_, sub := g.store.GetLinkStatus(1)
+			return !sub
+		})
+	}
+
+	g := &JT809Gateway{store: NewPlatformStore(), metrics: newGatewayMetrics()}
+	if err := g.ClosePlatformLink(9, jtt809.CloseLinkOther); err != ErrPlatformNotFound {
+		t.Fatalf("expected ErrPlatformNotFound, got %v", err)
+	}
+}
+
+func TestNewGatewayReleasesResourcesOnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if _, err := readMQTTPacket(r); err != nil {
+			closed <- err
+			return
+		}
+		writeMQTTPacket(conn, mqttConnack, []byte{0, 0})
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for {
+			if _, err := readMQTTPacket(r); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	// 轨迹目录被同名文件占用，最后创建的轨迹存储失败
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "track"), nil, 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	_, err = NewJT809Gateway(Config{
+		MainListen: "127.0.0.1:0",
+		DataDir:    dir,
+		Accounts:   []Account{{UserID: 1, Password: "pass", GnssCenterID: 1}},
+		MQTT:       &MQTTConfig{Broker: ln.Addr().String()},
+	}, nil)
+	if err == nil {
+		t.Fatal("expected track store error")
+	}
+	select {
+	case err := <-closed:
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("mqtt publisher still connected after constructor failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mqtt publisher never connected")
+	}
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -16,6 +18,12 @@ type PlatformStore struct {
 	mu           sync.RWMutex
 	platforms    map[uint32]*PlatformState
 	sessionIndex map[string]uint32
+
+	// 持久化相关：dirty 记录待保存的平台，deleted 记录待删除的平台，由 Flush 统一落盘
+	persist Persistence
+	dirty   map[uint32]struct{}
+	deleted map[uint32]struct{}
+	flushMu sync.Mutex
 }
 
 // PlatformState 表示单个下级平台的会话信息与车辆缓存。
@@ -55,6 +63,8 @@ type VehicleState struct {
 	BatchCount   int
 
 	LastVideoAck *VideoAckState
+
+	RestoredAt time.Time // 从持久化恢复的时间，注册过期与淘汰计时不早于该时间
 }
 
 // VehicleRegistration 描述车辆注册上报内容。
@@ -104,6 +114,7 @@ type VehicleSnapshot struct {
 	Latitude     float64                 `json:"latitude,omitempty"`
 	BatchCount   int                     `json:"batch_count,omitempty"`
 	LastVideoAck *VideoAckState          `json:"video_ack,omitempty"`
+	RestoredAt   time.Time               `json:"restored_at,omitempty"`
 }
 
 // NewPlatformStore 初始化状态存储。
@@ -111,7 +122,115 @@ func NewPlatformStore() *PlatformStore {
 	return &PlatformStore{
 		platforms:    make(map[uint32]*PlatformState),
 		sessionIndex: make(map[string]uint32),
+		dirty:        make(map[uint32]struct{}),
+		deleted:      make(map[uint32]struct{}),
+	}
+}
+
+// AttachPersistence 绑定持久化后端并从中恢复平台与车辆状态。
+// 恢复的平台一律视为离线：不恢复会话、从链路与心跳时间，等待下级平台重新登录。
+// 恢复的车辆记录恢复时间，停机期间不计入注册过期与淘汰时长。
+func (s *PlatformStore) AttachPersistence(p Persistence) error {
+	if p == nil {
+		return errors.New("persistence is nil")
+	}
+	platforms, loadErr := p.LoadPlatforms()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persist = p
+	now := time.Now()
+	restored := 0
+	for _, pp := range platforms {
+		state := s.ensurePlatformLocked(pp.UserID)
+		state.GNSSCenterID = pp.GNSSCenterID
+		state.PlatformID = pp.PlatformID
+		state.AuthCode = pp.AuthCode
+		for _, pv := range pp.Vehicles {
+			v := state.ensureVehicleLocked(vehicleKey(pv.Number, pv.Color), pv.Number, pv.Color)
+			v.Registration = pv.Registration
+			v.Position = pv.Position
+			v.PositionTime = pv.PositionTime
+			v.BatchCount = pv.BatchCount
+			v.LastVideoAck = pv.LastVideoAck
+			v.RestoredAt = now
+		}
+		restored++
+	}
+	if restored > 0 {
+		slog.Info("platform state restored", "platforms", restored)
 	}
+	if loadErr != nil {
+		return fmt.Errorf("load persisted platforms: %w", loadErr)
+	}
+	return nil
+}
+
+// Flush 将自上次调用以来发生变化的平台状态写入持久化后端。
+// 未绑定持久化时直接返回。
+func (s *PlatformStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if s.persist == nil || (len(s.dirty) == 0 && len(s.deleted) == 0) {
+		s.mu.Unlock()
+		return nil
+	}
+	persist := s.persist
+	saves := make([]PersistedPlatform, 0, len(s.dirty))
+	for userID := range s.dirty {
+		if state, ok := s.platforms[userID]; ok {
+			saves = append(saves, state.persistedLocked())
+		}
+	}
+	deletes := make([]uint32, 0, len(s.deleted))
+	for userID := range s.deleted {
+		deletes = append(deletes, userID)
+	}
+	s.dirty = make(map[uint32]struct{})
+	s.deleted = make(map[uint32]struct{})
+	s.mu.Unlock()
+
+	var errs []error
+	for _, userID := range deletes {
+		if err := persist.DeletePlatform(userID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, p := range saves {
+		if err := persist.SavePlatform(p); err != nil {
+			errs = append(errs, err)
+			// 保存失败的平台重新标记，等待下次重试
+			s.mu.Lock()
+			if _, ok := s.platforms[p.UserID]; ok {
+				s.dirty[p.UserID] = struct{}{}
+			}
+			s.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ClosePersistence 落盘剩余变更并关闭持久化后端。
+func (s *PlatformStore) ClosePersistence() error {
+	flushErr := s.Flush()
+	s.mu.Lock()
+	persist := s.persist
+	s.persist = nil
+	s.mu.Unlock()
+	if persist == nil {
+		return flushErr
+	}
+	return errors.Join(flushErr, persist.Close())
+}
+
+func (s *PlatformStore) markDirtyLocked(userID uint32) {
+	if s.persist == nil {
+		return
+	}
+	delete(s.deleted, userID)
+	s.dirty[userID] = struct{}{}
 }
 
 // BindMainSession 在主链路登录成功后建立会话映射。
@@ -127,6 +246,7 @@ func (s *PlatformStore) BindMainSession(sessionID string, req jtt809.LoginReques
 	state.LastMainHeartbeat = time.Now()
 	state.MainDisconnectedAt = time.Time{} // 清除断开时间戳，表示已重连
 	s.sessionIndex[sessionID] = req.UserID
+	s.markDirtyLocked(req.UserID)
 }
 
 // BindSubSession 记录从链路连接。
@@ -161,29 +281,33 @@ func (s *PlatformStore) RecordHeartbeat(userID uint32, isMain bool) {
 	}
 }
 
-// RemoveSession 在连接关闭时清理索引。
-func (s *PlatformStore) RemoveSession(sessionID string) {
+// RemoveSession 在连接关闭时清理索引，返回平台主链路是否因此断开。
+// 同一账号仍有其他已登录连接（允许重复登录）时，由该连接接替主链路。
+func (s *PlatformStore) RemoveSession(sessionID string) bool {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	userID, ok := s.sessionIndex[sessionID]
 	if !ok {
-		s.mu.Unlock()
-		return
+		return false
 	}
+	delete(s.sessionIndex, sessionID)
 	state := s.platforms[userID]
-	if state == nil {
-		delete(s.sessionIndex, sessionID)
-		s.mu.Unlock()
-		return
+	if state == nil || state.MainSessionID != sessionID {
+		return false
 	}
-	if state.MainSessionID == sessionID {
-		// 主链路断开时，不关闭从链路，以支持降级场景
-		// 从链路可以继续接收下级平台的降级请求
-		state.MainSessionID = ""
-		state.MainDisconnectedAt = time.Now() // 记录主链路断开时间
-		slog.Info("main link disconnected, sub link remains active", "user_id", userID, "session", sessionID)
+	for sid, uid := range s.sessionIndex {
+		if uid == userID {
+			state.MainSessionID = sid
+			slog.Info("main link taken over by another session", "user_id", userID, "closed", sessionID, "session", sid)
+			return false
+		}
 	}
-	delete(s.sessionIndex, sessionID)
-	s.mu.Unlock()
+	// 主链路断开时，不关闭从链路，以支持降级场景
+	// 从链路可以继续接收下级平台的降级请求
+	state.MainSessionID = ""
+	state.MainDisconnectedAt = time.Now() // 记录主链路断开时间
+	slog.Info("main link disconnected, sub link remains active", "user_id", userID, "session", sessionID)
+	return true
 }
 
 // UpdateVehicleRegistration 存储车辆注册信息。
@@ -197,6 +321,7 @@ func (s *PlatformStore) UpdateVehicleRegistration(userID uint32, color jtt809.Pl
 	state := s.ensurePlatformLocked(userID)
 	v := state.ensureVehicleLocked(vehicleKey(vehicle, color), vehicle, color)
 	v.Registration = reg
+	s.markDirtyLocked(userID)
 }
 
 // UpdateLocation 写入最新定位数据。
@@ -213,6 +338,7 @@ func (s *PlatformStore) UpdateLocation(userID uint32, color jtt809.PlateColor, v
 	if batchCount > 0 {
 		v.BatchCount = batchCount
 	}
+	s.markDirtyLocked(userID)
 }
 
 // RecordVideoAck 缓存最新视频流地址。
@@ -226,6 +352,7 @@ func (s *PlatformStore) RecordVideoAck(userID uint32, color jtt809.PlateColor, v
 	state := s.ensurePlatformLocked(userID)
 	v := state.ensureVehicleLocked(vehicleKey(vehicle, color), vehicle, color)
 	v.LastVideoAck = ack
+	s.markDirtyLocked(userID)
 }
 
 // UpdateAuthCode 存储平台的时效口令
@@ -237,6 +364,7 @@ func (s *PlatformStore) UpdateAuthCode(userID uint32, platformID string, authCod
 	state := s.ensurePlatformLocked(userID)
 	state.PlatformID = platformID
 	state.AuthCode = authCode
+	s.markDirtyLocked(userID)
 }
 
 // GetAuthCode 获取平台的时效口令
@@ -280,11 +408,11 @@ func (s *PlatformStore) PlatformForSession(sessionID string) (uint32, bool) {
 	return user, ok
 }
 
-// ClearSubConn 清理从链路连接状态
-func (s *PlatformStore) ClearSubConn(userID uint32) {
+// ClearSubConn 清理从链路连接状态，c 已被新连接替换时不做处理。
+func (s *PlatformStore) ClearSubConn(userID uint32, c *client.SimpleClient) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if state, ok := s.platforms[userID]; ok {
+	if state, ok := s.platforms[userID]; ok && state.SubClient == c {
 		// 取消 context 以停止相关 goroutine
 		if state.SubLinkCancel != nil {
 			state.SubLinkCancel()
@@ -387,6 +515,10 @@ func (s *PlatformStore) RemovePlatform(userID uint32) {
 		}
 	}
 	delete(s.platforms, userID)
+	if s.persist != nil {
+		delete(s.dirty, userID)
+		s.deleted[userID] = struct{}{}
+	}
 }
 
 func (s *PlatformStore) ensurePlatformLocked(userID uint32) *PlatformState {
@@ -415,6 +547,35 @@ func (state *PlatformState) ensureVehicleLocked(key string, number string, color
 	return v
 }
 
+// CanTrackVehicle 判断平台是否可继续跟踪该车辆：车辆已存在或车辆数未达到 max。
+func (s *PlatformStore) CanTrackVehicle(userID uint32, vehicleKey string, max int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.platforms[userID]
+	if !ok {
+		return max > 0
+	}
+	if _, ok := state.Vehicles[vehicleKey]; ok {
+		return true
+	}
+	return len(state.Vehicles) < max
+}
+
+// VehicleOwners 返回缓存中存在该车辆的平台，按 userID 升序。
+func (s *PlatformStore) VehicleOwners(plate string, color jtt809.PlateColor) []uint32 {
+	key := vehicleKey(plate, color)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []uint32
+	for uid, state := range s.platforms {
+		if _, ok := state.Vehicles[key]; ok {
+			out = append(out, uid)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
 // RemoveVehicle 删除指定车辆
 func (s *PlatformStore) RemoveVehicle(userID uint32, vehicleKey string) {
 	s.mu.Lock()
@@ -424,6 +585,7 @@ func (s *PlatformStore) RemoveVehicle(userID uint32, vehicleKey string) {
 		return
 	}
 	delete(state.Vehicles, vehicleKey)
+	s.markDirtyLocked(userID)
 }
 
 func (state *PlatformState) snapshotLocked() PlatformSnapshot {
@@ -448,6 +610,7 @@ func (state *PlatformState) snapshotLocked() PlatformSnapshot {
 			VehicleColor: v.Color,
 			BatchCount:   v.BatchCount,
 			PositionTime: v.PositionTime,
+			RestoredAt:   v.RestoredAt,
 		}
 		if v.Registration != nil {
 			cp := *v.Registration
@@ -470,6 +633,44 @@ func (state *PlatformState) snapshotLocked() PlatformSnapshot {
 	return snap
 }
 
+func (state *PlatformState) persistedLocked() PersistedPlatform {
+	p := PersistedPlatform{
+		UserID:       state.UserID,
+		GNSSCenterID: state.GNSSCenterID,
+		PlatformID:   state.PlatformID,
+		AuthCode:     state.AuthCode,
+		Vehicles:     make([]PersistedVehicle, 0, len(state.Vehicles)),
+		SavedAt:      time.Now(),
+	}
+	for _, v := range state.Vehicles {
+		pv := PersistedVehicle{
+			Number:       v.Number,
+			Color:        v.Color,
+			PositionTime: v.PositionTime,
+			BatchCount:   v.BatchCount,
+		}
+		if v.Registration != nil {
+			cp := *v.Registration
+			pv.Registration = &cp
+		}
+		if v.Position != nil {
+			cp := *v.Position
+			pv.Position = &cp
+		}
+		if v.LastVideoAck != nil {
+			cp := *v.LastVideoAck
+			pv.LastVideoAck = &cp
+		}
+		p.Vehicles = append(p.Vehicles, pv)
+	}
+	return p
+}
+
 func vehicleKey(no string, color jtt809.PlateColor) string {
 	return fmt.Sprintf("%s#%d", no, color)
 }
+
+// platformVehicleKey 区分平台的车辆键，不同下级平台可能上报同一车牌。
+func platformVehicleKey(userID uint32, no string, color jtt809.PlateColor) string {
+	return fmt.Sprintf("%d#%s", userID, vehicleKey(no, color))
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// ErrTrackStoreDisabled 表示未配置数据目录，轨迹存储未启用。
+var ErrTrackStoreDisabled = errors.New("track store is not enabled, set Config.DataDir")
+
+// TrackSource 表示轨迹点的来源报文。
+type TrackSource string
+
+const (
+	TrackSourceRealtime      TrackSource = "realtime"      // 0x1202 实时上传
+	TrackSourceSupplementary TrackSource = "supplementary" // 0x1203 自动补报
+)
+
+// trackPartitionZone 轨迹按协议时区（UTC+8）的自然日分区。
+var trackPartitionZone = time.FixedZone("UTC+8", 8*3600)
+
+// TrackPoint 表示一条历史轨迹点。
+type TrackPoint struct {
+	UserID     uint32            `json:"user_id"`
+	VehicleNo  string            `json:"vehicle_no"`
+	Color      jtt809.PlateColor `json:"vehicle_color"`
+	Time       time.Time         `json:"time"`        // GNSS 定位时间，非法时取接收时间
+	ReceivedAt time.Time         `json:"received_at"` // 上级平台接收时间
+	Longitude  float64           `json:"longitude"`
+	Latitude   float64           `json:"latitude"`
+	Altitude   uint16            `json:"altitude"`
+	Speed      uint16            `json:"speed"` // 0.1 km/h
+	Direction  uint16            `json:"direction"`
+	Mileage    uint32            `json:"mileage,omitempty"` // 0.1 km
+	Alarm      uint32            `json:"alarm,omitempty"`
+	State      uint32            `json:"state,omitempty"`
+	Source     TrackSource       `json:"source"`
+}
+
+// NewTrackPoint 由 GNSS 数据构造轨迹点。
+func NewTrackPoint(userID uint32, plate string, color jtt809.PlateColor, gnss *jtt809.GNSSData, source TrackSource, receivedAt time.Time) TrackPoint {
+	t := gnss.DateTime.Time()
+	if t.IsZero() {
+		t = receivedAt
+	}
+	return TrackPoint{
+		UserID:     userID,
+		VehicleNo:  plate,
+		Color:      color,
+		Time:       t,
+		ReceivedAt: receivedAt,
+		Longitude:  gnss.Longitude,
+		Latitude:   gnss.Latitude,
+		Altitude:   gnss.Altitude,
+		Speed:      gnss.Speed,
+		Direction:  gnss.Direction,
+		Mileage:    gnss.Mileage,
+		Alarm:      gnss.Alarm,
+		State:      gnss.State,
+		Source:     source,
+	}
+}
+
+// TrackStore 是嵌入式的历史轨迹存储：按自然日分目录，每车每天一个只追加的 JSON Lines 文件。
+// 目录结构：<dir>/<YYYYMMDD>/<hex(车牌)>_<颜色>.jsonl
+type TrackStore struct {
+	dir       string
+	retention time.Duration
+
+	mu          sync.Mutex
+	files       map[string]*list.Element // path -> lru 中的 *trackFile
+	lru         *list.List               // 最近写入的在前
+	lastCleanup time.Time
+}
+
+type trackFile struct {
+	path string
+	f    *os.File
+}
+
+const (
+	// maxOpenTrackFiles 同时保持打开的分区文件上限，超过后关闭最久未写入的文件。
+	maxOpenTrackFiles = 512
+	// maxTrackQueryRange 单次轨迹查询的最大时间跨度。
+	maxTrackQueryRange = 31 * 24 * time.Hour
+	// maxTrackFutureSkew 定位时间晚于接收时间的容许偏差，超出的点不写入，避免错误的终端时钟产生清理不到的未来分区。
+	maxTrackFutureSkew = 24 * time.Hour
+)
+
+// NewTrackStore 创建轨迹存储，retentionDays<=0 表示永久保留。
+func NewTrackStore(dir string, retentionDays int) (*TrackStore, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, errors.New("track dir must not be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create track dir: %w", err)
+	}
+	var retention time.Duration
+	if retentionDays > 0 {
+		retention = time.Duration(retentionDays) * 24 * time.Hour
+	}
+	return &TrackStore{
+		dir:       dir,
+		retention: retention,
+		files:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}, nil
+}
+
+// Append 追加写入轨迹点，按定位时间落入对应日期分区。定位时间超过接收时间一天以上的点被丢弃并返回错误。
+func (s *TrackStore) Append(points ...TrackPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, p := range points {
+		received := p.ReceivedAt
+		if received.IsZero() {
+			received = time.Now()
+		}
+		if p.Time.Sub(received) > maxTrackFutureSkew {
+			errs = append(errs, fmt.Errorf("track point time %s is too far ahead of receive time %s", p.Time.Format(time.RFC3339), received.Format(time.RFC3339)))
+			continue
+		}
+		line, err := json.Marshal(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f, err := s.partitionFileLocked(p.Time, p.VehicleNo, p.Color)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			errs = append(errs, fmt.Errorf("append track point: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Query 查询 [from, to] 时间范围内的轨迹，结果按定位时间升序并去除重复补报点。时间跨度不得超过 31 天。
+func (s *TrackStore) Query(plate string, color jtt809.PlateColor, from, to time.Time) ([]TrackPoint, error) {
+	if to.Before(from) {
+		return nil, errors.New("query range end before start")
+	}
+	if to.Sub(from) > maxTrackQueryRange {
+		return nil, fmt.Errorf("query range exceeds %d days", maxTrackQueryRange/(24*time.Hour))
+	}
+	result := make([]TrackPoint, 0)
+	name := trackFileName(plate, color)
+	start := dayStart(from)
+	for day := start; !day.After(to); day = day.AddDate(0, 0, 1) {
+		path := filepath.Join(s.dir, day.Format("20060102"), name)
+		points, err := readTrackFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, p := range points {
+			if p.Time.Before(from) || p.Time.After(to) {
+				continue
+			}
+			result = append(result, p)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return dedupTrack(result), nil
+}
+
+// Cleanup 删除超出保留期的日期分区，至多每小时执行一次。
+func (s *TrackStore) Cleanup(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	s.mu.Lock()
+	if now.Sub(s.lastCleanup) < time.Hour {
+		s.mu.Unlock()
+		return
+	}
+	s.lastCleanup = now
+	s.closeFilesLocked()
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		slog.Warn("read track dir failed", "err", err)
+		return
+	}
+	cutoff := dayStart(now.Add(-s.retention))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		day, err := time.ParseInLocation("20060102", entry.Name(), trackPartitionZone)
+		if err != nil || !day.Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, entry.Name())); err != nil {
+			slog.Warn("remove expired track partition failed", "partition", entry.Name(), "err", err)
+			continue
+		}
+		slog.Info("expired track partition removed", "partition", entry.Name())
+	}
+}
+
+// Close 关闭全部打开的分区文件。
+func (s *TrackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFilesLocked()
+}
+
+func (s *TrackStore) partitionFileLocked(t time.Time, plate string, color jtt809.PlateColor) (*os.File, error) {
+	dayDir := filepath.Join(s.dir, t.In(trackPartitionZone).Format("20060102"))
+	path := filepath.Join(dayDir, trackFileName(plate, color))
+	if e, ok := s.files[path]; ok {
+		s.lru.MoveToFront(e)
+		return e.Value.(*trackFile).f, nil
+	}
+	if s.lru.Len() >= maxOpenTrackFiles {
+		oldest := s.lru.Remove(s.lru.Back()).(*trackFile)
+		delete(s.files, oldest.path)
+		if err := oldest.f.Close(); err != nil {
+			slog.Warn("close track file failed", "path", oldest.path, "err", err)
+		}
+	}
+	if err := os.MkdirAll(dayDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create track partition: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open track file: %w", err)
+	}
+	s.files[path] = s.lru.PushFront(&trackFile{path: path, f: f})
+	return f, nil
+}
+
+func (s *TrackStore) closeFilesLocked() error {
+	var errs []error
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		if err := e.Value.(*trackFile).f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.lru.Init()
+	clear(s.files)
+	return errors.Join(errs...)
+}
+
+func readTrackFile(path string) ([]TrackPoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var points []TrackPoint
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var p TrackPoint
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			// 进程异常退出可能留下不完整的最后一行，跳过即可
+			continue
+		}
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read track file: %w", err)
+	}
+	return points, nil
+}
+
+// trackFileName 车牌含中文，使用十六进制编码保证文件名在各平台可用。
+func trackFileName(plate string, color jtt809.PlateColor) string {
+	return fmt.Sprintf("%s_%d.jsonl", hex.EncodeToString([]byte(plate)), color)
+}
+
+func dayStart(t time.Time) time.Time {
+	t = t.In(trackPartitionZone)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, trackPartitionZone)
+}
+
+// dedupTrack 去除定位时间与坐标完全相同的重复点（下级平台可能重复补报），输入需已排序。
+func dedupTrack(points []TrackPoint) []TrackPoint {
+	if len(points) < 2 {
+		return points
+	}
+	out := points[:1]
+	for _, p := range points[1:] {
+		last := out[len(out)-1]
+		if p.Time.Equal(last.Time) && p.Longitude == last.Longitude && p.Latitude == last.Latitude {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// DownsampleTrack 对轨迹抽稀：相邻保留点间隔不小于 minInterval，且总点数不超过 maxPoints。
+// 首尾点始终保留，参数 <=0 表示不限制。
+func DownsampleTrack(points []TrackPoint, minInterval time.Duration, maxPoints int) []TrackPoint {
+	if len(points) <= 2 {
+		return points
+	}
+	out := points
+	if minInterval > 0 {
+		out = make([]TrackPoint, 0, len(points))
+		out = append(out, points[0])
+		for i := 1; i < len(points)-1; i++ {
+			if points[i].Time.Sub(out[len(out)-1].Time) >= minInterval {
+				out = append(out, points[i])
+			}
+		}
+		out = append(out, points[len(points)-1])
+	}
+	if maxPoints >= 2 && len(out) > maxPoints {
+		step := float64(len(out)-1) / float64(maxPoints-1)
+		sampled := make([]TrackPoint, 0, maxPoints)
+		for i := 0; i < maxPoints; i++ {
+			sampled = append(sampled, out[int(float64(i)*step+0.5)])
+		}
+		out = sampled
+	}
+	return out
+}
+
+// TrackGeoJSON 将轨迹转换为 GeoJSON FeatureCollection：
+// 第一个要素为整条轨迹的 LineString，其后为每个轨迹点的 Point（携带时间、速度等属性，便于回放）。
+func TrackGeoJSON(plate string, color jtt809.PlateColor, points []TrackPoint) map[string]any {
+	coords := make([][2]float64, 0, len(points))
+	features := make([]map[string]any, 0, len(points)+1)
+	for _, p := range points {
+		coords = append(coords, [2]float64{p.Longitude, p.Latitude})
+	}
+	features = append(features, map[string]any{
+		"type": "Feature",
+		"geometry": map[string]any{
+			"type":        "LineString",
+			"coordinates": coords,
+		},
+		"properties": map[string]any{
+			"vehicle_no":    plate,
+			"vehicle_color": color,
+			"count":         len(points),
+		},
+	})
+	for _, p := range points {
+		features = append(features, map[string]any{
+			"type": "Feature",
+			"geometry": map[string]any{
+				"type":        "Point",
+				"coordinates": [2]float64{p.Longitude, p.Latitude},
+			},
+			"properties": map[string]any{
+				"time":      p.Time,
+				"speed":     p.Speed,
+				"direction": p.Direction,
+				"altitude":  p.Altitude,
+				"mileage":   p.Mileage,
+				"alarm":     p.Alarm,
+				"source":    p.Source,
+			},
+		})
+	}
+	return map[string]any{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
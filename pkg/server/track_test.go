@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestTrackStoreAppendAndQuery(t *testing.T) {
+	store, err := NewTrackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("new track store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 3, 1, 23, 58, 0, 0, trackPartitionZone)
+	point := func(offset time.Duration, source TrackSource) TrackPoint {
+		return TrackPoint{
+			UserID:    10001,
+			VehicleNo: "粤B12345",
+			Color:     jtt809.PlateColorBlue,
+			Time:      base.Add(offset),
+			Longitude: 114.05 + offset.Minutes()/1000,
+			Latitude:  22.54,
+			Source:    source,
+		}
+	}
+	// 实时点跨越零点，补报点乱序到达并包含一个重复点
+	if err := store.Append(point(0, TrackSourceRealtime), point(4*time.Minute, TrackSourceRealtime)); err != nil {
+		t.Fatalf("append realtime: %v", err)
+	}
+	if err := store.Append(point(2*time.Minute, TrackSourceSupplementary), point(1*time.Minute, TrackSourceSupplementary), point(2*time.Minute, TrackSourceSupplementary)); err != nil {
+		t.Fatalf("append supplementary: %v", err)
+	}
+	if err := store.Append(TrackPoint{VehicleNo: "粤B99999", Color: jtt809.PlateColorBlue, Time: base}); err != nil {
+		t.Fatalf("append other vehicle: %v", err)
+	}
+
+	points, err := store.Query("粤B12345", jtt809.PlateColorBlue, base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(points) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(points))
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Time.Before(points[i-1].Time) {
+			t.Fatalf("points not sorted at %d", i)
+		}
+	}
+	if points[1].Source != TrackSourceSupplementary || points[3].Source != TrackSourceRealtime {
+		t.Fatalf("unexpected sources: %s %s", points[1].Source, points[3].Source)
+	}
+
+	partial, err := store.Query("粤B12345", jtt809.PlateColorBlue, base.Add(time.Minute), base.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("query partial: %v", err)
+	}
+	if len(partial) != 2 {
+		t.Fatalf("expected 2 points in partial range, got %d", len(partial))
+	}
+	if _, err := store.Query("粤B12345", jtt809.PlateColorBlue, base.AddDate(-1, 0, 0), base); err == nil {
+		t.Fatal("expected query range over 31 days rejected")
+	}
+}
+
+func TestTrackStoreRejectsFuturePoints(t *testing.T) {
+	store, err := NewTrackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("new track store: %v", err)
+	}
+	defer store.Close()
+
+	received := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	skewed := TrackPoint{VehicleNo: "粤B12345", Color: jtt809.PlateColorBlue, Time: received.Add(2 * time.Hour), ReceivedAt: received}
+	bogus := TrackPoint{VehicleNo: "粤B12345", Color: jtt809.PlateColorBlue, Time: received.AddDate(10, 0, 0), ReceivedAt: received}
+	if err := store.Append(skewed, bogus); err == nil {
+		t.Fatal("expected point far in the future rejected")
+	}
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "20250301" {
+		t.Fatalf("expected only the 20250301 partition, got %v", entries)
+	}
+}
+
+func TestTrackStoreOpenFileLimit(t *testing.T) {
+	store, err := NewTrackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("new track store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, trackPartitionZone)
+	appendPoint := func(plate string) {
+		t.Helper()
+		if err := store.Append(TrackPoint{VehicleNo: plate, Color: jtt809.PlateColorBlue, Time: base}); err != nil {
+			t.Fatalf("append %s: %v", plate, err)
+		}
+	}
+	for i := range maxOpenTrackFiles {
+		appendPoint(fmt.Sprintf("粤B%05d", i))
+	}
+	// 最早打开的车辆再次写入后变为最近使用，超出上限时只关闭最久未写入的文件
+	appendPoint("粤B00000")
+	appendPoint("粤C00000")
+	if n := len(store.files); n != maxOpenTrackFiles {
+		t.Fatalf("expected %d open files, got %d", maxOpenTrackFiles, n)
+	}
+	if _, ok := store.files[filepath.Join(store.dir, "20250301", trackFileName("粤B00000", jtt809.PlateColorBlue))]; !ok {
+		t.Fatal("expected recently used file kept open")
+	}
+	if _, ok := store.files[filepath.Join(store.dir, "20250301", trackFileName("粤B00001", jtt809.PlateColorBlue))]; ok {
+		t.Fatal("expected least recently used file closed")
+	}
+	points, err := store.Query("粤B00000", jtt809.PlateColorBlue, base, base)
+	if err != nil || len(points) != 1 {
+		t.Fatalf("expected deduplicated point after reopen, got %d: %v", len(points), err)
+	}
+}
+
+func TestDownsampleTrack(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	points := make([]TrackPoint, 0, 61)
+	for i := 0; i <= 60; i++ {
+		points = append(points, TrackPoint{Time: base.Add(time.Duration(i) * 10 * time.Second)})
+	}
+	byInterval := DownsampleTrack(points, time.Minute, 0)
+	if len(byInterval) != 11 {
+		t.Fatalf("expected 11 points, got %d", len(byInterval))
+	}
+	if !byInterval[0].Time.Equal(points[0].Time) || !byInterval[len(byInterval)-1].Time.Equal(points[60].Time) {
+		t.Fatalf("first and last points must be kept")
+	}
+	byCount := DownsampleTrack(points, 0, 5)
+	if len(byCount) != 5 || !byCount[4].Time.Equal(points[60].Time) {
+		t.Fatalf("unexpected max points result: %d", len(byCount))
+	}
+}
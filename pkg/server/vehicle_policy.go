@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// VehiclePolicy 车辆生命周期策略，时长字段为 0 时使用默认值。
+// 定位中断超过 OfflineAfter 时车辆标记为离线并触发 OnVehicleOffline，超过 EvictAfter 时从内存删除并触发 OnVehicleEvicted。
+type VehiclePolicy struct {
+	// AutoSubscribe 是否在车辆注册与定位中断后自动发送订阅请求（0x9205），nil 表示开启
+	AutoSubscribe *bool `yaml:"auto_subscribe"`
+	// SubscribeDelay 收到车辆注册后延迟订阅的时长，等待从链路就绪，默认 2s，<0 表示立即订阅
+	SubscribeDelay time.Duration `yaml:"subscribe_delay"`
+	// RegistrationExpiry 已注册但始终未上报定位的车辆保留时长，默认 10 分钟
+	RegistrationExpiry time.Duration `yaml:"registration_expiry"`
+	// ResubscribeAfter 定位中断超过该时长时重新发送订阅请求，默认 5 分钟
+	ResubscribeAfter time.Duration `yaml:"resubscribe_after"`
+	// OfflineAfter 定位中断超过该时长标记为离线，默认 5 分钟
+	OfflineAfter time.Duration `yaml:"offline_after"`
+	// EvictAfter 定位中断超过该时长从内存删除车辆，默认 10 分钟，<0 表示不删除
+	EvictAfter time.Duration `yaml:"evict_after"`
+	// MaxVehicles 单个平台最多跟踪的车辆数，达到上限后新车辆的报文被忽略，<=0 表示不限
+	MaxVehicles int `yaml:"max_vehicles"`
+}
+
+// merge 用 o 中的非零字段覆盖 p。
+func (p VehiclePolicy) merge(o VehiclePolicy) VehiclePolicy {
+	if o.AutoSubscribe != nil {
+		p.AutoSubscribe = o.AutoSubscribe
+	}
+	if o.SubscribeDelay != 0 {
+		p.SubscribeDelay = o.SubscribeDelay
+	}
+	if o.RegistrationExpiry != 0 {
+		p.RegistrationExpiry = o.RegistrationExpiry
+	}
+	if o.ResubscribeAfter != 0 {
+		p.ResubscribeAfter = o.ResubscribeAfter
+	}
+	if o.OfflineAfter != 0 {
+		p.OfflineAfter = o.OfflineAfter
+	}
+	if o.EvictAfter != 0 {
+		p.EvictAfter = o.EvictAfter
+	}
+	if o.MaxVehicles != 0 {
+		p.MaxVehicles = o.MaxVehicles
+	}
+	return p
+}
+
+// withDefaults 补齐未配置的字段。
+func (p VehiclePolicy) withDefaults() VehiclePolicy {
+	if p.AutoSubscribe == nil {
+		on := true
+		p.AutoSubscribe = &on
+	}
+	if p.SubscribeDelay == 0 {
+		p.SubscribeDelay = 2 * time.Second
+	}
+	if p.RegistrationExpiry <= 0 {
+		p.RegistrationExpiry = 10 * time.Minute
+	}
+	if p.ResubscribeAfter <= 0 {
+		p.ResubscribeAfter = 5 * time.Minute
+	}
+	if p.OfflineAfter <= 0 {
+		p.OfflineAfter = 5 * time.Minute
+	}
+	if p.EvictAfter == 0 {
+		p.EvictAfter = 10 * time.Minute
+	}
+	return p
+}
+
+// validate 校验补齐默认值后的策略，淘汰前需先完成重新订阅与离线判定。
+func (p VehiclePolicy) validate() error {
+	if p.EvictAfter < 0 {
+		return nil
+	}
+	if p.ResubscribeAfter >= p.EvictAfter {
+		return fmt.Errorf("vehicle resubscribe_after %v must be less than evict_after %v", p.ResubscribeAfter, p.EvictAfter)
+	}
+	if p.OfflineAfter > p.EvictAfter {
+		return errors.New("vehicle offline_after must not exceed evict_after")
+	}
+	return nil
+}
+
+func (p VehiclePolicy) autoSubscribe() bool {
+	return p.AutoSubscribe == nil || *p.AutoSubscribe
+}
+
+// vehiclePolicy 返回账号生效的车辆策略。
+func (p *runtimePolicy) vehiclePolicy(userID uint32) VehiclePolicy {
+	return p.vehicle.merge(p.vehicleOverrides[userID]).withDefaults()
+}
+
+// vehiclePolicy 返回账号生效的车辆策略。
+func (g *JT809Gateway) vehiclePolicy(userID uint32) VehiclePolicy {
+	return g.currentPolicy().vehiclePolicy(userID)
+}
+
+// vehicleOnline 判断车辆最近定位是否未超过账号的离线时长。
+func (g *JT809Gateway) vehicleOnline(userID uint32, v VehicleSnapshot, now time.Time) bool {
+	return !v.PositionTime.IsZero() && now.Sub(v.PositionTime) <= g.vehiclePolicy(userID).OfflineAfter
+}
+
+// admitVehicle 检查平台车辆数上限，已跟踪的车辆总是允许。
+func (g *JT809Gateway) admitVehicle(userID uint32, plate string, color jtt809.PlateColor) bool {
+	max := g.vehiclePolicy(userID).MaxVehicles
+	if max <= 0 || g.store.CanTrackVehicle(userID, vehicleKey(plate, color), max) {
+		return true
+	}
+	slog.Warn("vehicle limit reached, message ignored", "user_id", userID, "plate", plate, "max_vehicles", max)
+	return false
+}
+
+// evictVehicle 从内存删除车辆，仍处于在线状态时同时触发离线。
+func (g *JT809Gateway) evictVehicle(userID uint32, plate string, color jtt809.PlateColor, reason string) {
+	g.store.RemoveVehicle(userID, vehicleKey(plate, color))
+	if e, ok := g.presence.remove(userID, plate, color); ok {
+		g.vehicleOffline(e)
+	}
+	// 围栏、规则与质量检查按平台与车牌维护的状态同时清除，避免淘汰的车辆持续占用内存
+	if g.geofences != nil {
+		g.geofences.forget(userID, plate, color)
+	}
+	if g.rules != nil {
+		g.rules.forget(userID, plate, color)
+	}
+	if g.quality != nil {
+		g.quality.forget(userID, plate, color)
+	}
+	meta := newEventMeta(userID, "")
+	g.emit("OnVehicleEvicted", userID, plate, func(ctx context.Context, h EventHandler) error {
+		return h.OnVehicleEvicted(ctx, &VehicleEvictedEvent{EventMeta: meta, VehicleNo: plate, VehicleColor: color, Reason: reason})
+	})
+}
+
+// activeSince 返回淘汰计时的起点：从持久化恢复的车辆不早于恢复时间。
+func (v VehicleSnapshot) activeSince(t time.Time) time.Time {
+	if v.RestoredAt.After(t) {
+		return v.RestoredAt
+	}
+	return t
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestVehiclePolicyOverrides(t *testing.T) {
+	off := false
+	cfg := Config{
+		MainListen:    ":10709",
+		VehiclePolicy: VehiclePolicy{OfflineAfter: 30 * time.Minute, EvictAfter: 2 * time.Hour},
+		VehiclePolicyOverrides: map[uint32]VehiclePolicy{
+			2: {AutoSubscribe: &off, MaxVehicles: 1, SubscribeDelay: -1},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	p := newRuntimePolicy(cfg)
+
+	global := p.vehiclePolicy(1)
+	if !global.autoSubscribe() || global.OfflineAfter != 30*time.Minute || global.SubscribeDelay != 2*time.Second || global.MaxVehicles != 0 {
+		t.Fatalf("unexpected global policy %+v", global)
+	}
+	override := p.vehiclePolicy(2)
+	if override.autoSubscribe() || override.OfflineAfter != 30*time.Minute || override.EvictAfter != 2*time.Hour || override.MaxVehicles != 1 || override.SubscribeDelay >= 0 {
+		t.Fatalf("unexpected override policy %+v", override)
+	}
+
+	cfg.VehiclePolicyOverrides[3] = VehiclePolicy{OfflineAfter: 3 * time.Hour}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected override with offline_after beyond evict_after rejected")
+	}
+	cfg.VehiclePolicyOverrides[3] = VehiclePolicy{OfflineAfter: 3 * time.Hour, EvictAfter: -1}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected eviction disabled to be valid: %v", err)
+	}
+}
+
+func TestVehicleLifecycleCallbacks(t *testing.T) {
+	type call struct {
+		name  string
+		plate string
+	}
+	calls := make(chan call, 8)
+	g := &JT809Gateway{
+		cfg: Config{
+			VehiclePolicy:          VehiclePolicy{OfflineAfter: 30 * time.Minute, EvictAfter: time.Hour},
+			VehiclePolicyOverrides: map[uint32]VehiclePolicy{2: {MaxVehicles: 1}},
+		},
+		store:   NewPlatformStore(),
+		metrics: newGatewayMetrics(),
+		eventHandler: CallbacksHandler(&Callbacks{
+			OnVehicleOnline: func(_ uint32, plate string, _ jtt809.PlateColor) {
+				calls <- call{"online", plate}
+			},
+			OnVehicleOffline: func(_ uint32, plate string, _ jtt809.PlateColor, _ time.Time) {
+				calls <- call{"offline", plate}
+			},
+			OnVehicleEvicted: func(_ uint32, plate string, _ jtt809.PlateColor, reason string) {
+				calls <- call{"evicted:" + reason, plate}
+			},
+		}),
+	}
+	expect := func(want call) {
+		t.Helper()
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Fatalf("expected %+v, got %+v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %+v, got nothing", want)
+		}
+	}
+
+	g.markVehicleSeen(EventMeta{UserID: 1}, "粤B00001", jtt809.PlateColorBlue)
+	expect(call{"online", "粤B00001"})
+	g.markVehicleSeen(EventMeta{UserID: 1}, "粤B00001", jtt809.PlateColorBlue)
+
+	// 未超过账号离线时长不触发离线
+	g.checkVehiclePresence(time.Now().Add(10 * time.Minute))
+	g.checkVehiclePresence(time.Now().Add(31 * time.Minute))
+	expect(call{"offline", "粤B00001"})
+
+	// 删除在线车辆时同时触发离线
+	g.markVehicleSeen(EventMeta{UserID: 1}, "粤B00002", jtt809.PlateColorBlue)
+	expect(call{"online", "粤B00002"})
+	g.store.UpdateLocation(1, jtt809.PlateColorBlue, "粤B00002", &jtt809.VehiclePosition{}, 0)
+	g.evictVehicle(1, "粤B00002", jtt809.PlateColorBlue, "position_timeout")
+	// 回调异步执行，不校验先后顺序
+	got := map[call]bool{}
+	for range 2 {
+		select {
+		case c := <-calls:
+			got[c] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected offline and evicted callbacks, got %+v", got)
+		}
+	}
+	if !got[call{"offline", "粤B00002"}] || !got[call{"evicted:position_timeout", "粤B00002"}] {
+		t.Fatalf("expected offline and evicted callbacks, got %+v", got)
+	}
+	if snap, _ := g.store.Snapshot(1); len(snap.Vehicles) != 0 {
+		t.Fatalf("expected vehicle removed, got %+v", snap.Vehicles)
+	}
+
+	// 车辆数上限只限制新车辆
+	g.store.UpdateLocation(2, jtt809.PlateColorBlue, "粤B00003", &jtt809.VehiclePosition{}, 0)
+	if !g.admitVehicle(2, "粤B00003", jtt809.PlateColorBlue) {
+		t.Fatal("expected tracked vehicle admitted")
+	}
+	if g.admitVehicle(2, "粤B00004", jtt809.PlateColorBlue) {
+		t.Fatal("expected new vehicle rejected at limit")
+	}
+	if !g.admitVehicle(1, "粤B00004", jtt809.PlateColorBlue) {
+		t.Fatal("expected unlimited platform to admit vehicle")
+	}
+}
+
+func TestEvictVehicleForgetsEngineState(t *testing.T) {
+	const plate = "粤B00001"
+	fences, err := NewGeofenceEngine("")
+	if err != nil {
+		t.Fatalf("new geofence engine: %v", err)
+	}
+	if _, err := fences.Upsert(Geofence{Name: "场站", Shape: GeofenceCircle, Center: &GeoPoint{Longitude: 114.05, Latitude: 22.54}, Radius: 500}); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	g := &JT809Gateway{
+		store:     NewPlatformStore(),
+		metrics:   newGatewayMetrics(),
+		geofences: fences,
+		rules:     NewRuleEngine(DefaultRuleConfig(), fences),
+		quality:   NewQualityMonitor(DefaultQualityConfig()),
+	}
+	gnss := gnssAt(114.05, 22.54, time.Now())
+	g.rules.SetVehicleType(plate, jtt809.PlateColorBlue, "passenger")
+	// 两个平台上报同一车牌，状态各自独立
+	for _, uid := range []uint32{1, 2} {
+		g.geofences.Evaluate(uid, plate, jtt809.PlateColorBlue, gnss, time.Now())
+		g.rules.Evaluate(uid, plate, jtt809.PlateColorBlue, gnss, time.Now())
+		g.quality.Check(uid, plate, jtt809.PlateColorBlue, gnss, TrackSourceRealtime, time.Now())
+	}
+	if len(g.geofences.inside) != 2 || len(g.rules.vehicles) != 2 || len(g.quality.vehicles) != 2 {
+		t.Fatal("expected per-platform engine state before eviction")
+	}
+
+	g.evictVehicle(1, plate, jtt809.PlateColorBlue, "position_timeout")
+	if len(g.geofences.inside) != 1 || len(g.rules.vehicles) != 1 || len(g.quality.vehicles) != 1 {
+		t.Fatalf("expected only platform 1 state forgotten, got %d fences, %d rules, %d quality",
+			len(g.geofences.inside), len(g.rules.vehicles), len(g.quality.vehicles))
+	}
+	key := platformVehicleKey(2, plate, jtt809.PlateColorBlue)
+	if g.geofences.inside[key] == nil || g.rules.vehicles[key] == nil || g.quality.vehicles[key] == nil {
+		t.Fatal("expected platform 2 state kept")
+	}
+	if len(g.rules.types) != 1 {
+		t.Fatal("vehicle type is not platform state and should be kept")
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -83,22 +84,49 @@ func (g *JT809Gateway) VideoStreamUrlByPlate(plate string, color jtt809.PlateCol
 //
 //	0x1801 是下级→上级的上行消息，通过主链路发送。
 func (g *JT809Gateway) RequestVideoStream(req VideoRequest) error {
+	header, body, err := g.buildVideoRequest(req)
+	if err != nil {
+		return err
+	}
+	if err := g.SendToSubordinate(req.UserID, header, body); err != nil {
+		return fmt.Errorf("send video request: %w", err)
+	}
+	slog.Info("video request sent", "user_id", req.UserID, "plate", req.VehicleNo, "channel", req.ChannelID)
+	return nil
+}
+
+// RequestVideoStreamAndWait 发送实时视频请求并等待下级平台应答（0x1801），超时返回 ErrRequestTimeout。
+// 返回的应答 Result 非 0 表示下级平台拒绝请求。
+func (g *JT809Gateway) RequestVideoStreamAndWait(ctx context.Context, req VideoRequest) (*VideoAckState, error) {
+	header, body, err := g.buildVideoRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := g.SendAndWait(ctx, req.UserID, header, body, jtt809.UP_REALVIDEO_MSG_STARTUP_ACK)
+	if err != nil {
+		return nil, fmt.Errorf("send video request: %w", err)
+	}
+	return reply.(*VideoAckState), nil
+}
+
+// buildVideoRequest 校验请求并构造 0x9801 报文头与业务体，车牌颜色缺省为蓝色。
+func (g *JT809Gateway) buildVideoRequest(req VideoRequest) (jtt809.Header, jtt809.Body, error) {
 	if req.VehicleNo == "" {
-		return errors.New("vehicle_no is required")
+		return jtt809.Header{}, nil, errors.New("vehicle_no is required")
 	}
 	if req.VehicleColor == 0 {
 		req.VehicleColor = jtt809.PlateColorBlue
 	}
 	_, authCode := g.store.GetAuthCode(req.UserID)
 	if authCode == "" {
-		return fmt.Errorf("authorize_code not found in store for platform %d. Please wait for the platform to report the authorize code after login", req.UserID)
+		return jtt809.Header{}, nil, fmt.Errorf("authorize_code not found in store for platform %d. Please wait for the platform to report the authorize code after login", req.UserID)
 	}
 	snap, ok := g.store.Snapshot(req.UserID)
 	if !ok {
-		return fmt.Errorf("platform %d not online", req.UserID)
+		return jtt809.Header{}, nil, fmt.Errorf("platform %d not online", req.UserID)
 	}
 	if snap.GNSSCenterID == 0 {
-		return fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
+		return jtt809.Header{}, nil, fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
 	}
 	var (
 		gnssData []byte
@@ -107,10 +135,10 @@ func (g *JT809Gateway) RequestVideoStream(req VideoRequest) error {
 	if strings.TrimSpace(req.GnssHex) != "" {
 		gnssData, err = hex.DecodeString(strings.TrimSpace(req.GnssHex))
 		if err != nil {
-			return fmt.Errorf("parse gnss hex: %w", err)
+			return jtt809.Header{}, nil, fmt.Errorf("parse gnss hex: %w", err)
 		}
 		if len(gnssData) != 36 {
-			return fmt.Errorf("gnss data must be 36 bytes, got %d", len(gnssData))
+			return jtt809.Header{}, nil, fmt.Errorf("gnss data must be 36 bytes, got %d", len(gnssData))
 		}
 	}
 	body := jt1078.DownRealTimeVideoStartupReq{
@@ -121,23 +149,16 @@ func (g *JT809Gateway) RequestVideoStream(req VideoRequest) error {
 	}
 	payload, err := body.Encode()
 	if err != nil {
-		return fmt.Errorf("encode video request: %w", err)
+		return jtt809.Header{}, nil, fmt.Errorf("encode video request: %w", err)
 	}
 	subBody, err := buildSubBusinessBody(req.VehicleNo, req.VehicleColor, body.MsgID(), payload)
 	if err != nil {
-		return err
+		return jtt809.Header{}, nil, err
 	}
 	header := jtt809.Header{
 		GNSSCenterID: snap.GNSSCenterID,
 	}
-	if err := g.SendToSubordinate(req.UserID, header, rawBody{
-		msgID:   jtt809.DOWN_REALVIDEO_MSG,
-		payload: subBody,
-	}); err != nil {
-		return fmt.Errorf("send video request: %w", err)
-	}
-	slog.Info("video request sent", "user_id", req.UserID, "plate", req.VehicleNo, "channel", req.ChannelID)
-	return nil
+	return header, rawBody{msgID: jtt809.DOWN_REALVIDEO_MSG, payload: subBody}, nil
 }
 
 // rawBody 允许直接注入编码好的业务体。
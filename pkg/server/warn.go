@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+// WarnInform 表示上级平台主动下发的报警预警（0x9402）内容。
+type WarnInform struct {
+	UserID       uint32            `json:"user_id"`
+	VehicleNo    string            `json:"vehicle_no"`
+	VehicleColor jtt809.PlateColor `json:"vehicle_color"`
+	WarnType     jtt809.WarnType   `json:"warn_type"`
+	WarnTime     time.Time         `json:"warn_time"`
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Content      string            `json:"content"`
+}
+
+// SendWarnInform 通过从链路向车属下级平台下发报警预警消息（0x9400/0x9402）。
+// 源平台编码取 Config.PlatformID，目的平台编码取下级平台上报的平台唯一编码。
+func (g *JT809Gateway) SendWarnInform(req WarnInform) error {
+	if req.VehicleNo == "" {
+		return errors.New("vehicle_no is required")
+	}
+	if req.VehicleColor == 0 {
+		req.VehicleColor = jtt809.PlateColorBlue
+	}
+	snap, err := g.sendableSnapshot(req.UserID)
+	if err != nil {
+		return err
+	}
+	if snap.GNSSCenterID == 0 {
+		return fmt.Errorf("gnss_center_id is missing for platform %d, abort send", req.UserID)
+	}
+	if req.WarnTime.IsZero() {
+		req.WarnTime = time.Now()
+	}
+	if req.StartTime.IsZero() {
+		req.StartTime = req.WarnTime
+	}
+	if req.EndTime.IsZero() {
+		req.EndTime = req.WarnTime
+	}
+	body := jtt809.DownWarnMsgInformTips{WarnMsgInformTips: jtt809.WarnMsgInformTips{
+		SourcePlatformID: g.cfg.PlatformID,
+		WarnType:         req.WarnType,
+		WarnTime:         req.WarnTime,
+		StartTime:        req.StartTime,
+		EndTime:          req.EndTime,
+		VehicleNo:        req.VehicleNo,
+		VehicleColor:     req.VehicleColor,
+		TargetPlatformID: snap.PlatformID,
+		WarnContent:      req.Content,
+	}}
+	header := jtt809.Header{
+		GNSSCenterID: snap.GNSSCenterID,
+	}
+	if err := g.SendToSubordinate(req.UserID, header, body); err != nil {
+		return fmt.Errorf("send warn inform: %w", err)
+	}
+	slog.Info("warn inform sent", "user_id", req.UserID, "plate", req.VehicleNo, "warn_type", fmt.Sprintf("0x%04X", req.WarnType))
+	return nil
+}
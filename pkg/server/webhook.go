@@ -0,0 +1,544 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWebhookBatchSize     = 100
+	defaultWebhookBatchInterval = time.Second
+	defaultWebhookTimeout       = 10 * time.Second
+	defaultWebhookMaxRetries    = 10
+	defaultWebhookRetryBackoff  = 5 * time.Second
+	maxWebhookRetryBackoff      = 10 * time.Minute
+	defaultWebhookMaxPending    = 10000
+	webhookQueueSize            = 1024
+)
+
+// Webhook 请求头。签名为 HMAC-SHA256(Secret, 时间戳 + "." + 请求体) 的十六进制值，带 "sha256=" 前缀。
+const (
+	WebhookHeaderEvent     = "X-JTT809-Event"
+	WebhookHeaderDelivery  = "X-JTT809-Delivery"
+	WebhookHeaderTimestamp = "X-JTT809-Timestamp"
+	WebhookHeaderSignature = "X-JTT809-Signature"
+)
+
+// WebhookConfig 配置 Webhook 事件推送。
+type WebhookConfig struct {
+	// URL 默认推送地址，未在 URLs 中单独配置的事件均推送到该地址，为空表示不推送
+	URL string `yaml:"url"`
+	// URLs 按事件类型单独配置推送地址，值为空字符串表示该类型不推送
+	URLs map[EventType]string `yaml:"urls"`
+	// Secret 签名密钥，为空时不签名
+	Secret string `yaml:"secret"`
+	// BatchSize 定位事件（实时与补报）合并推送的最大条数，<=0 时使用默认值 100
+	BatchSize int `yaml:"batch_size"`
+	// BatchInterval 定位事件合并等待时间，<=0 时使用默认值 1s
+	BatchInterval time.Duration `yaml:"batch_interval"`
+	// Timeout 单次请求超时，<=0 时使用默认值 10s
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxRetries 失败重试次数上限，超过后写入死信日志，<=0 时使用默认值 10
+	MaxRetries int `yaml:"max_retries"`
+	// RetryBackoff 首次重试等待时间，之后按指数增长，最长 10 分钟，<=0 时使用默认值 5s
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// QueueDir 重试队列与死信日志目录，为空时重试队列仅保存在内存，死信只记录日志
+	QueueDir string `yaml:"queue_dir"`
+	// MaxPending 重试队列最多保存的推送数，已满时新的失败推送直接丢弃并计数，<=0 时使用默认值 10000
+	MaxPending int `yaml:"max_pending"`
+}
+
+// urlFor 返回事件类型对应的推送地址。
+func (c WebhookConfig) urlFor(typ EventType) string {
+	if u, ok := c.URLs[typ]; ok {
+		return u
+	}
+	return c.URL
+}
+
+// webhookDelivery 表示一次推送（单个事件或一批定位事件），失败后按此结构落盘等待重试。
+type webhookDelivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       EventType       `json:"event"`
+	Body        json.RawMessage `json:"body"` // 事件数组
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+type webhookBatchKey struct {
+	url string
+	typ EventType
+}
+
+// WebhookDispatcher 以 HTTP POST JSON 的方式推送网关事件，实现 EventSink。
+// 请求体始终为事件数组：定位事件按地址合并推送，其他事件逐条推送。
+// 推送失败按指数退避重试，超过重试次数或收到不可重试的 4xx 应答后写入死信日志。
+type WebhookDispatcher struct {
+	cfg    WebhookConfig
+	client *http.Client
+
+	queue chan *webhookDelivery
+
+	mu      sync.Mutex
+	batches map[webhookBatchKey][]*Event
+	pending map[string]*webhookDelivery // 等待重试，按 ID 索引
+	dirty   map[string]struct{}         // 待写入或删除的重试文件，由 persistLoop 处理
+	deadMu  sync.Mutex
+
+	seq     atomic.Uint64
+	dropped atomic.Uint64 // 重试队列已满丢弃的推送数
+	closed  atomic.Bool
+	stop    chan struct{} // 通知合并与重试任务退出
+	drain   chan struct{} // 合并任务退出后通知发送任务清空队列
+	persist chan struct{} // 有重试文件待落盘
+	flushed chan struct{} // 发送任务退出后通知落盘任务写完剩余文件
+	wg      sync.WaitGroup
+	sendWG  sync.WaitGroup
+	fileWG  sync.WaitGroup
+}
+
+// NewWebhookDispatcher 创建 Webhook 推送器，并恢复 QueueDir 中未完成的重试任务。
+func NewWebhookDispatcher(cfg WebhookConfig) (*WebhookDispatcher, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultWebhookBatchSize
+	}
+	if cfg.BatchInterval <= 0 {
+		cfg.BatchInterval = defaultWebhookBatchInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultWebhookMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultWebhookRetryBackoff
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultWebhookMaxPending
+	}
+	d := &WebhookDispatcher{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   make(chan *webhookDelivery, webhookQueueSize),
+		batches: make(map[webhookBatchKey][]*Event),
+		pending: make(map[string]*webhookDelivery),
+		dirty:   make(map[string]struct{}),
+		stop:    make(chan struct{}),
+		drain:   make(chan struct{}),
+		persist: make(chan struct{}, 1),
+		flushed: make(chan struct{}),
+	}
+	if cfg.QueueDir != "" {
+		if err := os.MkdirAll(d.pendingDir(), 0o755); err != nil {
+			return nil, fmt.Errorf("create webhook queue dir: %w", err)
+		}
+		if err := d.loadPending(); err != nil {
+			return nil, err
+		}
+	}
+	d.fileWG.Add(1)
+	go d.persistLoop()
+	d.sendWG.Add(1)
+	go d.sendLoop()
+	d.wg.Add(2)
+	go d.batchLoop()
+	go d.retryLoop()
+	return d, nil
+}
+
+// Publish 实现 EventSink，未配置推送地址的事件直接忽略。
+func (d *WebhookDispatcher) Publish(evt *Event) {
+	url := d.cfg.urlFor(evt.Type)
+	if url == "" {
+		return
+	}
+	if evt.Type != EventVehicleLocation && evt.Type != EventVehicleLocationSupplementary {
+		d.enqueue(url, evt.Type, []*Event{evt})
+		return
+	}
+	key := webhookBatchKey{url: url, typ: evt.Type}
+	d.mu.Lock()
+	batch := append(d.batches[key], evt)
+	if len(batch) < d.cfg.BatchSize {
+		d.batches[key] = batch
+		d.mu.Unlock()
+		return
+	}
+	delete(d.batches, key)
+	d.mu.Unlock()
+	d.enqueue(url, evt.Type, batch)
+}
+
+// Close 推送剩余的合并事件并停止后台任务，未送达的推送保留在重试队列中。
+func (d *WebhookDispatcher) Close() error {
+	if !d.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(d.stop)
+	d.wg.Wait()
+	close(d.drain)
+	d.sendWG.Wait()
+	close(d.flushed)
+	d.fileWG.Wait()
+	return nil
+}
+
+// Dropped 返回因重试队列已满丢弃的推送数。
+func (d *WebhookDispatcher) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// Pending 返回等待重试的推送数量。
+func (d *WebhookDispatcher) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
+
+func (d *WebhookDispatcher) newDelivery(url string, typ EventType, events []*Event) *webhookDelivery {
+	body, err := json.Marshal(events)
+	if err != nil {
+		slog.Error("marshal webhook events failed", "event", typ, "err", err)
+		return nil
+	}
+	return &webhookDelivery{
+		ID:    fmt.Sprintf("%d-%d", time.Now().UnixNano(), d.seq.Add(1)),
+		URL:   url,
+		Event: typ,
+		Body:  body,
+	}
+}
+
+func (d *WebhookDispatcher) enqueue(url string, typ EventType, events []*Event) {
+	del := d.newDelivery(url, typ, events)
+	if del == nil {
+		return
+	}
+	if !d.closed.Load() {
+		select {
+		case d.queue <- del:
+			return
+		default:
+		}
+	}
+	// 发送队列已满或已关闭，转入重试队列
+	d.schedule(del, "send queue full", time.Now())
+}
+
+func (d *WebhookDispatcher) sendLoop() {
+	defer d.sendWG.Done()
+	for {
+		select {
+		case del := <-d.queue:
+			d.attempt(del)
+		case <-d.drain:
+			for {
+				select {
+				case del := <-d.queue:
+					d.attempt(del)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *WebhookDispatcher) batchLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.cfg.BatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.flushBatches()
+		case <-d.stop:
+			d.flushBatches()
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) flushBatches() {
+	d.mu.Lock()
+	batches := d.batches
+	d.batches = make(map[webhookBatchKey][]*Event)
+	d.mu.Unlock()
+	for key, events := range batches {
+		del := d.newDelivery(key.url, key.typ, events)
+		if del == nil {
+			continue
+		}
+		// 关闭过程中同样放入发送队列，由 sendLoop 清空
+		select {
+		case d.queue <- del:
+		default:
+			d.schedule(del, "send queue full", time.Now())
+		}
+	}
+}
+
+func (d *WebhookDispatcher) retryLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			for _, del := range d.due(now) {
+				if d.closed.Load() {
+					return
+				}
+				d.attempt(del)
+			}
+		}
+	}
+}
+
+// due 返回已到重试时间的推送。
+func (d *WebhookDispatcher) due(now time.Time) []*webhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []*webhookDelivery
+	for _, del := range d.pending {
+		if !del.NextAttempt.After(now) {
+			out = append(out, del)
+		}
+	}
+	return out
+}
+
+// attempt 执行一次推送并根据结果完成、重试或转入死信。
+func (d *WebhookDispatcher) attempt(del *webhookDelivery) {
+	retryable, err := d.post(del)
+	if err == nil {
+		d.complete(del)
+		return
+	}
+	// 重试中的推送可能正被 persistDirty 序列化，字段需在锁内修改
+	d.mu.Lock()
+	del.Attempts++
+	attempts := del.Attempts
+	dead := !retryable || attempts > d.cfg.MaxRetries
+	if dead {
+		del.LastError = err.Error()
+	}
+	d.mu.Unlock()
+	if dead {
+		d.deadLetter(del)
+		d.complete(del)
+		return
+	}
+	d.schedule(del, err.Error(), time.Now().Add(d.backoff(attempts)))
+}
+
+func (d *WebhookDispatcher) post(del *webhookDelivery) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderEvent, string(del.Event))
+	req.Header.Set(WebhookHeaderDelivery, del.ID)
+	req.Header.Set(WebhookHeaderTimestamp, ts)
+	if d.cfg.Secret != "" {
+		req.Header.Set(WebhookHeaderSignature, SignWebhook(d.cfg.Secret, ts, del.Body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	// 4xx 表示请求本身被拒绝，重试无意义；超时与限流除外
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return false, err
+	}
+	return true, err
+}
+
+// SignWebhook 计算 Webhook 签名，接收方可用同样方式校验 X-JTT809-Signature。
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.RetryBackoff
+	for i := 1; i < attempts && wait < maxWebhookRetryBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxWebhookRetryBackoff)
+}
+
+// schedule 将推送放入重试队列，落盘由 persistLoop 在后台完成，可在 Publish 路径上调用。
+// 重试队列已满时丢弃新的推送并计数。
+func (d *WebhookDispatcher) schedule(del *webhookDelivery, reason string, next time.Time) {
+	d.mu.Lock()
+	if _, ok := d.pending[del.ID]; !ok && len(d.pending) >= d.cfg.MaxPending {
+		d.mu.Unlock()
+		d.dropped.Add(1)
+		slog.Warn("webhook retry queue full, delivery dropped", "id", del.ID, "event", del.Event, "url", del.URL, "err", reason)
+		return
+	}
+	del.LastError = reason
+	del.NextAttempt = next
+	d.pending[del.ID] = del
+	d.markDirtyLocked(del.ID)
+	d.mu.Unlock()
+	slog.Warn("webhook delivery failed, will retry", "id", del.ID, "event", del.Event, "url", del.URL, "attempts", del.Attempts, "next", next, "err", reason)
+}
+
+// complete 将推送移出重试队列。
+func (d *WebhookDispatcher) complete(del *webhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[del.ID]; ok {
+		delete(d.pending, del.ID)
+		d.markDirtyLocked(del.ID)
+	}
+}
+
+func (d *WebhookDispatcher) markDirtyLocked(id string) {
+	if d.cfg.QueueDir == "" {
+		return
+	}
+	d.dirty[id] = struct{}{}
+	select {
+	case d.persist <- struct{}{}:
+	default:
+	}
+}
+
+// persistLoop 写入或删除变化的重试文件，发送任务退出后写完剩余变化再返回。
+func (d *WebhookDispatcher) persistLoop() {
+	defer d.fileWG.Done()
+	for {
+		select {
+		case <-d.persist:
+			d.persistDirty()
+		case <-d.flushed:
+			d.persistDirty()
+			return
+		}
+	}
+}
+
+// persistDirty 同步重试文件：仍在重试队列中的推送覆盖写入，已完成的删除。
+func (d *WebhookDispatcher) persistDirty() {
+	d.mu.Lock()
+	dirty := d.dirty
+	d.dirty = make(map[string]struct{})
+	writes := make(map[string][]byte, len(dirty))
+	for id := range dirty {
+		if del, ok := d.pending[id]; ok {
+			data, err := json.Marshal(del)
+			if err != nil {
+				continue
+			}
+			writes[id] = data
+		}
+	}
+	d.mu.Unlock()
+
+	for id := range dirty {
+		path := d.pendingPath(id)
+		data, ok := writes[id]
+		if !ok {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("remove webhook delivery failed", "id", id, "err", err)
+			}
+			continue
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			slog.Warn("persist webhook delivery failed", "id", id, "err", err)
+		}
+	}
+}
+
+// deadLetter 记录放弃推送的事件，QueueDir 非空时按行追加到 dead-letter.log。
+func (d *WebhookDispatcher) deadLetter(del *webhookDelivery) {
+	slog.Error("webhook delivery dropped", "id", del.ID, "event", del.Event, "url", del.URL, "attempts", del.Attempts, "err", del.LastError)
+	if d.cfg.QueueDir == "" {
+		return
+	}
+	line, err := json.Marshal(del)
+	if err != nil {
+		return
+	}
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(d.cfg.QueueDir, "dead-letter.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Warn("open webhook dead letter log failed", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Warn("write webhook dead letter log failed", "err", err)
+	}
+}
+
+func (d *WebhookDispatcher) loadPending() error {
+	entries, err := os.ReadDir(d.pendingDir())
+	if err != nil {
+		return fmt.Errorf("read webhook queue dir: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(d.pendingDir(), e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("read webhook delivery failed", "file", path, "err", err)
+			continue
+		}
+		var del webhookDelivery
+		if err := json.Unmarshal(data, &del); err != nil || del.ID == "" {
+			slog.Warn("skip corrupted webhook delivery", "file", path, "err", err)
+			continue
+		}
+		d.pending[del.ID] = &del
+	}
+	if len(d.pending) > 0 {
+		slog.Info("webhook retry queue restored", "count", len(d.pending))
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) pendingDir() string {
+	return filepath.Join(d.cfg.QueueDir, "pending")
+}
+
+func (d *WebhookDispatcher) pendingPath(id string) string {
+	return filepath.Join(d.pendingDir(), id+".json")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zboyco/jtt809/pkg/jtt809"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(WebhookHeaderSignature); got != SignWebhook("secret", r.Header.Get(WebhookHeaderTimestamp), body) {
+			t.Errorf("bad signature %q", got)
+		}
+		mu.Lock()
+		requests = append(requests, body)
+		n := len(requests)
+		mu.Unlock()
+		// 首次请求失败，触发重试
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer reject.Close()
+
+	dir := t.TempDir()
+	d, err := NewWebhookDispatcher(WebhookConfig{
+		URL:           srv.URL,
+		URLs:          map[EventType]string{EventLogin: reject.URL, EventQualityIssue: ""},
+		Secret:        "secret",
+		BatchSize:     2,
+		BatchInterval: time.Hour,
+		RetryBackoff:  time.Millisecond,
+		QueueDir:      dir,
+	})
+	if err != nil {
+		t.Fatalf("new dispatcher: %v", err)
+	}
+	defer d.Close()
+
+	loc := &LocationData{Longitude: 114.05, Latitude: 22.54}
+	d.Publish(&Event{ID: "1", Type: EventVehicleLocation, UserID: 1, VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue, Data: loc})
+	d.Publish(&Event{ID: "2", Type: EventVehicleLocation, UserID: 1, VehicleNo: "粤B12345", VehicleColor: jtt809.PlateColorBlue, Data: loc})
+	d.Publish(&Event{ID: "3", Type: EventQualityIssue, UserID: 1})
+
+	waitFor(t, func() bool { return d.Pending() == 1 })
+	waitFor(t, func() bool {
+		files, _ := os.ReadDir(filepath.Join(dir, "pending"))
+		return len(files) == 1
+	})
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests) == 2 && d.Pending() == 0
+	})
+	var events []Event
+	if err := json.Unmarshal(requests[1], &events); err != nil || len(events) != 2 || events[1].ID != "2" {
+		t.Fatalf("unexpected batch: %s", requests[1])
+	}
+	waitFor(t, func() bool {
+		files, _ := os.ReadDir(filepath.Join(dir, "pending"))
+		return len(files) == 0
+	})
+
+	// 4xx 应答不重试，直接进入死信
+	d.Publish(&Event{ID: "4", Type: EventLogin, UserID: 1})
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, "dead-letter.log"))
+		return strings.Contains(string(data), `"event":"login"`)
+	})
+}
+
+func TestWebhookRetryQueueLimit(t *testing.T) {
+	d, err := NewWebhookDispatcher(WebhookConfig{URL: "http://127.0.0.1:1", MaxPending: 1, RetryBackoff: time.Hour})
+	if err != nil {
+		t.Fatalf("new dispatcher: %v", err)
+	}
+	defer d.Close()
+	first := d.newDelivery(d.cfg.URL, EventLogin, nil)
+	d.schedule(first, "down", time.Now().Add(time.Hour))
+	d.schedule(d.newDelivery(d.cfg.URL, EventLogin, nil), "down", time.Now().Add(time.Hour))
+	if d.Pending() != 1 || d.Dropped() != 1 {
+		t.Fatalf("expected 1 pending and 1 dropped, got %d and %d", d.Pending(), d.Dropped())
+	}
+	// 已在队列中的推送再次重试不受上限影响
+	d.schedule(first, "down", time.Now().Add(time.Hour))
+	if d.Dropped() != 1 {
+		t.Fatalf("expected retry of queued delivery kept, got %d dropped", d.Dropped())
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}